@@ -0,0 +1,229 @@
+package alert
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CHECK_INTERVAL  = time.Minute
+	DIGEST_INTERVAL = time.Hour
+	// alerts mailed in one pass, the rest wait for the next check
+	DELIVERY_BATCH = 50
+	// a failed alert is mailed again on every check, and with every digest,
+	// until it has been tried this often
+	MAX_ALERT_ATTEMPTS = 60
+)
+
+var wake = make(chan struct{}, 1)
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "anti-apt"
+	}
+	return name
+}
+
+// recipients are the configured addresses plus, when asked for, every admin's
+// email and extra.
+func recipients(alertingConfig model.AlertingConfig, extra ...string) []string {
+	var to []string
+	add := func(address string) {
+		address = strings.TrimSpace(address)
+		if address != extras.EMPTY_STRING && !slices.Contains(to, address) {
+			to = append(to, address)
+		}
+	}
+
+	for _, address := range alertingConfig.Recipients {
+		add(address)
+	}
+	if alertingConfig.NotifyAdmins {
+		admins, _ := dao.FetchAdminProfile(map[string]any{})
+		for _, admin := range admins {
+			add(admin.Email)
+		}
+	}
+	for _, address := range extra {
+		add(address)
+	}
+	return to
+}
+
+// raise records an alert of rule. High severity alerts are mailed by Run
+// straight away, low severity ones go in the next digest when it is enabled.
+func raise(alertingConfig model.AlertingConfig, rule string, severity string, data map[string]any, extraRecipients ...string) {
+	data["Hostname"] = hostname()
+	data["Time"] = time.Now().Format(extras.TIME_FORMAT)
+
+	subject, body, err := render(alertingConfig, rule, data)
+	if err != nil {
+		// slog.Println("ERROR WHILE RENDERING ALERT: ", rule, err)
+		return
+	}
+
+	alert := model.Alert{
+		Rule:       rule,
+		Severity:   severity,
+		Subject:    subject,
+		Body:       body,
+		Recipients: strings.Join(recipients(alertingConfig, extraRecipients...), ","),
+		Status:     extras.ALERT_PENDING,
+		CreatedAt:  time.Now(),
+	}
+	if severity == extras.ALERT_SEVERITY_LOW && alertingConfig.Digest {
+		alert.Status = extras.ALERT_DIGEST
+	}
+	if err = config.Db.Create(&alert).Error; err != nil {
+		// slog.Println("ERROR WHILE SAVING ALERT: ", rule, err)
+		return
+	}
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func readConfig() (model.AlertingConfig, bool) {
+	alertingConfig, err := config.ReadAlertingConfig()
+	if err != nil || !alertingConfig.Enabled {
+		return alertingConfig, false
+	}
+	return alertingConfig, true
+}
+
+func splitRecipients(recipients string) []string {
+	if recipients == extras.EMPTY_STRING {
+		return nil
+	}
+	return strings.Split(recipients, ",")
+}
+
+// markAlerts records an attempt to mail alerts. Failed ones keep their status
+// to be tried again, until MAX_ALERT_ATTEMPTS.
+func markAlerts(alerts []model.Alert, err error) {
+	update := func(ids []int, fields map[string]any) {
+		if len(ids) > 0 {
+			fields["attempts"] = gorm.Expr("attempts + 1")
+			config.Db.Model(&model.Alert{}).Where("id IN ?", ids).Updates(fields)
+		}
+	}
+
+	var retried, settled []int
+	for _, alert := range alerts {
+		if err != nil && alert.Attempts+1 < MAX_ALERT_ATTEMPTS {
+			retried = append(retried, alert.Id)
+		} else {
+			settled = append(settled, alert.Id)
+		}
+	}
+
+	if err == nil {
+		update(settled, map[string]any{
+			"status":  extras.ALERT_SENT,
+			"error":   extras.EMPTY_STRING,
+			"sent_at": sql.NullTime{Time: time.Now(), Valid: true},
+		})
+		return
+	}
+	update(retried, map[string]any{"error": err.Error()})
+	update(settled, map[string]any{"status": extras.ALERT_FAILED, "error": err.Error()})
+}
+
+// deliverPending mails the pending alerts, the ones that failed before only
+// when retry is set so a raised alert does not wait on them.
+func deliverPending(alertingConfig model.AlertingConfig, retry bool) {
+	var alerts []model.Alert
+	query := config.Db.Where("status = ?", extras.ALERT_PENDING)
+	if !retry {
+		query = query.Where("attempts = 0")
+	}
+	if err := query.Order("id").Limit(DELIVERY_BATCH).Find(&alerts).Error; err != nil {
+		return
+	}
+	for _, alert := range alerts {
+		err := sendMail(alertingConfig.Smtp, splitRecipients(alert.Recipients), alert.Subject, alert.Body)
+		markAlerts([]model.Alert{alert}, err)
+	}
+}
+
+// sendDigest mails every alert waiting for the digest in one message to the
+// union of their recipients.
+func sendDigest(alertingConfig model.AlertingConfig) {
+	var alerts []model.Alert
+	if err := config.Db.Where("status = ?", extras.ALERT_DIGEST).Order("id").Find(&alerts).Error; err != nil || len(alerts) == 0 {
+		return
+	}
+
+	var to []string
+	for _, alert := range alerts {
+		for _, address := range splitRecipients(alert.Recipients) {
+			if !slices.Contains(to, address) {
+				to = append(to, address)
+			}
+		}
+	}
+
+	subject, body, err := render(alertingConfig, extras.ALERT_RULE_DIGEST, map[string]any{"Alerts": alerts})
+	if err == nil {
+		err = sendMail(alertingConfig.Smtp, to, subject, body)
+	}
+	markAlerts(alerts, err)
+}
+
+// Run mails alerts as they are raised, checks the appliance's health every
+// CHECK_INTERVAL and sends the digest every DIGEST_INTERVAL.
+func Run(ctx context.Context) {
+	check := time.NewTicker(CHECK_INTERVAL)
+	defer check.Stop()
+	digest := time.NewTicker(DIGEST_INTERVAL)
+	defer digest.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			if alertingConfig, ok := readConfig(); ok {
+				deliverPending(alertingConfig, false)
+			}
+		case <-check.C:
+			if alertingConfig, ok := readConfig(); ok {
+				runChecks(alertingConfig)
+				deliverPending(alertingConfig, true)
+			}
+		case <-digest.C:
+			if alertingConfig, ok := readConfig(); ok {
+				sendDigest(alertingConfig)
+			}
+		}
+	}
+}
+
+// Test mails the test template to recipient, or to the configured recipients
+// when it is empty. Nothing is recorded.
+func Test(alertingConfig model.AlertingConfig, recipient string) error {
+	to := recipients(alertingConfig)
+	if recipient != extras.EMPTY_STRING {
+		to = []string{recipient}
+	}
+
+	subject, body, err := render(alertingConfig, extras.ALERT_RULE_TEST, map[string]any{"Hostname": hostname(), "Time": time.Now().Format(extras.TIME_FORMAT)})
+	if err != nil {
+		return fmt.Errorf("%w: %v", extras.ErrAlertTemplate, err)
+	}
+	return sendMail(alertingConfig.Smtp, to, subject, body)
+}
@@ -0,0 +1,35 @@
+package alert
+
+import (
+	"anti-apt-backend/internal/dbtest"
+	"anti-apt-backend/model"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMarkAlertsKeepsFailedAlertsPending(t *testing.T) {
+	db := dbtest.Use(t)
+	markAlerts([]model.Alert{{Id: 1, Attempts: 0}, {Id: 2, Attempts: MAX_ALERT_ATTEMPTS - 1}}, errors.New("relay down"))
+
+	updates := db.Matching("UPDATE `alerts`")
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want one for the retried and one for the failed alert: %v", len(updates), db.Statements())
+	}
+	if strings.Contains(updates[0], "`status`") || !strings.Contains(updates[0], "attempts + 1") {
+		t.Errorf("alert with attempts left should stay pending and count the attempt: %s", updates[0])
+	}
+	if !strings.Contains(updates[1], "`status`") || !strings.Contains(updates[1], "attempts + 1") {
+		t.Errorf("alert out of attempts should be failed: %s", updates[1])
+	}
+}
+
+func TestMarkAlertsSent(t *testing.T) {
+	db := dbtest.Use(t)
+	markAlerts([]model.Alert{{Id: 1}, {Id: 2, Attempts: 3}}, nil)
+
+	updates := db.Matching("UPDATE `alerts`")
+	if len(updates) != 1 || !strings.Contains(updates[0], "`sent_at`") || !strings.Contains(updates[0], "`status`") {
+		t.Errorf("sent alerts not marked in one update: %v", db.Statements())
+	}
+}
@@ -0,0 +1,198 @@
+package alert
+
+import (
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// used when a rule is enabled without a threshold
+const (
+	DEFAULT_UNREACHABLE_MINUTES = 10
+	DEFAULT_MIN_FREE_PERCENT    = 10
+	DEFAULT_LICENSE_DAYS        = 30
+)
+
+var DEFAULT_ALERT_RATINGS = []string{string(model.Critical), string(model.HighRisk)}
+
+// state of the health checks, an alert is raised once when a check starts
+// failing and again only after it recovered
+var (
+	mu                 sync.Mutex
+	unreachableSince   = make(map[string]time.Time)
+	unreachableAlerted = make(map[string]bool)
+	diskAlerted        bool
+	licenseAlertedOn   string
+	haState            string
+	haStateRead        bool
+)
+
+func threshold(rule model.AlertRule, fallback int) int {
+	if rule.Threshold > 0 {
+		return rule.Threshold
+	}
+	return fallback
+}
+
+// SandboxHealth is told after every refresh of the sandbox pool whether node
+// answered.
+func SandboxHealth(node string, healthy bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if healthy {
+		delete(unreachableSince, node)
+		delete(unreachableAlerted, node)
+		return
+	}
+	if _, ok := unreachableSince[node]; !ok {
+		unreachableSince[node] = time.Now()
+	}
+}
+
+// TaskReported raises a verdict alert when the task's rating is one the rule
+// watches. Critical verdicts are mailed at once, the others can wait for the
+// digest.
+func TaskReported(notification model.NotificationEvent) {
+	alertingConfig, ok := readConfig()
+	rule := alertingConfig.Rules.Verdict
+	if !ok || !rule.Enabled {
+		return
+	}
+	ratings := rule.Ratings
+	if len(ratings) == 0 {
+		ratings = DEFAULT_ALERT_RATINGS
+	}
+	if !slices.Contains(ratings, notification.Rating) {
+		return
+	}
+
+	severity := extras.ALERT_SEVERITY_LOW
+	if notification.Rating == string(model.Critical) {
+		severity = extras.ALERT_SEVERITY_HIGH
+	}
+
+	var deviceEmails []string
+	if alertingConfig.NotifyDevices && notification.ClientIp != extras.EMPTY_STRING {
+		devices, _ := dao.FetchDeviceProfile(map[string]any{"IpAddress": notification.ClientIp})
+		for _, d := range devices {
+			deviceEmails = append(deviceEmails, d.Email)
+		}
+	}
+
+	raise(alertingConfig, extras.ALERT_RULE_VERDICT, severity, map[string]any{
+		"TaskId":   notification.TaskId,
+		"TaskType": notification.TaskType,
+		"Name":     notification.Name,
+		"SHA256":   notification.SHA256,
+		"Rating":   notification.Rating,
+		"Verdict":  notification.FinalVerdict,
+		"Score":    notification.Score,
+		"ClientIp": notification.ClientIp,
+	}, deviceEmails...)
+}
+
+func runChecks(alertingConfig model.AlertingConfig) {
+	rules := alertingConfig.Rules
+	if rules.SandboxUnreachable.Enabled {
+		checkSandboxes(alertingConfig)
+	}
+	if rules.DiskSpace.Enabled {
+		checkDiskSpace(alertingConfig)
+	}
+	if rules.LicenseExpiry.Enabled {
+		checkLicense(alertingConfig)
+	}
+	checkHaState(alertingConfig)
+}
+
+func checkSandboxes(alertingConfig model.AlertingConfig) {
+	minutes := threshold(alertingConfig.Rules.SandboxUnreachable, DEFAULT_UNREACHABLE_MINUTES)
+
+	mu.Lock()
+	due := make(map[string]time.Time)
+	for node, since := range unreachableSince {
+		if !unreachableAlerted[node] && time.Since(since) >= time.Duration(minutes)*time.Minute {
+			unreachableAlerted[node] = true
+			due[node] = since
+		}
+	}
+	mu.Unlock()
+
+	for node, since := range due {
+		raise(alertingConfig, extras.ALERT_RULE_SANDBOX_UNREACHABLE, extras.ALERT_SEVERITY_HIGH, map[string]any{
+			"Node":    node,
+			"Since":   since.Format(extras.TIME_FORMAT),
+			"Minutes": int(time.Since(since).Minutes()),
+		})
+	}
+}
+
+func checkDiskSpace(alertingConfig model.AlertingConfig) {
+	used, err := util.GetSpaceInfo()
+	if err != nil {
+		return
+	}
+	free := math.Round((100-used)*100) / 100
+	minFree := threshold(alertingConfig.Rules.DiskSpace, DEFAULT_MIN_FREE_PERCENT)
+
+	if free >= float64(minFree) {
+		diskAlerted = false
+		return
+	}
+	if diskAlerted {
+		return
+	}
+	diskAlerted = true
+	raise(alertingConfig, extras.ALERT_RULE_DISK_SPACE, extras.ALERT_SEVERITY_HIGH, map[string]any{
+		"FreePercent": free,
+		"Threshold":   minFree,
+	})
+}
+
+// checkLicense warns once a day while the license expires within the rule's
+// number of days.
+func checkLicense(alertingConfig model.AlertingConfig) {
+	licenseKey, err := dao.FetchLicenseKeyProfile()
+	if err != nil || licenseKey.ExpiryTime.IsZero() {
+		return
+	}
+	days := int(math.Ceil(time.Until(licenseKey.ExpiryTime).Hours() / 24))
+	today := time.Now().Format("2006-01-02")
+	if days > threshold(alertingConfig.Rules.LicenseExpiry, DEFAULT_LICENSE_DAYS) || licenseAlertedOn == today {
+		return
+	}
+	licenseAlertedOn = today
+
+	raise(alertingConfig, extras.ALERT_RULE_LICENSE_EXPIRY, extras.ALERT_SEVERITY_LOW, map[string]any{
+		"Days":       max(days, 0),
+		"ExpiryTime": licenseKey.ExpiryTime.Format(extras.TIME_FORMAT),
+		"SerialId":   licenseKey.DeviceSerialId,
+	})
+}
+
+// checkHaState compares the state keepalived last wrote with the one seen at
+// the previous check, the first check only remembers it.
+func checkHaState(alertingConfig model.AlertingConfig) {
+	state := "none"
+	if data, err := os.ReadFile(extras.HA_STATE_FILE); err == nil && strings.TrimSpace(string(data)) != extras.EMPTY_STRING {
+		state = strings.TrimSpace(string(data))
+	}
+
+	previous, read := haState, haStateRead
+	haState, haStateRead = state, true
+	if !read || previous == state || !alertingConfig.Rules.HaStateChange.Enabled {
+		return
+	}
+	raise(alertingConfig, extras.ALERT_RULE_HA_STATE_CHANGE, extras.ALERT_SEVERITY_HIGH, map[string]any{
+		"PreviousState": previous,
+		"State":         state,
+	})
+}
@@ -0,0 +1,103 @@
+package alert
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const SMTP_TIMEOUT = 30 * time.Second
+
+func buildMail(from string, to []string, subject string, body string) []byte {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var mail strings.Builder
+	mail.WriteString("From: " + from + "\r\n")
+	mail.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	mail.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	mail.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	mail.WriteString("Message-ID: <" + uuid.NewString() + "@" + domain + ">\r\n")
+	mail.WriteString("MIME-Version: 1.0\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	mail.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	mail.WriteString("\r\n")
+	mail.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(mail.String())
+}
+
+// sendMail delivers one mail through the configured server. With starttls the
+// server has to offer STARTTLS, the mail is never sent in the clear instead.
+func sendMail(settings model.SmtpSettings, to []string, subject string, body string) error {
+	if len(to) == 0 {
+		return extras.ErrNoAlertRecipients
+	}
+
+	address := net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+	tlsConfig := &tls.Config{ServerName: settings.Host, InsecureSkipVerify: settings.SkipTlsVerify}
+	dialer := &net.Dialer{Timeout: SMTP_TIMEOUT}
+
+	var conn net.Conn
+	var err error
+	if settings.Security == extras.SMTP_SECURITY_TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * SMTP_TIMEOUT))
+
+	client, err := smtp.NewClient(conn, settings.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if settings.Security == extras.SMTP_SECURITY_STARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return extras.ErrSmtpStartTls
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if settings.Username != extras.EMPTY_STRING {
+		if err = client.Auth(smtp.PlainAuth(extras.EMPTY_STRING, settings.Username, settings.Password, settings.Host)); err != nil {
+			return fmt.Errorf("smtp authentication failed: %w", err)
+		}
+	}
+
+	if err = client.Mail(settings.From); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err = client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s refused: %w", recipient, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(buildMail(settings.From, to, subject, body)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,131 @@
+package alert
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var defaultTemplates = map[string]model.AlertTemplate{
+	extras.ALERT_RULE_VERDICT: {
+		Subject: "[Anti-APT] {{.Rating}} verdict for {{.Name}}",
+		Body: `The {{.TaskType}} task {{.TaskId}} was rated {{.Rating}}.
+
+Name:         {{.Name}}
+{{- if .SHA256}}
+SHA-256:      {{.SHA256}}
+{{- end}}
+Verdict:      {{.Verdict}}
+Score:        {{.Score}}
+Submitted by: {{.ClientIp}}
+Appliance:    {{.Hostname}}
+Time:         {{.Time}}
+`,
+	},
+	extras.ALERT_RULE_SANDBOX_UNREACHABLE: {
+		Subject: "[Anti-APT] Sandbox node {{.Node}} is unreachable",
+		Body: `The sandbox node {{.Node}} has not answered since {{.Since}} ({{.Minutes}} minutes).
+No new files are submitted to it until it is back.
+
+Appliance: {{.Hostname}}
+Time:      {{.Time}}
+`,
+	},
+	extras.ALERT_RULE_DISK_SPACE: {
+		Subject: "[Anti-APT] Disk space low on {{.Hostname}}",
+		Body: `Only {{.FreePercent}}% of the disk is free, the alert threshold is {{.Threshold}}%.
+
+Appliance: {{.Hostname}}
+Time:      {{.Time}}
+`,
+	},
+	extras.ALERT_RULE_LICENSE_EXPIRY: {
+		Subject: "[Anti-APT] License expires in {{.Days}} days",
+		Body: `The license of {{.Hostname}} expires on {{.ExpiryTime}}.
+{{- if .SerialId}}
+
+Serial: {{.SerialId}}
+{{- end}}
+
+Time: {{.Time}}
+`,
+	},
+	extras.ALERT_RULE_HA_STATE_CHANGE: {
+		Subject: "[Anti-APT] HA state of {{.Hostname}} changed to {{.State}}",
+		Body: `The HA state changed from {{.PreviousState}} to {{.State}}.
+
+Appliance: {{.Hostname}}
+Time:      {{.Time}}
+`,
+	},
+	extras.ALERT_RULE_TEST: {
+		Subject: "[Anti-APT] Test alert",
+		Body: `This is a test alert from {{.Hostname}}, the mail settings work.
+
+Time: {{.Time}}
+`,
+	},
+	extras.ALERT_RULE_DIGEST: {
+		Subject: "[Anti-APT] {{len .Alerts}} alerts in the last hour",
+		Body: `{{range .Alerts}}{{.CreatedAt.Format "2006-01-02 15:04:05"}}  {{.Subject}}
+{{end}}
+{{- range .Alerts}}
+----------------------------------------
+{{.Subject}}
+
+{{.Body}}
+{{- end}}
+`,
+	},
+}
+
+func parseTemplate(rule string, text string) (*template.Template, error) {
+	return template.New(rule).Option("missingkey=zero").Parse(text)
+}
+
+// ValidateTemplates checks that every override parses and belongs to a rule.
+func ValidateTemplates(templates map[string]model.AlertTemplate) error {
+	for rule, t := range templates {
+		if _, ok := defaultTemplates[rule]; !ok {
+			return fmt.Errorf("%w: unknown rule %s", extras.ErrAlertTemplate, rule)
+		}
+		for _, text := range []string{t.Subject, t.Body} {
+			if _, err := parseTemplate(rule, text); err != nil {
+				return fmt.Errorf("%w: %v", extras.ErrAlertTemplate, err)
+			}
+		}
+	}
+	return nil
+}
+
+// render fills the subject and body of rule, an override in alertingConfig
+// replaces the built in text part by part.
+func render(alertingConfig model.AlertingConfig, rule string, data any) (string, string, error) {
+	t := defaultTemplates[rule]
+	if override, ok := alertingConfig.Templates[rule]; ok {
+		if override.Subject != extras.EMPTY_STRING {
+			t.Subject = override.Subject
+		}
+		if override.Body != extras.EMPTY_STRING {
+			t.Body = override.Body
+		}
+	}
+
+	var out [2]bytes.Buffer
+	for i, text := range []string{t.Subject, t.Body} {
+		parsed, err := parseTemplate(rule, text)
+		if err != nil {
+			return extras.EMPTY_STRING, extras.EMPTY_STRING, err
+		}
+		if err = parsed.Execute(&out[i], data); err != nil {
+			return extras.EMPTY_STRING, extras.EMPTY_STRING, err
+		}
+	}
+
+	// a subject is a single header line
+	subject := strings.Join(strings.Fields(out[0].String()), " ")
+	return subject, out[1].String(), nil
+}
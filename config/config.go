@@ -1092,3 +1092,154 @@ func FetchLicenseKeyData() (model.LicenseKeyConfig, error) {
 
 	return config, nil
 }
+
+func ReadSandboxNodesConfig() (model.SandboxNodesConfig, error) {
+	var nodesConfig model.SandboxNodesConfig
+
+	yamlData, err := os.ReadFile(extras.SANDBOX_NODES_FILE_PATH)
+	if err != nil {
+		return nodesConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &nodesConfig); err != nil {
+		return nodesConfig, err
+	}
+
+	return nodesConfig, nil
+}
+
+func ReadPrefilterPipelineConfig() (model.PrefilterPipelineConfig, error) {
+	var pipelineConfig model.PrefilterPipelineConfig
+
+	yamlData, err := os.ReadFile(extras.PREFILTER_PIPELINE_FILE_PATH)
+	if err != nil {
+		return pipelineConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &pipelineConfig); err != nil {
+		return pipelineConfig, err
+	}
+
+	return pipelineConfig, nil
+}
+
+func ReadArchivePasswordsConfig() (model.ArchivePasswordsConfig, error) {
+	var passwordsConfig model.ArchivePasswordsConfig
+
+	yamlData, err := os.ReadFile(extras.ARCHIVE_PASSWORDS_FILE_PATH)
+	if err != nil {
+		return passwordsConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &passwordsConfig); err != nil {
+		return passwordsConfig, err
+	}
+
+	return passwordsConfig, nil
+}
+
+func UpdateArchivePasswordsConfig(passwordsConfig model.ArchivePasswordsConfig) error {
+	yamlData, err := yaml.Marshal(&passwordsConfig)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(extras.ARCHIVE_PASSWORDS_FILE_PATH, yamlData, 0644)
+}
+
+func ReadSiemConfig() (model.SiemConfig, error) {
+	var siemConfig model.SiemConfig
+
+	yamlData, err := os.ReadFile(extras.SIEM_CONFIG_FILE_PATH)
+	if err != nil {
+		return siemConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &siemConfig); err != nil {
+		return siemConfig, err
+	}
+
+	return siemConfig, nil
+}
+
+func UpdateSiemConfig(siemConfig model.SiemConfig) error {
+	yamlData, err := yaml.Marshal(&siemConfig)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(extras.SIEM_CONFIG_FILE_PATH, yamlData, 0644)
+}
+
+func ReadAlertingConfig() (model.AlertingConfig, error) {
+	var alertingConfig model.AlertingConfig
+
+	yamlData, err := os.ReadFile(extras.ALERTING_CONFIG_FILE_PATH)
+	if err != nil {
+		return alertingConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &alertingConfig); err != nil {
+		return alertingConfig, err
+	}
+
+	return alertingConfig, nil
+}
+
+// UpdateAlertingConfig keeps the file private, it holds the smtp password.
+func UpdateAlertingConfig(alertingConfig model.AlertingConfig) error {
+	yamlData, err := yaml.Marshal(&alertingConfig)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(extras.ALERTING_CONFIG_FILE_PATH, yamlData, 0600)
+}
+
+func ReadVerdictCacheConfig() (model.VerdictCacheConfig, error) {
+	var verdictCacheConfig model.VerdictCacheConfig
+
+	yamlData, err := os.ReadFile(extras.VERDICT_CACHE_CONFIG_FILE_PATH)
+	if err != nil {
+		return verdictCacheConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &verdictCacheConfig); err != nil {
+		return verdictCacheConfig, err
+	}
+
+	return verdictCacheConfig, nil
+}
+
+func UpdateVerdictCacheConfig(verdictCacheConfig model.VerdictCacheConfig) error {
+	yamlData, err := yaml.Marshal(&verdictCacheConfig)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(extras.VERDICT_CACHE_CONFIG_FILE_PATH, yamlData, 0644)
+}
+
+func ReadSimilarityConfig() (model.SimilarityConfig, error) {
+	var similarityConfig model.SimilarityConfig
+
+	yamlData, err := os.ReadFile(extras.SIMILARITY_CONFIG_FILE_PATH)
+	if err != nil {
+		return similarityConfig, err
+	}
+
+	if err := yaml.Unmarshal(yamlData, &similarityConfig); err != nil {
+		return similarityConfig, err
+	}
+
+	return similarityConfig, nil
+}
+
+func UpdateSimilarityConfig(similarityConfig model.SimilarityConfig) error {
+	yamlData, err := yaml.Marshal(&similarityConfig)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(extras.SIMILARITY_CONFIG_FILE_PATH, yamlData, 0644)
+}
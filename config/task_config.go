@@ -17,14 +17,40 @@ func DBconfig() error {
 		return err
 	}
 
+	// rows promoted from the duplicates were written without a version, they
+	// have to be set before the column turns not null
+	if db.Migrator().HasTable(&model.TaskLiveAnalysisTable{}) {
+		db.Exec("UPDATE task_live_analysis_tables SET version = 0 WHERE version IS NULL")
+	}
+
 	err = db.AutoMigrate(
 		&model.FileOnDemand{},
 		&model.UrlOnDemand{},
 		&model.TaskLiveAnalysisTable{},
 		&model.TaskFinishedTable{},
 		&model.TaskDuplicateTable{},
+		&model.TaskStageResult{},
+		&model.TaskYaraMatch{},
+		&model.YaraRuleset{},
+		&model.UrlIntel{},
+		&model.FileListEntry{},
+		&model.FuzzyHashChunk{},
+		&model.TaskSignature{},
+		&model.TaskNetworkIndicator{},
+		&model.TaskDroppedFile{},
+		&model.TaskActivity{},
+		&model.TaskProcess{},
+		&model.AttackMapping{},
+		&model.TaskAttackTechnique{},
+		&model.Webhook{},
+		&model.NotificationOutbox{},
+		&model.Alert{},
+		&model.TaskEvent{},
+		&model.VerdictHistory{},
 		&model.AuditTable{},
 		&model.FileHashes{},
+		&model.ThreatFeed{},
+		&model.ThreatFeedRun{},
 	)
 	if err != nil {
 		// slog.Println("Error migrating database: ", err)
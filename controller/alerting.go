@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAlertingConfig(ctx *gin.Context) {
+	resp := service.GetAlertingConfig()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateAlertingConfig(ctx *gin.Context) {
+	var alertingConfig model.AlertingConfig
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&alertingConfig); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, "Updated the email alert settings", "ALERTING", session.Values["admin_name"].(string))
+
+	resp = service.UpdateAlertingConfig(alertingConfig)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func TestAlerting(ctx *gin.Context) {
+	var req model.AlertTestRequest
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, "Sent a test email alert", "ALERTING", session.Values["admin_name"].(string))
+
+	resp = service.TestAlerting(req)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetAlerts(ctx *gin.Context) {
+	resp := service.GetAlerts(strings.ToLower(strings.TrimSpace(ctx.Query("status"))))
+	ctx.JSON(resp.StatusCode, resp)
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetArchivePasswords(ctx *gin.Context) {
+	resp := service.GetArchivePasswords()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateArchivePasswords(ctx *gin.Context) {
+	var passwordsConfig model.ArchivePasswordsConfig
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&passwordsConfig); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, "Updated the archive password list", "ARCHIVE PASSWORDS", session.Values["admin_name"].(string))
+
+	resp = service.UpdateArchivePasswords(passwordsConfig)
+	ctx.JSON(resp.StatusCode, resp)
+}
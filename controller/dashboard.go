@@ -7,6 +7,6 @@ import (
 )
 
 func Dashboard(ctx *gin.Context) {
-	resp := service.Dashboard(ctx.Query("action"))
+	resp := service.Dashboard(ctx.Query("action"), ctx.Query("window"))
 	ctx.JSON(resp.StatusCode, resp)
 }
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetFileList(ctx *gin.Context) {
+	resp := service.GetFileList(strings.TrimSpace(ctx.Query("list")), strings.TrimSpace(ctx.Query("type")))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func CreateFileListEntry(ctx *gin.Context) {
+	var req model.ListEntryRequest
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Added %s %s to the %s list", req.Type, req.Value, req.List), "FILE LIST", session.Values["admin_name"].(string))
+
+	resp = service.CreateFileListEntry(req, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateFileListEntry(ctx *gin.Context) {
+	var req model.ListEntryRequest
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	if err = ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Updated file list entry %d to %s %s on the %s list", id, req.Type, req.Value, req.List), "FILE LIST", session.Values["admin_name"].(string))
+
+	resp = service.UpdateFileListEntry(id, req, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func DeleteFileListEntry(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Deleted file list entry %d", id), "FILE LIST", session.Values["admin_name"].(string))
+
+	resp = service.DeleteFileListEntry(id)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func ExportListsForFirewall(ctx *gin.Context) {
+	resp := service.ExportListsForFirewall()
+	ctx.JSON(resp.StatusCode, resp)
+}
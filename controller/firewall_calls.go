@@ -4,6 +4,7 @@ import (
 	"anti-apt-backend/extras"
 	"anti-apt-backend/model"
 	"anti-apt-backend/service"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -35,7 +36,7 @@ func CreateUrlOnDemandForFirewall(ctx *gin.Context) {
 		return
 	}
 
-	resp = service.CreateUrlOnDemand(urlRequest, "DEVICE", "")
+	resp = service.CreateUrlOnDemand(urlRequest, "DEVICE", ctx.ClientIP())
 	jobID := ""
 	if resp.StatusCode == http.StatusOK {
 		jobID = resp.Data.(string)
@@ -51,6 +52,8 @@ func CreateJobForFw(ctx *gin.Context) {
 		CreateFileOnDemandForFirewall(ctx)
 	case "3":
 		CreateUrlOnDemandForFirewall(ctx)
+	case "4":
+		CheckHashesOnDemand(ctx)
 	}
 }
 
@@ -59,6 +62,28 @@ func CheckHashOnDemand(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, resp)
 }
 
+// CheckHashesOnDemand looks up a json list or newline separated hashes in one
+// call
+func CheckHashesOnDemand(ctx *gin.Context) {
+	var resp model.APIResponse
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, extras.MAX_BULK_HASH_BODY_SIZE))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	hashes, err := service.ParseHashList(body, ctx.ContentType())
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_HASH_LIST, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	resp = service.CheckHashesInBulk(hashes)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
 func TestAPTFw(ctx *gin.Context) {
 	type PaylOad struct {
 		Verdict string `json:"verdict"`
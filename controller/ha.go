@@ -26,6 +26,7 @@ func CreateHa(ctx *gin.Context) {
 	}
 
 	resp := service.CreateHa(req)
+	service.ForwardHaEvent(req.RequestType, resp)
 	ctx.JSON(resp.StatusCode, resp)
 }
 
@@ -107,6 +108,7 @@ func HaCopyConfig(ctx *gin.Context) {
 func SyncBackup(ctx *gin.Context) {
 	err := service.SyncBackup()
 	if err != nil {
+		service.ForwardHaEvent(3, model.NewErrorResponse(http.StatusBadRequest, "Error syncing backup", err))
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Error syncing backup: %v", err)})
 		return
 	}
@@ -124,6 +126,7 @@ func GenerateKeepalivedConfigForBackup(ctx *gin.Context) {
 
 func DisableHaInAnotherAppliance(ctx *gin.Context) {
 	resp := service.DisableHa()
+	service.ForwardHaEvent(2, resp)
 	if resp.StatusCode != http.StatusOK {
 		ctx.JSON(resp.StatusCode, resp)
 		return
@@ -57,6 +57,7 @@ func Login(ctx *gin.Context) {
 	}
 
 	resp = service.Login(loginRequest, session, nil)
+	service.ForwardLogin(loginRequest.Username, ctx.ClientIP(), resp.StatusCode == http.StatusOK)
 	if err := session.Save(ctx.Request, ctx.Writer); err != nil {
 		// logger.LoggerFunc("error", logger.LoggerMessage("sysLog:not authorized"))
 		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_SESSION_NOT_SAVED, err)
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskRef reads the task id from the path and its type from the query, tasks
+// are files unless type=url.
+func taskRef(ctx *gin.Context) (int, string, bool) {
+	taskId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp := model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_JOB_ID, extras.ErrInvalidJobId)
+		ctx.JSON(resp.StatusCode, resp)
+		return 0, extras.EMPTY_STRING, false
+	}
+
+	taskType := strings.ToLower(strings.TrimSpace(ctx.Query("type")))
+	if taskType != extras.TASK_TYPE_URL {
+		taskType = extras.TASK_TYPE_FILE
+	}
+	return taskId, taskType, true
+}
+
+func ReanalyzeTask(ctx *gin.Context) {
+	var req model.ReanalyzeRequest
+	var resp model.APIResponse
+
+	taskId, taskType, ok := taskRef(ctx)
+	if !ok {
+		return
+	}
+	// the body is optional, without it the original settings are kept
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	adminName := session.Values["admin_name"].(string)
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Requested reanalysis of %s task %d", taskType, taskId), "SCAN TASKS", adminName)
+
+	resp = service.ReanalyzeTask(taskId, taskType, req, adminName)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetVerdictHistory(ctx *gin.Context) {
+	taskId, taskType, ok := taskRef(ctx)
+	if !ok {
+		return
+	}
+
+	resp := service.GetVerdictHistory(taskId, taskType)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetSampleRetention(ctx *gin.Context) {
+	resp := service.GetSampleRetention()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateSampleRetention(ctx *gin.Context) {
+	var retention model.SampleRetention
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&retention); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Set the sample retention to %d days", retention.Days), "SCAN TASKS", session.Values["admin_name"].(string))
+
+	resp = service.UpdateSampleRetention(retention)
+	ctx.JSON(resp.StatusCode, resp)
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -67,3 +68,44 @@ func DownloadReport(ctx *gin.Context) {
 		log.Println("Error removing pdf file")
 	}
 }
+
+func GetStixBundle(ctx *gin.Context) {
+	jobId := strings.TrimSpace(ctx.Query("job_id"))
+	actionType := strings.TrimSpace(ctx.Query("type"))
+	if actionType == extras.EMPTY_STRING {
+		actionType = "file"
+	}
+
+	if jobId == extras.EMPTY_STRING {
+		resp := model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_JOB_ID, extras.ErrInvalidJobId)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	resp := service.GetStixBundle(jobId, actionType)
+	if resp.StatusCode != http.StatusOK {
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	ctx.Header("Content-Description", "File Transfer")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=stix_%s_%s.json", actionType, jobId))
+	ctx.JSON(http.StatusOK, resp.Data)
+}
+
+func GetTaskTimeline(ctx *gin.Context) {
+	taskId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp := model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_JOB_ID, extras.ErrInvalidJobId)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	taskType := strings.ToLower(strings.TrimSpace(ctx.Query("type")))
+	if taskType != extras.TASK_TYPE_URL {
+		taskType = extras.TASK_TYPE_FILE
+	}
+
+	resp := service.GetTaskTimeline(taskId, taskType)
+	ctx.JSON(resp.StatusCode, resp)
+}
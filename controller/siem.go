@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSiemConfig(ctx *gin.Context) {
+	resp := service.GetSiemConfig()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateSiemConfig(ctx *gin.Context) {
+	var siemConfig model.SiemConfig
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&siemConfig); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, "Updated the SIEM forwarding destinations", "SIEM", session.Values["admin_name"].(string))
+
+	resp = service.UpdateSiemConfig(siemConfig)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func TestSiemDestination(ctx *gin.Context) {
+	var destination model.SiemDestination
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&destination); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Sent a test event to SIEM destination %s", destination.Name), "SIEM", session.Values["admin_name"].(string))
+
+	resp = service.TestSiemDestination(destination)
+	ctx.JSON(resp.StatusCode, resp)
+}
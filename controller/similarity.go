@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SearchSimilar(ctx *gin.Context) {
+	jobId, err := strconv.Atoi(ctx.Query("job_id"))
+	if err != nil {
+		resp := model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+
+	resp := service.SearchSimilar(jobId, limit)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetSimilarityConfig(ctx *gin.Context) {
+	resp := service.GetSimilarityConfig()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateSimilarityConfig(ctx *gin.Context) {
+	var similarityConfig model.SimilarityConfig
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&similarityConfig); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Set the similarity threshold to %d", similarityConfig.Threshold), "SIMILARITY", session.Values["admin_name"].(string))
+
+	resp = service.UpdateSimilarityConfig(similarityConfig)
+	ctx.JSON(resp.StatusCode, resp)
+}
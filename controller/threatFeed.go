@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetThreatFeeds(ctx *gin.Context) {
+	resp := service.GetThreatFeeds()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func CreateThreatFeed(ctx *gin.Context) {
+	var req model.ThreatFeedRequest
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Created threat feed %s", req.Name), "THREAT FEED", session.Values["admin_name"].(string))
+
+	resp = service.CreateThreatFeed(req, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateThreatFeed(ctx *gin.Context) {
+	var req model.ThreatFeedRequest
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	if err = ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Updated threat feed %s", req.Name), "THREAT FEED", session.Values["admin_name"].(string))
+
+	resp = service.UpdateThreatFeed(id, req)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func DeleteThreatFeed(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Deleted threat feed %d", id), "THREAT FEED", session.Values["admin_name"].(string))
+
+	resp = service.DeleteThreatFeed(id)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func RunThreatFeed(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Pulled threat feed %d", id), "THREAT FEED", session.Values["admin_name"].(string))
+
+	resp = service.RunThreatFeed(id, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+// formInt reads an optional number from the form, def when it is not given.
+func formInt(ctx *gin.Context, key string, def int) (int, error) {
+	value := strings.TrimSpace(ctx.Request.FormValue(key))
+	if value == extras.EMPTY_STRING {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func UploadThreatFeed(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	session, err := auth.Store.Get(ctx.Request, "sessionid")
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_SESSION_INVALID, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	file, header, err := ctx.Request.FormFile("file")
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, extras.MAX_FEED_SIZE+1))
+	if err == nil && int64(len(data)) > extras.MAX_FEED_SIZE {
+		err = extras.ErrThreatFeedTooLarge
+	}
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	confidence, err := formInt(ctx, "confidence", extras.DEFAULT_FEED_CONFIDENCE)
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, extras.ErrThreatFeedConfidence)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	expiryDays, err := formInt(ctx, "expiry_days", extras.DEFAULT_FEED_EXPIRY_DAYS)
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, extras.ErrThreatFeedExpiry)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Uploaded threat feed %s", header.Filename), "THREAT FEED", session.Values["admin_name"].(string))
+
+	resp = service.UploadThreatFeed(data, strings.ToLower(strings.TrimSpace(ctx.Request.FormValue("format"))), strings.TrimSpace(ctx.Request.FormValue("source")), confidence, expiryDays, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetThreatFeedRuns(ctx *gin.Context) {
+	feedId, _ := strconv.Atoi(ctx.Query("feed_id"))
+	resp := service.GetThreatFeedRuns(feedId)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetFileHashes(ctx *gin.Context) {
+	resp := service.GetFileHashes(strings.TrimSpace(ctx.Query("source")))
+	ctx.JSON(resp.StatusCode, resp)
+}
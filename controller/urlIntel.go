@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetUrlIntel(ctx *gin.Context) {
+	resp := service.GetUrlIntel(strings.TrimSpace(ctx.Query("list")), strings.TrimSpace(ctx.Query("type")))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func CreateUrlIntelEntry(ctx *gin.Context) {
+	var req model.ListEntryRequest
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Added %s to the %s list", req.Value, req.List), "URL INTEL", session.Values["admin_name"].(string))
+
+	resp = service.CreateUrlIntelEntry(req, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateUrlIntelEntry(ctx *gin.Context) {
+	var req model.ListEntryRequest
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	if err = ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Updated url intel entry %d to %s on the %s list", id, req.Value, req.List), "URL INTEL", session.Values["admin_name"].(string))
+
+	resp = service.UpdateUrlIntelEntry(id, req, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func ImportUrlIntel(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	session, err := auth.Store.Get(ctx.Request, "sessionid")
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_SESSION_INVALID, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	file, header, err := ctx.Request.FormFile("file")
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	defer file.Close()
+
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Imported url intel from %s", header.Filename), "URL INTEL", session.Values["admin_name"].(string))
+
+	resp = service.ImportUrlIntel(file, strings.ToLower(strings.TrimSpace(ctx.Request.FormValue("list"))), strings.TrimSpace(ctx.Request.FormValue("source")))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func ExportUrlIntel(ctx *gin.Context) {
+	data, err := service.ExportUrlIntel(strings.TrimSpace(ctx.Query("list")))
+	if err != nil {
+		resp := model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	ctx.Header("Content-Description", "File Transfer")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=url_intel_%s.csv", time.Now().Format("20060102150405")))
+	ctx.Data(http.StatusOK, "text/csv", data)
+}
+
+func DeleteUrlIntel(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Deleted url intel entry %d", id), "URL INTEL", session.Values["admin_name"].(string))
+
+	resp = service.DeleteUrlIntel(id)
+	ctx.JSON(resp.StatusCode, resp)
+}
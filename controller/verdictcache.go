@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetVerdictCache(ctx *gin.Context) {
+	resp := service.GetVerdictCache()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateVerdictCacheConfig(ctx *gin.Context) {
+	var verdictCacheConfig model.VerdictCacheConfig
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&verdictCacheConfig); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, "Updated the verdict cache policy", "VERDICT CACHE", session.Values["admin_name"].(string))
+
+	resp = service.UpdateVerdictCacheConfig(verdictCacheConfig)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func ReloadVerdictCache(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, "Reloaded the verdict cache", "VERDICT CACHE", session.Values["admin_name"].(string))
+
+	resp = service.ReloadVerdictCache()
+	ctx.JSON(resp.StatusCode, resp)
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetWebhooks(ctx *gin.Context) {
+	resp := service.GetWebhooks()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func CreateWebhook(ctx *gin.Context) {
+	var req model.WebhookRequest
+	var resp model.APIResponse
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Created webhook %s", req.Name), "WEBHOOK", session.Values["admin_name"].(string))
+
+	resp = service.CreateWebhook(req, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UpdateWebhook(ctx *gin.Context) {
+	var req model.WebhookRequest
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	if err = ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Updated webhook %s", req.Name), "WEBHOOK", session.Values["admin_name"].(string))
+
+	resp = service.UpdateWebhook(id, req)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func DeleteWebhook(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Deleted webhook %d", id), "WEBHOOK", session.Values["admin_name"].(string))
+
+	resp = service.DeleteWebhook(id)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func GetNotifications(ctx *gin.Context) {
+	resp := service.GetNotifications(strings.ToLower(strings.TrimSpace(ctx.Query("status"))), strings.ToLower(strings.TrimSpace(ctx.Query("subscriber"))))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func RetryNotification(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Retried notification %d", id), "WEBHOOK", session.Values["admin_name"].(string))
+
+	resp = service.RetryNotification(id)
+	ctx.JSON(resp.StatusCode, resp)
+}
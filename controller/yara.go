@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"anti-apt-backend/auth"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetYaraRulesets(ctx *gin.Context) {
+	resp := service.GetYaraRulesets()
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func UploadYaraRuleset(ctx *gin.Context) {
+	var resp model.APIResponse
+
+	session, err := auth.Store.Get(ctx.Request, "sessionid")
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_SESSION_INVALID, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	file, _, err := ctx.Request.FormFile("file")
+	if err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	defer file.Close()
+
+	name := ctx.Request.FormValue("name")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Uploaded yara ruleset %s", name), "YARA RULESET", session.Values["admin_name"].(string))
+
+	resp = service.UploadYaraRuleset(name, file, session.Values["admin_name"].(string))
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func EnableYaraRuleset(ctx *gin.Context) {
+	setYaraRulesetEnabled(ctx, true)
+}
+
+func DisableYaraRuleset(ctx *gin.Context) {
+	setYaraRulesetEnabled(ctx, false)
+}
+
+func setYaraRulesetEnabled(ctx *gin.Context, enabled bool) {
+	var resp model.APIResponse
+	name := ctx.Param("name")
+
+	action := "Disabled"
+	if enabled {
+		action = "Enabled"
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("%s yara ruleset %s", action, name), "YARA RULESET", session.Values["admin_name"].(string))
+
+	resp = service.SetYaraRulesetEnabled(name, enabled)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func SetYaraRulesetVersion(ctx *gin.Context) {
+	var req model.YaraRulesetVersionRequest
+	var resp model.APIResponse
+	name := ctx.Param("name")
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+
+	session, _ := auth.Store.Get(ctx.Request, "sessionid")
+	defer service.CreateAuditLogs(&resp, fmt.Sprintf("Switched yara ruleset %s to version %d", name, req.Version), "YARA RULESET", session.Values["admin_name"].(string))
+
+	resp = service.SetYaraRulesetVersion(name, req.Version)
+	ctx.JSON(resp.StatusCode, resp)
+}
+
+func TestYaraRuleset(ctx *gin.Context) {
+	file, _, err := ctx.Request.FormFile("file")
+	if err != nil {
+		resp := model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+		ctx.JSON(resp.StatusCode, resp)
+		return
+	}
+	defer file.Close()
+
+	resp := service.TestYaraRuleset(ctx.Param("name"), ctx.Request.FormValue("version"), file)
+	ctx.JSON(resp.StatusCode, resp)
+}
@@ -0,0 +1,79 @@
+package dao
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// more specific entries decide over broader ones
+var fileListSpecificity = map[string]int{
+	extras.FILE_LIST_HASH:     3,
+	extras.FILE_LIST_SIGNER:   2,
+	extras.FILE_LIST_FILENAME: 1,
+}
+
+// LookupFileList returns the unexpired entry deciding a file, or nil when the
+// file is in neither list. filePath is only read for its signer when there are
+// signer entries. A hash entry wins over a signer entry and that over a file
+// name glob, and allow wins a tie with block.
+func LookupFileList(fileName string, filePath string, hashes ...string) (*model.FileListEntry, error) {
+	var quoted []string
+	for _, hash := range hashes {
+		if hash = strings.ToLower(strings.TrimSpace(hash)); hash != extras.EMPTY_STRING {
+			quoted = append(quoted, "'"+util.EscapeSqlString(hash)+"'")
+		}
+	}
+
+	conditions := []string{fmt.Sprintf("type IN ('%s', '%s')", extras.FILE_LIST_SIGNER, extras.FILE_LIST_FILENAME)}
+	if len(quoted) > 0 {
+		conditions = append(conditions, fmt.Sprintf("(type = '%s' AND value IN (%s))", extras.FILE_LIST_HASH, strings.Join(quoted, ", ")))
+	}
+
+	var entries []model.FileListEntry
+	queryString := fmt.Sprintf("SELECT * FROM %s WHERE (%s) AND (expires_at IS NULL OR expires_at > '%s')", extras.FileListTable, strings.Join(conditions, " OR "), time.Now().Format(extras.TIME_FORMAT))
+	fileList := DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &entries,
+	}
+	if err := GormOperations(&fileList, config.Db, EXEC); err != nil {
+		return nil, err
+	}
+
+	var thumbprints []string
+	signerRead := false
+
+	var match *model.FileListEntry
+	for i, entry := range entries {
+		switch entry.Type {
+		case extras.FILE_LIST_FILENAME:
+			if !util.MatchFileNameGlob(entry.Value, fileName) {
+				continue
+			}
+		case extras.FILE_LIST_SIGNER:
+			if !signerRead {
+				thumbprints = util.SignerThumbprints(filePath)
+				signerRead = true
+			}
+			if !slices.Contains(thumbprints, entry.Value) {
+				continue
+			}
+		}
+		if match == nil || fileListBeats(entry, *match) {
+			match = &entries[i]
+		}
+	}
+	return match, nil
+}
+
+func fileListBeats(a model.FileListEntry, b model.FileListEntry) bool {
+	if fileListSpecificity[a.Type] != fileListSpecificity[b.Type] {
+		return fileListSpecificity[a.Type] > fileListSpecificity[b.Type]
+	}
+	return a.List == extras.ALLOW && b.List != extras.ALLOW
+}
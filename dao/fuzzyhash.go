@@ -0,0 +1,86 @@
+package dao
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/hash"
+	"anti-apt-backend/model"
+	"fmt"
+	"strings"
+)
+
+// IndexFuzzyHash adds the pieces of the ssdeep digest of task taskId to the
+// similarity index.
+func IndexFuzzyHash(taskId int, fuzzyHash string) error {
+	var values []string
+	for blockSize, chunks := range hash.FuzzyHashChunks(fuzzyHash) {
+		for _, chunk := range chunks {
+			values = append(values, fmt.Sprintf("(%d, %d, '%s')", taskId, blockSize, chunk))
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	chunkRepo := DatabaseOperationsRepo{
+		QueryExecSet: []string{
+			fmt.Sprintf("DELETE FROM %s WHERE task_id = %d", extras.FuzzyHashChunkTable, taskId),
+			fmt.Sprintf("INSERT INTO %s (task_id, block_size, chunk) VALUES %s", extras.FuzzyHashChunkTable, strings.Join(values, ", ")),
+		},
+	}
+	return GormOperations(&chunkRepo, config.Db, EXEC)
+}
+
+// IndexFuzzyHashes indexes up to limit files after afterId that have an ssdeep
+// digest and no pieces in the index, and returns the last id it went through.
+func IndexFuzzyHashes(afterId int, limit int) (int, error) {
+	var files []model.FileOnDemand
+	queryString := fmt.Sprintf("SELECT id, ssdeep FROM %s f WHERE id > %d AND IFNULL(ssdeep, '') != '' AND NOT EXISTS (SELECT 1 FROM %s c WHERE c.task_id = f.id) ORDER BY id LIMIT %d", extras.FileOnDemandTable, afterId, extras.FuzzyHashChunkTable, limit)
+	fodRepo := DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &files,
+	}
+	if err := GormOperations(&fodRepo, config.Db, EXEC); err != nil {
+		return afterId, err
+	}
+
+	for _, file := range files {
+		if err := IndexFuzzyHash(file.Id, file.Ssdeep); err != nil {
+			return afterId, err
+		}
+		afterId = file.Id
+	}
+	return afterId, nil
+}
+
+// FuzzyHashCandidates returns the newest files sharing a piece of fuzzyHash,
+// the only ones it can be similar to. Only files found malicious are returned
+// when maliciousOnly is set.
+func FuzzyHashCandidates(fuzzyHash string, excludeId int, maliciousOnly bool, limit int) ([]model.SimilarSample, error) {
+	var pieces []string
+	for blockSize, chunks := range hash.FuzzyHashChunks(fuzzyHash) {
+		pieces = append(pieces, fmt.Sprintf("(block_size = %d AND chunk IN ('%s'))", blockSize, strings.Join(chunks, "', '")))
+	}
+	if len(pieces) == 0 {
+		return nil, nil
+	}
+
+	conditions := []string{
+		fmt.Sprintf("id IN (SELECT task_id FROM %s WHERE %s)", extras.FuzzyHashChunkTable, strings.Join(pieces, " OR ")),
+		fmt.Sprintf("id != %d", excludeId),
+	}
+	if maliciousOnly {
+		conditions = append(conditions, fmt.Sprintf("final_verdict = '%s'", extras.BLOCK))
+	}
+
+	var candidates []model.SimilarSample
+	queryString := fmt.Sprintf("SELECT id AS task_id, file_name, md5, sha256, ssdeep, rating, score, final_verdict, submitted_time FROM %s WHERE %s ORDER BY id DESC LIMIT %d", extras.FileOnDemandTable, strings.Join(conditions, " AND "), limit)
+	fodRepo := DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &candidates,
+	}
+	if err := GormOperations(&fodRepo, config.Db, EXEC); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
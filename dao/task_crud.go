@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -119,27 +120,62 @@ func ResetQueueDb() {
 		// slog.Println("error reading file: %v", err)
 	}
 
+	// rows written before the state machine used "<state> (not) in queue"
+	for _, state := range []string{extras.Pending, extras.Running} {
+		err := config.Db.Model(&model.TaskLiveAnalysisTable{}).Where("status IN ?", []string{state + " in queue", state + " not in queue"}).Update("status", state).Error
+		if err != nil {
+			// slog.Println("error updating tasks: %v", err)
+		}
+	}
+
 	if rebooted {
-		err := config.Db.Model(&model.TaskLiveAnalysisTable{}).Where("id > 0").Update("status", extras.PendingNotInQueue).Error
+		// sandbox vms do not survive a reboot, start every live task over
+		err := config.Db.Exec(fmt.Sprintf("INSERT INTO %s (task_id, task_type, from_state, to_state, stage, reason, detail, error, sandbox_id, sandbox_node, sandbox_retry_count, running_retry_count, created_at) SELECT COALESCE(id, url_id), IF(id IS NULL, '%s', '%s'), status, '%s', '', '%s', '', '', sandbox_id, sandbox_node, sandbox_retry_count, running_retry_count, '%s' FROM %s WHERE status <> '%s'", extras.TaskEventTable, extras.TASK_TYPE_URL, extras.TASK_TYPE_FILE, extras.Pending, extras.TASK_REASON_DEVICE_REBOOTED, time.Now().Format(extras.TIME_FORMAT), extras.TaskLiveAnalysisTable, extras.Pending)).Error
+		if err != nil {
+			// slog.Println("error recording restarted tasks: %v", err)
+		}
+
+		err = config.Db.Model(&model.TaskLiveAnalysisTable{}).Where("task_live_analysis_id > 0").Updates(map[string]interface{}{
+			"status":        extras.Pending,
+			"claimed_until": nil,
+			"version":       gorm.Expr("IFNULL(version, 0) + 1"),
+		}).Error
 		if err != nil {
 			// slog.Println("error updating tasks: %v", err)
 		}
 		setDeviceRebootedFlagTo0()
 	} else {
-		// update all tasks to PendingNotInQueue where status = PendingInQueue
-		err := config.Db.Model(&model.TaskLiveAnalysisTable{}).Where("status = ?", extras.PendingInQueue).Update("status", extras.PendingNotInQueue).Error
+		err := ReleaseTaskClaims()
 		if err != nil {
-			// slog.Println("error updating  pending tasks: %v", err)
+			// slog.Println("error updating tasks: %v", err)
 		}
+	}
 
-		// update all tasks to RunningNotInQueue where status = RunningInQueue
-		err = config.Db.Model(&model.TaskLiveAnalysisTable{}).Where("status = ?", extras.RunningInQueue).Update("status", extras.RunningNotInQueue).Error
-		if err != nil {
-			// slog.Println("error updating running tasks: %v", err)
-		}
+	releaseUnpackingArchives()
+}
+
+// releaseUnpackingArchives lets archives whose unpacking was cut short finish
+// on the members saved before, an archive without any is not known to be clean.
+func releaseUnpackingArchives() {
+	queryString := fmt.Sprintf("UPDATE %s p SET p.status = '%s' WHERE p.status = '%s' AND EXISTS (SELECT 1 FROM (SELECT parent_id FROM %s WHERE parent_id > 0) c WHERE c.parent_id = p.id)", extras.FileOnDemandTable, extras.ARCHIVE_UNPACKED, extras.ARCHIVE_UNPACKING, extras.FileOnDemandTable)
+	if err := config.Db.Exec(queryString).Error; err != nil {
+		// slog.Println("error releasing archives: %v", err)
+	}
+	queryString = fmt.Sprintf("UPDATE %s SET status = '%s', rating = '%s', final_verdict = '%s', finished_time = '%s' WHERE status = '%s'", extras.FileOnDemandTable, extras.ARCHIVE_NOT_QUEUED, model.Unknown, extras.BLOCK, time.Now().Format(extras.TIME_FORMAT), extras.ARCHIVE_UNPACKING)
+	if err := config.Db.Exec(queryString).Error; err != nil {
+		// slog.Println("error releasing archives: %v", err)
 	}
 }
 
+// ReleaseTaskClaims drops the leases held on live tasks so they can be
+// claimed again straight away instead of after the lease runs out.
+func ReleaseTaskClaims() error {
+	return config.Db.Model(&model.TaskLiveAnalysisTable{}).Where("claimed_until IS NOT NULL").Updates(map[string]interface{}{
+		"claimed_until": nil,
+		"version":       gorm.Expr("IFNULL(version, 0) + 1"),
+	}).Error
+}
+
 func readDeviceRebootedFlag() (bool, error) {
 	filePath := extras.DEVICE_REBOOTED_FLAG_PATH
 
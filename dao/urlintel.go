@@ -0,0 +1,67 @@
+package dao
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// more specific entries decide over broader ones
+var urlIntelSpecificity = map[string]int{
+	extras.URL_INTEL_URL:    5,
+	extras.URL_INTEL_PATH:   4,
+	extras.URL_INTEL_HOST:   3,
+	extras.URL_INTEL_IP:     3,
+	extras.URL_INTEL_DOMAIN: 2,
+}
+
+// LookupUrlIntel returns the unexpired entry deciding rawUrl, or nil when the
+// url is in neither list. The most specific entry wins, then the longest
+// value, and allow wins a tie with block.
+func LookupUrlIntel(rawUrl string) (*model.UrlIntel, error) {
+	keys, err := util.UrlIntelKeys(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []string
+	for intelType, values := range keys {
+		var quoted []string
+		for _, value := range values {
+			quoted = append(quoted, "'"+util.EscapeSqlString(value)+"'")
+		}
+		conditions = append(conditions, fmt.Sprintf("(type = '%s' AND value IN (%s))", intelType, strings.Join(quoted, ", ")))
+	}
+
+	var entries []model.UrlIntel
+	queryString := fmt.Sprintf("SELECT * FROM %s WHERE (%s) AND (expires_at IS NULL OR expires_at > '%s')", extras.UrlIntelTable, strings.Join(conditions, " OR "), time.Now().Format(extras.TIME_FORMAT))
+	urlIntel := DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &entries,
+	}
+	if err = GormOperations(&urlIntel, config.Db, EXEC); err != nil {
+		return nil, err
+	}
+
+	var match *model.UrlIntel
+	for i := range entries {
+		if match == nil || urlIntelBeats(entries[i], *match) {
+			match = &entries[i]
+		}
+	}
+	return match, nil
+}
+
+func urlIntelBeats(a model.UrlIntel, b model.UrlIntel) bool {
+	if urlIntelSpecificity[a.Type] != urlIntelSpecificity[b.Type] {
+		return urlIntelSpecificity[a.Type] > urlIntelSpecificity[b.Type]
+	}
+	if len(a.Value) != len(b.Value) {
+		return len(a.Value) > len(b.Value)
+	}
+	return a.List == extras.ALLOW && b.List != extras.ALLOW
+}
@@ -69,6 +69,32 @@ const (
 	ERR_INVALID_DATE_FORMAT               = "invalid date format"
 	ERR_PASSWORD_CHANGED_SUCCESSFULLY     = "password changed successfully"
 	ERR_IN_FETCHING_SANDBOX_TASKS         = "Error while fetching sandbox tasks"
+	ERR_INVALID_PRIORITY                  = "invalid priority"
+	ERR_INVALID_YARA_RULES                = "invalid yara rules"
+	ERR_YARA_RULESET_NOT_FOUND            = "yara ruleset not found"
+	ERR_INVALID_URL_INTEL_ENTRY           = "invalid url intel entry"
+	ERR_URL_INTEL_NOT_FOUND               = "url intel entry not found"
+	ERR_INVALID_TIME_WINDOW               = "invalid time window"
+	ERR_INVALID_WEBHOOK                   = "invalid webhook"
+	ERR_WEBHOOK_NOT_FOUND                 = "webhook not found"
+	ERR_NOTIFICATION_NOT_FOUND            = "failed notification not found"
+	ERR_INVALID_SIEM_DESTINATION          = "invalid siem destination"
+	ERR_SIEM_TEST_FAILED                  = "could not send the test event"
+	ERR_INVALID_ALERTING_CONFIG           = "invalid alerting settings"
+	ERR_ALERT_TEST_FAILED                 = "could not send the test email"
+	ERR_INVALID_REANALYSIS                = "invalid reanalysis request"
+	ERR_TASK_NOT_FINISHED                 = "task has not finished analysis"
+	ERR_SAMPLE_NOT_RETAINED               = "sample is no longer retained"
+	ERR_INVALID_ANALYSIS_OPTIONS          = "invalid analysis options"
+	ERR_INVALID_VERDICT_CACHE_CONFIG      = "invalid verdict cache settings"
+	ERR_INVALID_HASH_LIST                 = "invalid hash list"
+	ERR_INVALID_THREAT_FEED               = "invalid threat feed"
+	ERR_THREAT_FEED_NOT_FOUND             = "threat feed not found"
+	ERR_THREAT_FEED_RUN_FAILED            = "threat feed run failed"
+	ERR_INVALID_FILE_LIST_ENTRY           = "invalid file list entry"
+	ERR_FILE_LIST_ENTRY_NOT_FOUND         = "file list entry not found"
+	ERR_INVALID_SIMILARITY_CONFIG         = "invalid similarity settings"
+	ERR_NO_FUZZY_HASH                     = "no fuzzy hash for the task"
 )
 
 const (
@@ -109,6 +135,18 @@ const (
 	IGNORE_EXTENSIONS_FILE_PATH      = "/var/www/html/data/ignore_extensions.txt"
 	MAX_SANDBOX_TASKS_FILE_PATH      = "/var/www/html/data/max_sandbox_tasks"
 	TIMEOUT_FILE_PATH                = "/var/www/html/data/timeout"
+	SANDBOX_BACKEND_FILE_PATH        = "/var/www/html/data/sandbox_backend"
+	SANDBOX_NODES_FILE_PATH          = "/var/www/html/web/database/sandbox_nodes.yaml"
+	PREFILTER_PIPELINE_FILE_PATH     = "/var/www/html/web/database/prefilter_pipeline.yaml"
+	YARA_RULES_PATH                  = "/var/www/html/web/database/yara/"
+	ARCHIVE_PASSWORDS_FILE_PATH      = "/var/www/html/web/database/archive_passwords.yaml"
+	SIEM_CONFIG_FILE_PATH            = "/var/www/html/web/database/siem_forwarding.yaml"
+	SIEM_SPOOL_PATH                  = "/var/www/html/data/siem_spool/"
+	ALERTING_CONFIG_FILE_PATH        = "/var/www/html/web/database/alerting.yaml"
+	SAMPLE_RETENTION_FILE_PATH       = "/var/www/html/data/sample_retention_days"
+	SAMPLE_RETENTION_PATH            = "/var/www/html/data/sample_retention/"
+	VERDICT_CACHE_CONFIG_FILE_PATH   = "/var/www/html/web/database/verdict_cache.yaml"
+	SIMILARITY_CONFIG_FILE_PATH      = "/var/www/html/web/database/similarity.yaml"
 )
 
 var (
@@ -172,10 +210,71 @@ var (
 )
 
 var (
-	ErrFileNotFound    = fmt.Errorf("file not found")
-	ErrTaskNotFound    = fmt.Errorf("task not found")
-	ErrMachineNotfound = fmt.Errorf("machine not found")
-	ErrReportNotFound  = fmt.Errorf("report not found")
+	ErrFileNotFound           = fmt.Errorf("file not found")
+	ErrTaskNotFound           = fmt.Errorf("task not found")
+	ErrMachineNotfound        = fmt.Errorf("machine not found")
+	ErrReportNotFound         = fmt.Errorf("report not found")
+	ErrInvalidPriority        = fmt.Errorf("invalid priority")
+	ErrYaraRulesetNotFound    = fmt.Errorf("yara ruleset not found")
+	ErrArchiveBomb            = fmt.Errorf("archive expands beyond the allowed compression ratio")
+	ErrArchiveLimit           = fmt.Errorf("archive exceeds the unpacking limits")
+	ErrArchiveEncrypted       = fmt.Errorf("archive is encrypted")
+	ErrYaraRulesetName        = fmt.Errorf("ruleset name may only contain letters, digits, '-' and '_'")
+	ErrUrlIntelType           = fmt.Errorf("url intel type must be url, host, domain, path or ip")
+	ErrUrlIntelList           = fmt.Errorf("url intel list must be allow or block")
+	ErrUrlIntelValue          = fmt.Errorf("url intel value does not match its type")
+	ErrUrlIntelNotFound       = fmt.Errorf("url intel entry not found")
+	ErrInvalidTimeWindow      = fmt.Errorf("time window should be like 12h or 7d")
+	ErrWebhookName            = fmt.Errorf("webhook name is required")
+	ErrWebhookUrl             = fmt.Errorf("webhook url should be an http or https url")
+	ErrWebhookEvent           = fmt.Errorf("unknown webhook event")
+	ErrWebhookNotFound        = fmt.Errorf("webhook not found")
+	ErrNotificationNotFound   = fmt.Errorf("failed notification not found")
+	ErrSiemDestinationName    = fmt.Errorf("destination names should be unique and made of letters, digits, - and _")
+	ErrSiemDestinationHost    = fmt.Errorf("destination host and port are required")
+	ErrSiemProtocol           = fmt.Errorf("protocol should be udp, tcp or tls")
+	ErrSiemFormat             = fmt.Errorf("format should be cef, leef or json")
+	ErrSmtpServer             = fmt.Errorf("smtp host, port and sender address are required")
+	ErrSmtpSecurity           = fmt.Errorf("smtp security should be none, starttls or tls")
+	ErrSmtpStartTls           = fmt.Errorf("smtp server does not support STARTTLS")
+	ErrAlertRecipient         = fmt.Errorf("invalid alert recipient address")
+	ErrNoAlertRecipients      = fmt.Errorf("no alert recipients configured")
+	ErrAlertRating            = fmt.Errorf("verdict alerts can only be raised for Critical, High, Medium or Low ratings")
+	ErrAlertThreshold         = fmt.Errorf("alert thresholds should be positive")
+	ErrAlertTemplate          = fmt.Errorf("invalid alert template")
+	ErrTaskNotFinished        = fmt.Errorf("only finished tasks can be reanalysed")
+	ErrSampleNotRetained      = fmt.Errorf("the sample is no longer kept, upload the file again")
+	ErrVmPlatform             = fmt.Errorf("os should be windows, linux, darwin or android")
+	ErrAnalysisTimeout        = fmt.Errorf("analysis timeout should be between 30 and 3600 seconds")
+	ErrRetentionDays          = fmt.Errorf("retention days should be between 0 and 365")
+	ErrVmTag                  = fmt.Errorf("vm tag should be at most 64 letters, digits, ., - and _")
+	ErrAnalysisRoute          = fmt.Errorf("route should be none, inetsim, tor or direct")
+	ErrAnalysisPackage        = fmt.Errorf("unknown analysis package")
+	ErrAnalysisOptions        = fmt.Errorf("options should be comma separated key=value pairs")
+	ErrVerdictCachePolicy     = fmt.Errorf("ttl and rescan hours should not be negative")
+	ErrVerdictCacheConfidence = fmt.Errorf("minimum feed confidence should be between 0 and 100")
+	ErrNoHashes               = fmt.Errorf("at least one hash is required")
+	ErrTooManyHashes          = fmt.Errorf("at most 1000 hashes can be looked up at once")
+	ErrInvalidHash            = fmt.Errorf("hash should be a hex md5, sha1 or sha256")
+	ErrThreatFeedName         = fmt.Errorf("feed names should be made of letters, digits, - and _")
+	ErrThreatFeedUrl          = fmt.Errorf("feed url should be an http or https url")
+	ErrThreatFeedFormat       = fmt.Errorf("feed format should be csv, hashes, misp or stix")
+	ErrThreatFeedInterval     = fmt.Errorf("feed interval should be between 5 and 10080 minutes")
+	ErrThreatFeedConfidence   = fmt.Errorf("confidence should be between 0 and 100")
+	ErrThreatFeedExpiry       = fmt.Errorf("expiry days should be between 0 and 365")
+	ErrThreatFeedNotFound     = fmt.Errorf("threat feed not found")
+	ErrThreatFeedRunning      = fmt.Errorf("the feed is already being pulled")
+	ErrThreatFeedEmpty        = fmt.Errorf("no hashes found in the feed")
+	ErrThreatFeedTooLarge     = fmt.Errorf("feed is larger than 100MB")
+	ErrFileListType           = fmt.Errorf("file list type must be hash, filename or signer")
+	ErrFileListValue          = fmt.Errorf("file list value does not match its type")
+	ErrFileListList           = fmt.Errorf("file list must be allow or block")
+	ErrFileListSignerAllow    = fmt.Errorf("signer entries can only block, signatures are not verified")
+	ErrFileListNotFound       = fmt.Errorf("file list entry not found")
+	ErrListExpiry             = fmt.Errorf("expiry should be in the future")
+	ErrListEntryExists        = fmt.Errorf("an entry with this type and value already exists")
+	ErrSimilarityThreshold    = fmt.Errorf("similarity threshold should be between 0 and 100")
+	ErrNoFuzzyHash            = fmt.Errorf("the file of this task was not fuzzy hashed")
 )
 
 const (
@@ -253,6 +352,11 @@ const (
 	CLEAN_FOUND_FROM_CACHE   = "clean found from cache"
 	PREVIOUSLY_SCANNED_FILE  = "file was previously scanned or sourced from preset data "
 	PREVIOUSLY_SCANNED_URL   = "url was previously scanned or sourced from preset data "
+	ARCHIVE_UNPACKING        = "archive being unpacked"
+	ARCHIVE_UNPACKED         = "archive unpacked, members analysed separately"
+	ARCHIVE_NOT_QUEUED       = "archive members could not be queued"
+	ARCHIVE_BOMB_DETECTED    = "archive bomb detected"
+	ARCHIVE_ENCRYPTED        = "encrypted, not analysed"
 )
 
 const (
@@ -260,6 +364,187 @@ const (
 	BLOCK = "block"
 )
 
+const (
+	TASK_TYPE_FILE = "file"
+	TASK_TYPE_URL  = "url"
+)
+
+// events sent to the notification subscribers
+const (
+	EVENT_TASK_REPORTED      = "task.reported"
+	EVENT_TASK_ABORTED       = "task.aborted"
+	EVENT_VERDICT_OVERRIDDEN = "verdict.overridden"
+)
+
+var NOTIFICATION_EVENTS = []string{EVENT_TASK_REPORTED, EVENT_TASK_ABORTED, EVENT_VERDICT_OVERRIDDEN}
+
+const (
+	SUBSCRIBER_FIREWALL = "firewall"
+	SUBSCRIBER_WEBHOOK  = "webhook"
+)
+
+const (
+	NOTIFICATION_PENDING   = "pending"
+	NOTIFICATION_DELIVERED = "delivered"
+	NOTIFICATION_FAILED    = "failed"
+)
+
+// categories of events forwarded to a SIEM
+const (
+	SIEM_CATEGORY_TASK   = "task"
+	SIEM_CATEGORY_AUDIT  = "audit"
+	SIEM_CATEGORY_SYSTEM = "system"
+	SIEM_CATEGORY_HA     = "ha"
+)
+
+const (
+	SIEM_FORMAT_CEF  = "cef"
+	SIEM_FORMAT_LEEF = "leef"
+	SIEM_FORMAT_JSON = "json"
+)
+
+const (
+	SIEM_PROTOCOL_UDP = "udp"
+	SIEM_PROTOCOL_TCP = "tcp"
+	SIEM_PROTOCOL_TLS = "tls"
+)
+
+// stages of the queue a task event happens in
+const (
+	TASK_STAGE_PREFILTER  = "prefilter"
+	TASK_STAGE_REPUTATION = "reputation"
+	TASK_STAGE_SUBMISSION = "submission"
+	TASK_STAGE_ANALYSIS   = "analysis"
+)
+
+// why a task changed state, kept in its timeline
+const (
+	TASK_REASON_PREFILTER_BLOCKED         = "prefilter_blocked"
+	TASK_REASON_PREFILTER_ALLOWED         = "prefilter_allowed"
+	TASK_REASON_PREFILTER_PASSED          = "prefilter_passed"
+	TASK_REASON_URL_INTEL_BLOCKED         = "url_intel_blocked"
+	TASK_REASON_URL_INTEL_ALLOWED         = "url_intel_allowed"
+	TASK_REASON_FILE_LIST_BLOCKED         = "file_list_blocked"
+	TASK_REASON_FILE_LIST_ALLOWED         = "file_list_allowed"
+	TASK_REASON_CAPACITY_FULL             = "sandbox_capacity_full"
+	TASK_REASON_PENDING_TIMEOUT           = "pending_timeout"
+	TASK_REASON_SUBMIT_FAILED             = "sandbox_submit_failed"
+	TASK_REASON_SANDBOX_RETRIES_EXHAUSTED = "sandbox_retries_exhausted"
+	TASK_REASON_SUBMITTED                 = "submitted_to_sandbox"
+	TASK_REASON_SANDBOX_TASK_MISSING      = "sandbox_task_missing"
+	TASK_REASON_RUNNING_RETRIES_EXHAUSTED = "running_retries_exhausted"
+	TASK_REASON_RUNNING_TIMEOUT           = "running_timeout"
+	TASK_REASON_SANDBOX_FAILED            = "sandbox_failed"
+	TASK_REASON_SANDBOX_NODE_UNREACHABLE  = "sandbox_node_unreachable"
+	TASK_REASON_SANDBOX_REPORTED          = "sandbox_reported"
+	TASK_REASON_DEVICE_REBOOTED           = "device_rebooted"
+	TASK_REASON_REANALYSIS_REQUESTED      = "reanalysis_requested"
+)
+
+// alert rules, also the names of their message templates
+const (
+	ALERT_RULE_VERDICT             = "verdict"
+	ALERT_RULE_SANDBOX_UNREACHABLE = "sandbox_unreachable"
+	ALERT_RULE_DISK_SPACE          = "disk_space"
+	ALERT_RULE_LICENSE_EXPIRY      = "license_expiry"
+	ALERT_RULE_HA_STATE_CHANGE     = "ha_state_change"
+	ALERT_RULE_TEST                = "test"
+	ALERT_RULE_DIGEST              = "digest"
+)
+
+// high severity alerts are mailed at once, low severity ones wait for the
+// hourly digest when it is enabled
+const (
+	ALERT_SEVERITY_HIGH = "high"
+	ALERT_SEVERITY_LOW  = "low"
+)
+
+// a pending or digest alert that could not be mailed is tried again, it is
+// only failed when it runs out of attempts
+const (
+	ALERT_PENDING = "pending"
+	ALERT_DIGEST  = "digest"
+	ALERT_SENT    = "sent"
+	ALERT_FAILED  = "failed"
+)
+
+const (
+	SMTP_SECURITY_NONE     = "none"
+	SMTP_SECURITY_STARTTLS = "starttls"
+	SMTP_SECURITY_TLS      = "tls"
+)
+
+// kinds of network indicators kept from a sandbox report
+const (
+	NETWORK_DNS  = "dns"
+	NETWORK_HTTP = "http"
+	NETWORK_IP   = "ip"
+)
+
+// categories and operations of the activities kept from a sandbox report
+const (
+	ACTIVITY_FILE     = "file"
+	ACTIVITY_REGISTRY = "registry"
+	ACTIVITY_MUTEX    = "mutex"
+
+	ACTIVITY_CREATED = "created"
+	ACTIVITY_WRITTEN = "written"
+	ACTIVITY_DELETED = "deleted"
+)
+
+// kinds of indicator extracted from an analysis
+const (
+	IOC_DOMAIN = "domain"
+	IOC_IPV4   = "ipv4"
+	IOC_IPV6   = "ipv6"
+	IOC_URL    = "url"
+	IOC_SHA256 = "sha256"
+	IOC_MUTEX  = "mutex"
+)
+
+// detections an ATT&CK mapping can apply to
+const (
+	ATTACK_SOURCE_SIGNATURE = "signature"
+	ATTACK_SOURCE_YARA      = "yara"
+	ATTACK_SOURCE_CLAMAV    = "clamav"
+)
+
+// what a url intel entry is matched against
+const (
+	URL_INTEL_URL    = "url"    // the exact normalised url
+	URL_INTEL_PATH   = "path"   // host and a leading part of the path
+	URL_INTEL_HOST   = "host"   // the exact host name
+	URL_INTEL_DOMAIN = "domain" // a domain and all of its subdomains
+	URL_INTEL_IP     = "ip"     // urls with an ip address as host
+
+	URL_INTEL_SOURCE_MANUAL = "manual"
+	URL_INTEL_SOURCE_LEGACY = "temp_malicious_urls"
+)
+
+// what a file list entry is matched against
+const (
+	FILE_LIST_HASH     = "hash"     // the md5, sha1 or sha256 of the file
+	FILE_LIST_FILENAME = "filename" // a glob on the submitted file name
+	FILE_LIST_SIGNER   = "signer"   // sha1 or sha256 thumbprint of the signing certificate
+)
+
+// ssdeep similarity, from 0 to 100, a submission at least this similar to a
+// malicious sample is blocked provisionally through the verdict cache while it
+// is analysed
+const (
+	DEFAULT_SIMILARITY_THRESHOLD = 80
+	DEFAULT_SIMILAR_RESULTS      = 20
+	MAX_SIMILAR_RESULTS          = 100
+	// newest samples sharing a piece of the digest scored per search
+	MAX_SIMILARITY_CANDIDATES = 2000
+	// fuzzy hashes indexed per batch when indexing the files from before the
+	// index
+	FUZZY_HASH_INDEX_BATCH = 1000
+	// how long an upload waits on its provisional verdict to report it, the
+	// search goes on after
+	PROVISIONAL_VERDICT_WAIT = time.Second
+)
+
 const (
 	READONLY = 1
 )
@@ -310,6 +595,15 @@ var MAX_ALLOWED_FIRMWARE_FILE_SIZE int64 = 4831838208
 var MAX_ALLOWED_BUILD_SIZE int64 = 1024 * 1024 * 100
 var CHUNK_SIZE int64 = 1024 * 1024 * 8
 
+// limits for unpacking submitted archives, every member must also fit in MAX_ALLOWED_FILE_SIZE
+var MAX_ARCHIVE_DEPTH = 3
+var MAX_ARCHIVE_FILES = 500
+var MAX_ARCHIVE_TOTAL_SIZE int64 = 1024 * 1024 * 1024
+var MAX_COMPRESSION_RATIO int64 = 100
+
+// tried on encrypted archives after the password given with the submission
+var DEFAULT_ARCHIVE_PASSWORDS = []string{"infected", "malware", "virus"}
+
 const (
 	FW_EMPTY   = 0
 	FW_CLEAN   = 1
@@ -318,6 +612,56 @@ const (
 	FW_UNKNOWN = 4
 )
 
+// limits of one bulk hash lookup
+var MAX_BULK_HASHES = 1000
+var MAX_BULK_HASH_BODY_SIZE int64 = 1024 * 1024
+
+// hash types told apart by their length in hex
+const (
+	HASH_TYPE_MD5    = "md5"
+	HASH_TYPE_SHA1   = "sha1"
+	HASH_TYPE_SHA256 = "sha256"
+)
+
+// verdict of a hash with no cached verdict
+const VERDICT_UNKNOWN = "unknown"
+
+// formats threat feeds are read in
+const (
+	FEED_FORMAT_CSV    = "csv"
+	FEED_FORMAT_HASHES = "hashes" // one hash per line
+	FEED_FORMAT_MISP   = "misp"
+	FEED_FORMAT_STIX   = "stix"
+)
+
+var FEED_FORMATS = []string{FEED_FORMAT_CSV, FEED_FORMAT_HASHES, FEED_FORMAT_MISP, FEED_FORMAT_STIX}
+
+// what started a threat feed run, and how it ended
+const (
+	FEED_TRIGGER_SCHEDULE = "schedule"
+	FEED_TRIGGER_MANUAL   = "manual"
+	FEED_TRIGGER_UPLOAD   = "upload"
+
+	FEED_RUN_RUNNING = "running"
+	FEED_RUN_SUCCESS = "success"
+	FEED_RUN_FAILED  = "failed"
+)
+
+// source of the hashes in file_hashes from before the feeds, and of uploads
+// that do not name one
+const (
+	FEED_SOURCE_PRESET = "preset"
+	FEED_SOURCE_UPLOAD = "upload"
+)
+
+var DEFAULT_FEED_INTERVAL_MINUTES = 60
+var MIN_FEED_INTERVAL_MINUTES = 5
+var MAX_FEED_INTERVAL_MINUTES = 7 * 24 * 60
+var DEFAULT_FEED_CONFIDENCE = 75
+var DEFAULT_FEED_EXPIRY_DAYS = 30
+var MAX_FEED_EXPIRY_DAYS = 365
+var MAX_FEED_SIZE int64 = 100 * 1024 * 1024
+
 const (
 	NOT_PRESENT = -1
 	ANALYSING   = 0
@@ -364,20 +708,98 @@ const (
 	TaskLiveAnalysisTable = "task_live_analysis_tables"
 	TaskFinishedTable     = "task_finished_tables"
 	TaskDuplicateTable    = "task_duplicate_tables"
+	TaskStageResultTable  = "task_stage_results"
+	TaskYaraMatchTable    = "task_yara_matches"
+	YaraRulesetTable      = "yara_rulesets"
+	UrlIntelTable         = "url_intels"
+	TaskSignatureTable    = "task_signatures"
+	TaskNetworkTable      = "task_network_indicators"
+	TaskDroppedFileTable  = "task_dropped_files"
+	TaskActivityTable     = "task_activities"
+	TaskProcessTable      = "task_processes"
+	AttackMappingTable    = "attack_mappings"
+	TaskAttackTable       = "task_attack_techniques"
+	WebhookTable          = "webhooks"
+	NotificationTable     = "notification_outboxes"
+	AlertTable            = "alerts"
+	TaskEventTable        = "task_events"
+	VerdictHistoryTable   = "verdict_histories"
+	ThreatFeedTable       = "threat_feeds"
+	ThreatFeedRunTable    = "threat_feed_runs"
+	FileListTable         = "file_list_entries"
+	FuzzyHashChunkTable   = "fuzzy_hash_chunks"
 	FileOnDemandTable     = "file_on_demands"
 	UrlOnDemandTable      = "url_on_demands"
 )
 
 const (
-	PendingNotInQueue = "pending not in queue"
-	PendingInQueue    = "pending in queue"
-	Pending           = "pending"
-	RunningNotInQueue = "running not in queue"
-	RunningInQueue    = "running in queue"
-	Running           = "running" // in log_queue
-	Reported          = "reported"
-	Aborted           = "aborted"
-	Completed         = "completed"
+	Pending   = "pending"
+	Queued    = "queued"
+	Running   = "running"
+	Reported  = "reported"
+	Aborted   = "aborted"
+	Completed = "completed"
+)
+
+// pre-filter stage outcomes and the actions they can map to
+const (
+	STAGE_DETECTED = "detected"
+	STAGE_CLEAN    = "clean"
+	STAGE_ERROR    = "error"
+	STAGE_TIMEOUT  = "timeout"
+	STAGE_SKIPPED  = "skipped"
+
+	STAGE_BLOCK    = "block"
+	STAGE_ESCALATE = "escalate"
+	STAGE_ALLOW    = "allow"
+)
+
+// what set a verdict kept in the verdict history
+const (
+	VERDICT_SOURCE_ANALYSIS   = "analysis"
+	VERDICT_SOURCE_REANALYSIS = "reanalysis"
+	VERDICT_SOURCE_OVERRIDE   = "override"
+	VERDICT_SOURCE_MALWARE_DB = "malware_db" // file_hashes, the malware hashes from the threat feeds
+	VERDICT_SOURCE_FILE_LIST  = "file_list"  // a hash on the allow or block list
+	// a malicious sample the file is similar to, until the file's own
+	// analysis gives its verdict
+	VERDICT_SOURCE_PROVISIONAL = "provisional"
+)
+
+// sandbox platforms a task can be reanalysed on
+var VM_PLATFORMS = []string{"windows", "linux", "darwin", "android"}
+
+// internet access of the vm during the analysis
+const (
+	ROUTE_NONE    = "none"
+	ROUTE_INETSIM = "inetsim"
+	ROUTE_TOR     = "tor"
+	ROUTE_DIRECT  = "direct"
+)
+
+var ANALYSIS_ROUTES = []string{ROUTE_NONE, ROUTE_INETSIM, ROUTE_TOR, ROUTE_DIRECT}
+
+// cuckoo analysis packages a submission can force instead of the one picked
+// from the file type
+var ANALYSIS_PACKAGES = []string{"applet", "bin", "cpl", "dll", "doc", "exe", "generic", "hta", "ie", "ff", "jar", "js", "msi", "pdf", "ppt", "ps1", "python", "vbs", "wsf", "xls", "zip"}
+
+// analysis timeout, in seconds, a submission can ask for
+const (
+	MIN_ANALYSIS_TIMEOUT = 30
+	MAX_ANALYSIS_TIMEOUT = 3600
+)
+
+// samples are kept for reanalysis this many days unless configured otherwise
+const (
+	DEFAULT_SAMPLE_RETENTION_DAYS = 7
+	MAX_SAMPLE_RETENTION_DAYS     = 365
+)
+
+// submission priority classes
+const (
+	PRIORITY_INLINE = "inline" // firewall holding the file until it gets a verdict
+	PRIORITY_MANUAL = "manual"
+	PRIORITY_BULK   = "bulk"
 )
 
 const HTMLTemplate = `
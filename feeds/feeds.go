@@ -0,0 +1,313 @@
+package feeds
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"anti-apt-backend/verdictcache"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// feeds are checked this often for being due
+const SCHEDULE_INTERVAL = time.Minute
+
+const FETCH_TIMEOUT = 2 * time.Minute
+
+// rows upserted per statement
+const STORE_BATCH = 500
+
+// ids of the feeds being pulled
+var running sync.Map
+
+// pulls reuse the connections of one client that verifies certificates and one
+// that does not
+var (
+	client         = &http.Client{Timeout: FETCH_TIMEOUT}
+	insecureClient = &http.Client{Timeout: FETCH_TIMEOUT, Transport: insecureTransport()}
+)
+
+func insecureTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return transport
+}
+
+// Init keys the hashes stored before the feeds so the feeds de-duplicate
+// against them. Rows repeating a hash are left without a key.
+func Init() {
+	queryString := fmt.Sprintf("UPDATE IGNORE %s SET hash_key = LOWER(COALESCE(NULLIF(sha256, ''), NULLIF(sha1, ''), NULLIF(md5, ''))), source = IF(IFNULL(source, '') = '', '%s', source) WHERE hash_key IS NULL", dao.FileHashesTable, extras.FEED_SOURCE_PRESET)
+	if err := config.Db.Exec(queryString).Error; err != nil {
+		// slog.Println("ERROR WHILE KEYING FILE HASHES: ", err)
+	}
+}
+
+func fetch(feed model.ThreatFeed) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, feed.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if feed.ApiKey != extras.EMPTY_STRING {
+		req.Header.Set("Authorization", feed.ApiKey)
+	}
+	if feed.Format == extras.FEED_FORMAT_MISP || feed.Format == extras.FEED_FORMAT_STIX {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	feedClient := client
+	if feed.SkipTlsVerify {
+		feedClient = insecureClient
+	}
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("feed answered with status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, extras.MAX_FEED_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > extras.MAX_FEED_SIZE {
+		return nil, extras.ErrThreatFeedTooLarge
+	}
+	return data, nil
+}
+
+// dedupe merges the entries repeating a hash, the merged entry keeps the
+// highest confidence and the latest expiry.
+func dedupe(entries []Entry) ([]Entry, int) {
+	index := make(map[string]int)
+	var unique []Entry
+	for _, entry := range entries {
+		i, ok := index[entry.key()]
+		if !ok {
+			index[entry.key()] = len(unique)
+			unique = append(unique, entry)
+			continue
+		}
+
+		merged := &unique[i]
+		if merged.Md5 == extras.EMPTY_STRING {
+			merged.Md5 = entry.Md5
+		}
+		if merged.Sha1 == extras.EMPTY_STRING {
+			merged.Sha1 = entry.Sha1
+		}
+		merged.Confidence = max(merged.Confidence, entry.Confidence)
+		if !merged.ExpiresAt.IsZero() && (entry.ExpiresAt.IsZero() || entry.ExpiresAt.After(merged.ExpiresAt)) {
+			merged.ExpiresAt = entry.ExpiresAt
+		}
+	}
+	return unique, len(entries) - len(unique)
+}
+
+// store upserts entries. A hash already known takes the source of the more
+// confident report, the highest confidence and the latest expiry, no expiry
+// being the latest.
+func store(entries []Entry, source string, feedId int, confidence int, expiryDays int) error {
+	now := time.Now()
+	return config.Db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(entries); start += STORE_BATCH {
+			batch := entries[start:min(start+STORE_BATCH, len(entries))]
+
+			var values []string
+			for _, entry := range batch {
+				entryConfidence := entry.Confidence
+				if entryConfidence == 0 {
+					entryConfidence = confidence
+				}
+				expiresAt := "NULL"
+				if !entry.ExpiresAt.IsZero() {
+					expiresAt = fmt.Sprintf("'%s'", entry.ExpiresAt.Format(extras.TIME_FORMAT))
+				} else if expiryDays > 0 {
+					expiresAt = fmt.Sprintf("'%s'", now.AddDate(0, 0, expiryDays).Format(extras.TIME_FORMAT))
+				}
+				values = append(values, fmt.Sprintf("('%s', '%s', '%s', '%s', '%s', %d, %d, %s, '%s', '%s')", entry.Md5, entry.Sha1, entry.Sha256, entry.key(), util.EscapeSqlString(source), feedId, entryConfidence, expiresAt, now.Format(extras.TIME_FORMAT), now.Format(extras.TIME_FORMAT)))
+			}
+
+			// confidence is assigned last, the columns before it compare
+			// against the stored one
+			queryString := fmt.Sprintf("INSERT INTO %s (md5, sha1, sha256, hash_key, source, feed_id, confidence, expires_at, created_at, updated_at) VALUES %s ON DUPLICATE KEY UPDATE md5 = IF(VALUES(md5) != '', VALUES(md5), md5), sha1 = IF(VALUES(sha1) != '', VALUES(sha1), sha1), source = IF(VALUES(confidence) >= confidence, VALUES(source), source), feed_id = IF(VALUES(confidence) >= confidence, VALUES(feed_id), feed_id), expires_at = IF(expires_at IS NULL OR VALUES(expires_at) IS NULL, NULL, GREATEST(expires_at, VALUES(expires_at))), updated_at = VALUES(updated_at), confidence = GREATEST(confidence, VALUES(confidence))", dao.FileHashesTable, strings.Join(values, ", "))
+			if err := tx.Exec(queryString).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func purgeExpired() (int, error) {
+	result := config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE expires_at IS NOT NULL AND expires_at < '%s'", dao.FileHashesTable, time.Now().Format(extras.TIME_FORMAT)))
+	return int(result.RowsAffected), result.Error
+}
+
+// purgeDropped removes the hashes feedId reported before a pull started at and
+// left out of it, the ones taken over by a more confident source stay.
+func purgeDropped(feedId int, startedAt time.Time) (int, error) {
+	result := config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE feed_id = %d AND updated_at < '%s'", dao.FileHashesTable, feedId, startedAt.Format(extras.TIME_FORMAT)))
+	return int(result.RowsAffected), result.Error
+}
+
+// ingest parses data into the file hashes and logs it on run.
+func ingest(run *model.ThreatFeedRun, data []byte, confidence int, expiryDays int) error {
+	entries, rejected, err := Parse(run.Format, data)
+	run.Parsed = len(entries)
+	run.Rejected = rejected
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 && rejected > 0 {
+		return extras.ErrThreatFeedEmpty
+	}
+
+	entries, run.Duplicates = dedupe(entries)
+	if err = store(entries, run.Source, run.FeedId, confidence, expiryDays); err != nil {
+		return err
+	}
+	run.Stored = len(entries)
+
+	if run.Expired, err = purgeExpired(); err != nil {
+		// slog.Println("ERROR WHILE PURGING EXPIRED FILE HASHES: ", err)
+	}
+	return nil
+}
+
+// reloadVerdicts makes the stored hashes answer lookups right away instead of
+// at the next reload of the verdict cache.
+func reloadVerdicts() {
+	if err := verdictcache.Load(); err != nil {
+		// slog.Println("ERROR WHILE RELOADING VERDICT CACHE: ", err)
+	}
+}
+
+func startRun(run *model.ThreatFeedRun) {
+	run.Status = extras.FEED_RUN_RUNNING
+	run.StartedAt = time.Now()
+	if err := config.Db.Create(run).Error; err != nil {
+		// slog.Println("ERROR WHILE LOGGING FEED RUN: ", err)
+	}
+}
+
+func finishRun(run *model.ThreatFeedRun, err error) {
+	run.Status = extras.FEED_RUN_SUCCESS
+	if err != nil {
+		run.Status = extras.FEED_RUN_FAILED
+		run.Error = err.Error()
+	}
+	run.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := config.Db.Save(run).Error; err != nil {
+		// slog.Println("ERROR WHILE LOGGING FEED RUN: ", err)
+	}
+}
+
+// Pull fetches feed and stores its hashes. A feed is pulled once at a time.
+func Pull(feed model.ThreatFeed, kind string, startedBy string) (model.ThreatFeedRun, error) {
+	if _, busy := running.LoadOrStore(feed.Id, true); busy {
+		return model.ThreatFeedRun{}, extras.ErrThreatFeedRunning
+	}
+	defer running.Delete(feed.Id)
+
+	run := model.ThreatFeedRun{
+		FeedId:    feed.Id,
+		Source:    feed.Name,
+		Kind:      kind,
+		Format:    feed.Format,
+		StartedBy: startedBy,
+	}
+	startRun(&run)
+	config.Db.Exec(fmt.Sprintf("UPDATE %s SET last_run_at = '%s', last_status = '%s' WHERE id = %d", extras.ThreatFeedTable, run.StartedAt.Format(extras.TIME_FORMAT), extras.FEED_RUN_RUNNING, feed.Id))
+
+	data, err := fetch(feed)
+	if err == nil {
+		err = ingest(&run, data, feed.Confidence, feed.ExpiryDays)
+	}
+	// a pull is the whole feed, what it no longer lists was dropped. An empty
+	// feed is more likely broken than cleared.
+	if err == nil && run.Stored > 0 {
+		var purgeErr error
+		if run.Dropped, purgeErr = purgeDropped(feed.Id, run.StartedAt); purgeErr != nil {
+			// slog.Println("ERROR WHILE PURGING DROPPED FILE HASHES: ", purgeErr)
+		}
+	}
+	finishRun(&run, err)
+	config.Db.Exec(fmt.Sprintf("UPDATE %s SET last_status = '%s' WHERE id = %d", extras.ThreatFeedTable, run.Status, feed.Id))
+
+	if err == nil {
+		reloadVerdicts()
+	}
+	return run, err
+}
+
+// Upload stores the hashes of a feed file uploaded by hand under source.
+func Upload(data []byte, format string, source string, confidence int, expiryDays int, startedBy string) (model.ThreatFeedRun, error) {
+	run := model.ThreatFeedRun{
+		Source:    source,
+		Kind:      extras.FEED_TRIGGER_UPLOAD,
+		Format:    format,
+		StartedBy: startedBy,
+	}
+	startRun(&run)
+	err := ingest(&run, data, confidence, expiryDays)
+	finishRun(&run, err)
+
+	if err == nil {
+		reloadVerdicts()
+	}
+	return run, err
+}
+
+// due returns the enabled feeds whose interval has passed since their last
+// run.
+func due() ([]model.ThreatFeed, error) {
+	var feeds []model.ThreatFeed
+	err := config.Db.Where("enabled = ?", true).Find(&feeds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var dueFeeds []model.ThreatFeed
+	for _, feed := range feeds {
+		if !feed.LastRunAt.Valid || time.Since(feed.LastRunAt.Time) >= time.Duration(feed.IntervalMinutes)*time.Minute {
+			dueFeeds = append(dueFeeds, feed)
+		}
+	}
+	return dueFeeds, nil
+}
+
+// Run pulls the feeds as they fall due.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(SCHEDULE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dueFeeds, err := due()
+			if err != nil {
+				// slog.Println("ERROR WHILE FETCHING THREAT FEEDS: ", err)
+				continue
+			}
+			for _, feed := range dueFeeds {
+				go Pull(feed, extras.FEED_TRIGGER_SCHEDULE, extras.EMPTY_STRING)
+			}
+		}
+	}
+}
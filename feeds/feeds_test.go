@@ -0,0 +1,91 @@
+package feeds
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/internal/dbtest"
+	"anti-apt-backend/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupe(t *testing.T) {
+	soon := time.Now().Add(time.Hour)
+	later := time.Now().Add(48 * time.Hour)
+	entries := []Entry{
+		{Sha256: testSha256, Confidence: 40, ExpiresAt: soon},
+		{Md5: otherMd5},
+		{Sha256: testSha256, Md5: testMd5, Confidence: 90, ExpiresAt: later},
+		{Sha256: testSha256, Confidence: 60, ExpiresAt: soon},
+	}
+
+	unique, duplicates := dedupe(entries)
+	if len(unique) != 2 || duplicates != 2 {
+		t.Fatalf("got %d entries and %d duplicates, want 2 and 2: %+v", len(unique), duplicates, unique)
+	}
+	merged := unique[0]
+	if merged.Confidence != 90 || !merged.ExpiresAt.Equal(later) || merged.Md5 != testMd5 {
+		t.Errorf("merged entry should keep the highest confidence, latest expiry and every hash: %+v", merged)
+	}
+
+	// no expiry outlasts any expiry
+	unique, _ = dedupe([]Entry{{Md5: testMd5, ExpiresAt: soon}, {Md5: testMd5}})
+	if !unique[0].ExpiresAt.IsZero() {
+		t.Errorf("entry without expiry should win, got %v", unique[0].ExpiresAt)
+	}
+}
+
+func TestPull(t *testing.T) {
+	db := dbtest.Use(t)
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		io.WriteString(w, testSha256+"\n"+testMd5+"\n"+testSha256+"\nnot-a-hash\n")
+	}))
+	defer server.Close()
+
+	feed := model.ThreatFeed{Id: 7, Name: "test feed", Url: server.URL, Format: extras.FEED_FORMAT_HASHES, ApiKey: "secret", Confidence: 70}
+	run, err := Pull(feed, extras.FEED_TRIGGER_MANUAL, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "secret" {
+		t.Errorf("api key sent as %q", authorization)
+	}
+	if run.Status != extras.FEED_RUN_SUCCESS || run.Parsed != 3 || run.Duplicates != 1 || run.Rejected != 1 || run.Stored != 2 || run.Dropped != 1 {
+		t.Errorf("unexpected run: %+v", run)
+	}
+
+	if len(db.Matching("INSERT INTO `threat_feed_runs`")) != 1 || len(db.Matching("UPDATE `threat_feed_runs`")) != 1 {
+		t.Errorf("run not logged: %v", db.Statements())
+	}
+	stored := db.Matching("INSERT INTO file_hashes")
+	if len(stored) != 1 || !strings.Contains(stored[0], testSha256) || !strings.Contains(stored[0], ", 7, 70, ") {
+		t.Errorf("hashes not stored under the feed: %v", stored)
+	}
+	if len(db.Matching("DELETE FROM file_hashes WHERE feed_id = 7 AND updated_at < ")) != 1 {
+		t.Errorf("hashes dropped by the feed not removed: %v", db.Statements())
+	}
+}
+
+func TestPullFailedFetch(t *testing.T) {
+	db := dbtest.Use(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	feed := model.ThreatFeed{Id: 8, Name: "down feed", Url: server.URL, Format: extras.FEED_FORMAT_HASHES}
+	run, err := Pull(feed, extras.FEED_TRIGGER_MANUAL, "admin")
+	if err == nil || run.Status != extras.FEED_RUN_FAILED {
+		t.Fatalf("got %v and run %+v, want a failed run", err, run)
+	}
+	if len(db.Matching("INSERT INTO file_hashes")) != 0 || len(db.Matching("DELETE FROM file_hashes WHERE feed_id")) != 0 {
+		t.Errorf("failed pull touched the hashes: %v", db.Statements())
+	}
+}
@@ -0,0 +1,363 @@
+package feeds
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is one file read from a feed, with the hashes the feed gave for it.
+type Entry struct {
+	Md5        string
+	Sha1       string
+	Sha256     string
+	Confidence int       // 0 when the feed does not say
+	ExpiresAt  time.Time // zero when the feed does not say
+}
+
+// add sets hash on the field of its type, it is false for anything else.
+func (e *Entry) add(hash string) bool {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	switch util.HashType(hash) {
+	case extras.HASH_TYPE_MD5:
+		e.Md5 = hash
+	case extras.HASH_TYPE_SHA1:
+		e.Sha1 = hash
+	case extras.HASH_TYPE_SHA256:
+		e.Sha256 = hash
+	default:
+		return false
+	}
+	return true
+}
+
+// key is the strongest of the entry's hashes, entries are de-duplicated on it.
+func (e Entry) key() string {
+	switch {
+	case e.Sha256 != extras.EMPTY_STRING:
+		return e.Sha256
+	case e.Sha1 != extras.EMPTY_STRING:
+		return e.Sha1
+	}
+	return e.Md5
+}
+
+// Parse reads the entries of a feed in format. rejected counts the records
+// that looked like entries but held no valid hash.
+func Parse(format string, data []byte) (entries []Entry, rejected int, err error) {
+	switch format {
+	case extras.FEED_FORMAT_CSV:
+		return parseCsv(data)
+	case extras.FEED_FORMAT_HASHES:
+		entries, rejected = parseHashes(data)
+		return entries, rejected, nil
+	case extras.FEED_FORMAT_MISP:
+		return parseMisp(data)
+	case extras.FEED_FORMAT_STIX:
+		return parseStix(data)
+	}
+	return nil, 0, extras.ErrThreatFeedFormat
+}
+
+func isComment(line string) bool {
+	return line == extras.EMPTY_STRING || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//")
+}
+
+// parseHashes reads a hash per line, anything after the hash on the line, like
+// the file name sha256sum prints, is ignored.
+func parseHashes(data []byte) ([]Entry, int) {
+	var entries []Entry
+	rejected := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if isComment(line) {
+			continue
+		}
+		var entry Entry
+		if !entry.add(strings.Trim(strings.Fields(line)[0], `",;`)) {
+			rejected++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rejected
+}
+
+func parseExpiry(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, extras.TIME_FORMAT, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseConfidence(value string) int {
+	confidence, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return min(max(confidence, 0), 100)
+}
+
+// parseCsv takes the hashes from any column of a row. A first row without a
+// hash is a header, its confidence and expires_at columns are read as well.
+func parseCsv(data []byte) ([]Entry, int, error) {
+	csvReader := csv.NewReader(bytes.NewReader(data))
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	csvReader.Comment = '#'
+	csvReader.LazyQuotes = true
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	confidenceColumn, expiryColumn := -1, -1
+	var entries []Entry
+	rejected := 0
+	for i, row := range rows {
+		var entry Entry
+		for _, field := range row {
+			entry.add(field)
+		}
+
+		if entry.key() == extras.EMPTY_STRING {
+			if i == 0 {
+				for j, name := range row {
+					switch strings.ToLower(strings.TrimSpace(name)) {
+					case "confidence", "score":
+						confidenceColumn = j
+					case "expires_at", "expiry", "valid_until":
+						expiryColumn = j
+					}
+				}
+			} else if len(row) > 1 || strings.TrimSpace(row[0]) != extras.EMPTY_STRING {
+				rejected++
+			}
+			continue
+		}
+
+		if confidenceColumn >= 0 && confidenceColumn < len(row) {
+			entry.Confidence = parseConfidence(strings.TrimSpace(row[confidenceColumn]))
+		}
+		if expiryColumn >= 0 && expiryColumn < len(row) {
+			entry.ExpiresAt, _ = parseExpiry(strings.TrimSpace(row[expiryColumn]))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rejected, nil
+}
+
+type mispAttribute struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	ToIds   *bool  `json:"to_ids"`
+	Deleted bool   `json:"deleted"`
+}
+
+type mispObject struct {
+	Name      string          `json:"name"`
+	Attribute []mispAttribute `json:"Attribute"`
+}
+
+type mispEvent struct {
+	Attribute []mispAttribute `json:"Attribute"`
+	Object    []mispObject    `json:"Object"`
+}
+
+// mispDocument is an exported event, or a restSearch response holding events
+// or attributes.
+type mispDocument struct {
+	Event    *mispEvent      `json:"Event"`
+	Response json.RawMessage `json:"response"`
+}
+
+// mispHash returns the hash of a hash attribute, the part after the file name
+// for the filename|hash types.
+func mispHash(attribute mispAttribute) (string, bool) {
+	if attribute.Deleted || (attribute.ToIds != nil && !*attribute.ToIds) {
+		return extras.EMPTY_STRING, false
+	}
+	switch attribute.Type {
+	case "md5", "sha1", "sha256":
+		return attribute.Value, true
+	case "filename|md5", "filename|sha1", "filename|sha256", "malware-sample":
+		_, hash, _ := strings.Cut(attribute.Value, "|")
+		return hash, true
+	}
+	return extras.EMPTY_STRING, false
+}
+
+func mispEvents(data []byte) ([]mispEvent, error) {
+	data = bytes.TrimSpace(data)
+	var documents []mispDocument
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &documents); err != nil {
+			return nil, err
+		}
+	} else {
+		var document mispDocument
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	var events []mispEvent
+	for _, document := range documents {
+		if document.Event != nil {
+			events = append(events, *document.Event)
+		}
+		if len(document.Response) == 0 {
+			continue
+		}
+		response := bytes.TrimSpace(document.Response)
+		if bytes.HasPrefix(response, []byte("[")) {
+			nested, err := mispEvents(response)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, nested...)
+			continue
+		}
+		var attributes mispEvent
+		if err := json.Unmarshal(response, &attributes); err != nil {
+			return nil, err
+		}
+		events = append(events, attributes)
+	}
+	return events, nil
+}
+
+// parseMisp reads the hash attributes of MISP events. The hashes of a file
+// object are one entry, other hash attributes an entry each.
+func parseMisp(data []byte) ([]Entry, int, error) {
+	events, err := mispEvents(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []Entry
+	rejected := 0
+	addAttributes := func(attributes []mispAttribute, grouped bool) {
+		var entry Entry
+		for _, attribute := range attributes {
+			hash, ok := mispHash(attribute)
+			if !ok {
+				continue
+			}
+			if !grouped {
+				entry = Entry{}
+			}
+			if !entry.add(hash) {
+				rejected++
+				continue
+			}
+			if !grouped {
+				entries = append(entries, entry)
+			}
+		}
+		if grouped && entry.key() != extras.EMPTY_STRING {
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, event := range events {
+		addAttributes(event.Attribute, false)
+		for _, object := range event.Object {
+			addAttributes(object.Attribute, object.Name == "file")
+		}
+	}
+	return entries, rejected, nil
+}
+
+var stixHashComparison = regexp.MustCompile(`file:hashes\.(?:'[^']+'|"[^"]+"|[A-Za-z0-9_-]+)\s*=\s*'([^']*)'`)
+
+// parseStix reads the file hashes of a STIX 2 bundle, from the patterns of
+// indicators and from file objects. The confidence and valid_until of an
+// indicator carry over to its entries.
+func parseStix(data []byte) ([]Entry, int, error) {
+	var bundle struct {
+		Objects []model.StixObject `json:"objects"`
+	}
+	data = bytes.TrimSpace(data)
+	var err error
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &bundle.Objects)
+	} else {
+		err = json.Unmarshal(data, &bundle)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []Entry
+	rejected := 0
+	for _, object := range bundle.Objects {
+		switch object.Type {
+		case "indicator":
+			if object.PatternType != extras.EMPTY_STRING && object.PatternType != "stix" {
+				continue
+			}
+			comparisons := stixHashComparison.FindAllStringSubmatch(object.Pattern, -1)
+
+			// the hashes of one file when each type shows up once, or of as
+			// many files as there are comparisons
+			var entry Entry
+			var found []Entry
+			grouped := true
+			for _, comparison := range comparisons {
+				var single Entry
+				if !single.add(comparison[1]) {
+					rejected++
+					continue
+				}
+				if (single.Md5 != extras.EMPTY_STRING && entry.Md5 != extras.EMPTY_STRING) || (single.Sha1 != extras.EMPTY_STRING && entry.Sha1 != extras.EMPTY_STRING) || (single.Sha256 != extras.EMPTY_STRING && entry.Sha256 != extras.EMPTY_STRING) {
+					grouped = false
+				}
+				entry.add(comparison[1])
+				found = append(found, single)
+			}
+			if grouped && entry.key() != extras.EMPTY_STRING {
+				found = []Entry{entry}
+			}
+
+			for _, e := range found {
+				e.Confidence = min(max(object.Confidence, 0), 100)
+				if object.ValidUntil != extras.EMPTY_STRING {
+					e.ExpiresAt, _ = parseExpiry(object.ValidUntil)
+				}
+				entries = append(entries, e)
+			}
+
+		case "file":
+			var entry Entry
+			for algorithm, hash := range object.Hashes {
+				switch strings.ToUpper(strings.ReplaceAll(algorithm, "-", "")) {
+				case "MD5", "SHA1", "SHA256":
+				default:
+					continue
+				}
+				if !entry.add(hash) {
+					rejected++
+				}
+			}
+			if entry.key() != extras.EMPTY_STRING {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, rejected, nil
+}
@@ -0,0 +1,126 @@
+package feeds
+
+import (
+	"anti-apt-backend/extras"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testMd5    = "44d88612fea8a8f36de82e1278abb02f"
+	testSha1   = "3395856ce81f2b7382dee72602f798b642f14140"
+	testSha256 = "275a021bbfb6489e54d471899f7db9d1663fc695ec2fe2a2c4538aabf651fd0f"
+	otherMd5   = "0cc175b9c0f1b6a831c399e269772661"
+)
+
+func TestParseHashes(t *testing.T) {
+	data := strings.Join([]string{
+		"# comment",
+		"",
+		strings.ToUpper(testSha256) + "  eicar.com",
+		testMd5,
+		"not-a-hash",
+	}, "\n")
+
+	entries, rejected, err := Parse(extras.FEED_FORMAT_HASHES, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || rejected != 1 {
+		t.Fatalf("got %d entries and %d rejected, want 2 and 1: %+v", len(entries), rejected, entries)
+	}
+	if entries[0].Sha256 != testSha256 || entries[1].Md5 != testMd5 {
+		t.Errorf("hashes not read in lower case: %+v", entries)
+	}
+}
+
+func TestParseCsv(t *testing.T) {
+	data := strings.Join([]string{
+		"md5,sha256,confidence,expires_at",
+		testMd5 + "," + testSha256 + ",90,2030-01-02",
+		otherMd5 + ",,",
+		"bad,row,,",
+	}, "\n")
+
+	entries, rejected, err := Parse(extras.FEED_FORMAT_CSV, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || rejected != 1 {
+		t.Fatalf("got %d entries and %d rejected, want 2 and 1: %+v", len(entries), rejected, entries)
+	}
+	first := entries[0]
+	if first.Md5 != testMd5 || first.Sha256 != testSha256 || first.Confidence != 90 {
+		t.Errorf("unexpected first entry: %+v", first)
+	}
+	if want := time.Date(2030, 1, 2, 0, 0, 0, 0, time.Local); !first.ExpiresAt.Equal(want) {
+		t.Errorf("expiry %v, want %v", first.ExpiresAt, want)
+	}
+	if entries[1].Md5 != otherMd5 || entries[1].Confidence != 0 || !entries[1].ExpiresAt.IsZero() {
+		t.Errorf("unexpected second entry: %+v", entries[1])
+	}
+}
+
+func TestParseMisp(t *testing.T) {
+	data := `{"response": [{"Event": {
+		"Attribute": [
+			{"type": "md5", "value": "` + otherMd5 + `"},
+			{"type": "sha1", "value": "` + testSha1 + `", "to_ids": false},
+			{"type": "domain", "value": "example.com"},
+			{"type": "sha256", "value": "short", "deleted": false}
+		],
+		"Object": [{"name": "file", "Attribute": [
+			{"type": "filename|md5", "value": "eicar.com|` + testMd5 + `"},
+			{"type": "sha256", "value": "` + testSha256 + `"}
+		]}]
+	}}]}`
+
+	entries, rejected, err := Parse(extras.FEED_FORMAT_MISP, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || rejected != 1 {
+		t.Fatalf("got %d entries and %d rejected, want 2 and 1: %+v", len(entries), rejected, entries)
+	}
+	if entries[0].Md5 != otherMd5 {
+		t.Errorf("attribute not read: %+v", entries[0])
+	}
+	if entries[1].Md5 != testMd5 || entries[1].Sha256 != testSha256 {
+		t.Errorf("file object not read as one entry: %+v", entries[1])
+	}
+}
+
+func TestParseStix(t *testing.T) {
+	data := `{"type": "bundle", "objects": [
+		{"type": "indicator", "pattern_type": "stix", "confidence": 80, "valid_until": "2030-01-02T00:00:00Z",
+			"pattern": "[file:hashes.MD5 = '` + testMd5 + `' AND file:hashes.'SHA-256' = '` + testSha256 + `']"},
+		{"type": "indicator", "pattern": "[file:hashes.MD5 = '` + otherMd5 + `' OR file:hashes.MD5 = 'zz']"},
+		{"type": "indicator", "pattern_type": "yara", "pattern": "rule x { condition: true }"},
+		{"type": "file", "hashes": {"SHA-1": "` + testSha1 + `"}}
+	]}`
+
+	entries, rejected, err := Parse(extras.FEED_FORMAT_STIX, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || rejected != 1 {
+		t.Fatalf("got %d entries and %d rejected, want 3 and 1: %+v", len(entries), rejected, entries)
+	}
+	first := entries[0]
+	if first.Md5 != testMd5 || first.Sha256 != testSha256 || first.Confidence != 80 {
+		t.Errorf("indicator hashes not one entry: %+v", first)
+	}
+	if want := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC); !first.ExpiresAt.Equal(want) {
+		t.Errorf("expiry %v, want %v", first.ExpiresAt, want)
+	}
+	if entries[1].Md5 != otherMd5 || entries[2].Sha1 != testSha1 {
+		t.Errorf("unexpected entries: %+v", entries[1:])
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	if _, _, err := Parse("xml", []byte(testMd5)); err != extras.ErrThreatFeedFormat {
+		t.Errorf("got %v, want ErrThreatFeedFormat", err)
+	}
+}
@@ -0,0 +1,273 @@
+package hash
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ssdeep context triggered piecewise hashing. The digest is cut where a rolling
+// hash of the last few bytes hits a trigger value, so a change to a file only
+// changes the pieces around it and similar files get similar digests.
+const (
+	SSDEEP_ROLLING_WINDOW = 7
+	SSDEEP_MIN_BLOCK_SIZE = 3
+	SSDEEP_SPAMSUM_LENGTH = 64
+	SSDEEP_HASH_PRIME     = 0x01000193
+	SSDEEP_HASH_INIT      = 0x28021967
+)
+
+// files larger than this are not fuzzy hashed
+const MAX_FUZZY_HASH_SIZE = 256 * 1024 * 1024
+
+const ssdeepB64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+type rollingHash struct {
+	window     [SSDEEP_ROLLING_WINDOW]byte
+	h1, h2, h3 uint32
+	n          uint32
+}
+
+func (r *rollingHash) roll(c byte) {
+	r.h2 -= r.h1
+	r.h2 += SSDEEP_ROLLING_WINDOW * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n%SSDEEP_ROLLING_WINDOW])
+	r.window[r.n%SSDEEP_ROLLING_WINDOW] = c
+	r.n++
+	r.h3 = (r.h3 << 5) ^ uint32(c)
+}
+
+func (r *rollingHash) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+// spamsum returns the digests of data at blockSize and twice blockSize, and
+// how many pieces the first one was cut into before its last.
+func spamsum(data []byte, blockSize uint32) (string, string, int) {
+	var roll rollingHash
+	h1, h2 := uint32(SSDEEP_HASH_INIT), uint32(SSDEEP_HASH_INIT)
+	var digest1, digest2 []byte
+	// the trigger seen once a digest is full, it ends the digest when the
+	// data ends on a zero rolling hash
+	var last1, last2 byte
+
+	for _, c := range data {
+		h1 = (h1 * SSDEEP_HASH_PRIME) ^ uint32(c)
+		h2 = (h2 * SSDEEP_HASH_PRIME) ^ uint32(c)
+		roll.roll(c)
+		sum := roll.sum()
+
+		if sum%blockSize == blockSize-1 {
+			if len(digest1) < SSDEEP_SPAMSUM_LENGTH-1 {
+				digest1 = append(digest1, ssdeepB64[h1%64])
+				h1 = SSDEEP_HASH_INIT
+				last1 = 0
+			} else {
+				last1 = ssdeepB64[h1%64]
+			}
+		}
+		if sum%(2*blockSize) == 2*blockSize-1 {
+			if len(digest2) < SSDEEP_SPAMSUM_LENGTH/2-1 {
+				digest2 = append(digest2, ssdeepB64[h2%64])
+				h2 = SSDEEP_HASH_INIT
+				last2 = 0
+			} else {
+				last2 = ssdeepB64[h2%64]
+			}
+		}
+	}
+
+	pieces := len(digest1)
+	if roll.sum() != 0 {
+		digest1 = append(digest1, ssdeepB64[h1%64])
+		digest2 = append(digest2, ssdeepB64[h2%64])
+	} else {
+		if last1 != 0 {
+			digest1 = append(digest1, last1)
+		}
+		if last2 != 0 {
+			digest2 = append(digest2, last2)
+		}
+	}
+	return string(digest1), string(digest2), pieces
+}
+
+// FuzzyHash is the ssdeep digest of data, blocksize:digest:digest.
+func FuzzyHash(data []byte) string {
+	blockSize := uint32(SSDEEP_MIN_BLOCK_SIZE)
+	for uint64(blockSize)*SSDEEP_SPAMSUM_LENGTH < uint64(len(data)) {
+		blockSize *= 2
+	}
+
+	for {
+		digest1, digest2, pieces := spamsum(data, blockSize)
+		// too few pieces to compare well, cut smaller
+		if blockSize > SSDEEP_MIN_BLOCK_SIZE && pieces < SSDEEP_SPAMSUM_LENGTH/2 {
+			blockSize /= 2
+			continue
+		}
+		return fmt.Sprintf("%d:%s:%s", blockSize, digest1, digest2)
+	}
+}
+
+func CalculateFuzzyHash(filepath string) (string, error) {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > MAX_FUZZY_HASH_SIZE {
+		return "", fmt.Errorf("file too large to fuzzy hash")
+	}
+
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return "", err
+	}
+	return FuzzyHash(data), nil
+}
+
+// ParseFuzzyHash splits an ssdeep digest into its block size and its two
+// digests.
+func ParseFuzzyHash(fuzzyHash string) (int, string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(fuzzyHash), ":", 3)
+	if len(parts) != 3 {
+		return 0, "", "", fmt.Errorf("invalid ssdeep digest")
+	}
+	blockSize, err := strconv.Atoi(parts[0])
+	if err != nil || blockSize < SSDEEP_MIN_BLOCK_SIZE {
+		return 0, "", "", fmt.Errorf("invalid ssdeep block size")
+	}
+	// digests with a file name after them, as ssdeep prints them
+	digest2, _, _ := strings.Cut(parts[2], ",")
+	return blockSize, parts[1], digest2, nil
+}
+
+// eliminateSequences shortens runs of a character to three, long runs say
+// little about a file and would make unrelated files look alike.
+func eliminateSequences(digest string) string {
+	var out []byte
+	for i := 0; i < len(digest); i++ {
+		if i >= 3 && digest[i] == digest[i-1] && digest[i] == digest[i-2] && digest[i] == digest[i-3] {
+			continue
+		}
+		out = append(out, digest[i])
+	}
+	return string(out)
+}
+
+func hasCommonSubstring(a string, b string) bool {
+	for i := 0; i+SSDEEP_ROLLING_WINDOW <= len(a); i++ {
+		if strings.Contains(b, a[i:i+SSDEEP_ROLLING_WINDOW]) {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance is the levenshtein distance with a substitution costing as
+// much as a removal and an insertion.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := previous[j-1]
+			if a[i-1] != b[j-1] {
+				cost += 2
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func scoreDigests(a string, b string, blockSize int) int {
+	if len(a) > SSDEEP_SPAMSUM_LENGTH || len(b) > SSDEEP_SPAMSUM_LENGTH || !hasCommonSubstring(a, b) {
+		return 0
+	}
+
+	score := editDistance(a, b) * SSDEEP_SPAMSUM_LENGTH / (len(a) + len(b))
+	score = 100 * score / SSDEEP_SPAMSUM_LENGTH
+	if score >= 100 {
+		return 0
+	}
+	score = 100 - score
+
+	// small block sizes match short digests too easily, the score is capped
+	// by how much data the digests cover
+	if blockSize >= (99+SSDEEP_ROLLING_WINDOW)/SSDEEP_ROLLING_WINDOW*SSDEEP_MIN_BLOCK_SIZE {
+		return score
+	}
+	return min(score, blockSize/SSDEEP_MIN_BLOCK_SIZE*min(len(a), len(b)))
+}
+
+// CompareFuzzyHashes scores the similarity of two ssdeep digests from 0, no
+// similarity, to 100. Digests of block sizes more than a factor two apart
+// cannot be compared and score 0.
+func CompareFuzzyHashes(a string, b string) int {
+	blockSize1, a1, a2, err := ParseFuzzyHash(a)
+	if err != nil {
+		return 0
+	}
+	blockSize2, b1, b2, err := ParseFuzzyHash(b)
+	if err != nil {
+		return 0
+	}
+	if blockSize1 != blockSize2 && blockSize1 != 2*blockSize2 && blockSize2 != 2*blockSize1 {
+		return 0
+	}
+
+	a1, a2 = eliminateSequences(a1), eliminateSequences(a2)
+	b1, b2 = eliminateSequences(b1), eliminateSequences(b2)
+
+	switch {
+	case blockSize1 == blockSize2:
+		if a1 == b1 && a2 == b2 {
+			return 100
+		}
+		return max(scoreDigests(a1, b1, blockSize1), scoreDigests(a2, b2, 2*blockSize1))
+	case blockSize1 == 2*blockSize2:
+		return scoreDigests(a1, b2, blockSize1)
+	default:
+		return scoreDigests(a2, b1, blockSize2)
+	}
+}
+
+// FuzzyHashChunks are the pieces of an ssdeep digest two digests must share to
+// score above 0, keyed by the block size each digest was cut at. A digest too
+// short to have any is its own piece.
+func FuzzyHashChunks(fuzzyHash string) map[int][]string {
+	blockSize, digest1, digest2, err := ParseFuzzyHash(fuzzyHash)
+	if err != nil {
+		return nil
+	}
+
+	chunks := make(map[int][]string)
+	for size, digest := range map[int]string{blockSize: digest1, 2 * blockSize: digest2} {
+		digest = eliminateSequences(digest)
+		if digest == "" {
+			continue
+		}
+		if len(digest) < SSDEEP_ROLLING_WINDOW {
+			chunks[size] = []string{digest}
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for i := 0; i+SSDEEP_ROLLING_WINDOW <= len(digest); i++ {
+			chunk := digest[i : i+SSDEEP_ROLLING_WINDOW]
+			if !seen[chunk] {
+				seen[chunk] = true
+				chunks[size] = append(chunks[size], chunk)
+			}
+		}
+	}
+	return chunks
+}
@@ -0,0 +1,124 @@
+package hash
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// the examples of the python-ssdeep documentation, hashed and compared by the
+// ssdeep library
+const (
+	ssdeepSample1 = "Also called fuzzy hashes, Ctph can match inputs that have homologies."
+	ssdeepDigest1 = "3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C"
+	ssdeepSample2 = "Also called fuzzy hashes, CTPH can match inputs that have homologies."
+	ssdeepDigest2 = "3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C"
+	ssdeepScore   = 22
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestFuzzyHash(t *testing.T) {
+	vectors := []struct {
+		data   string
+		digest string
+	}{
+		{"", "3::"},
+		{ssdeepSample1, ssdeepDigest1},
+		{ssdeepSample2, ssdeepDigest2},
+	}
+	for _, vector := range vectors {
+		if got := FuzzyHash([]byte(vector.data)); got != vector.digest {
+			t.Errorf("FuzzyHash(%q) = %s, want %s", vector.data, got, vector.digest)
+		}
+	}
+}
+
+func TestFuzzyHashBlockSize(t *testing.T) {
+	for _, size := range []int{1 << 10, 1 << 16, 1 << 20} {
+		blockSize, digest1, digest2, err := ParseFuzzyHash(FuzzyHash(randomData(int64(size), size)))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if blockSize*SSDEEP_SPAMSUM_LENGTH >= 2*size {
+			t.Errorf("%d bytes: block size %d larger than needed", size, blockSize)
+		}
+		if len(digest1) > SSDEEP_SPAMSUM_LENGTH || len(digest2) > SSDEEP_SPAMSUM_LENGTH/2 {
+			t.Errorf("%d bytes: digests too long: %s:%s", size, digest1, digest2)
+		}
+		if blockSize > SSDEEP_MIN_BLOCK_SIZE && len(digest1) < SSDEEP_SPAMSUM_LENGTH/2 {
+			t.Errorf("%d bytes: block size %d leaves %d pieces", size, blockSize, len(digest1))
+		}
+	}
+}
+
+func TestCompareFuzzyHashes(t *testing.T) {
+	data := randomData(1, 1<<16)
+	digest := FuzzyHash(data)
+	if got := CompareFuzzyHashes(digest, digest); got != 100 {
+		t.Errorf("identical digests: got %d, want 100", got)
+	}
+
+	// a few bytes changed in the middle only change the pieces around them
+	changed := append([]byte{}, data...)
+	copy(changed[len(changed)/2:], "changed")
+	if got := CompareFuzzyHashes(digest, FuzzyHash(changed)); got < 80 || got == 100 {
+		t.Errorf("slightly changed data: got %d, want at least 80", got)
+	}
+
+	if got := CompareFuzzyHashes(digest, FuzzyHash(randomData(2, 1<<16))); got != 0 {
+		t.Errorf("unrelated data: got %d, want 0", got)
+	}
+
+	if got := CompareFuzzyHashes(ssdeepDigest1, ssdeepDigest2); got != ssdeepScore {
+		t.Errorf("documentation examples: got %d, want %d", got, ssdeepScore)
+	}
+}
+
+func TestCompareFuzzyHashesBlockSizes(t *testing.T) {
+	pieces := "AXGBicFlgVNhBGcL6wCrFQEvAXGBicFlgVNhBGcL6wCrFQEv"
+	if got := CompareFuzzyHashes("96:"+pieces+":x", "48:y:"+pieces); got != 100 {
+		t.Errorf("block sizes 2x apart: got %d, want 100", got)
+	}
+	if got := CompareFuzzyHashes("48:y:"+pieces, "96:"+pieces+":x"); got != 100 {
+		t.Errorf("block sizes 2x apart, swapped: got %d, want 100", got)
+	}
+	if got := CompareFuzzyHashes("192:"+pieces+":"+pieces, "48:"+pieces+":"+pieces); got != 0 {
+		t.Errorf("block sizes 4x apart: got %d, want 0", got)
+	}
+	if got := CompareFuzzyHashes("3:"+pieces, "3:"+pieces+":"); got != 0 {
+		t.Errorf("malformed digest: got %d, want 0", got)
+	}
+}
+
+func TestFuzzyHashChunks(t *testing.T) {
+	shared := func(a string, b string) bool {
+		chunksB := FuzzyHashChunks(b)
+		for blockSize, chunks := range FuzzyHashChunks(a) {
+			for _, chunk := range chunks {
+				if slices.Contains(chunksB[blockSize], chunk) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if !shared(ssdeepDigest1, ssdeepDigest2) {
+		t.Errorf("similar digests share no chunk")
+	}
+	pieces := "AXGBicFlgVNhBGcL6wCrFQEv"
+	if !shared("96:"+pieces+":x", "48:y:"+pieces) {
+		t.Errorf("digests 2x apart share no chunk")
+	}
+	if shared(FuzzyHash(randomData(1, 1<<16)), FuzzyHash(randomData(2, 1<<16))) {
+		t.Errorf("unrelated digests share a chunk")
+	}
+	if chunks := FuzzyHashChunks("3:AAAAAAAAAA:AAAAAAAAAA"); len(chunks[3]) != 1 || chunks[3][0] != "AAA" {
+		t.Errorf("runs are not shortened before chunking: %v", chunks)
+	}
+}
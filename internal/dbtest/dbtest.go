@@ -0,0 +1,181 @@
+// Package dbtest points config.Db at a database that records the statements
+// run against it, for tests to check the queries a function builds.
+package dbtest
+
+import (
+	"anti-apt-backend/config"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Db records the statements run against it. Statements change RowsAffected
+// rows, inserting the row with id 1, and queries read the rows RowsFor gives
+// them, none when it is nil.
+type Db struct {
+	RowsAffected int64
+	RowsFor      func(query string) ([]string, [][]driver.Value)
+
+	mu         sync.Mutex
+	statements []string
+	rolledBack bool
+}
+
+func (d *Db) record(query string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, query)
+}
+
+// Statements returns the statements run so far, in order.
+func (d *Db) Statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.statements...)
+}
+
+// Matching returns the statements starting with prefix.
+func (d *Db) Matching(prefix string) []string {
+	var found []string
+	for _, statement := range d.Statements() {
+		if strings.HasPrefix(statement, prefix) {
+			found = append(found, statement)
+		}
+	}
+	return found
+}
+
+// RolledBack tells whether a transaction was rolled back.
+func (d *Db) RolledBack() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rolledBack
+}
+
+func (d *Db) rows(query string) driver.Rows {
+	rows := &rows{}
+	if d.RowsFor != nil {
+		rows.columns, rows.values = d.RowsFor(query)
+	}
+	return rows
+}
+
+type conn struct{ d *Db }
+
+func (c conn) Prepare(query string) (driver.Stmt, error) { return stmt{c.d, query}, nil }
+func (c conn) Close() error                              { return nil }
+func (c conn) Begin() (driver.Tx, error)                 { return tx{c.d}, nil }
+func (c conn) CheckNamedValue(*driver.NamedValue) error  { return nil }
+
+func (c conn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.d.record(query)
+	return result{c.d.RowsAffected}, nil
+}
+
+func (c conn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(query)
+	return c.d.rows(query), nil
+}
+
+type stmt struct {
+	d     *Db
+	query string
+}
+
+func (s stmt) Close() error  { return nil }
+func (s stmt) NumInput() int { return -1 }
+func (s stmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.record(s.query)
+	return result{s.d.RowsAffected}, nil
+}
+func (s stmt) Query([]driver.Value) (driver.Rows, error) {
+	s.d.record(s.query)
+	return s.d.rows(s.query), nil
+}
+
+type result struct{ rowsAffected int64 }
+
+func (result) LastInsertId() (int64, error)   { return 1, nil }
+func (r result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+type tx struct{ d *Db }
+
+func (tx) Commit() error { return nil }
+func (t tx) Rollback() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.rolledBack = true
+	return nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// a driver name can only be registered once, each test opens its own Db under
+// its name
+type dispatcher struct {
+	mu  sync.Mutex
+	dbs map[string]*Db
+}
+
+func (d *dispatcher) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return conn{d.dbs[name]}, nil
+}
+
+var (
+	dbs      = &dispatcher{dbs: make(map[string]*Db)}
+	register sync.Once
+)
+
+// Use points config.Db at a new Db for the length of the test.
+func Use(t *testing.T) *Db {
+	t.Helper()
+	register.Do(func() { sql.Register("dbtest", dbs) })
+	d := &Db{RowsAffected: 1}
+	dbs.mu.Lock()
+	dbs.dbs[t.Name()] = d
+	dbs.mu.Unlock()
+
+	sqlDb, err := sql.Open("dbtest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDb, SkipInitializeWithVersion: true}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.Db
+	config.Db = db
+	t.Cleanup(func() {
+		config.Db = previous
+		sqlDb.Close()
+		dbs.mu.Lock()
+		delete(dbs.dbs, t.Name())
+		dbs.mu.Unlock()
+	})
+	return d
+}
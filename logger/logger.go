@@ -3,6 +3,7 @@ package logger
 import (
 	"anti-apt-backend/dao"
 	"anti-apt-backend/extras"
+	"anti-apt-backend/siem"
 	"bufio"
 	"fmt"
 	"log"
@@ -18,7 +19,37 @@ import (
 var logOnce sync.Once
 var logger *logrus.Logger
 
+var levelSeverity = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  4,
+	"error": 7,
+	"fatal": 10,
+	"panic": 10,
+}
+
+// forwardSystemLog sends system and vm logs to the SIEM, task logs are
+// covered by the task verdict events.
+func forwardSystemLog(levelType string, value interface{}) {
+	message := fmt.Sprintf("%v", value)
+	for _, prefix := range []string{"sysLog:", "vmLog:"} {
+		if strings.HasPrefix(message, prefix) {
+			siem.Forward(siem.Event{
+				Category: extras.SIEM_CATEGORY_SYSTEM,
+				Name:     strings.TrimSuffix(prefix, ":"),
+				Severity: levelSeverity[levelType],
+				Message:  strings.TrimPrefix(message, prefix),
+				Fields:   map[string]string{"level": levelType},
+			})
+			return
+		}
+	}
+}
+
 func LoggerFunc(LevelType string, v ...interface{}) {
+	if len(v) > 0 {
+		forwardSystemLog(LevelType, v[0])
+	}
 	viewLog, err := dao.FetchViewLogs()
 	if err != nil {
 		fmt.Println(err)
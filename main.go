@@ -1,23 +1,32 @@
 package main
 
 import (
+	"anti-apt-backend/alert"
 	"anti-apt-backend/auth"
 	"anti-apt-backend/config"
 	"anti-apt-backend/controller"
 	"anti-apt-backend/controller/interface_handler"
 	"anti-apt-backend/dao"
 	"anti-apt-backend/extras"
+	"anti-apt-backend/feeds"
 	"anti-apt-backend/logger"
 	"anti-apt-backend/middlewares"
+	"anti-apt-backend/service"
 	"anti-apt-backend/service/interfaces"
 	queues "anti-apt-backend/service/queue"
+	"anti-apt-backend/siem"
+	"anti-apt-backend/verdictcache"
 
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 
@@ -25,7 +34,8 @@ import (
 )
 
 var (
-	webPort = ":8082"
+	webPort         = ":8082"
+	shutdownTimeout = 30 * time.Second
 )
 
 func init() {
@@ -36,6 +46,14 @@ func init() {
 	interfaces.InitPhysicalInterfacesConfig()
 	config.DBconfig()
 	dao.ResetQueueDb()
+	service.InitUrlIntel()
+	go service.InitFuzzyHashIndex()
+	feeds.Init()
+	if err := verdictcache.Load(); err != nil {
+		log.Println("Error loading verdict cache: ", err)
+	}
+	queues.InitAttackMappings()
+	queues.InitSandboxPool()
 }
 
 // main is the entry point of the application
@@ -61,10 +79,14 @@ func main() {
 	fmt.Println("build updated sucessfully")
 	fmt.Println("Starting main server...")
 
-	go queues.NotInQueueHandler()
-	go queues.PendingQueueHandler()
-	go queues.RunningQueueHandler()
-	go queues.LogQueueHandler()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	supervisor := queues.StartQueueHandlers(ctx)
+	go siem.Run(ctx)
+	go alert.Run(ctx)
+	go verdictcache.Run(ctx)
+	go feeds.Run(ctx)
 
 	// service.CronTask()
 	// service.NewWorkerPool()
@@ -102,6 +124,8 @@ func main() {
 	wijungleGroup.PATCH("/device", controller.UpdateDevice)
 	wijungleGroup.POST("/change-password", controller.ChangePassword)
 	wijungleGroup.GET("/check-hash", controller.CheckHashOnDemand)
+	wijungleGroup.POST("/check-hashes", controller.CheckHashesOnDemand)
+	wijungleGroup.GET("/lists/export", controller.ExportListsForFirewall)
 	wijungleGroup.POST("/extend-license", controller.ExtendLicense)
 
 	newAuthGroup := router.Group("", auth.JWTAuthMiddleware())
@@ -121,6 +145,66 @@ func main() {
 
 	newAuthGroup.GET("/report", controller.GetReport)
 	newAuthGroup.GET("/report/download", controller.DownloadReport)
+	newAuthGroup.GET("/report/stix", controller.GetStixBundle)
+	newAuthGroup.GET("/task/:id/timeline", controller.GetTaskTimeline)
+	newAuthGroup.POST("/task/:id/reanalyze", controller.ReanalyzeTask)
+	newAuthGroup.GET("/task/:id/verdicts", controller.GetVerdictHistory)
+	newAuthGroup.GET("/sample-retention", controller.GetSampleRetention)
+	newAuthGroup.PUT("/sample-retention", controller.UpdateSampleRetention)
+
+	newAuthGroup.GET("/verdict-cache", controller.GetVerdictCache)
+	newAuthGroup.PUT("/verdict-cache", controller.UpdateVerdictCacheConfig)
+	newAuthGroup.POST("/verdict-cache/reload", controller.ReloadVerdictCache)
+	newAuthGroup.GET("/search/similar", controller.SearchSimilar)
+	newAuthGroup.GET("/similarity", controller.GetSimilarityConfig)
+	newAuthGroup.PUT("/similarity", controller.UpdateSimilarityConfig)
+
+	newAuthGroup.GET("/threat-feeds", controller.GetThreatFeeds)
+	newAuthGroup.POST("/threat-feeds", controller.CreateThreatFeed)
+	newAuthGroup.PUT("/threat-feeds/:id", controller.UpdateThreatFeed)
+	newAuthGroup.DELETE("/threat-feeds/:id", controller.DeleteThreatFeed)
+	newAuthGroup.POST("/threat-feeds/:id/run", controller.RunThreatFeed)
+	newAuthGroup.POST("/threat-feeds/upload", controller.UploadThreatFeed)
+	newAuthGroup.GET("/threat-feeds/runs", controller.GetThreatFeedRuns)
+	newAuthGroup.GET("/file-hashes", controller.GetFileHashes)
+
+	newAuthGroup.GET("/yara/rulesets", controller.GetYaraRulesets)
+	newAuthGroup.POST("/yara/rulesets", controller.UploadYaraRuleset)
+	newAuthGroup.PUT("/yara/rulesets/:name/enable", controller.EnableYaraRuleset)
+	newAuthGroup.PUT("/yara/rulesets/:name/disable", controller.DisableYaraRuleset)
+	newAuthGroup.PUT("/yara/rulesets/:name/version", controller.SetYaraRulesetVersion)
+	newAuthGroup.POST("/yara/rulesets/:name/test", controller.TestYaraRuleset)
+
+	newAuthGroup.GET("/archive-passwords", controller.GetArchivePasswords)
+	newAuthGroup.PUT("/archive-passwords", controller.UpdateArchivePasswords)
+
+	newAuthGroup.GET("/url-intel", controller.GetUrlIntel)
+	newAuthGroup.POST("/url-intel/import", controller.ImportUrlIntel)
+	newAuthGroup.GET("/url-intel/export", controller.ExportUrlIntel)
+	newAuthGroup.POST("/url-intel", controller.CreateUrlIntelEntry)
+	newAuthGroup.PUT("/url-intel/:id", controller.UpdateUrlIntelEntry)
+	newAuthGroup.DELETE("/url-intel/:id", controller.DeleteUrlIntel)
+	newAuthGroup.GET("/file-lists", controller.GetFileList)
+	newAuthGroup.POST("/file-lists", controller.CreateFileListEntry)
+	newAuthGroup.PUT("/file-lists/:id", controller.UpdateFileListEntry)
+	newAuthGroup.DELETE("/file-lists/:id", controller.DeleteFileListEntry)
+
+	newAuthGroup.GET("/webhooks", controller.GetWebhooks)
+	newAuthGroup.POST("/webhooks", controller.CreateWebhook)
+	newAuthGroup.PUT("/webhooks/:id", controller.UpdateWebhook)
+	newAuthGroup.DELETE("/webhooks/:id", controller.DeleteWebhook)
+	newAuthGroup.GET("/notifications", controller.GetNotifications)
+	newAuthGroup.PUT("/notifications/:id/retry", controller.RetryNotification)
+
+	newAuthGroup.GET("/siem/destinations", controller.GetSiemConfig)
+	newAuthGroup.PUT("/siem/destinations", controller.UpdateSiemConfig)
+	newAuthGroup.POST("/siem/destinations/test", controller.TestSiemDestination)
+
+	newAuthGroup.GET("/alerting", controller.GetAlertingConfig)
+	newAuthGroup.PUT("/alerting", controller.UpdateAlertingConfig)
+	newAuthGroup.POST("/alerting/test", controller.TestAlerting)
+	newAuthGroup.GET("/alerts", controller.GetAlerts)
+
 	newAuthGroup.GET("/portmapping", interface_handler.GetPortMapping)
 
 	newAuthGroup.POST("/troubleshoot", controller.Troubleshoot)
@@ -179,7 +263,30 @@ func main() {
 	// router.RunTLS(webPort, certFile, keyFile)
 
 	// Start the HTTP server and listen on the specified port
-	router.Run(webPort)
+	srv := &http.Server{
+		Addr:    webPort,
+		Handler: router,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("Error in starting server: ", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	fmt.Println("Shutting down main server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error in shutting down server: ", err)
+	}
+	if err := supervisor.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error in stopping queue handlers: ", err)
+	}
 
 	// This will update the CORS trusted origins after every api call
 	// ips, err = interfaces.FetchIps()
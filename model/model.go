@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 )
@@ -193,6 +194,35 @@ type OverrideVerdictRequest struct {
 	Comment string `json:"comment"`
 }
 
+type WebhookRequest struct {
+	Name          string   `json:"name"`
+	Url           string   `json:"url"`
+	Secret        string   `json:"secret"` // left as it is on update when empty
+	Events        []string `json:"events"`
+	Enabled       *bool    `json:"enabled"`
+	SkipTlsVerify bool     `json:"skip_tls_verify"`
+}
+
+// NotificationEvent is the payload sent to webhooks.
+type NotificationEvent struct {
+	Id           string    `json:"id"`
+	Event        string    `json:"event"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	TaskId       int       `json:"task_id"`
+	TaskType     string    `json:"task_type"`
+	ParentId     int       `json:"parent_id,omitempty"`
+	Name         string    `json:"name"` // file name or url
+	Md5          string    `json:"md5,omitempty"`
+	SHA          string    `json:"sha,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Status       string    `json:"status"`
+	Rating       string    `json:"rating"`
+	Score        float32   `json:"score"`
+	FinalVerdict string    `json:"final_verdict"`
+	OverriddenBy string    `json:"overridden_by,omitempty"`
+	ClientIp     string    `json:"client_ip,omitempty"`
+}
+
 type OverriddenVerdict struct {
 	JobID           string `json:"job_id"`
 	Filename        string `json:"filename,omitempty"`
@@ -283,10 +313,13 @@ type Task struct {
 }
 
 type Report struct {
-	Info     ReportInfo     `json:"info"`
-	Target   ReportTarget   `json:"target"`
-	Behavior ReportBehavior `json:"behavior"`
-	Debug    ReportDebug    `json:"debug"`
+	Info       ReportInfo        `json:"info"`
+	Target     ReportTarget      `json:"target"`
+	Behavior   ReportBehavior    `json:"behavior"`
+	Debug      ReportDebug       `json:"debug"`
+	Signatures []ReportSignature `json:"signatures"`
+	Network    ReportNetwork     `json:"network"`
+	Dropped    []ReportDropped   `json:"dropped"`
 }
 
 type ReportInfo struct {
@@ -317,8 +350,76 @@ type ReportFile struct {
 }
 
 type ReportBehavior struct {
-	Generic   []ReportGeneric   `json:"generic"`
-	Processes []ReportProcesses `json:"processes"`
+	Generic   []ReportGeneric       `json:"generic"`
+	Processes []ReportProcesses     `json:"processes"`
+	Summary   ReportBehaviorSummary `json:"summary"`
+}
+
+type ReportBehaviorSummary struct {
+	FileCreated   []string `json:"file_created"`
+	FileWritten   []string `json:"file_written"`
+	FileDeleted   []string `json:"file_deleted"`
+	RegkeyWritten []string `json:"regkey_written"`
+	RegkeyDeleted []string `json:"regkey_deleted"`
+	Mutex         []string `json:"mutex"`
+}
+
+type ReportSignature struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Severity    int      `json:"severity"`
+	Families    []string `json:"families"`
+	Markcount   int      `json:"markcount"`
+}
+
+type ReportNetwork struct {
+	Dns   []ReportDns  `json:"dns"`
+	Http  []ReportHttp `json:"http"`
+	Hosts []ReportHost `json:"hosts"`
+}
+
+type ReportDns struct {
+	Request string            `json:"request"`
+	Type    string            `json:"type"`
+	Answers []ReportDnsAnswer `json:"answers"`
+}
+
+type ReportDnsAnswer struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+type ReportHttp struct {
+	Uri       string `json:"uri"`
+	Method    string `json:"method"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	UserAgent string `json:"user-agent"`
+}
+
+// ReportHost is a contacted ip. Cuckoo lists them as plain strings, CAPE as
+// objects with the ip and its country.
+type ReportHost struct {
+	Ip      string `json:"ip"`
+	Country string `json:"country_name"`
+}
+
+func (h *ReportHost) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &h.Ip)
+	}
+	type host ReportHost
+	return json.Unmarshal(data, (*host)(h))
+}
+
+type ReportDropped struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Type   string `json:"type"`
+	Md5    string `json:"md5"`
+	Sha1   string `json:"sha1"`
+	Sha256 string `json:"sha256"`
 }
 
 type ReportGeneric struct {
@@ -331,6 +432,8 @@ type ReportProcesses struct {
 	ProcessPath   string  `json:"process_path"`
 	Track         bool    `json:"track"`
 	ProcessId     int     `json:"pid"`
+	ParentId      int     `json:"ppid"`
+	CommandLine   string  `json:"command_line"`
 	ProcessName   string  `json:"process_name"`
 	ModulesLength int     `json:"modules_length"`
 	FirstSeen     float64 `json:"first_seen"`
@@ -361,10 +464,253 @@ type Machine struct {
 	ResultserverPort int64       `json:"resultserver_port"`
 }
 
+type SandboxNodesConfig struct {
+	Nodes []SandboxNode `yaml:"nodes"`
+}
+
+type SandboxNode struct {
+	Id       string `yaml:"id" json:"id"`
+	Backend  string `yaml:"backend" json:"backend"`
+	BaseURL  string `yaml:"base_url" json:"base_url"`
+	APIKey   string `yaml:"api_key" json:"api_key"`
+	Capacity int    `yaml:"capacity" json:"capacity"`
+}
+
+type PrefilterPipelineConfig struct {
+	Stages []PrefilterStage `yaml:"stages" json:"stages"`
+}
+
+// PrefilterStage maps an engine outcome (detected, clean, error, timeout) to
+// block, escalate or allow. Escalate hands the file to the next stage, and to
+// the sandbox after the last one.
+type PrefilterStage struct {
+	Engine    string            `yaml:"engine" json:"engine"`
+	Enabled   bool              `yaml:"enabled" json:"enabled"`
+	Timeout   int               `yaml:"timeout" json:"timeout"` // seconds
+	FileTypes []string          `yaml:"file_types" json:"file_types"`
+	Verdicts  map[string]string `yaml:"verdicts" json:"verdicts"`
+	Score     float32           `yaml:"score" json:"score"`
+}
+
 type JobInfo struct {
-	Summary  JobSummary `json:"summary"`
-	Details  JobDetail  `json:"details"`
-	Filename string     `json:"filename"`
+	Summary          JobSummary            `json:"summary"`
+	Details          JobDetail             `json:"details"`
+	Filename         string                `json:"filename"`
+	StageResults     []TaskStageResult     `json:"stageResults"`
+	YaraMatches      []TaskYaraMatch       `json:"yaraMatches"`
+	Analysis         TaskAnalysis          `json:"analysis"`
+	AttackTechniques []TaskAttackTechnique `json:"attack_techniques"`
+	Iocs             []Ioc                 `json:"iocs"`
+	AnalysisOptions  AnalysisOptions       `json:"analysisOptions"`
+}
+
+// TaskAnalysis is what was kept of the sandbox report.
+type TaskAnalysis struct {
+	Signatures        []TaskSignature        `json:"signatures"`
+	NetworkIndicators []TaskNetworkIndicator `json:"networkIndicators"`
+	DroppedFiles      []TaskDroppedFile      `json:"droppedFiles"`
+	Activities        []TaskActivity         `json:"activities"`
+	Processes         []TaskProcess          `json:"processes"`
+}
+
+type ArchivePasswordsConfig struct {
+	Passwords []string `yaml:"passwords" json:"passwords"`
+}
+
+// SiemConfig lists where events are forwarded, each destination picks the
+// categories it receives.
+type SiemConfig struct {
+	Destinations []SiemDestination `yaml:"destinations" json:"destinations"`
+}
+
+type SiemDestination struct {
+	Name          string         `yaml:"name" json:"name"`
+	Enabled       bool           `yaml:"enabled" json:"enabled"`
+	Host          string         `yaml:"host" json:"host"`
+	Port          int            `yaml:"port" json:"port"`
+	Protocol      string         `yaml:"protocol" json:"protocol"` // udp, tcp or tls
+	Format        string         `yaml:"format" json:"format"`     // cef, leef or json
+	SkipTlsVerify bool           `yaml:"skip_tls_verify" json:"skip_tls_verify"`
+	Categories    SiemCategories `yaml:"categories" json:"categories"`
+}
+
+type SiemCategories struct {
+	Task   bool `yaml:"task" json:"task"`
+	Audit  bool `yaml:"audit" json:"audit"`
+	System bool `yaml:"system" json:"system"`
+	Ha     bool `yaml:"ha" json:"ha"`
+}
+
+// AlertingConfig holds the mail server, who gets alerts and which rules raise
+// them. Templates override the built in subject and body of a rule.
+type AlertingConfig struct {
+	Enabled       bool                     `yaml:"enabled" json:"enabled"`
+	Smtp          SmtpSettings             `yaml:"smtp" json:"smtp"`
+	Recipients    []string                 `yaml:"recipients" json:"recipients"`
+	NotifyAdmins  bool                     `yaml:"notify_admins" json:"notify_admins"`   // mail every admin's email as well
+	NotifyDevices bool                     `yaml:"notify_devices" json:"notify_devices"` // mail verdicts to the device that submitted the task
+	Digest        bool                     `yaml:"digest" json:"digest"`
+	Rules         AlertRules               `yaml:"rules" json:"rules"`
+	Templates     map[string]AlertTemplate `yaml:"templates" json:"templates"`
+}
+
+type SmtpSettings struct {
+	Host          string `yaml:"host" json:"host"`
+	Port          int    `yaml:"port" json:"port"`
+	Username      string `yaml:"username" json:"username"`
+	Password      string `yaml:"password" json:"password,omitempty"`
+	From          string `yaml:"from" json:"from"`
+	Security      string `yaml:"security" json:"security"` // none, starttls or tls
+	SkipTlsVerify bool   `yaml:"skip_tls_verify" json:"skip_tls_verify"`
+}
+
+type AlertRules struct {
+	Verdict            AlertRule `yaml:"verdict" json:"verdict"`
+	SandboxUnreachable AlertRule `yaml:"sandbox_unreachable" json:"sandbox_unreachable"` // threshold in minutes
+	DiskSpace          AlertRule `yaml:"disk_space" json:"disk_space"`                   // threshold in percent of free space
+	LicenseExpiry      AlertRule `yaml:"license_expiry" json:"license_expiry"`           // threshold in days
+	HaStateChange      AlertRule `yaml:"ha_state_change" json:"ha_state_change"`
+}
+
+type AlertRule struct {
+	Enabled   bool     `yaml:"enabled" json:"enabled"`
+	Threshold int      `yaml:"threshold,omitempty" json:"threshold,omitempty"`
+	Ratings   []string `yaml:"ratings,omitempty" json:"ratings,omitempty"`
+}
+
+type AlertTemplate struct {
+	Subject string `yaml:"subject" json:"subject"`
+	Body    string `yaml:"body" json:"body"`
+}
+
+type AlertTestRequest struct {
+	Recipient string `json:"recipient"`
+}
+
+// AnalysisOptions are the sandbox settings a file can be submitted with,
+// empty fields keep the sandbox defaults.
+type AnalysisOptions struct {
+	Timeout  int    `json:"timeout"`
+	Platform string `json:"platform"`
+	Tag      string `json:"tag"`
+	Route    string `json:"route"`
+	Package  string `json:"package"`
+	Options  string `json:"options"`
+}
+
+// ReanalyzeRequest overrides the sandbox settings of the original submission,
+// empty fields keep them.
+type ReanalyzeRequest struct {
+	Os      string `json:"os"`
+	Timeout int    `json:"timeout"`
+}
+
+// VerdictCacheConfig sets how long cached verdicts are trusted, per verdict.
+// Malware hashes reported with less than MinFeedConfidence are not blocked.
+type VerdictCacheConfig struct {
+	Allow             VerdictPolicy `yaml:"allow" json:"allow"`
+	Block             VerdictPolicy `yaml:"block" json:"block"`
+	MinFeedConfidence int           `yaml:"min_feed_confidence" json:"min_feed_confidence"`
+}
+
+// VerdictPolicy hours are counted from when the verdict was given, 0 turns
+// the limit off. Past RescanAfterHours the verdict is still answered but a
+// file submitted again is analysed again, past TtlHours it is forgotten.
+type VerdictPolicy struct {
+	TtlHours         int `yaml:"ttl_hours" json:"ttl_hours"`
+	RescanAfterHours int `yaml:"rescan_after_hours" json:"rescan_after_hours"`
+}
+
+type VerdictCacheStats struct {
+	Hashes   int       `json:"hashes"`
+	Hits     int64     `json:"hits"`
+	Misses   int64     `json:"misses"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+type VerdictCacheInfo struct {
+	Config VerdictCacheConfig `json:"config"`
+	Stats  VerdictCacheStats  `json:"stats"`
+}
+
+type ThreatFeedRequest struct {
+	Name            string `json:"name"`
+	Url             string `json:"url"`
+	Format          string `json:"format"`
+	ApiKey          string `json:"api_key"` // left as it is on update when empty
+	Enabled         *bool  `json:"enabled"`
+	SkipTlsVerify   bool   `json:"skip_tls_verify"`
+	IntervalMinutes int    `json:"interval_minutes"`
+	Confidence      *int   `json:"confidence"`
+	ExpiryDays      *int   `json:"expiry_days"`
+}
+
+// ListEntryRequest creates or updates a url intel or file list entry, a
+// missing owner is the admin making the change.
+type ListEntryRequest struct {
+	Type      string     `json:"type"`
+	Value     string     `json:"value"`
+	List      string     `json:"list"`
+	Comment   string     `json:"comment"`
+	Owner     string     `json:"owner"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// FirewallListEntry is one allow or block entry exported to the firewall.
+type FirewallListEntry struct {
+	Type      string     `json:"type"`
+	Value     string     `json:"value"`
+	List      string     `json:"list"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type BulkHashRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+// HashVerdict answers one hash of a bulk lookup, the fields after FwVerdict are
+// only set when the verdict is known
+type HashVerdict struct {
+	Hash       string     `json:"hash"`
+	HashType   string     `json:"hash_type,omitempty"`
+	Verdict    string     `json:"verdict"`
+	FwVerdict  int        `json:"fw_verdict"`
+	Rating     string     `json:"rating,omitempty"`
+	Score      float32    `json:"score"`
+	FirstSeen  *time.Time `json:"first_seen,omitempty"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	TaskId     int        `json:"task_id,omitempty"`
+	Overridden bool       `json:"overridden"`
+	Source     string     `json:"source,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// SimilarityConfig turns provisional verdicts off with a threshold of 0.
+type SimilarityConfig struct {
+	Threshold int `yaml:"threshold" json:"threshold"`
+}
+
+// SimilarSample is a sample found by fuzzy hash, with its ssdeep similarity
+// from 0 to 100.
+type SimilarSample struct {
+	TaskId        int       `json:"task_id"`
+	FileName      string    `json:"file_name"`
+	Md5           string    `json:"md5"`
+	SHA256        string    `json:"sha256"`
+	Ssdeep        string    `json:"ssdeep"`
+	Similarity    int       `json:"similarity"`
+	Rating        string    `json:"rating"`
+	Score         float32   `json:"score"`
+	FinalVerdict  string    `json:"final_verdict"`
+	SubmittedTime time.Time `json:"submitted_time"`
+}
+
+type SampleRetention struct {
+	Days int `json:"days"`
+}
+
+type YaraRulesetVersionRequest struct {
+	Version int `json:"version" binding:"required"`
 }
 
 type JobSummary struct {
@@ -395,10 +741,70 @@ type JobDetail struct {
 	VMReason        string `json:"vmReason"`
 }
 
+// TaskTimeline is a task with every state transition it went through, oldest
+// first.
+type TaskTimeline struct {
+	TaskId        int         `json:"task_id"`
+	TaskType      string      `json:"task_type"`
+	Name          string      `json:"name"`
+	Status        string      `json:"status"`
+	SubmittedTime time.Time   `json:"submitted_time"`
+	Events        []TaskEvent `json:"events"`
+}
+
 type UrlJobInfo struct {
-	Summary JobSummary   `json:"summary"`
-	Details UrlJobDetail `json:"details"`
-	Url     string       `json:"url"`
+	Summary          JobSummary            `json:"summary"`
+	Details          UrlJobDetail          `json:"details"`
+	Url              string                `json:"url"`
+	Analysis         TaskAnalysis          `json:"analysis"`
+	AttackTechniques []TaskAttackTechnique `json:"attack_techniques"`
+	Iocs             []Ioc                 `json:"iocs"`
+}
+
+// Ioc is an indicator pulled out of what was kept of a task's analysis.
+type Ioc struct {
+	Type    string `json:"type"` // domain, ipv4, ipv6, url, sha256 or mutex
+	Value   string `json:"value"`
+	Source  string `json:"source"`  // the analysis it was found in
+	Context string `json:"context"` // file name of a dropped file, the dns answers of a domain
+}
+
+type StixBundle struct {
+	Type    string       `json:"type"`
+	Id      string       `json:"id"`
+	Objects []StixObject `json:"objects"`
+}
+
+// StixObject holds the properties of the STIX 2.1 objects the bundle export
+// writes, only the ones set for the object's type are marshalled.
+type StixObject struct {
+	Type             string            `json:"type"`
+	SpecVersion      string            `json:"spec_version"`
+	Id               string            `json:"id"`
+	CreatedByRef     string            `json:"created_by_ref,omitempty"`
+	Created          string            `json:"created,omitempty"`
+	Modified         string            `json:"modified,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	IdentityClass    string            `json:"identity_class,omitempty"`
+	IndicatorTypes   []string          `json:"indicator_types,omitempty"`
+	Pattern          string            `json:"pattern,omitempty"`
+	PatternType      string            `json:"pattern_type,omitempty"`
+	ValidFrom        string            `json:"valid_from,omitempty"`
+	MalwareTypes     []string          `json:"malware_types,omitempty"`
+	IsFamily         *bool             `json:"is_family,omitempty"`
+	SampleRefs       []string          `json:"sample_refs,omitempty"`
+	FirstObserved    string            `json:"first_observed,omitempty"`
+	LastObserved     string            `json:"last_observed,omitempty"`
+	NumberObserved   int               `json:"number_observed,omitempty"`
+	ObjectRefs       []string          `json:"object_refs,omitempty"`
+	RelationshipType string            `json:"relationship_type,omitempty"`
+	SourceRef        string            `json:"source_ref,omitempty"`
+	TargetRef        string            `json:"target_ref,omitempty"`
+	Value            string            `json:"value,omitempty"`
+	Hashes           map[string]string `json:"hashes,omitempty"`
+	Confidence       int               `json:"confidence,omitempty"`
+	ValidUntil       string            `json:"valid_until,omitempty"`
 }
 
 type UrlJobDetail struct {
@@ -6,29 +6,42 @@ import (
 )
 
 type FileOnDemand struct {
-	Id                int                   `gorm:"primaryKey" json:"task_id"`
-	FileName          string                `json:"filename"`
-	ContentType       string                `json:"content_type"`
-	SubmittedTime     time.Time             `json:"submitted_time"`
-	FinishedTime      sql.NullTime          `json:"finished_time"`
-	SubmittedBy       string                `json:"submitted_by"`
-	FileCount         int                   `json:"file_count"`
-	Rating            string                `json:"rating"`
-	Score             float32               `json:"score"`
-	FinalVerdict      string                `json:"final_verdict"`
-	Status            string                `json:"status"`
-	Comments          string                `json:"comments"`
-	FromDevice        bool                  `json:"input_type"`
-	OverriddenVerdict bool                  `json:"overridden_verdict"`
-	OverriddenBy      string                `json:"overridden_by"`
-	OsSupported       string                `json:"os_supported"`
-	Md5               string                `json:"md5"`
-	SHA               string                `json:"sha"`
-	SHA256            string                `json:"sha256"`
-	ClientIp          string                `json:"client_ip"`
-	TaskLiveAnalysis  TaskLiveAnalysisTable `gorm:"foreignKey:Id;constraint:OnDelete:CASCADE" json:"task_live_analysis"`
-	TaskFinished      TaskFinishedTable     `gorm:"foreignKey:Id;constraint:OnDelete:CASCADE" json:"task_finished"`
-	TaskDuplicate     TaskDuplicateTable    `gorm:"foreignKey:Id;constraint:OnDelete:CASCADE" json:"task_duplicate"`
+	Id                 int                   `gorm:"primaryKey" json:"task_id"`
+	FileName           string                `json:"filename"`
+	ContentType        string                `json:"content_type"`
+	SubmittedTime      time.Time             `json:"submitted_time"`
+	FinishedTime       sql.NullTime          `json:"finished_time"`
+	SubmittedBy        string                `json:"submitted_by"`
+	FileCount          int                   `json:"file_count"`
+	Rating             string                `json:"rating"`
+	Score              float32               `json:"score"`
+	FinalVerdict       string                `json:"final_verdict"`
+	Status             string                `json:"status"`
+	Comments           string                `json:"comments"`
+	FromDevice         bool                  `json:"input_type"`
+	OverriddenVerdict  bool                  `json:"overridden_verdict"`
+	OverriddenBy       string                `json:"overridden_by"`
+	OsSupported        string                `json:"os_supported"`
+	Md5                string                `json:"md5"`
+	SHA                string                `json:"sha"`
+	SHA256             string                `json:"sha256"`
+	ClientIp           string                `json:"client_ip"`
+	Priority           string                `json:"priority"`
+	ParentId           int                   `gorm:"index" json:"parent_id"`     // archive the file was unpacked from
+	ReanalysisOf       int                   `gorm:"index" json:"reanalysis_of"` // first submission of the sample when this is a reanalysis
+	AnalysisTimeout    int                   `json:"analysis_timeout"`           // seconds, 0 for the sandbox default
+	VmTag              string                `json:"vm_tag"`
+	Route              string                `json:"route"`
+	Package            string                `json:"package"`
+	Options            string                `json:"options"`
+	Ssdeep             string                `gorm:"size:160" json:"ssdeep"`
+	SsdeepBlockSize    int                   `gorm:"index" json:"ssdeep_block_size"` // only digests of close block sizes compare
+	ProvisionalVerdict string                `json:"provisional_verdict"`            // of the most similar malicious sample, given before the analysis
+	SimilarTo          int                   `json:"similar_to"`
+	Similarity         int                   `json:"similarity"`
+	TaskLiveAnalysis   TaskLiveAnalysisTable `gorm:"foreignKey:Id;constraint:OnDelete:CASCADE" json:"task_live_analysis"`
+	TaskFinished       TaskFinishedTable     `gorm:"foreignKey:Id;constraint:OnDelete:CASCADE" json:"task_finished"`
+	TaskDuplicate      TaskDuplicateTable    `gorm:"foreignKey:Id;constraint:OnDelete:CASCADE" json:"task_duplicate"`
 }
 
 type UrlOnDemand struct {
@@ -39,6 +52,7 @@ type UrlOnDemand struct {
 	SubmittedBy       string       `json:"submitted_by"`
 	UrlCount          int          `json:"url_count"`
 	Rating            string       `json:"rating"`
+	Score             float32      `json:"score"`
 	FinalVerdict      string       `json:"final_verdict"`
 	Status            string       `json:"status"`
 	Comments          string       `json:"comments"`
@@ -46,35 +60,296 @@ type UrlOnDemand struct {
 	OverriddenVerdict bool         `json:"overridden_verdict"`
 	OverriddenBy      string       `json:"overridden_by"`
 	OsSupported       string       `json:"os_supported"`
+	ClientIp          string       `json:"client_ip"`
+	Priority          string       `json:"priority"`
+	ReanalysisOf      int          `gorm:"index" json:"reanalysis_of"`
+	AnalysisTimeout   int          `json:"analysis_timeout"`
 }
 
 type TaskLiveAnalysisTable struct {
-	TaskLiveAnalysisId int    `gorm:"primaryKey" json:"task_live_analysis_id"`
-	Id                 int    `json:"task_id"` // Foreign Key
-	Status             string `json:"status"`
-	SandboxId          int    `json:"sandbox_id"`
-	QueueRetryCount    int    `json:"queue_retry_count"`
-	RunningRetryCount  int    `json:"running_retry_count"`
-	SandboxRetryCount  int    `json:"sandbox_retry_count"`
-	LogQueueFailed     bool   `json:"log_queue_failed"`
-	Md5                string `json:"md5"`
-	SHA                string `json:"sha"`
-	SHA256             string `json:"sha256"`
+	TaskLiveAnalysisId int           `gorm:"primaryKey" json:"task_live_analysis_id"`
+	Id                 int           `json:"task_id"`             // Foreign Key
+	UrlId              sql.NullInt64 `gorm:"index" json:"url_id"` // set instead of Id for url tasks
+	Status             string        `json:"status"`
+	SandboxId          int           `json:"sandbox_id"`
+	SandboxNode        string        `json:"sandbox_node"`
+	RunningRetryCount  int           `json:"running_retry_count"`
+	SandboxRetryCount  int           `json:"sandbox_retry_count"`
+	RunningStartedAt   sql.NullTime  `json:"running_started_at"`
+	Version            int           `gorm:"not null;default:0" json:"version"`
+	ClaimedUntil       sql.NullTime  `gorm:"index" json:"claimed_until"`
+	Md5                string        `json:"md5"`
+	SHA                string        `json:"sha"`
+	SHA256             string        `json:"sha256"`
+}
+
+type TaskStageResult struct {
+	Id        int       `gorm:"primaryKey" json:"id"`
+	TaskId    int       `gorm:"index" json:"task_id"`
+	Stage     int       `json:"stage"`
+	Engine    string    `json:"engine"`
+	Outcome   string    `json:"outcome"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail"`
+	Duration  int64     `json:"duration"` // milliseconds
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TaskYaraMatch struct {
+	Id             int       `gorm:"primaryKey" json:"id"`
+	TaskId         int       `gorm:"index" json:"task_id"`
+	Ruleset        string    `json:"ruleset"`
+	RulesetVersion int       `json:"ruleset_version"`
+	Rule           string    `json:"rule"`
+	Tags           string    `json:"tags"` // comma separated
+	Meta           string    `json:"meta"` // json object
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// The tables below keep the sandbox report after the task is deleted from the
+// sandbox. TaskType tells file and url tasks apart, their ids overlap.
+
+type TaskSignature struct {
+	Id          int       `gorm:"primaryKey" json:"id"`
+	TaskId      int       `gorm:"index:idx_task_signature_task" json:"task_id"`
+	TaskType    string    `gorm:"size:8;index:idx_task_signature_task" json:"task_type"`
+	Name        string    `gorm:"index" json:"name"`
+	Description string    `json:"description"`
+	Severity    int       `json:"severity"`
+	Families    string    `json:"families"` // comma separated
+	Marks       int       `json:"marks"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type TaskNetworkIndicator struct {
+	Id        int       `gorm:"primaryKey" json:"id"`
+	TaskId    int       `gorm:"index:idx_task_network_task" json:"task_id"`
+	TaskType  string    `gorm:"size:8;index:idx_task_network_task" json:"task_type"`
+	Kind      string    `gorm:"size:8" json:"kind"` // dns, http or ip
+	Value     string    `gorm:"size:700;index" json:"value"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TaskDroppedFile struct {
+	Id        int       `gorm:"primaryKey" json:"id"`
+	TaskId    int       `gorm:"index:idx_task_dropped_task" json:"task_id"`
+	TaskType  string    `gorm:"size:8;index:idx_task_dropped_task" json:"task_type"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	FileType  string    `json:"file_type"`
+	Md5       string    `json:"md5"`
+	SHA       string    `json:"sha"`
+	SHA256    string    `gorm:"size:64;index" json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskActivity is one file, registry or mutex the sample touched.
+type TaskActivity struct {
+	Id        int       `gorm:"primaryKey" json:"id"`
+	TaskId    int       `gorm:"index:idx_task_activity_task" json:"task_id"`
+	TaskType  string    `gorm:"size:8;index:idx_task_activity_task" json:"task_type"`
+	Category  string    `gorm:"size:16" json:"category"`  // file, registry or mutex
+	Operation string    `gorm:"size:16" json:"operation"` // created, written, deleted
+	Value     string    `gorm:"size:700;index" json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskProcess is one process of the tree, ParentPid links it to its parent.
+type TaskProcess struct {
+	Id          int       `gorm:"primaryKey" json:"id"`
+	TaskId      int       `gorm:"index:idx_task_process_task" json:"task_id"`
+	TaskType    string    `gorm:"size:8;index:idx_task_process_task" json:"task_type"`
+	Pid         int       `json:"pid"`
+	ParentPid   int       `json:"parent_pid"`
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	CommandLine string    `gorm:"type:text" json:"command_line"`
+	FirstSeen   float64   `json:"first_seen"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttackMapping maps a detection to an ATT&CK technique. Pattern is the
+// sandbox signature name, a yara rule name or tag, or the start of a clamav
+// signature name, depending on Source.
+type AttackMapping struct {
+	Id          int    `gorm:"primaryKey" json:"id"`
+	Source      string `gorm:"size:16;index:idx_attack_mapping" json:"source"`
+	Pattern     string `gorm:"size:191;index:idx_attack_mapping" json:"pattern"`
+	TacticId    string `json:"tactic_id"`
+	Tactic      string `json:"tactic"`
+	TechniqueId string `json:"technique_id"`
+	Technique   string `json:"technique"`
+}
+
+type TaskAttackTechnique struct {
+	Id          int       `gorm:"primaryKey" json:"id"`
+	TaskId      int       `gorm:"index:idx_task_attack_task" json:"task_id"`
+	TaskType    string    `gorm:"size:8;index:idx_task_attack_task" json:"task_type"`
+	TacticId    string    `json:"tactic_id"`
+	Tactic      string    `json:"tactic"`
+	TechniqueId string    `gorm:"size:16;index" json:"technique_id"`
+	Technique   string    `json:"technique"`
+	Source      string    `json:"source"`   // signature, yara or clamav
+	Evidence    string    `json:"evidence"` // the detection that mapped to it
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
+
+// Webhook is a target notified of task events, Events is a comma separated
+// list of the events it wants, all of them when empty.
+type Webhook struct {
+	Id            int       `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"size:64;uniqueIndex" json:"name"`
+	Url           string    `json:"url"`
+	Secret        string    `json:"-"` // signs the payloads, never sent back
+	Events        string    `json:"events"`
+	Enabled       bool      `json:"enabled"`
+	SkipTlsVerify bool      `json:"skip_tls_verify"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// NotificationOutbox is one delivery of an event to a subscriber. It is kept
+// pending until it is delivered or runs out of attempts.
+type NotificationOutbox struct {
+	Id             int          `gorm:"primaryKey" json:"id"`
+	Event          string       `gorm:"size:32" json:"event"`
+	Subscriber     string       `gorm:"size:16" json:"subscriber"` // firewall or webhook
+	WebhookId      int          `gorm:"index" json:"webhook_id"`
+	Target         string       `json:"target"`
+	Payload        string       `gorm:"type:text" json:"payload"`
+	Status         string       `gorm:"size:16;index:idx_notification_due" json:"status"`
+	Attempts       int          `json:"attempts"`
+	NextAttemptAt  time.Time    `gorm:"index:idx_notification_due" json:"next_attempt_at"`
+	LastStatusCode int          `json:"last_status_code"`
+	LastError      string       `gorm:"type:text" json:"last_error"`
+	CreatedAt      time.Time    `json:"created_at"`
+	DeliveredAt    sql.NullTime `json:"delivered_at"`
+}
+
+// TaskEvent is one state transition of a task in the queue, written in the
+// same transaction as the transition itself.
+type TaskEvent struct {
+	Id                int       `gorm:"primaryKey" json:"id"`
+	TaskId            int       `gorm:"index:idx_task_event_task" json:"task_id"`
+	TaskType          string    `gorm:"size:8;index:idx_task_event_task" json:"task_type"`
+	FromState         string    `gorm:"size:32" json:"from_state"`
+	ToState           string    `gorm:"size:32" json:"to_state"`
+	Stage             string    `gorm:"size:16" json:"stage"`
+	Reason            string    `gorm:"size:32" json:"reason"`
+	Detail            string    `gorm:"type:text" json:"detail"`
+	Error             string    `gorm:"type:text" json:"error"`
+	SandboxId         int       `json:"sandbox_id"`
+	SandboxNode       string    `json:"sandbox_node"`
+	SandboxRetryCount int       `json:"sandbox_retry_count"`
+	RunningRetryCount int       `json:"running_retry_count"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// VerdictHistory keeps every verdict given to a sample. Reanalyses and
+// overrides are grouped under the first submission in OriginalId.
+type VerdictHistory struct {
+	Id           int       `gorm:"primaryKey" json:"id"`
+	TaskId       int       `json:"task_id"`
+	TaskType     string    `gorm:"size:8;index:idx_verdict_history_original" json:"task_type"`
+	OriginalId   int       `gorm:"index:idx_verdict_history_original" json:"original_id"`
+	Rating       string    `json:"rating"`
+	Score        float32   `json:"score"`
+	FinalVerdict string    `json:"final_verdict"`
+	Source       string    `gorm:"size:16" json:"source"`
+	ChangedBy    string    `json:"changed_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Alert is one mail raised by an alert rule. Low severity alerts wait with
+// status digest until the hourly digest picks them up.
+type Alert struct {
+	Id         int          `gorm:"primaryKey" json:"id"`
+	Rule       string       `gorm:"size:32" json:"rule"`
+	Severity   string       `gorm:"size:8" json:"severity"`
+	Subject    string       `json:"subject"`
+	Body       string       `gorm:"type:text" json:"body"`
+	Recipients string       `gorm:"type:text" json:"recipients"`
+	Status     string       `gorm:"size:16;index" json:"status"`
+	Error      string       `gorm:"type:text" json:"error"`
+	Attempts   int          `json:"attempts"`
+	CreatedAt  time.Time    `gorm:"index" json:"created_at"`
+	SentAt     sql.NullTime `json:"sent_at"`
+}
+
+// every upload of a ruleset is kept as a new version, only the active version
+// of an enabled ruleset is scanned with
+type YaraRuleset struct {
+	Id         int       `gorm:"primaryKey" json:"id"`
+	Name       string    `gorm:"index" json:"name"`
+	Version    int       `json:"version"`
+	Enabled    bool      `json:"enabled"`
+	Active     bool      `json:"active"`
+	FilePath   string    `json:"file_path"`
+	SHA256     string    `json:"sha256"`
+	UploadedBy string    `json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UrlIntel is a url reputation entry. Lookups derive every key a url can be
+// matched on and fetch them through the type/value index.
+type UrlIntel struct {
+	Id        int          `gorm:"primaryKey" json:"id"`
+	Type      string       `gorm:"size:16;uniqueIndex:idx_url_intel_entry" json:"type"`
+	Value     string       `gorm:"size:700;uniqueIndex:idx_url_intel_entry" json:"value"`
+	List      string       `gorm:"size:16" json:"list"` // allow or block
+	Source    string       `json:"source"`
+	Comment   string       `gorm:"type:text" json:"comment"`
+	Owner     string       `json:"owner"`
+	ExpiresAt sql.NullTime `gorm:"index" json:"expires_at"`
+	CreatedBy string       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedBy string       `json:"updated_by"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
+// FileListEntry is a custom allow or block entry for files, matched on a hash,
+// a file name glob or the thumbprint of the signing certificate.
+type FileListEntry struct {
+	Id        int          `gorm:"primaryKey" json:"id"`
+	Type      string       `gorm:"size:16;uniqueIndex:idx_file_list_entry" json:"type"`
+	Value     string       `gorm:"size:255;uniqueIndex:idx_file_list_entry" json:"value"`
+	List      string       `gorm:"size:16" json:"list"` // allow or block
+	Comment   string       `gorm:"type:text" json:"comment"`
+	Owner     string       `json:"owner"`
+	ExpiresAt sql.NullTime `gorm:"index" json:"expires_at"`
+	CreatedBy string       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedBy string       `json:"updated_by"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
+// FuzzyHashChunk indexes a file by the pieces of its ssdeep digest, similarity
+// searches only score the files sharing a piece with the digest searched for.
+type FuzzyHashChunk struct {
+	Id        int    `gorm:"primaryKey" json:"id"`
+	TaskId    int    `gorm:"index" json:"task_id"`
+	BlockSize int    `gorm:"index:idx_fuzzy_hash_chunk" json:"block_size"`
+	Chunk     string `gorm:"type:varbinary(7);index:idx_fuzzy_hash_chunk" json:"chunk"` // digests are case sensitive
 }
 
 type TaskFinishedTable struct {
-	TaskFinishedId int  `gorm:"primaryKey" json:"task_finished_id"`
-	Id             int  `json:"task_id"` // Foreign Key
-	SandboxId      int  `json:"sandbox_id"`
-	Aborted        bool `json:"aborted"`
+	TaskFinishedId int           `gorm:"primaryKey" json:"task_finished_id"`
+	Id             int           `json:"task_id"` // Foreign Key
+	UrlId          sql.NullInt64 `gorm:"index" json:"url_id"`
+	SandboxId      int           `json:"sandbox_id"`
+	SandboxNode    string        `json:"sandbox_node"`
+	Aborted        bool          `json:"aborted"`
 }
 
 type TaskDuplicateTable struct {
-	TaskDuplicateId int    `gorm:"primaryKey" json:"task_duplicate_id"`
-	Id              int    `json:"task_id"`
-	Md5             string `json:"md5"`
-	SHA             string `json:"sha"`
-	SHA256          string `json:"sha256"`
+	TaskDuplicateId int           `gorm:"primaryKey" json:"task_duplicate_id"`
+	Id              int           `json:"task_id"`
+	UrlId           sql.NullInt64 `gorm:"index" json:"url_id"`
+	Md5             string        `json:"md5"`
+	SHA             string        `json:"sha"`
+	SHA256          string        `json:"sha256"`
 }
 
 type AuditTable struct {
@@ -89,9 +364,56 @@ type AuditTable struct {
 
 // }
 
+// FileHashes are the known malware hashes, pulled from the threat feeds or
+// uploaded. An entry is kept once under the strongest of its hashes.
 type FileHashes struct {
-	Id     int    `json:"id" gorm:"primaryKey"`
-	Sha1   string `json:"sha1"`
-	Sha256 string `json:"sha256"`
-	Md5    string `json:"md5"`
+	Id         int            `json:"id" gorm:"primaryKey"`
+	Sha1       string         `json:"sha1"`
+	Sha256     string         `json:"sha256"`
+	Md5        string         `json:"md5"`
+	HashKey    sql.NullString `json:"-" gorm:"size:64;uniqueIndex"`
+	Source     string         `gorm:"size:64;index" json:"source"`
+	FeedId     int            `gorm:"index" json:"feed_id"`
+	Confidence int            `json:"confidence"`
+	ExpiresAt  sql.NullTime   `gorm:"index" json:"expires_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// ThreatFeed is a source of malware hashes pulled every IntervalMinutes.
+type ThreatFeed struct {
+	Id              int          `gorm:"primaryKey" json:"id"`
+	Name            string       `gorm:"size:64;uniqueIndex" json:"name"`
+	Url             string       `json:"url"`
+	Format          string       `gorm:"size:16" json:"format"`
+	ApiKey          string       `json:"-"` // sent as the Authorization header, never sent back
+	Enabled         bool         `json:"enabled"`
+	SkipTlsVerify   bool         `json:"skip_tls_verify"`
+	IntervalMinutes int          `json:"interval_minutes"`
+	Confidence      int          `json:"confidence"`  // of entries that do not carry their own
+	ExpiryDays      int          `json:"expiry_days"` // 0 keeps the entries until the feed drops them
+	LastRunAt       sql.NullTime `json:"last_run_at"`
+	LastStatus      string       `gorm:"size:16" json:"last_status"`
+	CreatedBy       string       `json:"created_by"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+// ThreatFeedRun is the log of one pull or upload of a feed.
+type ThreatFeedRun struct {
+	Id         int          `gorm:"primaryKey" json:"id"`
+	FeedId     int          `gorm:"index" json:"feed_id"` // 0 for uploads
+	Source     string       `gorm:"size:64" json:"source"`
+	Kind       string       `gorm:"size:16" json:"kind"` // one of extras.FEED_TRIGGER_*
+	Format     string       `gorm:"size:16" json:"format"`
+	Status     string       `gorm:"size:16" json:"status"`
+	Parsed     int          `json:"parsed"`     // entries read from the feed
+	Duplicates int          `json:"duplicates"` // entries repeated within the feed
+	Rejected   int          `json:"rejected"`
+	Stored     int          `json:"stored"`
+	Expired    int          `json:"expired"` // expired entries removed after the run
+	Dropped    int          `json:"dropped"` // entries the feed no longer lists, removed after the run
+	Error      string       `gorm:"type:text" json:"error"`
+	StartedBy  string       `json:"started_by"`
+	StartedAt  time.Time    `gorm:"index" json:"started_at"`
+	FinishedAt sql.NullTime `json:"finished_at"`
 }
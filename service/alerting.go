@@ -0,0 +1,135 @@
+package service
+
+import (
+	"anti-apt-backend/alert"
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+)
+
+var alertRatings = []string{string(model.Critical), string(model.HighRisk), string(model.MediumRisk), string(model.LowRisk)}
+
+func validateAlertingConfig(alertingConfig *model.AlertingConfig) error {
+	smtp := &alertingConfig.Smtp
+	smtp.Host = strings.TrimSpace(smtp.Host)
+	smtp.From = strings.TrimSpace(smtp.From)
+	smtp.Security = strings.ToLower(strings.TrimSpace(smtp.Security))
+	if smtp.Security == extras.EMPTY_STRING {
+		smtp.Security = extras.SMTP_SECURITY_STARTTLS
+	}
+
+	if alertingConfig.Enabled && (smtp.Host == extras.EMPTY_STRING || smtp.Port <= 0 || smtp.Port > 65535 || smtp.From == extras.EMPTY_STRING) {
+		return extras.ErrSmtpServer
+	}
+	if !slices.Contains([]string{extras.SMTP_SECURITY_NONE, extras.SMTP_SECURITY_STARTTLS, extras.SMTP_SECURITY_TLS}, smtp.Security) {
+		return extras.ErrSmtpSecurity
+	}
+	if smtp.From != extras.EMPTY_STRING {
+		if _, err := mail.ParseAddress(smtp.From); err != nil {
+			return fmt.Errorf("%w: %s", extras.ErrAlertRecipient, smtp.From)
+		}
+	}
+
+	var recipients []string
+	for _, recipient := range alertingConfig.Recipients {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == extras.EMPTY_STRING || slices.Contains(recipients, recipient) {
+			continue
+		}
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("%w: %s", extras.ErrAlertRecipient, recipient)
+		}
+		recipients = append(recipients, recipient)
+	}
+	alertingConfig.Recipients = recipients
+
+	rules := &alertingConfig.Rules
+	for _, rule := range []model.AlertRule{rules.Verdict, rules.SandboxUnreachable, rules.DiskSpace, rules.LicenseExpiry, rules.HaStateChange} {
+		if rule.Threshold < 0 {
+			return extras.ErrAlertThreshold
+		}
+	}
+	if rules.DiskSpace.Threshold > 100 {
+		return extras.ErrAlertThreshold
+	}
+	for _, rating := range rules.Verdict.Ratings {
+		if !slices.Contains(alertRatings, rating) {
+			return extras.ErrAlertRating
+		}
+	}
+
+	return alert.ValidateTemplates(alertingConfig.Templates)
+}
+
+// GetAlertingConfig never returns the smtp password.
+func GetAlertingConfig() model.APIResponse {
+	alertingConfig, _ := config.ReadAlertingConfig()
+	alertingConfig.Smtp.Password = extras.EMPTY_STRING
+	if alertingConfig.Recipients == nil {
+		alertingConfig.Recipients = []string{}
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, alertingConfig)
+}
+
+// UpdateAlertingConfig keeps the saved smtp password when none is given.
+func UpdateAlertingConfig(alertingConfig model.AlertingConfig) model.APIResponse {
+	if err := validateAlertingConfig(&alertingConfig); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_ALERTING_CONFIG, err)
+	}
+
+	if alertingConfig.Smtp.Password == extras.EMPTY_STRING {
+		saved, _ := config.ReadAlertingConfig()
+		alertingConfig.Smtp.Password = saved.Smtp.Password
+	}
+	if err := config.UpdateAlertingConfig(alertingConfig); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+
+	alertingConfig.Smtp.Password = extras.EMPTY_STRING
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, alertingConfig)
+}
+
+// TestAlerting mails a test alert with the saved settings.
+func TestAlerting(req model.AlertTestRequest) model.APIResponse {
+	req.Recipient = strings.TrimSpace(req.Recipient)
+	if req.Recipient != extras.EMPTY_STRING {
+		if _, err := mail.ParseAddress(req.Recipient); err != nil {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_ALERTING_CONFIG, fmt.Errorf("%w: %s", extras.ErrAlertRecipient, req.Recipient))
+		}
+	}
+
+	alertingConfig, err := config.ReadAlertingConfig()
+	if err != nil || alertingConfig.Smtp.Host == extras.EMPTY_STRING {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_ALERTING_CONFIG, extras.ErrSmtpServer)
+	}
+	if err = alert.Test(alertingConfig, req.Recipient); err != nil {
+		return model.NewErrorResponse(http.StatusBadGateway, extras.ERR_ALERT_TEST_FAILED, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, "Test alert sent")
+}
+
+// GetAlerts lists the raised alerts, newest first.
+func GetAlerts(status string) model.APIResponse {
+	queryString := fmt.Sprintf("SELECT * FROM %s", extras.AlertTable)
+	if status != extras.EMPTY_STRING {
+		queryString += fmt.Sprintf(" WHERE status = '%s'", util.EscapeSqlString(status))
+	}
+	queryString += " ORDER BY id DESC LIMIT 500"
+
+	alerts := []model.Alert{}
+	alertRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &alerts,
+	}
+	if err := dao.GormOperations(&alertRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, alerts)
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/hash"
+	"anti-apt-backend/model"
+	queues "anti-apt-backend/service/queue"
+	"anti-apt-backend/util"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/h2non/filetype"
+)
+
+// createArchiveTasks unpacks the submitted archive and queues every member not
+// already known by hash as a child task of fod. The archive itself is not sent
+// to the sandbox, it gets the worst verdict of its members once they finish.
+// It returns false when the archive should be analysed as one file instead.
+func createArchiveTasks(fod model.FileOnDemand, fp string, ip string, password string) (model.APIResponse, bool) {
+	var resp model.APIResponse
+
+	respMes := "File: " + fod.FileName + " successfully uploaded"
+	if fod.FromDevice {
+		respMes = fmt.Sprintf("%d", fod.Id)
+		fod.ClientIp = ip
+	}
+
+	unpackDir, err := os.MkdirTemp(extras.SANDBOX_FILE_PATHS, "unpack-")
+	if err != nil {
+		return resp, false
+	}
+	defer os.RemoveAll(unpackDir)
+
+	members, err := util.UnpackArchive(fp, fod.FileName, unpackDir, archivePasswords(password))
+	if errors.Is(err, extras.ErrArchiveBomb) || errors.Is(err, extras.ErrArchiveEncrypted) {
+		if errors.Is(err, extras.ErrArchiveBomb) {
+			fod.Status = extras.ARCHIVE_BOMB_DETECTED
+			fod.Score = 10
+			fod.Rating = string(model.Critical)
+		} else {
+			fod.Status = extras.ARCHIVE_ENCRYPTED
+			fod.Rating = string(model.Unknown)
+		}
+		if err = saveUnanalysedFile(fod); err != nil {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err), true
+		}
+
+		go deleteLocalTask(fod.Id)
+		go queues.NotifyTask(extras.EVENT_TASK_REPORTED, extras.TASK_TYPE_FILE, fod.Id)
+		return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes), true
+	}
+	if err != nil || len(members) == 0 {
+		// slog.Println("ARCHIVE NOT UNPACKED, ANALYSING AS ONE FILE: ", fod.Id, err)
+		return resp, false
+	}
+
+	// not finished by FinishArchivesIfDone until every member is saved
+	fod.FileCount = len(members)
+	fod.Status = extras.ARCHIVE_UNPACKING
+	queryString := fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, priority, status, md5, sha, sha256) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s')", extras.FileOnDemandTable, fod.Id, util.EscapeSqlString(fod.FileName), fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fod.FromDevice, fod.Priority, fod.Status, fod.Md5, fod.SHA, fod.SHA256)
+	fodRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+	}
+	if err = dao.GormOperations(&fodRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err), true
+	}
+	go deleteLocalTask(fod.Id)
+
+	saved := 0
+	for _, member := range members {
+		childId, err := nextFileOnDemandId()
+		if err != nil {
+			// slog.Println("ERROR FROM DATABASE: ", err)
+			continue
+		}
+
+		child := model.FileOnDemand{
+			Id:            childId,
+			FileName:      member.Name,
+			SubmittedTime: time.Now(),
+			SubmittedBy:   fod.SubmittedBy,
+			Comments:      fod.Comments,
+			FileCount:     1,
+			FromDevice:    fod.FromDevice,
+			ClientIp:      fod.ClientIp,
+			Priority:      fod.Priority,
+			ParentId:      fod.Id,
+		}
+		setAnalysisOptions(&child, analysisOptionsOf(fod))
+
+		if member.Encrypted {
+			child.Status = extras.ARCHIVE_ENCRYPTED
+			child.Rating = string(model.Unknown)
+			if err = saveUnanalysedFile(child); err != nil {
+				// slog.Println("ERROR WHILE SAVING ARCHIVE MEMBER: ", member.Name, err)
+				continue
+			}
+			saved++
+			continue
+		}
+
+		childFp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", childId)
+		if err = os.Rename(member.Path, childFp); err != nil {
+			// slog.Println("ERROR WHILE MOVING ARCHIVE MEMBER: ", member.Name, err)
+			continue
+		}
+		child.ContentType = contentTypeOf(childFp)
+		child.Md5, _ = hash.CalculateHash(childFp, "md5")
+		child.SHA, _ = hash.CalculateHash(childFp, "sha1")
+		child.SHA256, _ = hash.CalculateHash(childFp, "sha256")
+		fuzzyHashOf(&child, childFp)
+
+		if !hasAnalysisOptions(child) && !inFileLists(child, childFp) {
+			resp = checkIfHashAlreadyPresent(child, child.Md5, child.SHA, child.SHA256, ip)
+			if resp.StatusCode == http.StatusOK {
+				go deleteLocalTask(child.Id)
+				saved++
+				continue
+			}
+		}
+
+		if err = queueFileOnDemand(child); err != nil {
+			// slog.Println("ERROR WHILE QUEUEING ARCHIVE MEMBER: ", member.Name, err)
+			go deleteLocalTask(child.Id)
+			continue
+		}
+		scoreProvisionalVerdict(child)
+		saved++
+	}
+
+	// with no member to wait on nothing would finish the archive, it is not
+	// known to be clean
+	if saved == 0 {
+		queryString = fmt.Sprintf("UPDATE %s SET status = '%s', rating = '%s', final_verdict = '%s', finished_time = '%s' WHERE id = %d", extras.FileOnDemandTable, extras.ARCHIVE_NOT_QUEUED, model.Unknown, extras.BLOCK, time.Now().Format(extras.TIME_FORMAT), fod.Id)
+		if err = config.Db.Exec(queryString).Error; err != nil {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err), true
+		}
+		go queues.NotifyTask(extras.EVENT_TASK_REPORTED, extras.TASK_TYPE_FILE, fod.Id)
+		return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes), true
+	}
+
+	queryString = fmt.Sprintf("UPDATE %s SET status = '%s' WHERE id = %d", extras.FileOnDemandTable, extras.ARCHIVE_UNPACKED, fod.Id)
+	if err = config.Db.Exec(queryString).Error; err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err), true
+	}
+
+	// members found by hash are finished already
+	queues.FinishArchivesIfDone()
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes), true
+}
+
+// saveUnanalysedFile saves fod as finished without analysis. It is blocked, an
+// archive that could not be looked into is not known to be clean.
+func saveUnanalysedFile(fod model.FileOnDemand) error {
+	fod.FinalVerdict = extras.BLOCK
+	fod.FinishedTime = sql.NullTime{Time: time.Now(), Valid: true}
+
+	queryString := fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, priority, status, finished_time, rating, final_verdict, md5, sha, sha256, score, parent_id) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f, %d)", extras.FileOnDemandTable, fod.Id, util.EscapeSqlString(fod.FileName), fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fod.FromDevice, fod.Priority, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score, fod.ParentId)
+	fodRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+	}
+	return dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
+}
+
+// archivePasswords puts the password given with the submission before the
+// appliance wide list.
+func archivePasswords(password string) []string {
+	var passwords []string
+	if password != extras.EMPTY_STRING {
+		passwords = append(passwords, password)
+	}
+
+	passwordsConfig, err := config.ReadArchivePasswordsConfig()
+	if err != nil {
+		return append(passwords, extras.DEFAULT_ARCHIVE_PASSWORDS...)
+	}
+	for _, p := range passwordsConfig.Passwords {
+		if p != password {
+			passwords = append(passwords, p)
+		}
+	}
+	return passwords
+}
+
+func GetArchivePasswords() model.APIResponse {
+	passwordsConfig, err := config.ReadArchivePasswordsConfig()
+	if err != nil {
+		passwordsConfig.Passwords = extras.DEFAULT_ARCHIVE_PASSWORDS
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, passwordsConfig)
+}
+
+func UpdateArchivePasswords(passwordsConfig model.ArchivePasswordsConfig) model.APIResponse {
+	var passwords []string
+	for _, password := range passwordsConfig.Passwords {
+		if password != extras.EMPTY_STRING && !slices.Contains(passwords, password) {
+			passwords = append(passwords, password)
+		}
+	}
+	passwordsConfig.Passwords = passwords
+
+	if err := config.UpdateArchivePasswordsConfig(passwordsConfig); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, passwordsConfig)
+}
+
+func contentTypeOf(fp string) string {
+	f, err := os.Open(fp)
+	if err != nil {
+		return extras.EMPTY_STRING
+	}
+	defer f.Close()
+
+	head := make([]byte, 8192)
+	n, _ := f.Read(head)
+	kind, _ := filetype.Match(head[:n])
+	if kind == filetype.Unknown {
+		return "application/octet-stream"
+	}
+	return kind.MIME.Value
+}
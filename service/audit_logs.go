@@ -2,8 +2,11 @@ package service
 
 import (
 	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
 	"anti-apt-backend/model"
+	"anti-apt-backend/siem"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -14,8 +17,10 @@ func CreateAuditLogs(resp *model.APIResponse, message string, auditType string,
 		}
 	}()
 
+	severity := 1
 	if resp.StatusCode != http.StatusOK {
 		message = resp.Message
+		severity = 3
 	}
 
 	auditLog := model.AuditTable{
@@ -25,6 +30,15 @@ func CreateAuditLogs(resp *model.APIResponse, message string, auditType string,
 		TimeStamp: time.Now(),
 	}
 
+	siem.Forward(siem.Event{
+		Category: extras.SIEM_CATEGORY_AUDIT,
+		Name:     strings.ToLower(strings.ReplaceAll(auditType, " ", "_")),
+		Severity: severity,
+		Message:  message,
+		Time:     auditLog.TimeStamp,
+		Fields:   map[string]string{"user": adminName},
+	})
+
 	if err := config.Db.Model(&model.AuditTable{}).Create(&auditLog).Error; err != nil {
 		// slog.Error("Failed to create audit log: ", err)
 		return err
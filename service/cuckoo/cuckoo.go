@@ -17,6 +17,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -24,9 +25,19 @@ var (
 	BASE_URL = "http://127.0.0.1:1337"
 )
 
-func (c *Client) CreateTaskFile(ctx context.Context, id int, fp string) (sandboxId int, err error) {
+// baseURL lets a client built for a specific sandbox node override the
+// appliance-wide BASE_URL.
+func (c *Client) baseURL() string {
+	if c.BaseURL != extras.EMPTY_STRING {
+		return c.BaseURL
+	}
+	return BASE_URL
+}
+
+// CreateTaskFile uploads fp, task carries the optional cuckoo task settings.
+func (c *Client) CreateTaskFile(ctx context.Context, id int, fp string, task *model.Task) (sandboxId int, err error) {
 
-	URL := fmt.Sprintf("%s/tasks/create/file", BASE_URL)
+	URL := fmt.Sprintf("%s/tasks/create/file", c.baseURL())
 
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
@@ -60,6 +71,13 @@ func (c *Client) CreateTaskFile(ctx context.Context, id int, fp string) (sandbox
 		return -1, err
 	}
 
+	for field, value := range taskFields(task) {
+		err = writer.WriteField(field, value)
+		if err != nil {
+			return -1, err
+		}
+	}
+
 	err = writer.Close()
 	if err != nil {
 		// slog.Println("ERROR WHILE CLOSING WRITER: ", err)
@@ -110,14 +128,46 @@ func (c *Client) CreateTaskFile(ctx context.Context, id int, fp string) (sandbox
 	return taskResp.TaskId, nil
 }
 
-func (c *Client) CreateTaskUrl(ctx context.Context, id int, urlToSubmit string) (sandboxId int, err error) {
+// taskFields are the optional task settings sent along with a file or url.
+func taskFields(task *model.Task) map[string]string {
+	fields := make(map[string]string)
+	if task == nil {
+		return fields
+	}
+	if task.Priority > 0 {
+		fields["priority"] = strconv.FormatInt(task.Priority, 10)
+	}
+	if platform, ok := task.Platform.(string); ok && platform != extras.EMPTY_STRING {
+		fields["platform"] = platform
+	}
+	if task.Timeout > 0 {
+		fields["timeout"] = strconv.FormatInt(task.Timeout, 10)
+	}
+	if len(task.Tags) > 0 {
+		fields["tags"] = strings.Join(task.Tags, ",")
+	}
+	if pkg, ok := task.Package.(string); ok && pkg != extras.EMPTY_STRING {
+		fields["package"] = pkg
+	}
+	if options, ok := task.Options.(string); ok && options != extras.EMPTY_STRING {
+		fields["options"] = options
+	}
+	return fields
+}
+
+// CreateTaskUrl submits urlToSubmit, task carries the optional cuckoo task
+// settings.
+func (c *Client) CreateTaskUrl(ctx context.Context, id int, urlToSubmit string, task *model.Task) (sandboxId int, err error) {
 
-	URL := fmt.Sprintf("%s/tasks/create/url", BASE_URL)
+	URL := fmt.Sprintf("%s/tasks/create/url", c.baseURL())
 
 	urlToSubmit = strings.TrimSpace(urlToSubmit)
 
 	body := url.Values{}
 	body.Set("url", urlToSubmit)
+	for field, value := range taskFields(task) {
+		body.Set(field, value)
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", URL, strings.NewReader(body.Encode()))
 	if err != nil {
@@ -164,7 +214,7 @@ func (c *Client) CreateTaskUrl(ctx context.Context, id int, urlToSubmit string)
 
 func (c *Client) TasksView(ctx context.Context, taskID int) (*model.Task, error) {
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/view/%d", BASE_URL, taskID), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/view/%d", c.baseURL(), taskID), nil)
 	if err != nil {
 		logger.LoggerFunc("error", logger.LoggerMessage("taskLog:couldn't create request"))
 		return nil, err
@@ -204,7 +254,7 @@ func (c *Client) TasksView(ctx context.Context, taskID int) (*model.Task, error)
 
 func (c *Client) TasksReport(ctx context.Context, taskID int) (*model.Report, error) {
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/report/%d", BASE_URL, taskID), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/report/%d", c.baseURL(), taskID), nil)
 	if err != nil {
 		// logger.LoggerFunc("error", logger.LoggerMessage("taskLog:couldn't create request"))
 		return nil, err
@@ -262,9 +312,7 @@ func (c *Client) TasksReport(ctx context.Context, taskID int) (*model.Report, er
 
 func (c *Client) ListMachines(ctx context.Context) ([]*model.Machine, error) {
 
-	c.BaseURL = "http://127.0.0.1:1337"
-
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/machines/list", BASE_URL), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/machines/list", c.baseURL()), nil)
 	if err != nil {
 		// logger.LoggerFunc("error", logger.LoggerMessage("taskLog:couldn't create request"))
 		// fmt.Println("error in creating request: ", err)
@@ -300,7 +348,7 @@ func (c *Client) ListMachines(ctx context.Context) ([]*model.Machine, error) {
 
 func (c *Client) ViewMachine(ctx context.Context, machineName string) (*model.Machine, error) {
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/machines/view/%s", BASE_URL, machineName), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/machines/view/%s", c.baseURL(), machineName), nil)
 	if err != nil {
 		// logger.LoggerFunc("error", logger.LoggerMessage("taskLog:couldn't create request"))
 		return nil, err
@@ -334,7 +382,7 @@ func (c *Client) ViewMachine(ctx context.Context, machineName string) (*model.Ma
 
 func (c *Client) TasksDelete(ctx context.Context, taskID int) (err error) {
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/delete/%d", BASE_URL, taskID), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/delete/%d", c.baseURL(), taskID), nil)
 	if err != nil {
 		return err
 	}
@@ -363,7 +411,7 @@ func (c *Client) TasksDelete(ctx context.Context, taskID int) (err error) {
 
 func (c *Client) ListAllTasks(ctx context.Context) ([]*model.Task, error) {
 
-	URL := fmt.Sprintf("%s/tasks/list", BASE_URL)
+	URL := fmt.Sprintf("%s/tasks/list", c.baseURL())
 	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
 	if err != nil {
 		return nil, err
@@ -42,41 +42,26 @@ func readAPIKeyFromFile() (string, error) {
 
 func (c *Client) MakeRequest(req *http.Request) (*http.Response, error) {
 
-	if API_KEY == extras.EMPTY_STRING {
-		// fmt.Println("Setting API key from config file")
-		apiKey, _ := readAPIKeyFromFile()
-		// if err != nil {
-		// 	// fmt.Println("error in reading API key from file: ", err)
-		// 	return nil, err
-		// }
-		API_KEY = apiKey
+	if c.APIKey == extras.EMPTY_STRING {
+		if API_KEY == extras.EMPTY_STRING {
+			// fmt.Println("Setting API key from config file")
+			apiKey, _ := readAPIKeyFromFile()
+			API_KEY = apiKey
+		}
+		c.APIKey = API_KEY
 	}
 
-	// if BASE_URL == extras.EMPTY_STRING {
-	// 	fmt.Println("Setting Base URL from config file")
-	// 	baseUrl, err := readBaseURLFromFile()
-	// 	if err != nil {
-	// 		fmt.Println("Failed to set base URL from conf file: ", err)
-	// 		return nil, err
-	// 	}
-	// 	BASE_URL = baseUrl
-	// }
-	// log.Println("API KEY: ", API_KEY)
-
-	if API_KEY == extras.EMPTY_STRING {
+	if c.APIKey == extras.EMPTY_STRING {
 		// fmt.Println("API key not found")
 		return nil, fmt.Errorf("API key not found")
 	}
 
-	if BASE_URL == extras.EMPTY_STRING {
+	if c.baseURL() == extras.EMPTY_STRING {
 		fmt.Println("Base URL not found")
 		return nil, fmt.Errorf("base URL not found")
 	}
 
-	c.APIKey = API_KEY
-	c.BaseURL = BASE_URL
-
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", API_KEY))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
 
 	resp, err := c.Client.Do(req)
 	if err != nil {
@@ -8,11 +8,13 @@ import (
 	"anti-apt-backend/util"
 	"bufio"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -65,7 +67,17 @@ type HdwrUsage struct {
 	Time string  `json:"time"`
 }
 
-func Dashboard(action string) model.APIResponse {
+type AttackTechniqueCount struct {
+	TacticId    string `json:"tactic_id"`
+	Tactic      string `json:"tactic"`
+	TechniqueId string `json:"technique_id"`
+	Technique   string `json:"technique"`
+	Tasks       int    `json:"tasks"`
+}
+
+// window is only read by the actions that take one, like 12h or 7d. It
+// defaults to the last 24 hours.
+func Dashboard(action string, window string) model.APIResponse {
 	switch action {
 	case "scanned-count-per-hour":
 		return ScannedCount(time.Hour)
@@ -91,11 +103,53 @@ func Dashboard(action string) model.APIResponse {
 		return GetHdwrData()
 	case "get-device":
 		return GetDevice()
+	case "attack-techniques":
+		duration, err := parseTimeWindow(window)
+		if err != nil {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_TIME_WINDOW, err)
+		}
+		return AttackTechniqueCounts(duration)
 	}
 
 	return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_ACTION_TYPE, extras.ErrInvalidActionType)
 }
 
+func parseTimeWindow(window string) (time.Duration, error) {
+	if window == extras.EMPTY_STRING {
+		return 24 * time.Hour, nil
+	}
+	if days, found := strings.CutSuffix(window, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, extras.ErrInvalidTimeWindow
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return 0, extras.ErrInvalidTimeWindow
+	}
+	return duration, nil
+}
+
+// AttackTechniqueCounts counts the tasks each ATT&CK technique was seen in over
+// the last duration, most seen first.
+func AttackTechniqueCounts(duration time.Duration) model.APIResponse {
+	counts := []AttackTechniqueCount{}
+	since := time.Now().Add(-duration).Format(extras.TIME_FORMAT)
+
+	queryString := fmt.Sprintf("SELECT tactic_id, tactic, technique_id, technique, COUNT(DISTINCT task_type, task_id) AS tasks FROM %s WHERE created_at >= '%s' GROUP BY tactic_id, tactic, technique_id, technique ORDER BY tasks DESC, technique_id", extras.TaskAttackTable, since)
+	attackRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &counts,
+	}
+	if err := dao.GormOperations(&attackRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, counts)
+}
+
 func ScannedCount(duration time.Duration) model.APIResponse {
 	var counter []ScannedCounter
 	var fods []model.FileOnDemand
@@ -272,10 +326,10 @@ func ScanStatistics(duration time.Duration, infinite bool) model.APIResponse {
 		case "Clean":
 			stat.Safe++
 		default:
-			if job.Status == extras.PendingInQueue || job.Status == extras.PendingNotInQueue || job.Status == extras.RunningNotInQueue {
+			if job.Status == extras.Pending || job.Status == extras.Queued {
 				stat.Pending++
 			}
-			if job.Status == extras.RunningInQueue {
+			if job.Status == extras.Running {
 				stat.Processing++
 			}
 		}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"anti-apt-backend/verdictcache"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var fileListTypes = []string{extras.FILE_LIST_HASH, extras.FILE_LIST_FILENAME, extras.FILE_LIST_SIGNER}
+
+func GetFileList(list string, listType string) model.APIResponse {
+	var conditions []string
+	if list != extras.EMPTY_STRING {
+		conditions = append(conditions, fmt.Sprintf("list = '%s'", util.EscapeSqlString(list)))
+	}
+	if listType != extras.EMPTY_STRING {
+		conditions = append(conditions, fmt.Sprintf("type = '%s'", util.EscapeSqlString(listType)))
+	}
+
+	queryString := fmt.Sprintf("SELECT * FROM %s ORDER BY id", extras.FileListTable)
+	if len(conditions) > 0 {
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY id", extras.FileListTable, strings.Join(conditions, " AND "))
+	}
+
+	entries := []model.FileListEntry{}
+	fileList := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &entries,
+	}
+	if err := dao.GormOperations(&fileList, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entries)
+}
+
+// listExpiry checks an expiry given for a list entry, none means the entry
+// does not expire.
+func listExpiry(expiresAt *time.Time) (sql.NullTime, error) {
+	if expiresAt == nil {
+		return sql.NullTime{}, nil
+	}
+	if !expiresAt.After(time.Now()) {
+		return sql.NullTime{}, extras.ErrListExpiry
+	}
+	return sql.NullTime{Time: *expiresAt, Valid: true}, nil
+}
+
+// applyFileListRequest validates req and copies it onto entry.
+func applyFileListRequest(entry *model.FileListEntry, req model.ListEntryRequest, curUsr string) error {
+	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+	if !slices.Contains(fileListTypes, req.Type) {
+		return extras.ErrFileListType
+	}
+	req.List = strings.ToLower(strings.TrimSpace(req.List))
+	if req.List != extras.ALLOW && req.List != extras.BLOCK {
+		return extras.ErrFileListList
+	}
+	// a signer is taken from the file without checking the signature, anyone
+	// could copy it onto a file they want let through
+	if req.Type == extras.FILE_LIST_SIGNER && req.List == extras.ALLOW {
+		return extras.ErrFileListSignerAllow
+	}
+
+	value, err := util.NormalizeFileListValue(req.Type, req.Value)
+	if err != nil {
+		return err
+	}
+	expiresAt, err := listExpiry(req.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	entry.Type = req.Type
+	entry.Value = value
+	entry.List = req.List
+	entry.Comment = strings.TrimSpace(req.Comment)
+	entry.Owner = strings.TrimSpace(req.Owner)
+	if entry.Owner == extras.EMPTY_STRING {
+		entry.Owner = curUsr
+	}
+	entry.ExpiresAt = expiresAt
+	return nil
+}
+
+// fileListEntryExists tells whether another entry than id has the type and
+// value.
+func fileListEntryExists(entry model.FileListEntry) bool {
+	var count int64
+	config.Db.Model(&model.FileListEntry{}).Where("type = ? AND value = ? AND id != ?", entry.Type, entry.Value, entry.Id).Count(&count)
+	return count > 0
+}
+
+func CreateFileListEntry(req model.ListEntryRequest, curUsr string) model.APIResponse {
+	entry := model.FileListEntry{
+		CreatedBy: curUsr,
+		CreatedAt: time.Now(),
+	}
+	if err := applyFileListRequest(&entry, req, curUsr); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_FILE_LIST_ENTRY, err)
+	}
+	if fileListEntryExists(entry) {
+		return model.NewErrorResponse(http.StatusConflict, extras.ERR_INVALID_FILE_LIST_ENTRY, extras.ErrListEntryExists)
+	}
+
+	if err := config.Db.Create(&entry).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	verdictcache.PutListed(entry)
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entry)
+}
+
+func UpdateFileListEntry(id int, req model.ListEntryRequest, curUsr string) model.APIResponse {
+	var entry model.FileListEntry
+	if err := config.Db.Where("id = ?", id).First(&entry).Error; err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_FILE_LIST_ENTRY_NOT_FOUND, extras.ErrFileListNotFound)
+	}
+	if err := applyFileListRequest(&entry, req, curUsr); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_FILE_LIST_ENTRY, err)
+	}
+	if fileListEntryExists(entry) {
+		return model.NewErrorResponse(http.StatusConflict, extras.ERR_INVALID_FILE_LIST_ENTRY, extras.ErrListEntryExists)
+	}
+
+	entry.UpdatedBy = curUsr
+	entry.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := config.Db.Save(&entry).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	verdictcache.PutListed(entry)
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entry)
+}
+
+func DeleteFileListEntry(id int) model.APIResponse {
+	result := config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %d", extras.FileListTable, id))
+	if result.Error != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_FILE_LIST_ENTRY_NOT_FOUND, extras.ErrFileListNotFound)
+	}
+	verdictcache.DropListed(id)
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Entry %d deleted", id))
+}
+
+func firewallListEntry(listType string, value string, list string, expiresAt sql.NullTime) model.FirewallListEntry {
+	entry := model.FirewallListEntry{Type: listType, Value: value, List: list}
+	if expiresAt.Valid {
+		entry.ExpiresAt = &expiresAt.Time
+	}
+	return entry
+}
+
+// ExportListsForFirewall returns the unexpired url intel and file list
+// entries for the firewall to enforce on its own. Types keep the names of
+// their store, the url intel types and hash, filename and signer.
+func ExportListsForFirewall() model.APIResponse {
+	now := time.Now().Format(extras.TIME_FORMAT)
+
+	var urlIntel []model.UrlIntel
+	urlIntelRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s WHERE expires_at IS NULL OR expires_at > '%s' ORDER BY id", extras.UrlIntelTable, now)},
+		Result:       &urlIntel,
+	}
+	if err := dao.GormOperations(&urlIntelRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+
+	var fileList []model.FileListEntry
+	fileListRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s WHERE expires_at IS NULL OR expires_at > '%s' ORDER BY id", extras.FileListTable, now)},
+		Result:       &fileList,
+	}
+	if err := dao.GormOperations(&fileListRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+
+	entries := []model.FirewallListEntry{}
+	for _, entry := range urlIntel {
+		entries = append(entries, firewallListEntry(entry.Type, entry.Value, entry.List, entry.ExpiresAt))
+	}
+	for _, entry := range fileList {
+		entries = append(entries, firewallListEntry(entry.Type, entry.Value, entry.List, entry.ExpiresAt))
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entries)
+}
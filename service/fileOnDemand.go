@@ -7,6 +7,7 @@ import (
 	"anti-apt-backend/hash"
 	"anti-apt-backend/model"
 	"anti-apt-backend/util"
+	"anti-apt-backend/verdictcache"
 	"bufio"
 	"database/sql"
 	"fmt"
@@ -92,24 +93,27 @@ func CreateFileOnDemand(formRequest *multipart.Form, adminName, ip string) model
 		fromDevice = true
 	}
 
+	priority, err := util.GetPriorityOfFile(formRequest, fromDevice)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_PRIORITY, err)
+	}
+
+	analysisOptions, err := util.GetAnalysisOptionsOfFile(formRequest)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_ANALYSIS_OPTIONS, err)
+	}
+
 	// var platForm = "Windows 7"
 	// platFormInBytes, _ := os.ReadFile(extras.PLATFORM_FILE_NAME)
 	// if strings.Contains(strings.ToLower(string(platFormInBytes)), "ubuntu") {
 	// 	platForm = "Ubuntu 20"
 	// }
 
-	fodId := 0
-	queryString := fmt.Sprintf("SELECT id FROM %s ORDER BY id DESC LIMIT 1", extras.FileOnDemandTable)
-	fodRepo := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
-		Result:       &fodId,
-	}
-	err = dao.GormOperations(&fodRepo, config.Db, "exec")
+	fodId, err := nextFileOnDemandId()
 	if err != nil {
 		// slog.Println("ERROR FROM DATABASE: ", err)
 		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
 	}
-	fodId++
 
 	fod := model.FileOnDemand{
 		Id:            fodId,
@@ -121,7 +125,9 @@ func CreateFileOnDemand(formRequest *multipart.Form, adminName, ip string) model
 		// OsSupported:   platForm,
 		FileCount:  1,
 		FromDevice: fromDevice,
+		Priority:   priority,
 	}
+	setAnalysisOptions(&fod, analysisOptions)
 
 	if _, err := os.Stat(extras.SANDBOX_FILE_PATHS); os.IsNotExist(err) {
 		if err := os.Mkdir(extras.SANDBOX_FILE_PATHS, 0755); err != nil {
@@ -176,11 +182,27 @@ func CreateFileOnDemand(formRequest *multipart.Form, adminName, ip string) model
 	fod.Md5 = md5
 	fod.SHA = sha1
 	fod.SHA256 = sha256
+	fuzzyHashOf(&fod, fp)
+
+	// a file sent with its own sandbox settings is analysed again, a listed
+	// file is left to the queue which decides it by the lists
+	if !hasAnalysisOptions(fod) && !inFileLists(fod, fp) {
+		resp = checkIfHashAlreadyPresent(fod, md5, sha1, sha256, ip)
+		if resp.StatusCode == http.StatusOK {
+			go deleteLocalTask(fod.Id)
+			return resp
+		}
+	}
 
-	resp = checkIfHashAlreadyPresent(fod, md5, sha1, sha256, ip)
-	if resp.StatusCode == http.StatusOK {
-		go deleteLocalTask(fod.Id)
-		return resp
+	if util.ArchiveKind(fp) != extras.EMPTY_STRING {
+		tempOutputf.Close()
+		password := extras.EMPTY_STRING
+		if len(formRequest.Value["password"]) > 0 {
+			password = formRequest.Value["password"][0]
+		}
+		if resp, ok := createArchiveTasks(fod, fp, ip, password); ok {
+			return resp
+		}
 	}
 
 	// if strings.Contains(strings.ToLower(string(eicarBytes)), "eicar") {
@@ -208,45 +230,103 @@ func CreateFileOnDemand(formRequest *multipart.Form, adminName, ip string) model
 	// 	return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
 	// }
 
-	var count int64 = 0
-	fodRepo = dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE (md5 = '%s' OR sha = '%s' OR sha256 = '%s')", extras.TaskLiveAnalysisTable, fod.Md5, fod.SHA, fod.SHA256)},
-		Result:       &count,
+	if fromDevice {
+		fod.ClientIp = ip
 	}
-	err = dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
+
+	err = queueFileOnDemand(fod)
 	if err != nil {
 		// slog.Println("ERROR FROM DATABASE: ", err)
 		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
 	}
 
-	queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, file_count, from_device, md5, sha, sha256) VALUES (%d, '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s')", extras.FileOnDemandTable, fod.Id, fod.FileName, fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.FileCount, fromDevice, fod.Md5, fod.SHA, fod.SHA256)
+	// the firewall reads the job id back and is not kept waiting
 	if fromDevice {
-		fod.ClientIp = ip
-		queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, md5, sha, sha256) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s')", extras.FileOnDemandTable, fod.Id, fod.FileName, fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fromDevice, fod.Md5, fod.SHA, fod.SHA256)
+		scoreProvisionalVerdict(fod)
+	} else {
+		respMes += provisionalMessage(awaitProvisionalVerdict(fod))
+	}
+	resp = model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
+	return resp
+}
+
+func nextFileOnDemandId() (int, error) {
+	fodId := 0
+	queryString := fmt.Sprintf("SELECT id FROM %s ORDER BY id DESC LIMIT 1", extras.FileOnDemandTable)
+	fodRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &fodId,
+	}
+	err := dao.GormOperations(&fodRepo, config.Db, "exec")
+	return fodId + 1, err
+}
+
+// analysisOptionsOf are the sandbox settings fod was submitted with.
+func analysisOptionsOf(fod model.FileOnDemand) model.AnalysisOptions {
+	return model.AnalysisOptions{
+		Timeout:  fod.AnalysisTimeout,
+		Platform: fod.OsSupported,
+		Tag:      fod.VmTag,
+		Route:    fod.Route,
+		Package:  fod.Package,
+		Options:  fod.Options,
 	}
+}
+
+func setAnalysisOptions(fod *model.FileOnDemand, opts model.AnalysisOptions) {
+	fod.AnalysisTimeout = opts.Timeout
+	fod.OsSupported = opts.Platform
+	fod.VmTag = opts.Tag
+	fod.Route = opts.Route
+	fod.Package = opts.Package
+	fod.Options = opts.Options
+}
+
+func hasAnalysisOptions(fod model.FileOnDemand) bool {
+	return analysisOptionsOf(fod) != model.AnalysisOptions{}
+}
+
+// queueFileOnDemand saves fod and adds it to the live analysis table, or to the
+// duplicate table when a file with the same hash is already being analysed.
+// Files with their own sandbox settings never wait on another analysis.
+func queueFileOnDemand(fod model.FileOnDemand) error {
+	var count int64 = 0
+	fodRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id IS NOT NULL AND (md5 = '%s' OR sha = '%s' OR sha256 = '%s')", extras.TaskLiveAnalysisTable, fod.Md5, fod.SHA, fod.SHA256)},
+		Result:       &count,
+	}
+	if !hasAnalysisOptions(fod) {
+		if err := dao.GormOperations(&fodRepo, config.Db, dao.EXEC); err != nil {
+			return err
+		}
+	}
+
+	queryString := fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, priority, md5, sha, sha256, parent_id, analysis_timeout, os_supported, vm_tag, route, package, options, ssdeep, ssdeep_block_size, provisional_verdict, similar_to, similarity) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', %d, %d, '%s', '%s', '%s', '%s', '%s', '%s', %d, '%s', %d, %d)", extras.FileOnDemandTable, fod.Id, util.EscapeSqlString(fod.FileName), fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fod.FromDevice, fod.Priority, fod.Md5, fod.SHA, fod.SHA256, fod.ParentId, fod.AnalysisTimeout, fod.OsSupported, fod.VmTag, fod.Route, fod.Package, util.EscapeSqlString(fod.Options), fod.Ssdeep, fod.SsdeepBlockSize, fod.ProvisionalVerdict, fod.SimilarTo, fod.Similarity)
 
 	fodRepo = dao.DatabaseOperationsRepo{
 		QueryExecSet: []string{queryString},
 	}
 
 	if count <= 0 {
-		queryString = fmt.Sprintf("INSERT INTO %s (id, status, queue_retry_count, running_retry_count, sandbox_retry_count, log_queue_failed, md5, sha, sha256) VALUES (%d, '%s', %d, %d, %d, %t, '%s', '%s', '%s')", extras.TaskLiveAnalysisTable, fod.Id, extras.PendingNotInQueue, 0, 0, 0, false, fod.Md5, fod.SHA, fod.SHA256)
+		queryString = fmt.Sprintf("INSERT INTO %s (id, status, running_retry_count, sandbox_retry_count, version, md5, sha, sha256) VALUES (%d, '%s', %d, %d, %d, '%s', '%s', '%s')", extras.TaskLiveAnalysisTable, fod.Id, extras.Pending, 0, 0, 0, fod.Md5, fod.SHA, fod.SHA256)
 		fodRepo.QueryExecSet = append(fodRepo.QueryExecSet, queryString)
 	} else {
 		queryString = fmt.Sprintf("INSERT INTO %s (id, md5, sha, sha256) VALUES (%d, '%s', '%s', '%s')", extras.TaskDuplicateTable, fod.Id, fod.Md5, fod.SHA, fod.SHA256)
 		fodRepo.QueryExecSet = append(fodRepo.QueryExecSet, queryString)
 	}
 
-	err = dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
-	if err != nil {
-		// slog.Println("ERROR FROM DATABASE: ", err)
-		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
-	}
+	return dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
+}
 
-	resp = model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
-	return resp
+// inFileLists tells whether an allow or block list entry decides fod, such a
+// file is not answered from the verdict cache.
+func inFileLists(fod model.FileOnDemand, fp string) bool {
+	entry, err := dao.LookupFileList(fod.FileName, fp, fod.Md5, fod.SHA, fod.SHA256)
+	return err == nil && entry != nil
 }
 
+// checkIfHashAlreadyPresent answers fod from the verdict cache when its hash
+// has a verdict that is not due for a rescan, the file is not analysed then.
 func checkIfHashAlreadyPresent(fod model.FileOnDemand, md5 string, sha1 string, sha256 string, ip string) model.APIResponse {
 	var respMes string
 	if fod.FromDevice {
@@ -255,117 +335,44 @@ func checkIfHashAlreadyPresent(fod model.FileOnDemand, md5 string, sha1 string,
 		respMes = "File: " + fod.FileName + " successfully uploaded"
 	}
 
-	queryString := ""
-	isClean, err := dao.IsCleanHashFromDb(md5, sha1, sha256)
-	if err != nil {
-		// slog.Println("ERROR WHILE CHECKING FOR CLEAN HASH: ", err)
-		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
+	entry, ok := verdictcache.Lookup(sha256, sha1, md5)
+	if !ok || verdictcache.NeedsRescan(entry) {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FILE_NOT_FOUND, extras.ErrFileNotFound)
 	}
-	if isClean {
-		// slog.Println("ALREADY ANALYSED")
-		fod.Status = extras.PREVIOUSLY_SCANNED_FILE
-		fod.FinishedTime = sql.NullTime{Time: time.Now(), Valid: true}
 
-		type VerdictAndRating struct {
-			FinalVerdict string
-			Rating       string
-			Score        float32
-		}
-		var verdictAndRating VerdictAndRating
-
-		queryString = fmt.Sprintf("SELECT final_verdict, rating, score FROM %s WHERE (md5 = '%s' OR sha = '%s' OR sha256 = '%s')", extras.FileOnDemandTable, md5, sha1, sha256)
-
-		fodRepo := dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{queryString},
-			Result:       &verdictAndRating,
-		}
-
-		err := dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
-		if err != nil || verdictAndRating.Rating == "" || verdictAndRating.FinalVerdict == "" {
-			// slog.Println("ERROR FROM DATABASE: ", err)
-			verdictAndRating.Rating = string(model.Clean)
-			verdictAndRating.FinalVerdict = extras.ALLOW
-			verdictAndRating.Score = 0
-		}
-
-		fod.Rating = verdictAndRating.Rating
-		fod.FinalVerdict = verdictAndRating.FinalVerdict
-		fod.Score = verdictAndRating.Score
-
-		queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, file_count, from_device, status, finished_time, rating, final_verdict, md5, sha, sha256, score) VALUES (%d, '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f)", extras.FileOnDemandTable, fod.Id, fod.FileName, fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.FileCount, fod.FromDevice, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score)
-		if fod.FromDevice {
-			fod.ClientIp = ip
-			queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, status, finished_time, rating, final_verdict, md5, sha, sha256, score) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f)", extras.FileOnDemandTable, fod.Id, fod.FileName, fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fod.FromDevice, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score)
+	// slog.Println("ALREADY ANALYSED")
+	fod.Status = extras.PREVIOUSLY_SCANNED_FILE
+	fod.FinishedTime = sql.NullTime{Time: time.Now(), Valid: true}
+	fod.Rating = entry.Rating
+	fod.FinalVerdict = entry.Verdict
+	fod.Score = entry.Score
+	if fod.Rating == extras.EMPTY_STRING {
+		fod.Rating = string(model.Clean)
+		if fod.FinalVerdict == extras.BLOCK {
+			fod.Rating = string(model.Critical)
 		}
+	}
 
-		fodRepo = dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{queryString},
-			Result:       &fod,
-		}
+	queryString := fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, file_count, from_device, status, finished_time, rating, final_verdict, md5, sha, sha256, score, parent_id, ssdeep, ssdeep_block_size) VALUES (%d, '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f, %d, '%s', %d)", extras.FileOnDemandTable, fod.Id, util.EscapeSqlString(fod.FileName), fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.FileCount, fod.FromDevice, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score, fod.ParentId, fod.Ssdeep, fod.SsdeepBlockSize)
+	if fod.FromDevice {
+		fod.ClientIp = ip
+		queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, status, finished_time, rating, final_verdict, md5, sha, sha256, score, parent_id, ssdeep, ssdeep_block_size) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f, %d, '%s', %d)", extras.FileOnDemandTable, fod.Id, util.EscapeSqlString(fod.FileName), fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fod.FromDevice, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score, fod.ParentId, fod.Ssdeep, fod.SsdeepBlockSize)
+	}
 
-		err = dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
-		if err != nil {
-			// slog.Println("ERROR WHILE INSERTING FOD: ", err)
-			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
-		}
-		return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
+	fodRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
 	}
 
-	isMalware, err := dao.IsMalwareHashFromDb(md5, sha1, sha256)
+	err := dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
 	if err != nil {
-		// slog.Println("ERROR WHILE CHECKING FOR MALWARE HASH: ", err)
+		// slog.Println("ERROR WHILE INSERTING FOD: ", err)
 		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
 	}
-	if isMalware {
-		// slog.Println("ALREADY ANALYSED")
-		fod.Status = extras.PREVIOUSLY_SCANNED_FILE
-		fod.FinishedTime = sql.NullTime{Time: time.Now(), Valid: true}
-
-		type VerdictAndRating struct {
-			FinalVerdict string
-			Rating       string
-			Score        float32
-		}
-		var verdictAndRating VerdictAndRating
-
-		queryString = fmt.Sprintf("SELECT final_verdict, rating, score FROM %s WHERE (md5 = '%s' OR sha = '%s' OR sha256 = '%s')", extras.FileOnDemandTable, md5, sha1, sha256)
-
-		fodRepo := dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{queryString},
-			Result:       &verdictAndRating,
-		}
-
-		err := dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
-		if err != nil || verdictAndRating.Rating == "" || verdictAndRating.FinalVerdict == "" {
-			// slog.Println("ERROR FROM DATABASE: ", err)
-			verdictAndRating.Rating = string(model.Critical)
-			verdictAndRating.FinalVerdict = extras.BLOCK
-			verdictAndRating.Score = 7
-		}
-
-		fod.Rating = verdictAndRating.Rating
-		fod.FinalVerdict = verdictAndRating.FinalVerdict
-		fod.Score = verdictAndRating.Score
-
-		queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, file_count, from_device, status, finished_time, rating, final_verdict, md5, sha, sha256, score) VALUES (%d, '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f)", extras.FileOnDemandTable, fod.Id, fod.FileName, fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.FileCount, fod.FromDevice, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score)
-		if fod.FromDevice {
-			fod.ClientIp = ip
-			queryString = fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, client_ip, file_count, from_device, status, finished_time, rating, final_verdict, md5, sha, sha256, score) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %f)", extras.FileOnDemandTable, fod.Id, fod.FileName, fod.ContentType, fod.SubmittedTime.Format(extras.TIME_FORMAT), fod.SubmittedBy, fod.Comments, fod.ClientIp, fod.FileCount, fod.FromDevice, fod.Status, fod.FinishedTime.Time.Format(extras.TIME_FORMAT), fod.Rating, fod.FinalVerdict, fod.Md5, fod.SHA, fod.SHA256, fod.Score)
-		}
-
-		fodRepo = dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{queryString},
-		}
-
-		err = dao.GormOperations(&fodRepo, config.Db, dao.EXEC)
-		if err != nil {
-			// slog.Println("ERROR WHILE INSERTING FOD: ", err)
-			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
-		}
-		return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
+	verdictcache.Seen(fod.SubmittedTime, md5, sha1, sha256)
+	if fod.Ssdeep != extras.EMPTY_STRING {
+		go dao.IndexFuzzyHash(fod.Id, fod.Ssdeep)
 	}
-
-	return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FILE_NOT_FOUND, extras.ErrFileNotFound)
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
 }
 
 func deleteLocalTask(id int) {
@@ -1,33 +1,42 @@
 package service
 
 import (
-	"anti-apt-backend/dao"
 	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"anti-apt-backend/verdictcache"
+	"bytes"
+	"encoding/json"
+	"net/http"
 	"strings"
 )
 
+// fwVerdictOf turns a verdict into the code the firewall expects.
+func fwVerdictOf(verdict string) int {
+	if verdict == extras.BLOCK {
+		return extras.FW_BLOCK
+	}
+	return extras.FW_CLEAN
+}
+
+// FileFromFireWall answers the firewall's hash lookups from the allow and
+// block lists first and then from the verdict cache, both held in memory as it
+// is on the path of every file the firewall sees.
 func FileFromFireWall(hashed string) int {
-	hashed = strings.TrimSpace(hashed)
+	hashed = strings.ToLower(strings.TrimSpace(hashed))
 	// slog.Println("FILE HASH: ", hashed)
 	if len(hashed) > 0 {
-		isMalware, err := dao.IsMalwareHashFromDb(hashed, hashed, hashed)
-		if err != nil {
-			// slog.Println("Error: ", err)
-			return extras.FW_EMPTY
+		if listEntry, ok := verdictcache.Listed(hashed); ok {
+			return fwVerdictOf(listEntry.List)
 		}
 
-		isClean, err := dao.IsCleanHashFromDb(hashed, hashed, hashed)
-		if err != nil {
-			// slog.Println("Error: ", err)
-			return extras.FW_EMPTY
-		}
-
-		if isClean {
+		entry, ok := verdictcache.Lookup(hashed)
+		if ok && entry.Verdict == extras.ALLOW {
 			// slog.Println("CLEAN VERDICT")
 			return extras.FW_CLEAN
 		}
 
-		if isMalware {
+		if ok && entry.Verdict == extras.BLOCK {
 			// slog.Println("BLOCK VERDICT")
 			return extras.FW_BLOCK
 		}
@@ -37,6 +46,88 @@ func FileFromFireWall(hashed string) int {
 	return extras.FW_EMPTY
 }
 
+// ParseHashList reads the hashes of a bulk lookup, either a json body, an
+// object with hashes or a bare array, or one hash per line.
+func ParseHashList(body []byte, contentType string) ([]string, error) {
+	body = bytes.TrimSpace(body)
+	if strings.Contains(contentType, "json") || bytes.HasPrefix(body, []byte("{")) || bytes.HasPrefix(body, []byte("[")) {
+		var hashes []string
+		if bytes.HasPrefix(body, []byte("[")) {
+			err := json.Unmarshal(body, &hashes)
+			return hashes, err
+		}
+		var req model.BulkHashRequest
+		err := json.Unmarshal(body, &req)
+		return req.Hashes, err
+	}
+
+	var hashes []string
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line != extras.EMPTY_STRING {
+			hashes = append(hashes, line)
+		}
+	}
+	return hashes, nil
+}
+
+// CheckHashesInBulk answers many hash lookups in one call, from the lists and
+// the verdict cache like FileFromFireWall. Repeated hashes are answered once.
+func CheckHashesInBulk(hashes []string) model.APIResponse {
+	if len(hashes) == 0 {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_HASH_LIST, extras.ErrNoHashes)
+	}
+	if len(hashes) > extras.MAX_BULK_HASHES {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_HASH_LIST, extras.ErrTooManyHashes)
+	}
+
+	checked := make(map[string]bool)
+	verdicts := []model.HashVerdict{}
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if checked[hash] {
+			continue
+		}
+		checked[hash] = true
+
+		verdict := model.HashVerdict{
+			Hash:      hash,
+			HashType:  util.HashType(hash),
+			Verdict:   extras.VERDICT_UNKNOWN,
+			FwVerdict: extras.FW_EMPTY,
+		}
+		if verdict.HashType == extras.EMPTY_STRING {
+			verdict.Error = extras.ErrInvalidHash.Error()
+			verdicts = append(verdicts, verdict)
+			continue
+		}
+
+		if listEntry, ok := verdictcache.Listed(hash); ok {
+			verdict.Verdict = listEntry.List
+			verdict.FwVerdict = fwVerdictOf(listEntry.List)
+			verdict.Source = extras.VERDICT_SOURCE_FILE_LIST
+			verdicts = append(verdicts, verdict)
+			continue
+		}
+
+		entry, ok := verdictcache.Lookup(hash)
+		if ok {
+			verdict.Verdict = entry.Verdict
+			verdict.FwVerdict = fwVerdictOf(entry.Verdict)
+			verdict.Rating = entry.Rating
+			verdict.Score = entry.Score
+			verdict.TaskId = entry.TaskId
+			verdict.Source = entry.Source
+			verdict.Overridden = entry.Source == extras.VERDICT_SOURCE_OVERRIDE
+			if !entry.FirstSeen.IsZero() {
+				verdict.FirstSeen, verdict.LastSeen = &entry.FirstSeen, &entry.LastSeen
+			}
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, verdicts)
+}
+
 // func FetchJobIDForFw(jobID string) int {
 // 		var fod map[string]model.FileOnDemand
 // 		var uod map[string]model.UrlOnDemand
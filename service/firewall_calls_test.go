@@ -0,0 +1,91 @@
+package service
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/internal/dbtest"
+	"anti-apt-backend/model"
+	"anti-apt-backend/verdictcache"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+const (
+	allowListedHash   = "0123456789abcdef0123456789abcdef"
+	blockListedHash   = "fedcba9876543210fedcba9876543210"
+	expiredListedHash = "00112233445566778899aabbccddeeff"
+)
+
+// listHashes puts the test entries in the list index and points config.Db at
+// a recording database, the lookups have to be answered without it.
+func listHashes(t *testing.T) *dbtest.Db {
+	t.Helper()
+	expired := sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+	for _, entry := range []model.FileListEntry{
+		{Id: 1, Type: extras.FILE_LIST_HASH, Value: allowListedHash, List: extras.ALLOW},
+		{Id: 2, Type: extras.FILE_LIST_HASH, Value: blockListedHash, List: extras.BLOCK},
+		{Id: 3, Type: extras.FILE_LIST_HASH, Value: expiredListedHash, List: extras.ALLOW, ExpiresAt: expired},
+	} {
+		verdictcache.PutListed(entry)
+	}
+	t.Cleanup(func() {
+		for id := 1; id <= 3; id++ {
+			verdictcache.DropListed(id)
+		}
+	})
+	return dbtest.Use(t)
+}
+
+func TestFileFromFireWallListsBeforeCache(t *testing.T) {
+	db := listHashes(t)
+	// the vendor file is also known as malware
+	verdictcache.Put(verdictcache.Entry{Verdict: extras.BLOCK, Source: extras.VERDICT_SOURCE_MALWARE_DB}, allowListedHash, expiredListedHash)
+
+	if got := FileFromFireWall(allowListedHash); got != extras.FW_CLEAN {
+		t.Errorf("allow listed hash with a cached block: got %d, want FW_CLEAN", got)
+	}
+	if got := FileFromFireWall(blockListedHash); got != extras.FW_BLOCK {
+		t.Errorf("block listed hash never submitted: got %d, want FW_BLOCK", got)
+	}
+	if got := FileFromFireWall("00000000000000000000000000000000"); got != extras.FW_EMPTY {
+		t.Errorf("unknown hash: got %d, want FW_EMPTY", got)
+	}
+	if got := FileFromFireWall(expiredListedHash); got != extras.FW_BLOCK {
+		t.Errorf("expired allow entry: got %d, want the cached FW_BLOCK", got)
+	}
+
+	// a changed entry moves to its new hash, a deleted one is gone
+	verdictcache.PutListed(model.FileListEntry{Id: 2, Type: extras.FILE_LIST_HASH, Value: "11111111111111111111111111111111", List: extras.BLOCK})
+	verdictcache.DropListed(1)
+	if got := FileFromFireWall(blockListedHash); got != extras.FW_EMPTY {
+		t.Errorf("hash of a changed entry: got %d, want FW_EMPTY", got)
+	}
+	if got := FileFromFireWall(allowListedHash); got != extras.FW_BLOCK {
+		t.Errorf("hash of a deleted allow entry: got %d, want the cached FW_BLOCK", got)
+	}
+
+	if statements := db.Statements(); len(statements) != 0 {
+		t.Errorf("firewall lookups reached the database: %v", statements)
+	}
+}
+
+func TestCheckHashesInBulkListsBeforeCache(t *testing.T) {
+	db := listHashes(t)
+	verdictcache.Put(verdictcache.Entry{Verdict: extras.BLOCK, Source: extras.VERDICT_SOURCE_MALWARE_DB}, allowListedHash)
+
+	resp := CheckHashesInBulk([]string{allowListedHash, blockListedHash})
+	verdicts, ok := resp.Data.([]model.HashVerdict)
+	if !ok || len(verdicts) != 2 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	want := map[string]int{allowListedHash: extras.FW_CLEAN, blockListedHash: extras.FW_BLOCK}
+	for _, verdict := range verdicts {
+		if verdict.FwVerdict != want[verdict.Hash] || verdict.Source != extras.VERDICT_SOURCE_FILE_LIST {
+			t.Errorf("%s: got fw verdict %d from %q, want %d from the file list", verdict.Hash, verdict.FwVerdict, verdict.Source, want[verdict.Hash])
+		}
+	}
+	if statements := db.Statements(); len(statements) != 0 {
+		t.Errorf("bulk lookups reached the database: %v", statements)
+	}
+}
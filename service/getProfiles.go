@@ -138,8 +138,10 @@ func GetAllProfiles(modelName string, action string) model.APIResponse {
 					"file_type":      fod.ContentType,
 					"queued":         fod.Status,
 					// "vm_instance":    fod.OsSupported,
-					"duration":    duration,
-					"finished_on": finishTime,
+					"duration":            duration,
+					"finished_on":         finishTime,
+					"provisional_verdict": fod.ProvisionalVerdict,
+					"similar_to":          fod.SimilarTo,
 				})
 			}
 
@@ -1059,7 +1061,7 @@ func GetAllProfiles(modelName string, action string) model.APIResponse {
 
 func FetchAllFODs() ([]model.FileOnDemand, error) {
 	var fods []model.FileOnDemand
-	queryString := "SELECT file_on_demands.id, file_on_demands.file_name, file_on_demands.content_type, file_on_demands.submitted_time, file_on_demands.submitted_by, file_on_demands.file_count, file_on_demands.rating, file_on_demands.score, file_on_demands.comments, file_on_demands.overridden_verdict, file_on_demands.overridden_by, file_on_demands.final_verdict, file_on_demands.from_device, file_on_demands.finished_time, file_on_demands.status FROM file_on_demands WHERE status != '' AND status IS NOT NULL"
+	queryString := "SELECT file_on_demands.id, file_on_demands.file_name, file_on_demands.content_type, file_on_demands.submitted_time, file_on_demands.submitted_by, file_on_demands.file_count, file_on_demands.rating, file_on_demands.score, file_on_demands.comments, file_on_demands.overridden_verdict, file_on_demands.overridden_by, file_on_demands.final_verdict, file_on_demands.from_device, file_on_demands.finished_time, file_on_demands.provisional_verdict, file_on_demands.similar_to, file_on_demands.status FROM file_on_demands WHERE status != '' AND status IS NOT NULL"
 	fodRepo := dao.DatabaseOperationsRepo{
 		QueryExecSet: []string{queryString},
 		Result:       &fods,
@@ -1072,7 +1074,7 @@ func FetchAllFODs() ([]model.FileOnDemand, error) {
 	}
 
 	var fods1 []model.FileOnDemand
-	queryString = "SELECT file_on_demands.id, file_on_demands.file_name, file_on_demands.content_type, file_on_demands.submitted_time, file_on_demands.submitted_by, file_on_demands.file_count, file_on_demands.rating, file_on_demands.score, file_on_demands.comments, file_on_demands.overridden_verdict, file_on_demands.overridden_by, file_on_demands.final_verdict, file_on_demands.from_device, file_on_demands.finished_time, CASE WHEN file_on_demands.status = '' OR file_on_demands.status IS NULL THEN task_live_analysis_tables.status ELSE file_on_demands.status END AS status FROM file_on_demands INNER JOIN task_live_analysis_tables ON task_live_analysis_tables.id = file_on_demands.id"
+	queryString = "SELECT file_on_demands.id, file_on_demands.file_name, file_on_demands.content_type, file_on_demands.submitted_time, file_on_demands.submitted_by, file_on_demands.file_count, file_on_demands.rating, file_on_demands.score, file_on_demands.comments, file_on_demands.overridden_verdict, file_on_demands.overridden_by, file_on_demands.final_verdict, file_on_demands.from_device, file_on_demands.finished_time, file_on_demands.provisional_verdict, file_on_demands.similar_to, CASE WHEN file_on_demands.status = '' OR file_on_demands.status IS NULL THEN task_live_analysis_tables.status ELSE file_on_demands.status END AS status FROM file_on_demands INNER JOIN task_live_analysis_tables ON task_live_analysis_tables.id = file_on_demands.id"
 	fodRepo = dao.DatabaseOperationsRepo{
 		QueryExecSet: []string{queryString},
 		Result:       &fods1,
@@ -646,7 +646,7 @@ func writeHa(str string, service bool) error {
 	cmd := exec.Command("/bin/systemctl", "restart", "keepalived")
 	err = cmd.Run()
 	if err != nil {
-		fmt.Println("Error restarting keepalived: %v", err)
+		fmt.Printf("Error restarting keepalived: %v\n", err)
 		return fmt.Errorf("Error restarting keepalived service: %v", err)
 	}
 	return nil
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// lookups windows makes on its own in every analysis VM
+var ignoredIocDomains = []string{
+	"msftncsi.com",
+	"msftconnecttest.com",
+	"windowsupdate.com",
+	"time.windows.com",
+	"teredo.ipv6.microsoft.com",
+}
+
+func isIgnoredDomain(domain string) bool {
+	for _, ignored := range ignoredIocDomains {
+		if domain == ignored || strings.HasSuffix(domain, "."+ignored) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipIoc returns the indicator for an address the sample talked to, addresses
+// that never leave the analysis network are left out.
+func ipIoc(value string, source string) (model.Ioc, bool) {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return model.Ioc{}, false
+	}
+	if ip.To4() != nil {
+		return model.Ioc{Type: extras.IOC_IPV4, Value: ip.String(), Source: source}, true
+	}
+	return model.Ioc{Type: extras.IOC_IPV6, Value: ip.String(), Source: source}, true
+}
+
+// extractIocs collects the domains, addresses, urls, dropped file hashes and
+// mutexes of a task's analysis, each one once.
+func extractIocs(analysis model.TaskAnalysis) []model.Ioc {
+	iocs := []model.Ioc{}
+	seen := make(map[string]bool)
+	add := func(ioc model.Ioc) {
+		if ioc.Value == extras.EMPTY_STRING || seen[ioc.Type+"|"+ioc.Value] {
+			return
+		}
+		seen[ioc.Type+"|"+ioc.Value] = true
+		iocs = append(iocs, ioc)
+	}
+	addHost := func(host string, source string) {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if ioc, ok := ipIoc(host, source); ok {
+			add(ioc)
+			return
+		}
+		if net.ParseIP(host) == nil && strings.Contains(host, ".") && !isIgnoredDomain(host) {
+			add(model.Ioc{Type: extras.IOC_DOMAIN, Value: host, Source: source})
+		}
+	}
+
+	for _, indicator := range analysis.NetworkIndicators {
+		switch indicator.Kind {
+		case extras.NETWORK_DNS:
+			host := strings.TrimSuffix(strings.ToLower(indicator.Value), ".")
+			if net.ParseIP(host) == nil && !isIgnoredDomain(host) {
+				add(model.Ioc{Type: extras.IOC_DOMAIN, Value: host, Source: extras.NETWORK_DNS, Context: indicator.Detail})
+			}
+		case extras.NETWORK_HTTP:
+			u, err := url.Parse(indicator.Value)
+			if err != nil || u.Hostname() == extras.EMPTY_STRING {
+				continue
+			}
+			if isIgnoredDomain(strings.ToLower(u.Hostname())) {
+				continue
+			}
+			add(model.Ioc{Type: extras.IOC_URL, Value: indicator.Value, Source: extras.NETWORK_HTTP, Context: indicator.Detail})
+			addHost(u.Hostname(), extras.NETWORK_HTTP)
+		case extras.NETWORK_IP:
+			if ioc, ok := ipIoc(indicator.Value, extras.NETWORK_IP); ok {
+				ioc.Context = indicator.Detail
+				add(ioc)
+			}
+		}
+	}
+
+	for _, file := range analysis.DroppedFiles {
+		add(model.Ioc{Type: extras.IOC_SHA256, Value: strings.ToLower(file.SHA256), Source: "dropped", Context: file.Name})
+	}
+
+	for _, activity := range analysis.Activities {
+		if activity.Category == extras.ACTIVITY_MUTEX {
+			add(model.Ioc{Type: extras.IOC_MUTEX, Value: activity.Value, Source: extras.ACTIVITY_MUTEX})
+		}
+	}
+
+	return iocs
+}
@@ -7,7 +7,9 @@ import (
 	"anti-apt-backend/extras"
 	"anti-apt-backend/logger"
 	"anti-apt-backend/model"
+	queues "anti-apt-backend/service/queue"
 	"anti-apt-backend/util"
+	"anti-apt-backend/verdictcache"
 	"fmt"
 	"log"
 	"net/http"
@@ -48,11 +50,13 @@ func OverrideVerdict(req model.OverrideVerdictRequest, ctx *gin.Context) model.A
 		if resp.StatusCode != http.StatusOK {
 			return resp
 		}
+		go queues.NotifyTask(extras.EVENT_VERDICT_OVERRIDDEN, extras.TASK_TYPE_FILE, req.JobID)
 	case "url":
 		resp = overrideUrlVerdict(req, curUsr)
 		if resp.StatusCode != http.StatusOK {
 			return resp
 		}
+		go queues.NotifyTask(extras.EVENT_VERDICT_OVERRIDDEN, extras.TASK_TYPE_URL, req.JobID)
 	default:
 		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_TYPE_IN_OVERRIDE, extras.ErrInvalidTypeInOverride)
 		return resp
@@ -107,6 +111,16 @@ func overrideFileVerdict(req model.OverrideVerdictRequest, updateBy string) mode
 			// logger.LoggerFunc("error", logger.LoggerMessage(err.Error()))
 			return resp
 		}
+		if err = saveOverrideHistory(jobId, extras.TASK_TYPE_FILE, updateBy); err != nil {
+			logger.LogAccToTaskId(jobId, fmt.Sprintf("ERROR WHILE SAVING VERDICT HISTORY, ERROR: %v", err))
+		}
+		verdictcache.Put(verdictcache.Entry{
+			Verdict: verdictReq,
+			Rating:  fod.Rating,
+			Score:   fod.Score,
+			Source:  extras.VERDICT_SOURCE_OVERRIDE,
+			TaskId:  fod.Id,
+		}, fod.Md5, fod.SHA, fod.SHA256)
 
 		// go func() {
 		// 	err = hash.SaveVerdict(fod.Md5, verdictReq)
@@ -170,6 +184,9 @@ func overrideUrlVerdict(req model.OverrideVerdictRequest, updateBy string) model
 			// logger.LoggerFunc("error", logger.LoggerMessage(err.Error()))
 			return resp
 		}
+		if err = saveOverrideHistory(jobId, extras.TASK_TYPE_URL, updateBy); err != nil {
+			logger.LogAccToTaskId(jobId, fmt.Sprintf("ERROR WHILE SAVING VERDICT HISTORY, ERROR: %v", err))
+		}
 
 	} else {
 		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_ALREADY_OVERRIDE, extras.ErrAlreadyOverridden)
@@ -0,0 +1,127 @@
+package queues
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// at most this many rows of each kind are kept from one report
+	MAX_ANALYSIS_ROWS = 1000
+	// rows written per insert statement
+	ANALYSIS_INSERT_BATCH = 200
+	// longest indexed value kept, longer ones are cut to fit the column
+	MAX_INDICATOR_LENGTH = 700
+	MAX_SIGNATURE_LENGTH = 191
+)
+
+func clip(value string, length int) string {
+	if len(value) > length {
+		return strings.ToValidUTF8(value[:length], extras.EMPTY_STRING)
+	}
+	return value
+}
+
+// analysisRows collects the values of one table, duplicates and anything over
+// MAX_ANALYSIS_ROWS are dropped.
+type analysisRows struct {
+	table   string
+	columns string
+	seen    map[string]bool
+	values  []string
+}
+
+func newAnalysisRows(table string, columns string) *analysisRows {
+	return &analysisRows{table: table, columns: columns, seen: make(map[string]bool)}
+}
+
+func (r *analysisRows) add(key string, value string) {
+	if key == extras.EMPTY_STRING || r.seen[key] || len(r.values) >= MAX_ANALYSIS_ROWS {
+		return
+	}
+	r.seen[key] = true
+	r.values = append(r.values, value)
+}
+
+func (r *analysisRows) queries() []string {
+	var queryStringArr []string
+	for start := 0; start < len(r.values); start += ANALYSIS_INSERT_BATCH {
+		batch := r.values[start:min(start+ANALYSIS_INSERT_BATCH, len(r.values))]
+		queryStringArr = append(queryStringArr, fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", r.table, r.columns, strings.Join(batch, ", ")))
+	}
+	return queryStringArr
+}
+
+// saveAnalysis returns the queries storing the signatures, network indicators,
+// dropped files, activity and processes of report against task, replacing what
+// an earlier analysis of the task stored.
+func saveAnalysis(task Task, report *model.Report) []string {
+	if report == nil {
+		return nil
+	}
+
+	taskType := task.Type
+	if taskType == extras.EMPTY_STRING {
+		taskType = extras.TASK_TYPE_FILE
+	}
+	now := time.Now().Format(extras.TIME_FORMAT)
+	esc := util.EscapeSqlString
+
+	signatures := newAnalysisRows(extras.TaskSignatureTable, "task_id, task_type, name, description, severity, families, marks, created_at")
+	for _, signature := range report.Signatures {
+		signatures.add(signature.Name, fmt.Sprintf("(%d, '%s', '%s', '%s', %d, '%s', %d, '%s')", task.Id, taskType, esc(clip(signature.Name, MAX_SIGNATURE_LENGTH)), esc(signature.Description), signature.Severity, esc(strings.Join(signature.Families, ",")), signature.Markcount, now))
+	}
+
+	network := newAnalysisRows(extras.TaskNetworkTable, "task_id, task_type, kind, value, detail, created_at")
+	addIndicator := func(kind string, value string, detail string) {
+		network.add(kind+"|"+value+"|"+detail, fmt.Sprintf("(%d, '%s', '%s', '%s', '%s', '%s')", task.Id, taskType, kind, esc(clip(value, MAX_INDICATOR_LENGTH)), esc(detail), now))
+	}
+	for _, dns := range report.Network.Dns {
+		var answers []string
+		for _, answer := range dns.Answers {
+			answers = append(answers, answer.Data)
+		}
+		addIndicator(extras.NETWORK_DNS, dns.Request, strings.TrimSpace(dns.Type+" "+strings.Join(answers, ",")))
+	}
+	for _, request := range report.Network.Http {
+		addIndicator(extras.NETWORK_HTTP, request.Uri, strings.TrimSpace(request.Method+" "+request.UserAgent))
+	}
+	for _, host := range report.Network.Hosts {
+		addIndicator(extras.NETWORK_IP, host.Ip, host.Country)
+	}
+
+	dropped := newAnalysisRows(extras.TaskDroppedFileTable, "task_id, task_type, name, path, size, file_type, md5, sha, sha256, created_at")
+	for _, file := range report.Dropped {
+		dropped.add(file.Sha256+"|"+file.Path, fmt.Sprintf("(%d, '%s', '%s', '%s', %d, '%s', '%s', '%s', '%s', '%s')", task.Id, taskType, esc(file.Name), esc(file.Path), file.Size, esc(file.Type), esc(file.Md5), esc(file.Sha1), esc(file.Sha256), now))
+	}
+
+	activities := newAnalysisRows(extras.TaskActivityTable, "task_id, task_type, category, operation, value, created_at")
+	addActivities := func(category string, operation string, values []string) {
+		for _, value := range values {
+			activities.add(category+"|"+operation+"|"+value, fmt.Sprintf("(%d, '%s', '%s', '%s', '%s', '%s')", task.Id, taskType, category, operation, esc(clip(value, MAX_INDICATOR_LENGTH)), now))
+		}
+	}
+	summary := report.Behavior.Summary
+	addActivities(extras.ACTIVITY_FILE, extras.ACTIVITY_CREATED, summary.FileCreated)
+	addActivities(extras.ACTIVITY_FILE, extras.ACTIVITY_WRITTEN, summary.FileWritten)
+	addActivities(extras.ACTIVITY_FILE, extras.ACTIVITY_DELETED, summary.FileDeleted)
+	addActivities(extras.ACTIVITY_REGISTRY, extras.ACTIVITY_WRITTEN, summary.RegkeyWritten)
+	addActivities(extras.ACTIVITY_REGISTRY, extras.ACTIVITY_DELETED, summary.RegkeyDeleted)
+	addActivities(extras.ACTIVITY_MUTEX, extras.ACTIVITY_CREATED, summary.Mutex)
+
+	processes := newAnalysisRows(extras.TaskProcessTable, "task_id, task_type, pid, parent_pid, name, path, command_line, first_seen, created_at")
+	for _, process := range report.Behavior.Processes {
+		processes.add(fmt.Sprintf("%d", process.ProcessId), fmt.Sprintf("(%d, '%s', %d, %d, '%s', '%s', '%s', %f, '%s')", task.Id, taskType, process.ProcessId, process.ParentId, esc(process.ProcessName), esc(process.ProcessPath), esc(process.CommandLine), process.FirstSeen, now))
+	}
+
+	var queryStringArr []string
+	for _, rows := range []*analysisRows{signatures, network, dropped, activities, processes} {
+		queryStringArr = append(queryStringArr, fmt.Sprintf("DELETE FROM %s WHERE task_id = %d AND task_type = '%s'", rows.table, task.Id, taskType))
+		queryStringArr = append(queryStringArr, rows.queries()...)
+	}
+	return queryStringArr
+}
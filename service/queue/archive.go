@@ -0,0 +1,72 @@
+package queues
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"fmt"
+	"time"
+)
+
+// FinishArchivesIfDone gives every unpacked archive whose members have all
+// finished the worst verdict among them. Archives still being unpacked are
+// left alone, their members are not all saved yet.
+func FinishArchivesIfDone() {
+	var parents []int
+	queryString := fmt.Sprintf("SELECT DISTINCT c.parent_id FROM %s c INNER JOIN %s p ON p.id = c.parent_id WHERE c.parent_id > 0 AND p.finished_time IS NULL AND p.status = '%s' AND NOT EXISTS (SELECT 1 FROM %s u WHERE u.parent_id = c.parent_id AND u.finished_time IS NULL)", FileOnDemandTable, FileOnDemandTable, extras.ARCHIVE_UNPACKED, FileOnDemandTable)
+	parentRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &parents,
+	}
+	err := dao.GormOperations(&parentRepo, config.Db, dao.EXEC)
+	if err != nil {
+		// slog.Println("ERROR WHILE FETCHING FINISHED ARCHIVES: ", err)
+		return
+	}
+
+	for _, parent := range parents {
+		var children []model.FileOnDemand
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE parent_id = %d", FileOnDemandTable, parent)
+		childRepo := dao.DatabaseOperationsRepo{
+			QueryExecSet: []string{queryString},
+			Result:       &children,
+		}
+		if err = dao.GormOperations(&childRepo, config.Db, dao.EXEC); err != nil || len(children) == 0 {
+			continue
+		}
+
+		worst := children[0]
+		for _, child := range children[1:] {
+			if child.FinalVerdict == extras.BLOCK && worst.FinalVerdict != extras.BLOCK || child.FinalVerdict == worst.FinalVerdict && child.Score > worst.Score {
+				worst = child
+			}
+		}
+
+		// another handler may finish the same archive at the same time
+		result := config.Db.Exec(fmt.Sprintf("UPDATE %s SET score = %f, rating = '%s', final_verdict = '%s', finished_time = '%s' WHERE id = %d AND finished_time IS NULL", FileOnDemandTable, worst.Score, worst.Rating, worst.FinalVerdict, time.Now().Format(extras.TIME_FORMAT), parent))
+		if result.Error == nil && result.RowsAffected == 1 {
+			cacheArchiveVerdict(parent, worst)
+			NotifyTask(extras.EVENT_TASK_REPORTED, extras.TASK_TYPE_FILE, parent)
+		}
+	}
+}
+
+// cacheArchiveVerdict gives the verdict cache the verdict of the archive
+// parent, taken from its worst member.
+func cacheArchiveVerdict(parent int, worst model.FileOnDemand) {
+	var fod model.FileOnDemand
+	if err := config.Db.Where("id = ?", parent).First(&fod).Error; err != nil {
+		// slog.Println("ERROR WHILE FETCHING ARCHIVE: ", parent, err)
+		return
+	}
+	task := Task{
+		Id:            fod.Id,
+		Md5:           fod.Md5,
+		SHA:           fod.SHA,
+		SHA256:        fod.SHA256,
+		SubmittedTime: fod.SubmittedTime,
+		ReanalysisOf:  fod.ReanalysisOf,
+	}
+	putVerdict(task, worst.FinalVerdict, worst.Rating, worst.Score)
+}
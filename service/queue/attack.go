@@ -0,0 +1,127 @@
+package queues
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"fmt"
+	"time"
+)
+
+// Tactics of the ATT&CK enterprise matrix used by the default mappings.
+var attackTactics = map[string]string{
+	"TA0001": "Initial Access",
+	"TA0002": "Execution",
+	"TA0003": "Persistence",
+	"TA0004": "Privilege Escalation",
+	"TA0005": "Defense Evasion",
+	"TA0006": "Credential Access",
+	"TA0007": "Discovery",
+	"TA0009": "Collection",
+	"TA0011": "Command and Control",
+	"TA0040": "Impact",
+}
+
+// defaultAttackMappings seeds the mapping table. Signature and yara patterns
+// are matched with LIKE, yara patterns are also matched against the rule tags,
+// clamav patterns are the start of the signature name.
+var defaultAttackMappings = []struct {
+	source, pattern, tacticId, techniqueId, technique string
+}{
+	{extras.ATTACK_SOURCE_SIGNATURE, "injection_createremotethread", "TA0005", "T1055", "Process Injection"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "injection_runpe", "TA0005", "T1055.012", "Process Hollowing"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "injection_%", "TA0004", "T1055", "Process Injection"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "persistence_autorun", "TA0003", "T1547.001", "Registry Run Keys / Startup Folder"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "creates_service", "TA0003", "T1543.003", "Windows Service"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "persistence_ads", "TA0005", "T1564.004", "NTFS File Attributes"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "antivm_%", "TA0005", "T1497", "Virtualization/Sandbox Evasion"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "antisandbox_sleep", "TA0005", "T1497.003", "Time Based Evasion"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "antisandbox_%", "TA0005", "T1497", "Virtualization/Sandbox Evasion"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "antidbg_%", "TA0005", "T1622", "Debugger Evasion"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "packer_upx", "TA0005", "T1027.002", "Software Packing"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "packer_%", "TA0005", "T1027.002", "Software Packing"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "deletes_self", "TA0005", "T1070.004", "File Deletion"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "disables_security", "TA0005", "T1562.001", "Disable or Modify Tools"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "deletes_shadow_copies", "TA0040", "T1490", "Inhibit System Recovery"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "ransomware_%", "TA0040", "T1486", "Data Encrypted for Impact"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "suspicious_powershell", "TA0002", "T1059.001", "PowerShell"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "powershell_%", "TA0002", "T1059.001", "PowerShell"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "office_%", "TA0002", "T1204.002", "Malicious File"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "network_tor", "TA0011", "T1090.003", "Multi-hop Proxy"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "network_http", "TA0011", "T1071.001", "Web Protocols"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "dead_host", "TA0011", "T1071", "Application Layer Protocol"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "infostealer_browser", "TA0006", "T1555.003", "Credentials from Web Browsers"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "infostealer_%", "TA0006", "T1552.001", "Credentials In Files"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "keylogger", "TA0006", "T1056.001", "Keylogging"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "recon_systeminfo", "TA0007", "T1082", "System Information Discovery"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "recon_%", "TA0007", "T1082", "System Information Discovery"},
+	{extras.ATTACK_SOURCE_SIGNATURE, "screenshot", "TA0009", "T1113", "Screen Capture"},
+
+	{extras.ATTACK_SOURCE_YARA, "ransomware", "TA0040", "T1486", "Data Encrypted for Impact"},
+	{extras.ATTACK_SOURCE_YARA, "keylogger", "TA0006", "T1056.001", "Keylogging"},
+	{extras.ATTACK_SOURCE_YARA, "packer", "TA0005", "T1027.002", "Software Packing"},
+	{extras.ATTACK_SOURCE_YARA, "injection", "TA0005", "T1055", "Process Injection"},
+	{extras.ATTACK_SOURCE_YARA, "persistence", "TA0003", "T1547.001", "Registry Run Keys / Startup Folder"},
+	{extras.ATTACK_SOURCE_YARA, "rat", "TA0011", "T1219", "Remote Access Software"},
+	{extras.ATTACK_SOURCE_YARA, "miner", "TA0040", "T1496", "Resource Hijacking"},
+	{extras.ATTACK_SOURCE_YARA, "webshell", "TA0003", "T1505.003", "Web Shell"},
+
+	{extras.ATTACK_SOURCE_CLAMAV, "Win.Ransomware", "TA0040", "T1486", "Data Encrypted for Impact"},
+	{extras.ATTACK_SOURCE_CLAMAV, "Win.Packed", "TA0005", "T1027.002", "Software Packing"},
+	{extras.ATTACK_SOURCE_CLAMAV, "Win.Coinminer", "TA0040", "T1496", "Resource Hijacking"},
+	{extras.ATTACK_SOURCE_CLAMAV, "Doc.Downloader", "TA0002", "T1204.002", "Malicious File"},
+	{extras.ATTACK_SOURCE_CLAMAV, "Doc.Dropper", "TA0002", "T1204.002", "Malicious File"},
+	{extras.ATTACK_SOURCE_CLAMAV, "Html.Phishing", "TA0001", "T1566", "Phishing"},
+	{extras.ATTACK_SOURCE_CLAMAV, "Win.Exploit", "TA0002", "T1203", "Exploitation for Client Execution"},
+}
+
+// InitAttackMappings fills the mapping table with the default mappings the
+// first time it is empty, entries added later are left alone.
+func InitAttackMappings() {
+	var count int64
+	if err := config.Db.Model(&model.AttackMapping{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	var mappings []model.AttackMapping
+	for _, m := range defaultAttackMappings {
+		mappings = append(mappings, model.AttackMapping{
+			Source:      m.source,
+			Pattern:     m.pattern,
+			TacticId:    m.tacticId,
+			Tactic:      attackTactics[m.tacticId],
+			TechniqueId: m.techniqueId,
+			Technique:   m.technique,
+		})
+	}
+	if err := config.Db.Create(&mappings).Error; err != nil {
+		// slog.Println("ERROR WHILE SEEDING ATT&CK MAPPINGS: ", err)
+	}
+}
+
+// saveAttackTechniques returns the queries mapping the signatures, yara matches
+// and clamav detections stored for task to ATT&CK techniques. They have to run
+// after the queries saving those detections.
+func saveAttackTechniques(task Task) []string {
+	taskType := task.Type
+	if taskType == extras.EMPTY_STRING {
+		taskType = extras.TASK_TYPE_FILE
+	}
+	now := time.Now().Format(extras.TIME_FORMAT)
+
+	columns := "task_id, task_type, tactic_id, tactic, technique_id, technique, source, evidence, created_at"
+	selected := fmt.Sprintf("%d, '%s', m.tactic_id, m.tactic, m.technique_id, m.technique, m.source", task.Id, taskType)
+
+	queryStringArr := []string{
+		fmt.Sprintf("DELETE FROM %s WHERE task_id = %d AND task_type = '%s'", extras.TaskAttackTable, task.Id, taskType),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT DISTINCT %s, s.name, '%s' FROM %s s JOIN %s m ON m.source = '%s' AND s.name LIKE m.pattern WHERE s.task_id = %d AND s.task_type = '%s'", extras.TaskAttackTable, columns, selected, now, extras.TaskSignatureTable, extras.AttackMappingTable, extras.ATTACK_SOURCE_SIGNATURE, task.Id, taskType),
+	}
+	// yara and clamav only scan files
+	if taskType != extras.TASK_TYPE_FILE {
+		return queryStringArr
+	}
+	return append(queryStringArr,
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT DISTINCT %s, CONCAT(y.ruleset, ':', y.rule), '%s' FROM %s y JOIN %s m ON m.source = '%s' AND (y.rule LIKE m.pattern OR FIND_IN_SET(m.pattern, y.tags) > 0) WHERE y.task_id = %d", extras.TaskAttackTable, columns, selected, now, extras.TaskYaraMatchTable, extras.AttackMappingTable, extras.ATTACK_SOURCE_YARA, task.Id),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT DISTINCT %s, r.detail, '%s' FROM %s r JOIN %s m ON m.source = '%s' AND r.detail LIKE CONCAT(m.pattern, '%%') WHERE r.task_id = %d AND r.engine = '%s' AND r.outcome = '%s'", extras.TaskAttackTable, columns, selected, now, extras.TaskStageResultTable, extras.AttackMappingTable, extras.ATTACK_SOURCE_CLAMAV, task.Id, ENGINE_CLAMD, extras.STAGE_DETECTED),
+	)
+}
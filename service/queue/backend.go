@@ -0,0 +1,183 @@
+package queues
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/service/cuckoo"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SandboxBackend is everything the queue handlers need from an analysis
+// host. Cuckoo, CAPEv2 or the mock client can be plugged in at startup.
+type SandboxBackend interface {
+	SubmitFile(ctx context.Context, taskId int, fp string, opts SubmitOptions) (int, error)
+	SubmitUrl(ctx context.Context, taskId int, url string, opts SubmitOptions) (int, error)
+	ListTasks(ctx context.Context) ([]Sandbox, error)
+	Report(ctx context.Context, sandboxId int) (*Report, error)
+	DeleteTask(ctx context.Context, sandboxId int) error
+	ListMachines(ctx context.Context) ([]Machine, error)
+}
+
+// SubmitOptions carries the per-task settings passed along with the sample.
+type SubmitOptions struct {
+	Priority string
+	Platform string
+	Timeout  int // seconds
+	Tag      string
+	Route    string
+	Package  string
+	Options  string // key=value pairs, comma separated
+}
+
+const (
+	BACKEND_CUCKOO = "cuckoo"
+	BACKEND_MOCK   = "mock"
+)
+
+// NewSandboxBackend builds the client for a single configured sandbox node.
+func NewSandboxBackend(node model.SandboxNode) (SandboxBackend, error) {
+	switch strings.ToLower(strings.TrimSpace(node.Backend)) {
+	case "", BACKEND_CUCKOO:
+		return NewCuckooBackend(node.BaseURL, node.APIKey), nil
+	case BACKEND_MOCK:
+		return NewMockCuckooClient(), nil
+	}
+	return nil, fmt.Errorf("unknown sandbox backend: %s", node.Backend)
+}
+
+func getSandboxBackendName() string {
+	content, err := os.ReadFile(extras.SANDBOX_BACKEND_FILE_PATH)
+	if err != nil {
+		return BACKEND_CUCKOO
+	}
+	return strings.TrimSpace(string(content))
+}
+
+type CuckooBackend struct {
+	baseURL    string
+	apiKey     string
+	apiTimeout time.Duration
+}
+
+// NewCuckooBackend falls back to cuckoo.BASE_URL and the key in cuckoo.conf
+// when baseURL or apiKey are empty.
+func NewCuckooBackend(baseURL, apiKey string) *CuckooBackend {
+	return &CuckooBackend{
+		baseURL:    strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		apiKey:     strings.TrimSpace(apiKey),
+		apiTimeout: SANDBOX_API_TIMEOUT,
+	}
+}
+
+func (b *CuckooBackend) client() *cuckoo.Client {
+	return cuckoo.New(&cuckoo.Config{
+		APIKey:  b.apiKey,
+		BaseURL: b.baseURL,
+	})
+}
+
+// apiClient is used for the quick list/report calls, uploads and deletes keep
+// the cuckoo package default timeout.
+func (b *CuckooBackend) apiClient() *cuckoo.Client {
+	return cuckoo.New(&cuckoo.Config{
+		APIKey:  b.apiKey,
+		BaseURL: b.baseURL,
+		Client:  &http.Client{Timeout: b.apiTimeout},
+	})
+}
+
+func (b *CuckooBackend) SubmitFile(ctx context.Context, taskId int, fp string, opts SubmitOptions) (int, error) {
+	return b.client().CreateTaskFile(ctx, taskId, fp, cuckooTask(opts))
+}
+
+func (b *CuckooBackend) SubmitUrl(ctx context.Context, taskId int, url string, opts SubmitOptions) (int, error) {
+	return b.client().CreateTaskUrl(ctx, taskId, url, cuckooTask(opts))
+}
+
+// cuckoo's names for the routes a submission can ask for
+var CUCKOO_ROUTES = map[string]string{
+	extras.ROUTE_NONE:    "none",
+	extras.ROUTE_INETSIM: "inetsim",
+	extras.ROUTE_TOR:     "tor",
+	extras.ROUTE_DIRECT:  "internet",
+}
+
+// cuckooTask maps opts onto the cuckoo task fields, the route is passed as one
+// of the task options.
+func cuckooTask(opts SubmitOptions) *model.Task {
+	task := &model.Task{
+		Priority: int64(CUCKOO_PRIORITIES[opts.Priority]),
+		Platform: opts.Platform,
+		Timeout:  int64(opts.Timeout),
+		Package:  opts.Package,
+	}
+	if opts.Tag != extras.EMPTY_STRING {
+		task.Tags = []string{opts.Tag}
+	}
+
+	options := opts.Options
+	if route, ok := CUCKOO_ROUTES[opts.Route]; ok {
+		options = strings.Trim("route="+route+","+options, ",")
+	}
+	task.Options = options
+	return task
+}
+
+func (b *CuckooBackend) ListTasks(ctx context.Context) ([]Sandbox, error) {
+	allTasks, err := b.apiClient().ListAllTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []Sandbox
+	var t time.Time
+	for _, task := range allTasks {
+		if task.CompletedOn != nil {
+			t, _ = time.Parse(time.RFC1123, fmt.Sprintf("%v", task.CompletedOn))
+		}
+		resp = append(resp, Sandbox{
+			SandboxId:   task.ID,
+			Status:      string(task.Status),
+			CompletedOn: t,
+		})
+	}
+
+	return resp, nil
+}
+
+func (b *CuckooBackend) Report(ctx context.Context, sandboxId int) (*Report, error) {
+	report, err := b.apiClient().TasksReport(ctx, sandboxId)
+	if err != nil {
+		return nil, err
+	}
+
+	if report == nil {
+		return nil, nil
+	}
+
+	completedOn, _ := time.Parse(time.RFC1123, fmt.Sprintf("%v", report.Info.Ended))
+
+	return &Report{Score: report.Info.Score, Completedon: completedOn, Details: report}, nil
+}
+
+func (b *CuckooBackend) DeleteTask(ctx context.Context, sandboxId int) error {
+	return b.client().TasksDelete(ctx, sandboxId)
+}
+
+func (b *CuckooBackend) ListMachines(ctx context.Context) ([]Machine, error) {
+	allMachines, err := b.apiClient().ListMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var machines []Machine
+	for _, machine := range allMachines {
+		machines = append(machines, Machine{Name: machine.Name, Locked: machine.Locked})
+	}
+	return machines, nil
+}
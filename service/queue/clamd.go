@@ -3,11 +3,12 @@ package queues
 import (
 	"anti-apt-backend/extras"
 	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"strings"
-
-	"github.com/dutchcoders/go-clamd"
 )
 
 const (
@@ -53,49 +54,57 @@ func getClamdAddress() string {
 	// return address
 }
 
-func scanResultThroughClamd(clamd *clamd.Clamd, filePath string) (bool, error) {
-	// slog.Println("Scanning file: ", filePath)
-	response, err := clamd.ScanFile(filePath)
+// scanResultThroughClamd asks clamd at address to scan filePath and returns
+// the signature that matched. The connection is closed when ctx is done, which
+// ends a scan clamd is still busy with.
+func scanResultThroughClamd(ctx context.Context, address string, filePath string) (bool, string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", address)
 	if err != nil {
-		return false, err
+		return false, extras.EMPTY_STRING, err
 	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
-	// slog.Println("Response: ", response)
+	// slog.Println("Scanning file: ", filePath)
+	if _, err = fmt.Fprintf(conn, "zSCAN %s\x00", filePath); err != nil {
+		return false, extras.EMPTY_STRING, err
+	}
 
-	for s := range response {
-		if s.Status == RES_FOUND {
-			// slog.Println("Found malicious file: ", filePath)
-			return true, nil
+	// one null terminated "path: result" line per file, clamd closes the
+	// connection after the last
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString(0)
+		line = strings.TrimSpace(strings.TrimRight(line, "\x00"))
+		if line != extras.EMPTY_STRING {
+			// slog.Println("Response: ", line)
+			_, result, _ := strings.Cut(line, ": ")
+			switch {
+			case strings.HasSuffix(result, " "+RES_FOUND):
+				// slog.Println("Found malicious file: ", filePath)
+				return true, strings.TrimSuffix(result, " "+RES_FOUND), nil
+			case strings.HasSuffix(result, " "+RES_ERROR):
+				return false, extras.EMPTY_STRING, fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " "+RES_ERROR))
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, extras.EMPTY_STRING, ctx.Err()
+			}
+			if err == io.EOF {
+				return false, extras.EMPTY_STRING, nil
+			}
+			return false, extras.EMPTY_STRING, err
 		}
-		// slog.Println("Status: ", s.Description, s.Status, s.Hash, s.Path, s.Raw, s.Size)
 	}
-	return false, nil
-
 }
 
-func ScanFileThroughClamd(task Task) (bool, error) {
-
-	clamdAddress := getClamdAddress()
-	clamd := clamd.NewClamd(clamdAddress)
-
+// ScanFileThroughClamd also returns the name of the signature that matched.
+func ScanFileThroughClamd(ctx context.Context, task Task) (bool, string, error) {
 	// ignoreExtensions, _ := extensionsToIgnore()
 
 	filePath := fmt.Sprintf(extras.SANDBOX_FILE_PATHS+"%d", task.Id)
-
-	response, err := clamd.ScanFile(filePath)
-	if err != nil {
-		return false, err
-	}
-
-	// slog.Println("Response: ", response)
-
-	for s := range response {
-		if s.Status == RES_FOUND {
-			// slog.Println("Found malicious file: ", filePath)
-			return true, nil
-		}
-		// slog.Println("Status: ", s.Description, s.Status, s.Hash, s.Path, s.Raw, s.Size)
-	}
-	return false, nil
-
+	return scanResultThroughClamd(ctx, getClamdAddress(), filePath)
 }
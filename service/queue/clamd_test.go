@@ -0,0 +1,77 @@
+package queues
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClamd listens like clamd and answers every scan with answer, or keeps
+// the connection open without answering when answer is empty.
+func fakeClamd(t *testing.T, answer string) (string, <-chan struct{}) {
+	t.Helper()
+	address := filepath.Join(t.TempDir(), "clamd.ctl")
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		if _, err := reader.ReadString(0); err != nil {
+			return
+		}
+		if answer != "" {
+			conn.Write([]byte(answer))
+			return
+		}
+		// a hung scan, the client has to hang up
+		reader.ReadByte()
+		close(closed)
+	}()
+	return address, closed
+}
+
+func TestScanResultThroughClamd(t *testing.T) {
+	address, _ := fakeClamd(t, "/tmp/1: Eicar-Test-Signature FOUND\x00")
+	detected, signature, err := scanResultThroughClamd(context.Background(), address, "/tmp/1")
+	if err != nil || !detected || signature != "Eicar-Test-Signature" {
+		t.Errorf("got %t %q %v, want the eicar signature", detected, signature, err)
+	}
+
+	address, _ = fakeClamd(t, "/tmp/2: OK\x00")
+	detected, _, err = scanResultThroughClamd(context.Background(), address, "/tmp/2")
+	if err != nil || detected {
+		t.Errorf("clean file: got %t %v", detected, err)
+	}
+
+	address, _ = fakeClamd(t, "/tmp/3: lstat() failed: No such file or directory. ERROR\x00")
+	if _, _, err = scanResultThroughClamd(context.Background(), address, "/tmp/3"); err == nil {
+		t.Errorf("clamd error not returned")
+	}
+}
+
+func TestScanResultThroughClamdStopsWithContext(t *testing.T) {
+	address, closed := fakeClamd(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := scanResultThroughClamd(ctx, address, "/tmp/1"); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the context error", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("connection to clamd left open")
+	}
+}
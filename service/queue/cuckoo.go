@@ -1,7 +1,6 @@
 package queues
 
 import (
-	"anti-apt-backend/extras"
 	"bytes"
 	"context"
 	"crypto/tls"
@@ -77,7 +76,7 @@ func (m *MockCuckooClient) updateTaskStatuses() {
 	}
 }
 
-func (m *MockCuckooClient) CreateTaskFileMock(ctx context.Context, taskId int, filePath string) (int, error) {
+func (m *MockCuckooClient) SubmitFile(ctx context.Context, taskId int, fp string, opts SubmitOptions) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.createErr != nil {
@@ -93,18 +92,25 @@ func (m *MockCuckooClient) CreateTaskFileMock(ctx context.Context, taskId int, f
 	return id, nil
 }
 
-func (m *MockCuckooClient) ListAllTasksMock(ctx context.Context) ([]SandboxTest, error) {
+func (m *MockCuckooClient) SubmitUrl(ctx context.Context, taskId int, url string, opts SubmitOptions) (int, error) {
+	return m.SubmitFile(ctx, taskId, url, opts)
+}
+
+func (m *MockCuckooClient) ListTasks(ctx context.Context) ([]Sandbox, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var tasks []SandboxTest
+	var tasks []Sandbox
 	for _, task := range m.tasks {
-		tasks = append(tasks, *task)
+		tasks = append(tasks, Sandbox{
+			SandboxId:   task.SandboxId,
+			Status:      task.Status,
+			CompletedOn: task.CompletedOn,
+		})
 	}
-	// slog.Println("LIST ALL TASKS: ", tasks)
 	return tasks, nil
 }
 
-func (m *MockCuckooClient) ListMachinesMock(ctx context.Context) ([]Machine, error) {
+func (m *MockCuckooClient) ListMachines(ctx context.Context) ([]Machine, error) {
 	var machines []Machine
 	for i := 0; i < 8; i++ {
 		machines = append(machines, Machine{Locked: rand.Intn(2) == 0, Name: fmt.Sprintf("machine-%d", i+1)})
@@ -112,7 +118,7 @@ func (m *MockCuckooClient) ListMachinesMock(ctx context.Context) ([]Machine, err
 	return machines, nil
 }
 
-func (m *MockCuckooClient) TasksReportMock(ctx context.Context, sandboxId int) (*Report, error) {
+func (m *MockCuckooClient) Report(ctx context.Context, sandboxId int) (*Report, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.reportErr != nil {
@@ -128,7 +134,7 @@ func (m *MockCuckooClient) TasksReportMock(ctx context.Context, sandboxId int) (
 	}, nil
 }
 
-func (m *MockCuckooClient) TasksDeleteMock(ctx context.Context, sandboxId int) error {
+func (m *MockCuckooClient) DeleteTask(ctx context.Context, sandboxId int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.deleteErr != nil {
@@ -138,129 +144,6 @@ func (m *MockCuckooClient) TasksDeleteMock(ctx context.Context, sandboxId int) e
 	return nil
 }
 
-func fetchAllTasksFromSandboxMock() ([]Sandbox, error) {
-	client := NewMockCuckooClient()
-	allTasks, err := client.ListAllTasksMock(context.Background())
-	if err != nil {
-		return nil, err
-	}
-
-	var resp []Sandbox
-	for _, task := range allTasks {
-		resp = append(resp, Sandbox{
-			SandboxId:   task.SandboxId,
-			Status:      task.Status,
-			CompletedOn: task.CompletedOn,
-		})
-	}
-
-	// slog.Println("ALL SANDBOX TASKS: ", resp)
-	return resp, nil
-}
-
-func countOfFreeVmMock() (int, error) {
-	client := NewMockCuckooClient()
-	allMachines, err := client.ListMachinesMock(context.Background())
-	count := 0
-	if err != nil {
-		return count, err
-	}
-	for _, machine := range allMachines {
-		if !machine.Locked {
-			count++
-		}
-	}
-	return count, nil
-}
-
-func sendToSandboxMock(task Task) (int, error) {
-	client := NewMockCuckooClient()
-	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
-	sandboxId, err := client.CreateTaskFileMock(context.Background(), task.Id, fp)
-	if err != nil {
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN SANDBOX: %v", err))
-		return -1, err
-	}
-
-	if sandboxId == 0 {
-		// logger.LogAccToTaskId(task.Id, "SANDBOXID IS ZERO")
-	}
-
-	return sandboxId, nil
-}
-
-func fetchScoreFromSandboxMock(taskId int, sandboxId int) (float32, error) {
-	client := NewMockCuckooClient()
-	report, err := client.TasksReportMock(context.Background(), sandboxId)
-	if err != nil {
-		// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING REPORT: %v", err))
-		return 0, err
-	}
-
-	if report != nil {
-		return report.Score, nil
-	}
-
-	return 0, nil
-}
-
-func deleteSandboxDataMock(taskId int, sandboxId int) error {
-	if sandboxId == 0 {
-		return nil
-	}
-
-	client := NewMockCuckooClient()
-	err := client.TasksDeleteMock(context.Background(), sandboxId)
-	if err != nil {
-		// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE DELETING SANDBOX DATA: %v", err))
-		return err
-	} else {
-		// logger.LogAccToTaskId(taskId, fmt.Sprintf("DELETED SANDBOX DATA: %d", sandboxId))
-		// slog.Println("DELETED SANDBOX DATA: ", sandboxId)
-	}
-
-	return nil
-}
-
-func FlushSandboxDataMock() error {
-	allTasks, err := fetchAllTasksFromSandbox()
-	if err != nil {
-		return err
-	}
-
-	for _, task := range allTasks {
-		err := deleteSandboxData(task.SandboxId, task.SandboxId)
-		if err != nil {
-			// slog.Println("ERROR WHILE DELETING SANDBOX DATA FOR ID: ", task.SandboxId, err)
-		}
-	}
-	return nil
-}
-
-func RemoveSandboxTasksWhichAreNotInTasksAndReachedTimeOutMock(allSandboxTasks []Sandbox, tasks []Task) error {
-	taskMap := make(map[int]bool)
-	for _, task := range tasks {
-		if task.SandboxId > 0 {
-			taskMap[task.SandboxId] = true
-		}
-	}
-
-	for _, sandboxTask := range allSandboxTasks {
-		if _, ok := taskMap[sandboxTask.SandboxId]; !ok && !sandboxTask.CompletedOn.IsZero() && time.Since(sandboxTask.CompletedOn) > 30*time.Minute {
-			// logger.LogAccToTaskId(sandboxTask.SandboxId, fmt.Sprintf("DELETING SANDBOX DATA FOR TASK: %v", sandboxTask))
-			// slog.Println("DELETING SANDBOX DATA FOR TASK: ", sandboxTask)
-			err := pushToQueue(LogQueue, Task{SandboxId: sandboxTask.SandboxId}, SandboxApiTimeout)
-			if err != nil {
-				// logger.LogAccToTaskId(sandboxTask.SandboxId, fmt.Sprintf("DELETING SANDBOX DATA FOR TASK: %v", sandboxTask))
-				// slog.Println("DELETING SANDBOX DATA FOR TASK: ", sandboxTask)
-				go deleteSandboxData(sandboxTask.SandboxId, sandboxTask.SandboxId)
-			}
-		}
-	}
-
-	return nil
-}
-
 func SendAcknowledgementToClientIpMock(taskId int) {
 
 	// slog.Println("SENDING ACKNOWLEDGEMENT TO CLIENT IP: ", taskId)
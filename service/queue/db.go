@@ -6,8 +6,13 @@ import (
 	"anti-apt-backend/extras"
 	"anti-apt-backend/hash"
 	"anti-apt-backend/util"
+	"anti-apt-backend/verdictcache"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 const (
@@ -15,36 +20,97 @@ const (
 	TaskFinishedTable      = "task_finished_tables"
 	TaskDuplicateTable     = "task_duplicate_tables"
 	FileOnDemandTable      = "file_on_demands"
+	UrlOnDemandTable       = "url_on_demands"
 )
 
-func fetchNotInQueueTasks() ([]Task, error) {
+// claimQuery selects and locks up to limit unclaimed tasks in status matching
+// condition, oldest first.
+func claimQuery(status string, now time.Time, condition string, limit int) string {
+	// url tasks have url_id set instead of id and read the submission from url_on_demands
+	return fmt.Sprintf("SELECT live.task_live_analysis_id AS live_id, COALESCE(live.id, live.url_id) AS id, IF(live.url_id IS NULL, '%s', '%s') AS type, live.status, live.sandbox_id, live.sandbox_node, live.running_retry_count, live.sandbox_retry_count, live.version, live.md5, live.sha, live.sha256, COALESCE(live.running_started_at, fod.submitted_time, uod.submitted_time) AS running_started_at, COALESCE(fod.submitted_time, uod.submitted_time) AS submitted_time, COALESCE(fod.submitted_by, uod.submitted_by) AS submitted_by, COALESCE(fod.file_name, uod.url_name) AS file_name, COALESCE(fod.client_ip, uod.client_ip) AS client_ip, COALESCE(fod.priority, uod.priority) AS priority, IFNULL(fod.parent_id, 0) AS parent_id, IFNULL(COALESCE(fod.reanalysis_of, uod.reanalysis_of), 0) AS reanalysis_of, IFNULL(COALESCE(fod.os_supported, uod.os_supported), '') AS platform, IFNULL(COALESCE(fod.analysis_timeout, uod.analysis_timeout), 0) AS timeout, IFNULL(fod.vm_tag, '') AS vm_tag, IFNULL(fod.route, '') AS route, IFNULL(fod.package, '') AS package, IFNULL(fod.options, '') AS options FROM %s live LEFT JOIN %s fod ON live.id = fod.id LEFT JOIN %s uod ON live.url_id = uod.id WHERE live.status = '%s' AND (live.claimed_until IS NULL OR live.claimed_until < '%s') AND %s ORDER BY live.task_live_analysis_id LIMIT %d FOR UPDATE OF live SKIP LOCKED", extras.TASK_TYPE_FILE, extras.TASK_TYPE_URL, TaskLiveAnalysingTable, FileOnDemandTable, UrlOnDemandTable, status, now.Format(extras.TIME_FORMAT), condition, limit)
+}
+
+// priorityConditions match the tasks of each priority class, tasks without a
+// known priority count as manual like priorityOf has it.
+func priorityConditions() map[string]string {
+	var known []string
+	conditions := make(map[string]string)
+	for class := range PRIORITY_WEIGHTS {
+		if class != extras.PRIORITY_MANUAL {
+			known = append(known, "'"+class+"'")
+			conditions[class] = fmt.Sprintf("COALESCE(fod.priority, uod.priority) = '%s'", class)
+		}
+	}
+	sort.Strings(known)
+	conditions[extras.PRIORITY_MANUAL] = fmt.Sprintf("IFNULL(COALESCE(fod.priority, uod.priority), '') NOT IN (%s)", strings.Join(known, ", "))
+	return conditions
+}
+
+// claimTasks locks up to limit unclaimed tasks in status and leases them to
+// the caller. Rows locked by another worker are skipped rather than waited on.
+// States with a scheduler hand out tasks in fair queuing order instead of by
+// id, from a window of the oldest tasks of every priority class so a backlog
+// of one class cannot hide the others.
+func claimTasks(status string, limit int) ([]Task, error) {
 
 	var tasks []Task
 
-	queryString := fmt.Sprintf("SELECT task_live_analysis_tables.*, file_on_demands.submitted_time FROM %s INNER JOIN %s ON %s.id = %s.id WHERE task_live_analysis_tables.status IN ('%s', '%s') or task_live_analysis_tables.log_queue_failed = true", TaskLiveAnalysingTable, FileOnDemandTable, TaskLiveAnalysingTable, FileOnDemandTable, PendingNotInQueue, RunningNotInQueue)
-	liveTask := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
-		Result:       &tasks,
+	scheduler, fair := schedulers[status]
+	now := time.Now()
+	queries := []string{claimQuery(status, now, "TRUE", limit)}
+	if fair {
+		queries = nil
+		for _, condition := range priorityConditions() {
+			queries = append(queries, claimQuery(status, now, condition, max(limit, CLAIM_WINDOW)))
+		}
 	}
-	err := dao.GormOperations(&liveTask, config.Db, dao.EXEC)
+
+	err := config.Db.Transaction(func(tx *gorm.DB) error {
+		for _, queryString := range queries {
+			var window []Task
+			if err := tx.Raw(queryString).Scan(&window).Error; err != nil {
+				return err
+			}
+			tasks = append(tasks, window...)
+		}
+		if len(tasks) == 0 {
+			return nil
+		}
+
+		if fair {
+			sort.Slice(tasks, func(i, j int) bool {
+				return tasks[i].LiveId < tasks[j].LiveId
+			})
+			tasks = scheduler.pick(tasks, limit)
+		}
+
+		ids := ""
+		for _, task := range tasks {
+			ids += fmt.Sprintf("%d,", task.LiveId)
+		}
+		ids = ids[:len(ids)-1]
+
+		queryString := fmt.Sprintf("UPDATE %s SET claimed_until = '%s', version = version + 1 WHERE task_live_analysis_id IN (%s)", TaskLiveAnalysingTable, now.Add(CLAIM_LEASE).Format(extras.TIME_FORMAT), ids)
+		return tx.Exec(queryString).Error
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	for i := range tasks {
+		tasks[i].Version++
+	}
 	return tasks, nil
 }
 
-func updateTaskStatus(taskId int, status string) error {
-
-	queryString := fmt.Sprintf("UPDATE %s SET status = '%s' WHERE id = %d", TaskLiveAnalysingTable, status, taskId)
-	liveTask := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
-	}
-	err := dao.GormOperations(&liveTask, config.Db, dao.EXEC)
-	if err != nil {
-		return err
+// updateLiveTask saves task and gives up its claim.
+func updateLiveTask(task Task) string {
+	runningStartedAt := "NULL"
+	if !task.RunningStartedAt.IsZero() {
+		runningStartedAt = fmt.Sprintf("'%s'", task.RunningStartedAt.Format(extras.TIME_FORMAT))
 	}
-	return nil
+
+	return fmt.Sprintf("UPDATE %s SET status = '%s', sandbox_id = %d, sandbox_node = '%s', sandbox_retry_count = %d, running_retry_count = %d, running_started_at = %s, claimed_until = NULL, version = version + 1 WHERE task_live_analysis_id = %d AND version = %d", TaskLiveAnalysingTable, task.Status, task.SandboxId, task.SandboxNode, task.SandboxRetryCount, task.RunningRetryCount, runningStartedAt, task.LiveId, task.Version)
 }
 
 func moveTaskToFinishedTable(task Task, aborted bool) []string {
@@ -52,9 +118,9 @@ func moveTaskToFinishedTable(task Task, aborted bool) []string {
 	// logger.LogAccToTaskId(task.Id, fmt.Sprintf("MOVING TASK TO FINISHED TABLE FOR TASK %d", task.Id))
 	// slog.Println("MOVING TASK TO FINISHED TABLE FOR TASK %d", task.Id)
 
-	queryString := fmt.Sprintf("DELETE FROM %s WHERE id = %d", TaskLiveAnalysingTable, task.Id)
+	queryString := fmt.Sprintf("DELETE FROM %s WHERE task_live_analysis_id = %d AND version = %d", TaskLiveAnalysingTable, task.LiveId, task.Version)
 	queryStringArr = append(queryStringArr, queryString)
-	queryString = fmt.Sprintf("INSERT INTO %s (id, sandbox_id, aborted) VALUES (%d, %d, %t)", TaskFinishedTable, task.Id, task.SandboxId, aborted)
+	queryString = fmt.Sprintf("INSERT INTO %s (%s, sandbox_id, sandbox_node, aborted) VALUES (%d, %d, '%s', %t)", TaskFinishedTable, keyColumn(task), task.Id, task.SandboxId, task.SandboxNode, aborted)
 	// dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, queryString)
 	queryStringArr = append(queryStringArr, queryString)
 
@@ -73,7 +139,7 @@ func processDuplicateTasksForReported(task Task, score float32) []string {
 
 	var duplicateTasks []int
 
-	queryString := fmt.Sprintf("SELECT id FROM %s WHERE md5 = '%s' or sha = '%s' or sha256 = '%s'", TaskDuplicateTable, task.Md5, task.SHA, task.SHA256)
+	queryString := fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL AND (md5 = '%s' or sha = '%s' or sha256 = '%s')", keyColumn(task), TaskDuplicateTable, keyColumn(task), task.Md5, task.SHA, task.SHA256)
 	duplicateTask := dao.DatabaseOperationsRepo{
 		QueryExecSet: []string{queryString},
 		Result:       &duplicateTasks,
@@ -88,16 +154,18 @@ func processDuplicateTasksForReported(task Task, score float32) []string {
 		return []string{}
 	}
 
-	for _, duplicate := range duplicateTasks {
-		go deleteLocalTask(duplicate)
+	if task.Type != extras.TASK_TYPE_URL {
+		for _, duplicate := range duplicateTasks {
+			go deleteLocalTask(duplicate)
+		}
 	}
 
 	var queryStringArr []string
-	queryString = fmt.Sprintf("INSERT INTO %s (id, sandbox_id, aborted) VALUES", TaskFinishedTable)
+	queryString = fmt.Sprintf("INSERT INTO %s (%s, sandbox_id, sandbox_node, aborted) VALUES", TaskFinishedTable, keyColumn(task))
 
 	for _, duplicate := range duplicateTasks {
-		queryString += fmt.Sprintf(" (%d, %d, 0),", duplicate, task.SandboxId)
-		queryStringArr = append(queryStringArr, updateFOD(Task{Id: duplicate}, score))
+		queryString += fmt.Sprintf(" (%d, %d, '%s', 0),", duplicate, task.SandboxId, task.SandboxNode)
+		queryStringArr = append(queryStringArr, updateFOD(Task{Id: duplicate, Type: task.Type}, score), verdictHistoryQuery(Task{Id: duplicate, Type: task.Type}))
 	}
 
 	queryString = queryString[:len(queryString)-1]
@@ -110,7 +178,7 @@ func processDuplicateTasksForReported(task Task, score float32) []string {
 	}
 	idsToRemove = idsToRemove[:len(idsToRemove)-1]
 
-	queryString = fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", TaskDuplicateTable, idsToRemove)
+	queryString = fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", TaskDuplicateTable, keyColumn(task), idsToRemove)
 	queryStringArr = append(queryStringArr, queryString)
 
 	return queryStringArr
@@ -125,7 +193,7 @@ func processDuplicateTasksForAborted(task Task) []string {
 
 	var count int64 = 0
 
-	queryString := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE md5 = '%s' or sha = '%s' or sha256 = '%s'", TaskDuplicateTable, task.Md5, task.SHA, task.SHA256)
+	queryString := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s IS NOT NULL AND (md5 = '%s' or sha = '%s' or sha256 = '%s')", TaskDuplicateTable, keyColumn(task), task.Md5, task.SHA, task.SHA256)
 	duplicateTaskData := dao.DatabaseOperationsRepo{
 		QueryExecSet: []string{queryString},
 		Result:       &count,
@@ -138,7 +206,7 @@ func processDuplicateTasksForAborted(task Task) []string {
 
 	if count > 0 {
 
-		queryString = fmt.Sprintf("SELECT id FROM %s WHERE md5 = '%s' or sha = '%s' or sha256 = '%s' ORDER BY id LIMIT 1", TaskDuplicateTable, task.Md5, task.SHA, task.SHA256)
+		queryString = fmt.Sprintf("SELECT %s AS id, md5, sha, sha256 FROM %s WHERE %s IS NOT NULL AND (md5 = '%s' or sha = '%s' or sha256 = '%s') ORDER BY task_duplicate_id LIMIT 1", keyColumn(task), TaskDuplicateTable, keyColumn(task), task.Md5, task.SHA, task.SHA256)
 		dbOprs := dao.DatabaseOperationsRepo{
 			QueryExecSet: []string{queryString},
 			Result:       &duplicateTask,
@@ -149,27 +217,23 @@ func processDuplicateTasksForAborted(task Task) []string {
 			// slog.Println("ERROR WHILE FETCHING DUPLICATE:", task.Id, err)
 		}
 
-		queryString = fmt.Sprintf("INSERT INTO %s (id, status) VALUES (%d, '%s')", TaskLiveAnalysingTable, duplicateTask.Id, PendingNotInQueue)
+		queryString = fmt.Sprintf("INSERT INTO %s (%s, status, running_retry_count, sandbox_retry_count, version, md5, sha, sha256) VALUES (%d, '%s', %d, %d, %d, '%s', '%s', '%s')", TaskLiveAnalysingTable, keyColumn(task), duplicateTask.Id, Pending, 0, 0, 0, duplicateTask.Md5, duplicateTask.SHA, duplicateTask.SHA256)
 		queryStringArr = append(queryStringArr, queryString)
 
-		queryString = fmt.Sprintf("DELETE FROM %s WHERE id = %d", TaskDuplicateTable, duplicateTask.Id)
+		queryString = fmt.Sprintf("DELETE FROM %s WHERE %s = %d", TaskDuplicateTable, keyColumn(task), duplicateTask.Id)
 		queryStringArr = append(queryStringArr, queryString)
 	}
 
 	return queryStringArr
 }
 
-func updateStatusChangeDirectlyThroughDb(task Task) error {
-
-	queryString := fmt.Sprintf("UPDATE %s SET status = '%s', sandbox_id = %d, queue_retry_count = %d, log_queue_failed = %t, sandbox_retry_count = %d, running_retry_count = %d  WHERE id = %d", TaskLiveAnalysingTable, task.Status, task.SandboxId, task.QueueRetryCount, task.LogQueueFailed, task.SandboxRetryCount, task.RunningRetryCount, task.Id)
-	liveTask := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
+// keyColumn is the column holding the task's id in the live, finished and
+// duplicate tables.
+func keyColumn(task Task) string {
+	if task.Type == extras.TASK_TYPE_URL {
+		return "url_id"
 	}
-	err := dao.GormOperations(&liveTask, config.Db, dao.EXEC)
-	if err != nil {
-		return err
-	}
-	return nil
+	return "id"
 }
 
 func updateFOD(task Task, score float32) string {
@@ -182,6 +246,10 @@ func updateFOD(task Task, score float32) string {
 		final_verdict = extras.BLOCK
 	}
 
+	if task.Type == extras.TASK_TYPE_URL {
+		return fmt.Sprintf("UPDATE %s SET score = %f, rating = '%s', final_verdict = '%s', status = '%s', finished_time = '%s' WHERE id = %d", UrlOnDemandTable, score, rating, final_verdict, extras.REPORTED, time.Now().Format(extras.TIME_FORMAT), task.Id)
+	}
+
 	queryString := fmt.Sprintf("UPDATE %s SET score = %f, rating = '%s', final_verdict = '%s', finished_time = '%s' WHERE id = %d", FileOnDemandTable, score, rating, final_verdict, time.Now().Format(extras.TIME_FORMAT), task.Id)
 	// fileOnDemand := dao.DatabaseOperationsRepo{
 	// 	QueryExecSet: []string{queryString},
@@ -194,6 +262,41 @@ func updateFOD(task Task, score float32) string {
 	return queryString
 }
 
+// cacheVerdict gives the verdict cache the verdict updateFOD wrote for the
+// file.
+func cacheVerdict(task Task, score float32) {
+	verdict := extras.ALLOW
+	if score != 0 {
+		verdict = extras.BLOCK
+	}
+	putVerdict(task, verdict, string(util.GetVerdict(score)), score)
+}
+
+func putVerdict(task Task, verdict string, rating string, score float32) {
+	entry := verdictcache.Entry{
+		Verdict: verdict,
+		Rating:  rating,
+		Score:   score,
+		Source:  extras.VERDICT_SOURCE_ANALYSIS,
+		TaskId:  task.Id,
+	}
+	entry.FirstSeen, entry.LastSeen = task.SubmittedTime, task.SubmittedTime
+	if task.ReanalysisOf > 0 {
+		entry.Source = extras.VERDICT_SOURCE_REANALYSIS
+	}
+	verdictcache.Put(entry, task.Md5, task.SHA, task.SHA256)
+}
+
+// verdictHistoryQuery copies the verdict updateFOD set on the task into the
+// verdict history, under the first submission when it is a reanalysis.
+func verdictHistoryQuery(task Task) string {
+	table := FileOnDemandTable
+	if task.Type == extras.TASK_TYPE_URL {
+		table = UrlOnDemandTable
+	}
+	return fmt.Sprintf("INSERT INTO %s (task_id, task_type, original_id, rating, score, final_verdict, source, changed_by, created_at) SELECT id, '%s', IF(IFNULL(reanalysis_of, 0) > 0, reanalysis_of, id), rating, score, final_verdict, IF(IFNULL(reanalysis_of, 0) > 0, '%s', '%s'), submitted_by, '%s' FROM %s WHERE id = %d", extras.VerdictHistoryTable, task.Type, extras.VERDICT_SOURCE_REANALYSIS, extras.VERDICT_SOURCE_ANALYSIS, time.Now().Format(extras.TIME_FORMAT), table, task.Id)
+}
+
 func saveVerdictInHash(task Task, score float32) {
 
 	queryString := fmt.Sprintf("SELECT id FROM %s WHERE id = %d", FileOnDemandTable, task.Id)
@@ -226,21 +329,6 @@ func saveVerdictInHash(task Task, score float32) {
 
 }
 
-func updateLiveTaskDirectlyThroughDb(task Task) error {
-
-	// logger.LogAccToTaskId(task.Id, fmt.Sprintf("LOG QUEUE FAILED: %t, SETTING STATUS TO %d", task.LogQueueFailed, task.Id))
-
-	queryString := fmt.Sprintf("UPDATE %s SET status = '%s', sandbox_id = %d, queue_retry_count = %d, log_queue_failed = %t, sandbox_retry_count = %d, running_retry_count = %d  WHERE id = %d", TaskLiveAnalysingTable, task.Status, task.SandboxId, task.QueueRetryCount, task.LogQueueFailed, task.SandboxRetryCount, task.RunningRetryCount, task.Id)
-	liveTask := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
-	}
-	err := dao.GormOperations(&liveTask, config.Db, dao.EXEC)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func fetchLiveTaskCount() (int, error) {
 
 	queryString := fmt.Sprintf("SELECT COUNT(*) FROM %s", TaskLiveAnalysingTable)
@@ -257,16 +345,3 @@ func fetchLiveTaskCount() (int, error) {
 
 	return liveTaskCount, nil
 }
-
-func updateLogQueueFail(taskId int, flag bool) error {
-
-	queryString := fmt.Sprintf("UPDATE %s SET log_queue_failed = %t WHERE id = %d", TaskLiveAnalysingTable, flag, taskId)
-	liveTask := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
-	}
-	err := dao.GormOperations(&liveTask, config.Db, dao.EXEC)
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,117 @@
+package queues
+
+import (
+	"anti-apt-backend/extras"
+	"sync"
+)
+
+// share of the sandbox each priority class gets while all of them have work
+var PRIORITY_WEIGHTS = map[string]float64{
+	extras.PRIORITY_INLINE: 6,
+	extras.PRIORITY_MANUAL: 3,
+	extras.PRIORITY_BULK:   1,
+}
+
+// cuckoo runs higher priority tasks first when its own queue backs up
+var CUCKOO_PRIORITIES = map[string]int{
+	extras.PRIORITY_INLINE: 3,
+	extras.PRIORITY_MANUAL: 2,
+	extras.PRIORITY_BULK:   1,
+}
+
+// oldest tasks of each priority class the fair pick chooses from
+const CLAIM_WINDOW = 500
+
+func priorityOf(task Task) string {
+	if _, ok := PRIORITY_WEIGHTS[task.Priority]; ok {
+		return task.Priority
+	}
+	return extras.PRIORITY_MANUAL
+}
+
+// a flow is one submitter within a priority class, the firewall is told apart
+// by its ip and analysts by their admin name
+func flowOf(task Task) string {
+	if task.ClientIp != extras.EMPTY_STRING {
+		return priorityOf(task) + "/" + task.ClientIp
+	}
+	return priorityOf(task) + "/" + task.SubmittedBy
+}
+
+// fairScheduler orders tasks by weighted fair queuing. Each class's weight is
+// split evenly among its active flows, so a burst from one firewall or one
+// analyst only delays its own later submissions.
+type fairScheduler struct {
+	mu     sync.Mutex
+	vtime  float64
+	finish map[string]float64
+}
+
+var schedulers = map[string]*fairScheduler{
+	Pending: newFairScheduler(),
+	Queued:  newFairScheduler(),
+}
+
+func newFairScheduler() *fairScheduler {
+	return &fairScheduler{finish: make(map[string]float64)}
+}
+
+// pick returns up to n of tasks in the order they should be served. tasks must
+// be sorted by id so each flow stays first in, first out.
+func (s *fairScheduler) pick(tasks []Task, n int) []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flows := make(map[string][]Task)
+	var flowOrder []string
+	activeFlows := make(map[string]int)
+	for _, task := range tasks {
+		flow := flowOf(task)
+		if _, ok := flows[flow]; !ok {
+			flowOrder = append(flowOrder, flow)
+			activeFlows[priorityOf(task)]++
+		}
+		flows[flow] = append(flows[flow], task)
+	}
+
+	// every task arrives now, so a flow's tags continue from its last served
+	// task or from the current virtual time, whichever is later
+	start := make(map[string]float64)
+	for _, flow := range flowOrder {
+		start[flow] = max(s.vtime, s.finish[flow])
+	}
+
+	var resp []Task
+	for len(resp) < n {
+		best := extras.EMPTY_STRING
+		var bestFinish float64
+		for _, flow := range flowOrder {
+			if len(flows[flow]) == 0 {
+				continue
+			}
+			class := priorityOf(flows[flow][0])
+			finish := start[flow] + float64(activeFlows[class])/PRIORITY_WEIGHTS[class]
+			if best == extras.EMPTY_STRING || finish < bestFinish {
+				best, bestFinish = flow, finish
+			}
+		}
+		if best == extras.EMPTY_STRING {
+			break
+		}
+
+		resp = append(resp, flows[best][0])
+		flows[best] = flows[best][1:]
+		start[best] = bestFinish
+		s.finish[best] = bestFinish
+		s.vtime = bestFinish
+	}
+
+	// idle flows would restart from vtime anyway
+	for flow, finish := range s.finish {
+		if finish <= s.vtime {
+			delete(s.finish, flow)
+		}
+	}
+
+	return resp
+}
@@ -0,0 +1,43 @@
+package queues
+
+import (
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"fmt"
+)
+
+// score given to a file on the block list
+const FILE_LIST_SCORE = 10
+
+// checkFileLists looks the task's file up in the allow and block lists. A
+// listed file is decided there without being scanned, an empty status is
+// returned otherwise.
+func checkFileLists(task *Task) string {
+	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
+	entry, err := dao.LookupFileList(task.FileName, fp, task.Md5, task.SHA, task.SHA256)
+	if err != nil || entry == nil {
+		return extras.EMPTY_STRING
+	}
+
+	task.Detail = fmt.Sprintf("%s %s %s", entry.List, entry.Type, entry.Value)
+	if entry.List == extras.ALLOW {
+		task.Reason = extras.TASK_REASON_FILE_LIST_ALLOWED
+		task.Score = 0
+		return AllowedThroughPrefilter
+	}
+	task.Reason = extras.TASK_REASON_FILE_LIST_BLOCKED
+	task.Score = FILE_LIST_SCORE
+	return ReportedThroughPrefilter
+}
+
+// decidedByFileList tells whether the task's verdict came from the lists, such
+// a verdict goes once the entry is removed so it is not cached.
+func decidedByFileList(task Task) bool {
+	return task.Reason == extras.TASK_REASON_FILE_LIST_ALLOWED || task.Reason == extras.TASK_REASON_FILE_LIST_BLOCKED
+}
+
+// fileListStatusQuery marks the file as not analysed, the verdict cache does
+// not load such files.
+func fileListStatusQuery(task Task) string {
+	return fmt.Sprintf("UPDATE %s SET status = '%s' WHERE id = %d", FileOnDemandTable, extras.PREVIOUSLY_SCANNED_FILE, task.Id)
+}
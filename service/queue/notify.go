@@ -0,0 +1,395 @@
+package queues
+
+import (
+	"anti-apt-backend/alert"
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/siem"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MAX_NOTIFICATION_ATTEMPTS = 10
+	// the wait before the nth retry doubles from NOTIFICATION_RETRY_BASE up to
+	// NOTIFICATION_RETRY_MAX
+	NOTIFICATION_RETRY_BASE = 30 * time.Second
+	NOTIFICATION_RETRY_MAX  = time.Hour
+	NOTIFICATION_TIMEOUT    = 10 * time.Second
+	NOTIFICATION_BATCH      = 50
+	// delivered notifications are kept this long for the delivery log
+	NOTIFICATION_RETENTION = 30 * 24 * time.Hour
+)
+
+// woken when a notification is queued, so it goes out without waiting for the
+// next poll
+var notificationWake = make(chan struct{}, 1)
+
+// deliveries reuse the connections of one client that verifies certificates
+// and one that does not
+var (
+	notificationClient         = &http.Client{}
+	insecureNotificationClient = &http.Client{Transport: insecureTransport()}
+)
+
+func insecureTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return transport
+}
+
+// A subscriber turns an event into deliveries and sends them. The firewall
+// that submitted the task and the configured webhooks are the two kinds.
+type subscriber interface {
+	outbox(event model.NotificationEvent) ([]model.NotificationOutbox, error)
+	deliver(ctx context.Context, entry model.NotificationOutbox) (int, error)
+}
+
+var subscribers = map[string]subscriber{
+	extras.SUBSCRIBER_FIREWALL: firewallSubscriber{},
+	extras.SUBSCRIBER_WEBHOOK:  webhookSubscriber{},
+}
+
+// firewallSubscriber sends the verdict back to the firewall device that
+// submitted the task, in the format the firewall has always read.
+type firewallSubscriber struct{}
+
+func (firewallSubscriber) outbox(event model.NotificationEvent) ([]model.NotificationOutbox, error) {
+	// the firewall only knows the tasks it submitted, not archive members, and
+	// is not told of overrides
+	if event.ClientIp == extras.EMPTY_STRING || event.ParentId != 0 || event.Event == extras.EVENT_VERDICT_OVERRIDDEN {
+		return nil, nil
+	}
+
+	verdict := event.FinalVerdict
+	if verdict == extras.EMPTY_STRING {
+		verdict = ANALYSING
+	}
+	payload := fmt.Sprintf(`{"verdict": "%s", "taskID": %d}`, verdict, event.TaskId)
+	if event.TaskType == extras.TASK_TYPE_URL {
+		payload = fmt.Sprintf(`{"verdict": "%s", "taskID": %d, "type": "%s"}`, verdict, event.TaskId, extras.TASK_TYPE_URL)
+	}
+
+	return []model.NotificationOutbox{{
+		Subscriber: extras.SUBSCRIBER_FIREWALL,
+		Target:     "https://" + event.ClientIp + ":8085/verdict",
+		Payload:    payload,
+	}}, nil
+}
+
+func (firewallSubscriber) deliver(ctx context.Context, entry model.NotificationOutbox) (int, error) {
+	// the firewall serves a self signed certificate
+	return postNotification(ctx, entry.Target, entry.Payload, nil, true)
+}
+
+type webhookSubscriber struct{}
+
+func (webhookSubscriber) outbox(event model.NotificationEvent) ([]model.NotificationOutbox, error) {
+	var webhooks []model.Webhook
+	webhookRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s WHERE enabled = true", extras.WebhookTable)},
+		Result:       &webhooks,
+	}
+	if err := dao.GormOperations(&webhookRepo, config.Db, dao.EXEC); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []model.NotificationOutbox
+	for _, webhook := range webhooks {
+		if webhook.Events != extras.EMPTY_STRING && !slices.Contains(strings.Split(webhook.Events, ","), event.Event) {
+			continue
+		}
+		entries = append(entries, model.NotificationOutbox{
+			Subscriber: extras.SUBSCRIBER_WEBHOOK,
+			WebhookId:  webhook.Id,
+			Target:     webhook.Url,
+			Payload:    string(payload),
+		})
+	}
+	return entries, nil
+}
+
+// deliver reads the webhook again, so a changed url or secret applies to the
+// retries of earlier events too.
+func (webhookSubscriber) deliver(ctx context.Context, entry model.NotificationOutbox) (int, error) {
+	var webhook model.Webhook
+	if err := config.Db.Where("id = ?", entry.WebhookId).First(&webhook).Error; err != nil {
+		return 0, fmt.Errorf("webhook %d: %v", entry.WebhookId, err)
+	}
+
+	headers := map[string]string{
+		"X-WiJungle-Event":    entry.Event,
+		"X-WiJungle-Delivery": strconv.Itoa(entry.Id),
+	}
+	if webhook.Secret != extras.EMPTY_STRING {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers["X-WiJungle-Timestamp"] = timestamp
+		headers["X-WiJungle-Signature"] = "sha256=" + SignNotification(webhook.Secret, timestamp, entry.Payload)
+	}
+	return postNotification(ctx, webhook.Url, entry.Payload, headers, webhook.SkipTlsVerify)
+}
+
+// SignNotification is the HMAC-SHA256 of "timestamp.payload" under secret, hex
+// encoded. Receivers check it against the X-WiJungle-Signature header.
+func SignNotification(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func postNotification(ctx context.Context, target string, payload string, headers map[string]string, skipTlsVerify bool) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, NOTIFICATION_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewBufferString(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := notificationClient
+	if skipTlsVerify {
+		client = insecureNotificationClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// taskEvent builds the event of a file or url task from its saved state.
+func taskEvent(event string, taskType string, taskId int) (model.NotificationEvent, error) {
+	notification := model.NotificationEvent{
+		Id:         uuid.NewString(),
+		Event:      event,
+		OccurredAt: time.Now(),
+		TaskId:     taskId,
+		TaskType:   taskType,
+	}
+
+	if taskType == extras.TASK_TYPE_URL {
+		var uod model.UrlOnDemand
+		if err := config.Db.Where("id = ?", taskId).First(&uod).Error; err != nil {
+			return notification, err
+		}
+		notification.Name = uod.UrlName
+		notification.Status = uod.Status
+		notification.Rating = uod.Rating
+		notification.Score = uod.Score
+		notification.FinalVerdict = uod.FinalVerdict
+		notification.OverriddenBy = uod.OverriddenBy
+		notification.ClientIp = uod.ClientIp
+		return notification, nil
+	}
+
+	var fod model.FileOnDemand
+	if err := config.Db.Where("id = ?", taskId).First(&fod).Error; err != nil {
+		return notification, err
+	}
+	notification.TaskType = extras.TASK_TYPE_FILE
+	notification.ParentId = fod.ParentId
+	notification.Name = fod.FileName
+	notification.Md5 = fod.Md5
+	notification.SHA = fod.SHA
+	notification.SHA256 = fod.SHA256
+	notification.Status = fod.Status
+	notification.Rating = fod.Rating
+	notification.Score = fod.Score
+	notification.FinalVerdict = fod.FinalVerdict
+	notification.OverriddenBy = fod.OverriddenBy
+	notification.ClientIp = fod.ClientIp
+	return notification, nil
+}
+
+var ratingSeverity = map[string]int{
+	string(model.Critical):   10,
+	string(model.HighRisk):   8,
+	string(model.MediumRisk): 5,
+	string(model.LowRisk):    3,
+}
+
+// forwardTaskEvent sends the task's verdict to the SIEM, it does not go
+// through the outbox as the forwarder spools on its own.
+func forwardTaskEvent(notification model.NotificationEvent) {
+	event := siem.Event{
+		Category: extras.SIEM_CATEGORY_TASK,
+		Name:     notification.Event,
+		Severity: 1,
+		Message:  fmt.Sprintf("%s task %d %s, verdict %s", notification.TaskType, notification.TaskId, notification.Status, notification.FinalVerdict),
+		Time:     notification.OccurredAt,
+		Fields: map[string]string{
+			"task_id":   fmt.Sprintf("%d", notification.TaskId),
+			"task_type": notification.TaskType,
+			"verdict":   notification.FinalVerdict,
+			"rating":    notification.Rating,
+			"client_ip": notification.ClientIp,
+		},
+	}
+	if severity, ok := ratingSeverity[notification.Rating]; ok {
+		event.Severity = severity
+	}
+	if notification.Event == extras.EVENT_TASK_ABORTED {
+		event.Severity = 3
+	}
+	if notification.TaskType == extras.TASK_TYPE_URL {
+		event.Fields["url"] = notification.Name
+	} else {
+		event.Fields["file_name"] = notification.Name
+		event.Fields["sha256"] = notification.SHA256
+	}
+	if notification.OverriddenBy != extras.EMPTY_STRING {
+		event.Fields["user"] = notification.OverriddenBy
+	}
+	siem.Forward(event)
+}
+
+// NotifyTask queues event for every subscriber interested in it. The
+// deliveries are saved before anything is sent, a restart does not lose them.
+func NotifyTask(event string, taskType string, taskId int) {
+	notification, err := taskEvent(event, taskType, taskId)
+	if err != nil {
+		// slog.Println("ERROR WHILE BUILDING NOTIFICATION: ", taskId, err)
+		return
+	}
+	forwardTaskEvent(notification)
+	if event == extras.EVENT_TASK_REPORTED {
+		alert.TaskReported(notification)
+	}
+
+	var entries []model.NotificationOutbox
+	for _, s := range subscribers {
+		subscriberEntries, err := s.outbox(notification)
+		if err != nil {
+			// slog.Println("ERROR WHILE QUEUEING NOTIFICATION: ", taskId, err)
+			continue
+		}
+		entries = append(entries, subscriberEntries...)
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for i := range entries {
+		entries[i].Event = event
+		entries[i].Status = extras.NOTIFICATION_PENDING
+		entries[i].NextAttemptAt = now
+		entries[i].CreatedAt = now
+	}
+	if err = config.Db.Create(&entries).Error; err != nil {
+		// slog.Println("ERROR WHILE SAVING NOTIFICATIONS: ", taskId, err)
+		return
+	}
+
+	select {
+	case notificationWake <- struct{}{}:
+	default:
+	}
+}
+
+func notificationBackoff(attempts int) time.Duration {
+	wait := NOTIFICATION_RETRY_BASE
+	for i := 1; i < attempts && wait < NOTIFICATION_RETRY_MAX; i++ {
+		wait *= 2
+	}
+	return min(wait, NOTIFICATION_RETRY_MAX)
+}
+
+// deliverNotifications sends the notifications that are due and records the
+// outcome, a failed one is retried later until MAX_NOTIFICATION_ATTEMPTS.
+func deliverNotifications(ctx context.Context) {
+	var entries []model.NotificationOutbox
+	queryString := fmt.Sprintf("SELECT * FROM %s WHERE status = '%s' AND next_attempt_at <= '%s' ORDER BY id LIMIT %d", extras.NotificationTable, extras.NOTIFICATION_PENDING, time.Now().Format(extras.TIME_FORMAT), NOTIFICATION_BATCH)
+	outboxRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &entries,
+	}
+	if err := dao.GormOperations(&outboxRepo, config.Db, dao.EXEC); err != nil {
+		// slog.Println("ERROR WHILE FETCHING NOTIFICATIONS: ", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		s, ok := subscribers[entry.Subscriber]
+		if !ok {
+			continue
+		}
+		statusCode, err := s.deliver(ctx, entry)
+
+		attempts := entry.Attempts + 1
+		now := time.Now()
+		update := map[string]any{"attempts": attempts, "last_status_code": statusCode, "last_error": extras.EMPTY_STRING}
+		switch {
+		case err == nil:
+			update["status"] = extras.NOTIFICATION_DELIVERED
+			update["delivered_at"] = now
+		case attempts >= MAX_NOTIFICATION_ATTEMPTS:
+			update["status"] = extras.NOTIFICATION_FAILED
+			update["last_error"] = err.Error()
+		default:
+			update["next_attempt_at"] = now.Add(notificationBackoff(attempts))
+			update["last_error"] = err.Error()
+		}
+		if err = config.Db.Model(&model.NotificationOutbox{}).Where("id = ?", entry.Id).Updates(update).Error; err != nil {
+			// slog.Println("ERROR WHILE UPDATING NOTIFICATION: ", entry.Id, err)
+		}
+	}
+}
+
+// NotificationHandler delivers the outbox until ctx is cancelled.
+func NotificationHandler(ctx context.Context) {
+	var lastPurge time.Time
+	for {
+		deliverNotifications(ctx)
+
+		if time.Since(lastPurge) > time.Hour {
+			lastPurge = time.Now()
+			config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE status = '%s' AND delivered_at < '%s'", extras.NotificationTable, extras.NOTIFICATION_DELIVERED, lastPurge.Add(-NOTIFICATION_RETENTION).Format(extras.TIME_FORMAT)))
+		}
+
+		t := time.NewTimer(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-notificationWake:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package queues
+
+import (
+	"anti-apt-backend/alert"
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gookit/slog"
+)
+
+const (
+	DEFAULT_SANDBOX_NODE  = "default"
+	DEFAULT_NODE_CAPACITY = 5
+)
+
+type SandboxNode struct {
+	Id       string
+	Capacity int
+	Backend  SandboxBackend
+
+	// state from the last refresh, guarded by SandboxPool.mu
+	healthy     bool
+	notReported int
+	inFlight    int
+	submitted   int
+	tasks       []Sandbox
+}
+
+// load is the share of the node's VMs that are busy or about to be.
+func (n *SandboxNode) load() float64 {
+	return float64(n.notReported+n.inFlight+n.submitted) / float64(n.Capacity)
+}
+
+func (n *SandboxNode) freeSlots() int {
+	free := n.Capacity - n.notReported - n.inFlight - n.submitted
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+type SandboxPool struct {
+	mu    sync.Mutex
+	nodes []*SandboxNode
+	byId  map[string]*SandboxNode
+}
+
+var pool = NewSandboxPool(nil)
+
+func NewSandboxPool(nodes []*SandboxNode) *SandboxPool {
+	p := &SandboxPool{byId: make(map[string]*SandboxNode)}
+	for _, node := range nodes {
+		if node.Capacity <= 0 {
+			node.Capacity = DEFAULT_NODE_CAPACITY
+		}
+		p.nodes = append(p.nodes, node)
+		p.byId[node.Id] = node
+	}
+	return p
+}
+
+func SetSandboxPool(p *SandboxPool) {
+	pool = p
+}
+
+// InitSandboxPool loads the nodes from SANDBOX_NODES_FILE_PATH. Without that
+// file the appliance runs a single local node, using the backend named in
+// SANDBOX_BACKEND_FILE_PATH.
+func InitSandboxPool() {
+	nodesConfig, err := config.ReadSandboxNodesConfig()
+	if err != nil || len(nodesConfig.Nodes) == 0 {
+		nodesConfig.Nodes = []model.SandboxNode{{
+			Id:       DEFAULT_SANDBOX_NODE,
+			Backend:  getSandboxBackendName(),
+			Capacity: DEFAULT_NODE_CAPACITY,
+		}}
+	}
+
+	var nodes []*SandboxNode
+	seen := make(map[string]bool)
+	for i, nodeConfig := range nodesConfig.Nodes {
+		nodeConfig.Id = strings.TrimSpace(nodeConfig.Id)
+		if nodeConfig.Id == extras.EMPTY_STRING {
+			nodeConfig.Id = fmt.Sprintf("node-%d", i+1)
+		}
+		// tasks record their node by id, a second node with it would never
+		// be asked for their reports
+		if seen[nodeConfig.Id] {
+			slog.Println("SKIPPING SANDBOX NODE WITH DUPLICATE ID: ", nodeConfig.Id)
+			continue
+		}
+		b, err := NewSandboxBackend(nodeConfig)
+		if err != nil {
+			slog.Println("SKIPPING SANDBOX NODE: ", nodeConfig.Id, err)
+			continue
+		}
+		seen[nodeConfig.Id] = true
+		nodes = append(nodes, &SandboxNode{
+			Id:       nodeConfig.Id,
+			Capacity: nodeConfig.Capacity,
+			Backend:  b,
+		})
+	}
+
+	SetSandboxPool(NewSandboxPool(nodes))
+}
+
+// node returns the node owning a task. Tasks recorded before nodes existed
+// have no node id and belong to the first configured node.
+func (p *SandboxPool) node(id string) (*SandboxNode, error) {
+	if node, ok := p.byId[id]; ok {
+		return node, nil
+	}
+	if id == extras.EMPTY_STRING && len(p.nodes) > 0 {
+		return p.nodes[0], nil
+	}
+	return nil, fmt.Errorf("sandbox node not found: %s", id)
+}
+
+// refresh lists the tasks on every node. A node that does not answer is marked
+// unhealthy and gets no new submissions until it does.
+func (p *SandboxPool) refresh(ctx context.Context) {
+	for _, node := range p.nodes {
+		tasks, err := node.Backend.ListTasks(ctx)
+
+		p.mu.Lock()
+		node.submitted = 0
+		if err != nil {
+			node.healthy = false
+			node.tasks = nil
+			p.mu.Unlock()
+			alert.SandboxHealth(node.Id, false)
+			continue
+		}
+		node.healthy = true
+		node.tasks = tasks
+		node.notReported = 0
+		for _, task := range tasks {
+			if task.Status != extras.Reported && task.Status != "failed_analysis" {
+				node.notReported++
+			}
+		}
+		p.mu.Unlock()
+		alert.SandboxHealth(node.Id, true)
+	}
+}
+
+// reserve picks the least-loaded healthy node with a free VM and counts the
+// submission against it until release is called.
+func (p *SandboxPool) reserve() *SandboxNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *SandboxNode
+	for _, node := range p.nodes {
+		if !node.healthy || node.freeSlots() <= 0 {
+			continue
+		}
+		if best == nil || node.load() < best.load() {
+			best = node
+		}
+	}
+	if best != nil {
+		best.inFlight++
+	}
+	return best
+}
+
+func (p *SandboxPool) release(node *SandboxNode, submitted bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node.inFlight--
+	if submitted {
+		node.submitted++
+	}
+}
+
+func (p *SandboxPool) freeSlots() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	free := 0
+	for _, node := range p.nodes {
+		if node.healthy {
+			free += node.freeSlots()
+		}
+	}
+	return free
+}
+
+func (p *SandboxPool) capacity() int {
+	total := 0
+	for _, node := range p.nodes {
+		total += node.Capacity
+	}
+	return total
+}
+
+// snapshot returns the tasks seen on each healthy node at the last refresh,
+// keyed by node id.
+func (p *SandboxPool) snapshot() map[string][]Sandbox {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resp := make(map[string][]Sandbox)
+	for _, node := range p.nodes {
+		if node.healthy {
+			resp[node.Id] = node.tasks
+		}
+	}
+	return resp
+}
+
+func (p *SandboxPool) notReported() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	count := 0
+	for _, node := range p.nodes {
+		count += node.notReported
+	}
+	return count
+}
@@ -0,0 +1,202 @@
+package queues
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/h2non/filetype"
+)
+
+const (
+	ENGINE_YARA       = "yara"
+	ENGINE_CLAMD      = "clamd"
+	ENGINE_QUICKSCOPE = "quickscope"
+)
+
+const (
+	DEFAULT_STAGE_TIMEOUT = 120
+	DEFAULT_STAGE_SCORE   = 3.5
+)
+
+type stageResult struct {
+	detected bool
+	detail   string
+	queries  []string // saved with the stage result when the stage finishes in time
+	err      error
+}
+
+// a pre-filter engine scans the task's file, it should give up when ctx is done
+type stageEngine func(ctx context.Context, task Task) stageResult
+
+var prefilterEngines = map[string]stageEngine{
+	ENGINE_YARA: func(ctx context.Context, task Task) stageResult {
+		detected, rules, queries, err := ScanFileThroughYara(ctx, task)
+		return stageResult{detected: detected, detail: rules, queries: queries, err: err}
+	},
+	ENGINE_CLAMD: func(ctx context.Context, task Task) stageResult {
+		detected, signature, err := ScanFileThroughClamd(ctx, task)
+		return stageResult{detected: detected, detail: signature, err: err}
+	},
+	ENGINE_QUICKSCOPE: func(ctx context.Context, task Task) stageResult {
+		detected, err := ScanFileThroughQuickScope(ctx, task)
+		return stageResult{detected: detected, err: err}
+	},
+}
+
+// used when a stage leaves an outcome out of its verdicts
+var defaultStageVerdicts = map[string]string{
+	extras.STAGE_DETECTED: extras.STAGE_BLOCK,
+	extras.STAGE_CLEAN:    extras.STAGE_ESCALATE,
+	extras.STAGE_ERROR:    extras.STAGE_ESCALATE,
+	extras.STAGE_TIMEOUT:  extras.STAGE_ESCALATE,
+}
+
+// defaultPrefilterPipeline runs the yara rulesets, then the fixed clamd then
+// quickscope order used before the pipeline was configurable.
+func defaultPrefilterPipeline() model.PrefilterPipelineConfig {
+	return model.PrefilterPipelineConfig{
+		Stages: []model.PrefilterStage{
+			{Engine: ENGINE_YARA, Enabled: true, Timeout: DEFAULT_STAGE_TIMEOUT, Score: DEFAULT_STAGE_SCORE},
+			{Engine: ENGINE_CLAMD, Enabled: true, Timeout: DEFAULT_STAGE_TIMEOUT, Score: DEFAULT_STAGE_SCORE},
+			{Engine: ENGINE_QUICKSCOPE, Enabled: true, Timeout: DEFAULT_STAGE_TIMEOUT, Score: DEFAULT_STAGE_SCORE},
+		},
+	}
+}
+
+func getPrefilterPipeline() model.PrefilterPipelineConfig {
+	pipeline, err := config.ReadPrefilterPipelineConfig()
+	if err != nil {
+		return defaultPrefilterPipeline()
+	}
+	return pipeline
+}
+
+// fileTypeOf returns the lower case extension of the submitted file name, or
+// the one guessed from its content when the name has none.
+func fileTypeOf(task Task) string {
+	ext := strings.ToLower(filepath.Ext(task.FileName))
+	if ext != extras.EMPTY_STRING {
+		return ext
+	}
+
+	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
+	bytes, _ := os.ReadFile(fp)
+	kind, _ := filetype.Match(bytes)
+	if kind == filetype.Unknown {
+		return extras.EMPTY_STRING
+	}
+	return "." + kind.Extension
+}
+
+func stageApplies(stage model.PrefilterStage, fileType string) bool {
+	if len(stage.FileTypes) == 0 {
+		return true
+	}
+	for _, ext := range stage.FileTypes {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+func runStage(stage model.PrefilterStage, engine stageEngine, task Task) (string, string, []string) {
+	timeout := stage.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_STAGE_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	done := make(chan stageResult, 1)
+	go func() {
+		done <- engine(ctx, task)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return extras.STAGE_TIMEOUT, fmt.Sprintf("no result after %ds", timeout), nil
+	case result := <-done:
+		if result.err != nil {
+			return extras.STAGE_ERROR, result.err.Error(), nil
+		}
+		if result.detected {
+			return extras.STAGE_DETECTED, result.detail, result.queries
+		}
+		return extras.STAGE_CLEAN, result.detail, result.queries
+	}
+}
+
+// runPrefilter runs the enabled stages in order until one blocks or allows the
+// file. It returns the status to move the task to, with the score in task.Score
+// and the queries saving each stage's result.
+func runPrefilter(task *Task) (string, []string) {
+	var queryStringArr []string
+
+	fileType := fileTypeOf(*task)
+
+	for i, stage := range getPrefilterPipeline().Stages {
+		if !stage.Enabled {
+			continue
+		}
+
+		engine, ok := prefilterEngines[strings.ToLower(stage.Engine)]
+		if !ok {
+			// slog.Println("UNKNOWN PREFILTER ENGINE: ", stage.Engine)
+			continue
+		}
+
+		started := time.Now()
+		var outcome, detail, action string
+		var queries []string
+		if stageApplies(stage, fileType) {
+			outcome, detail, queries = runStage(stage, engine, *task)
+			action = stage.Verdicts[outcome]
+			if !slices.Contains([]string{extras.STAGE_BLOCK, extras.STAGE_ESCALATE, extras.STAGE_ALLOW}, action) {
+				action = defaultStageVerdicts[outcome]
+			}
+		} else {
+			outcome = extras.STAGE_SKIPPED
+			detail = "file type " + fileType + " not scanned by this stage"
+			action = extras.STAGE_ESCALATE
+		}
+
+		queryStringArr = append(queryStringArr, fmt.Sprintf("INSERT INTO %s (task_id, stage, engine, outcome, action, detail, duration, created_at) VALUES (%d, %d, '%s', '%s', '%s', '%s', %d, '%s')", extras.TaskStageResultTable, task.Id, i+1, util.EscapeSqlString(stage.Engine), outcome, action, util.EscapeSqlString(detail), time.Since(started).Milliseconds(), started.Format(extras.TIME_FORMAT)))
+		queryStringArr = append(queryStringArr, queries...)
+
+		task.Detail = fmt.Sprintf("stage %d %s: %s", i+1, stage.Engine, outcome)
+		if detail != extras.EMPTY_STRING {
+			task.Detail += ", " + detail
+		}
+		switch action {
+		case extras.STAGE_BLOCK:
+			task.Reason = extras.TASK_REASON_PREFILTER_BLOCKED
+			task.Score = stage.Score
+			if task.Score <= 0 {
+				task.Score = DEFAULT_STAGE_SCORE
+			}
+			return ReportedThroughPrefilter, queryStringArr
+		case extras.STAGE_ALLOW:
+			task.Reason = extras.TASK_REASON_PREFILTER_ALLOWED
+			task.Score = 0
+			return AllowedThroughPrefilter, queryStringArr
+		}
+	}
+
+	task.Reason = extras.TASK_REASON_PREFILTER_PASSED
+	task.Detail = extras.EMPTY_STRING
+	return Queued, queryStringArr
+}
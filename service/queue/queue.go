@@ -1,15 +1,15 @@
 package queues
 
 import (
-	"anti-apt-backend/config"
-	"anti-apt-backend/dao"
 	"anti-apt-backend/extras"
-	"anti-apt-backend/logger"
+	"anti-apt-backend/model"
+	"anti-apt-backend/verdictcache"
 	"context"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -18,33 +18,50 @@ import (
 )
 
 const (
-	PendingNotInQueue         = "pending not in queue"
-	PendingInQueue            = "pending in queue"
-	Pending                   = "pending"
-	RunningNotInQueue         = "running not in queue"
-	RunningInQueue            = "running in queue"
-	Running                   = "running" // in log_queue
-	Reported                  = "reported"
-	ReportedThroughQuickScope = "reported through quickscope"
-	ReportedThroughClamd      = "reported through clamd"
-	Aborted                   = "aborted"
-	Completed                 = "completed"
-	SandboxApiTimeout         = "sandbox api timeout"
+	Pending                  = "pending" // waiting for the pre-filter pipeline
+	Queued                   = "queued"  // waiting for a free sandbox vm
+	Running                  = "running"
+	Reported                 = "reported"
+	ReportedThroughPrefilter = "reported through prefilter"
+	AllowedThroughPrefilter  = "allowed through prefilter"
+	Aborted                  = "aborted"
+	Completed                = "completed"
 )
 
 type Task struct {
-	Id                int
+	LiveId            int    // task_live_analysis_id of the live row
+	Id                int    // file on demand id, or url on demand id for url tasks
+	Type              string // extras.TASK_TYPE_FILE or extras.TASK_TYPE_URL
 	Status            string
 	SandboxId         int
-	QueueRetryCount   int
+	SandboxNode       string
 	RunningRetryCount int
 	SandboxRetryCount int
+	Version           int
 	Md5               string
 	SHA               string
 	SHA256            string
-	LogQueueFailed    bool
+	Priority          string
+	ClientIp          string
+	SubmittedBy       string
+	FileName          string
+	ParentId          int
+	ReanalysisOf      int
+	Platform          string // sandbox os asked for, empty for any
+	Timeout           int    // analysis timeout in seconds, 0 for the sandbox default
+	VmTag             string
+	Route             string
+	Package           string
+	Options           string // custom key=value options for the sandbox
 	SubmittedTime     time.Time
 	RunningStartedAt  time.Time
+	Score             float32 // set by the pre-filter, not stored on the live task
+
+	// why the task is changing state, recorded in its timeline and not
+	// stored on the live task either
+	Reason string
+	Detail string
+	Error  string
 }
 
 type FinishedTask struct {
@@ -53,12 +70,6 @@ type FinishedTask struct {
 	Aborted   bool
 }
 
-var (
-	PendingQueue = make(chan Task, 5000)
-	RunningQueue = make(chan Task, 5000)
-	LogQueue     = make(chan Task, 5000)
-)
-
 type DuplicateTask struct {
 	Id     int
 	Md5    string
@@ -67,9 +78,8 @@ type DuplicateTask struct {
 }
 
 const (
-	QUEUE_MAX_RETRIES   = 3
 	RUNNING_MAX_RETRIES = 5
-	MAX_FREE_VMS        = 5
+	CLAIM_BATCH_SIZE    = 50
 )
 
 var (
@@ -79,38 +89,6 @@ var (
 	SANDBOX_TIME_OUT      = 15 * time.Minute
 )
 
-// push to respective queue
-func pushToQueue(queue chan Task, task Task, status string) error {
-	task.Status = status
-	select {
-	case queue <- task:
-		return nil
-	default:
-		// // slog.Println("default case while pusing into queue : ", task.Id)
-		return fmt.Errorf("failed to push into queue")
-	}
-}
-
-func fetchTasksFromQueue(queue chan Task, count int) []Task {
-	var tasks []Task
-
-	if count == -1 {
-		for {
-			select {
-			case task := <-queue:
-				tasks = append(tasks, task)
-			default:
-				return tasks
-			}
-		}
-	}
-
-	for i := 0; i < count && len(queue) > 0; i++ {
-		tasks = append(tasks, <-queue)
-	}
-	return tasks
-}
-
 func getMaxSandboxTasks() int {
 	content, err := os.ReadFile(extras.MAX_SANDBOX_TASKS_FILE_PATH)
 	if err != nil {
@@ -139,446 +117,304 @@ func getTimeOut() int {
 	return value
 }
 
-// Step 3
-func NotInQueueHandler() {
+// runningTimeout gives tasks submitted with their own analysis timeout that
+// much longer in the sandbox.
+func runningTimeout(task Task) time.Duration {
+	return SANDBOX_TIME_OUT + time.Duration(task.Timeout)*time.Second
+}
+
+// PendingTaskHandler checks new files against the allow and block lists and
+// runs the rest through the pre-filter pipeline, urls get a reputation lookup
+// instead. Anything not blocked or allowed there is queued for the sandbox.
+func PendingTaskHandler(ctx context.Context) {
 
 	// ignoreExtensions, _ := extensionsToIgnore()
 
-	var targetQueue chan Task
-	var newStatus string
-	for {
-		// logger.LogAccToTaskId(0, "GO ROUTINES: "+fmt.Sprintf("%d", runtime.NumGoroutine()))
+	for ctx.Err() == nil {
 
-		tasks, err := fetchNotInQueueTasks()
-		if err != nil {
-			// // slog.Println("ERROR WHILE FETCHING NOT IN QUEUE TASKS: ", err)
+		tasks, err := claimTasks(Pending, CLAIM_BATCH_SIZE)
+		if err != nil || len(tasks) == 0 {
+			// // slog.Println("ERROR WHILE CLAIMING PENDING TASKS: ", err)
+			sleep(ctx, 1*time.Second)
 			continue
 		}
 
-		// // slog.Println("NOT IN QUEUE TASKS: ", tasks)
-
 		for _, task := range tasks {
 
-			if task.LogQueueFailed {
-				// logger.LogAccToTaskId(task.Id, fmt.Sprintf("LOG QUEUE FAILED : %v", task))
-				targetQueue = LogQueue
-				newStatus = task.Status
-				if strings.Contains(task.Status, PendingInQueue) {
-					newStatus = PendingNotInQueue
-				} else if strings.Contains(task.Status, RunningInQueue) {
-					newStatus = RunningNotInQueue
-				} else {
-					// logger.LogAccToTaskId(task.Id, fmt.Sprintf("UNKNOWN TASK STATUS: %v", task.Status))
-				}
-			} else if task.Status == PendingNotInQueue {
-
-				var foundMalicious = false
-
-				maliciousFromClamd, err := ScanFileThroughClamd(task)
-				if maliciousFromClamd {
-					foundMalicious = true
-					targetQueue = LogQueue
-					newStatus = ReportedThroughClamd
-				} else if err != nil || !maliciousFromClamd {
-					maliciousFromQuickScope, _ := ScanFileThroughQuickScope(task)
-					if maliciousFromQuickScope {
-						foundMalicious = true
-						targetQueue = LogQueue
-						newStatus = ReportedThroughQuickScope
-					}
-				}
+			var newStatus string
+			var stageQueries []string
+			if task.Type == extras.TASK_TYPE_URL {
+				newStatus = checkUrlReputation(&task)
+			} else if newStatus = checkFileLists(&task); newStatus == extras.EMPTY_STRING {
+				newStatus, stageQueries = runPrefilter(&task)
+			}
 
-				if !foundMalicious {
-					liveTaskCount, _ := fetchLiveTaskCount()
-					MAX_SANDBOX_TASKS = getMaxSandboxTasks()
-					if liveTaskCount >= MAX_FREE_VMS {
-						targetQueue = LogQueue
-						newStatus = Aborted
-					} else {
-						targetQueue = PendingQueue
-						newStatus = PendingInQueue
-					}
+			if newStatus == Queued {
+				liveTaskCount, _ := fetchLiveTaskCount()
+				MAX_SANDBOX_TASKS = getMaxSandboxTasks()
+				if liveTaskCount >= pool.capacity() {
+					newStatus = Aborted
+					task.Reason = extras.TASK_REASON_CAPACITY_FULL
+					task.Detail = fmt.Sprintf("%d live tasks, %d sandbox vms", liveTaskCount, pool.capacity())
 				}
-
-				// extension := strings.ToLower(filepath.Ext(filePath))
-				// if _, found := ignoreExtensions[extension]; found {
-				// 	targetQueue = PendingQueue
-				// 	newStatus = PendingInQueue
-				// }
-			} else if task.Status == RunningNotInQueue {
-				// if this case repeasts every time, it will lead to deadlock as it will replace time with current time
-				task.RunningStartedAt = time.Now()
-				targetQueue = RunningQueue
-				newStatus = RunningInQueue
-			} else {
-				// // slog.Println("UNKNOWN TASK STATUS: ", task.Status, task.Id)
-				continue
 			}
-			err := pushToQueue(targetQueue, task, newStatus)
+
+			// extension := strings.ToLower(filepath.Ext(filePath))
+			// if _, found := ignoreExtensions[extension]; found {
+			// 	newStatus = Queued
+			// }
+
+			err = changeStatus(task, newStatus, stageQueries...)
 			if err != nil {
-				// logger.LogAccToTaskId(task.Id, fmt.Sprintf("QUEUE COUNT %d, RUNNING COUNT %d, SANDBOX COUNT %d, ERROR WHILE PUSHING TASK INTO QUEUE: %v", task.QueueRetryCount, task.RunningRetryCount, task.SandboxRetryCount, err))
-				// slog.Println("ERROR WHILE PUSHING TASK INTO QUEUE: ", task.Id)
-			} else {
-				// direct update through DB, rest should all be through loq queue
-				// update log_queue_failed to 0, so that it will not be retried
-				if targetQueue == LogQueue {
-					err = updateLogQueueFail(task.Id, false)
-					if err != nil {
-						// logger.LogAccToTaskId(task.Id, "Failed to set log_queue_failed for task "+err.Error())
-						// slog.Println("Failed to set log_queue_failed for task %d : %v", task.Id, err)
-					}
-				} else {
-					err := updateTaskStatus(task.Id, newStatus)
-					if err != nil {
-						// logger.LogAccToTaskId(task.Id, "Failed to update task status "+err.Error())
-						// slog.Println("Failed to update task %d status to %s: %v", task.Id, newStatus, err)
-					}
-				}
+				// slog.Println("Failed to update task %d status to %s: %v", task.Id, newStatus, err)
 			}
 		}
-		time.Sleep(1 * time.Second)
 	}
 }
 
-var pendingSem = semaphore.NewWeighted(int64(MAX_CONCURRENT_LOG_QUEUE_TASKS))
-
-func PendingQueueHandler() {
-	for {
-		// freeVms, err := countOfFreeVm()
-		// if err != nil {
-		// 	// // slog.Println("ERROR WHILE FETCHING FREE VM'S: ", err)
-		// 	time.Sleep(2 * time.Second)
-		// 	continue
-		// }
+var pendingSem = semaphore.NewWeighted(int64(MAX_CONCURRENT_SUBMISSIONS))
 
-		freeVms := MAX_FREE_VMS
+const (
+	MAX_CONCURRENT_SUBMISSIONS = 50
+)
 
-		notReported := FetchSandboxTaskCountWhichAreNotReported()
+// QueuedTaskHandler submits queued tasks to the least-loaded sandbox node.
+func QueuedTaskHandler(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
-		if notReported >= freeVms {
-			time.Sleep(2 * time.Second)
-			continue
-		}
+	for ctx.Err() == nil {
+		pool.refresh(ctx)
 
-		freeVms = freeVms - notReported
+		freeVms := pool.freeSlots()
 		// slog.Println("FREE VM'S: ", freeVms)
 
 		if freeVms <= 0 {
-			time.Sleep(2 * time.Second)
+			sleep(ctx, 2*time.Second)
 			continue
 		}
 
-		tasks := fetchTasksFromQueue(PendingQueue, freeVms)
-
-		if len(tasks) == 0 {
-			time.Sleep(2 * time.Second)
+		tasks, err := claimTasks(Queued, freeVms)
+		if err != nil || len(tasks) == 0 {
+			sleep(ctx, 2*time.Second)
 			continue
 		}
 
-		// // slog.Println("PENDING QUEUE TASKS: ", tasks)
-		// var wg sync.WaitGroup
 		PENDING_QUEUE_TIMEOUT = time.Duration(getTimeOut()) * time.Minute
 		for _, task := range tasks {
-			if task.QueueRetryCount >= QUEUE_MAX_RETRIES || task.SubmittedTime.Add(PENDING_QUEUE_TIMEOUT).Before(time.Now()) {
-				// logger.LogAccToTaskId(task.Id, fmt.Sprintf("PENDING QUEUE TIMEOUT: %v", task.SubmittedTime))
-				changeStatusAndPushToLogQueue(task, Aborted)
-				// no need for further processing, decreased wg count by 1 so that deadlock will not happen
+			if task.SubmittedTime.Add(PENDING_QUEUE_TIMEOUT).Before(time.Now()) {
+				task.Reason = extras.TASK_REASON_PENDING_TIMEOUT
+				task.Detail = fmt.Sprintf("submitted at %s, timeout %v", task.SubmittedTime.Format(extras.TIME_FORMAT), PENDING_QUEUE_TIMEOUT)
+				changeStatus(task, Aborted)
 				continue
 			}
 
-			// added tasks in wg to avoid deadlock, as tasks can be lesser than freevms
-			// wg.Add(1)
+			node := pool.reserve()
+			if node == nil {
+				// every healthy node is full, wait for the next refresh
+				changeStatus(task, Queued)
+				continue
+			}
 
-			if err := pendingSem.Acquire(context.Background(), 1); err != nil {
+			if err := pendingSem.Acquire(ctx, 1); err != nil {
 				// slog.Println("Failed to acquire semaphore:", err)
-				// wg.Done()
+				pool.release(node, false)
 				continue
 			}
 
-			go func(task Task) {
-				// defer wg.Done()
+			wg.Add(1)
+			go func(task Task, node *SandboxNode) {
+				defer wg.Done()
 				defer pendingSem.Release(1)
-				sandboxId, err := sendToSandbox(task)
-				// logger.LogAccToTaskId(task.Id, fmt.Sprintf("SANDBOX ID IN PENDING QUEUE: %d", sandboxId))
-				// slog.Println("SANDBOX ID IN PENDING QUEUE: ", sandboxId)
+				sandboxId, err := sendToSandbox(node, task)
+				pool.release(node, err == nil && sandboxId > 0)
 				if err != nil || sandboxId <= 0 {
 					task.SandboxRetryCount++
-					if task.SandboxRetryCount >= SANDBOX_MAX_RETRIES {
-						// logger.LogAccToTaskId(task.Id, fmt.Sprintf("Sandbox retry count exceeded for task: %d", task.Id))
-						// slog.Println("Sandbox retry count exceeded for task: ", task.Id)
-						changeStatusAndPushToLogQueue(task, Aborted)
+					task.Detail = "sandbox node " + node.Id
+					if err != nil {
+						task.Error = err.Error()
 					} else {
-						err := pushToQueue(PendingQueue, task, task.Status)
-						if err != nil {
-							// logger.LogAccToTaskId(task.Id, fmt.Sprintf("QUEUE COUNT %d, RUNNING COUNT %d, SANDBOX COUNT %d, ERROR WHILE PUSHING TASK INTO QUEUE: %v", task.QueueRetryCount, task.RunningRetryCount, task.SandboxRetryCount, err))
-							// slog.Println("ERROR WHILE PUSHING TASK INTO PENDING QUEUE: ", err)
-							task.QueueRetryCount++
-							if task.QueueRetryCount >= QUEUE_MAX_RETRIES {
-								// logger.LogAccToTaskId(task.Id, fmt.Sprintf("Queue retry count exceeded for task: %d", task.Id))
-								// slog.Println("Queue retry count exceeded for task: ", task.Id)
-								changeStatusAndPushToLogQueue(task, Aborted)
-							} else {
-								// push back into pending queue instead of log queue, same task turn may get longer wait (PG)
-								err := pushToQueue(LogQueue, task, PendingNotInQueue)
-								if err != nil {
-									// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE PUSHING TASK INTO LOG QUEUE: %v", err))
-									// slog.Println("ERROR WHILE PUSHING TASK INTO LOG QUEUE: ", err)
-									task.Status = PendingNotInQueue
-									task.LogQueueFailed = true
-									err = updateLiveTaskDirectlyThroughDb(task)
-									if err != nil {
-										// logger.LogAccToTaskId(task.Id, fmt.Sprintf("Failed to update loq queue failed task %d status to %s: %v", task.Id, PendingNotInQueue, err))
-										// slog.Println("Failed to update loq queue failed task %d status to %s: %v", task.Id, PendingNotInQueue, err)
-									}
-								} else {
-									// logger.LogAccToTaskId(task.Id, fmt.Sprintf("QUEUE RETRY COUNT %d ", task.QueueRetryCount))
-									// logger.LogAccToTaskId(task.Id, fmt.Sprintf("PUSHED TASK INTO LOG QUEUE: %s", task.Status))
-									// slog.Println("PUSHED TASK INTO LOG QUEUE: ", task.Id, task.Status)
-								}
-							}
-
-						} else {
-							// logger.LogAccToTaskId(task.Id, fmt.Sprintf("PUSHED TASK BACK INTO PENDING QUEUE: %s", task.Status))
-							// slog.Println("PUSHED TASK BACK INTO PENDING QUEUE: ", task.Id, task.Status)
-						}
+						task.Error = fmt.Sprintf("sandbox returned task id %d", sandboxId)
 					}
-				} else {
-					task.SandboxId = sandboxId
-					task.RunningStartedAt = time.Now()
-					// logger.LogAccToTaskId(task.Id, fmt.Sprintf("TASKID %d- SANDBOXID %d- RUNNING_STARTED_AT %v : ", task.Id, task.SandboxId, task.RunningStartedAt))
-					// slog.Println("TASKID %d- SANDBOXID %d- RUNNING_STARTED_AT %v : ", task.Id, task.SandboxId, task.RunningStartedAt)
-					err := pushToQueue(RunningQueue, task, task.Status)
-					if err != nil {
-						// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE PUSHING TASK INTO RUNNING QUEUE: %v", err))
-						changeStatusAndPushToLogQueue(task, RunningNotInQueue)
+					if task.SandboxRetryCount >= SANDBOX_MAX_RETRIES {
+						task.Reason = extras.TASK_REASON_SANDBOX_RETRIES_EXHAUSTED
+						changeStatus(task, Aborted)
 					} else {
-						// logger.LogAccToTaskId(task.Id, fmt.Sprintf("PUSHED TASK INTO RUNNING QUEUE: %s", task.Status))
-						// slog.Println("PUSHED TASK INTO RUNNING QUEUE: ", task.Id, task.Status)
-						changeStatusAndPushToLogQueue(task, RunningInQueue)
+						task.Reason = extras.TASK_REASON_SUBMIT_FAILED
+						changeStatus(task, Queued)
 					}
+					return
 				}
-			}(task)
+
+				task.SandboxId = sandboxId
+				task.SandboxNode = node.Id
+				task.RunningStartedAt = time.Now()
+				task.Reason = extras.TASK_REASON_SUBMITTED
+				err = changeStatus(task, Running)
+				if err != nil {
+					// the claim was lost while uploading, another worker owns the task now
+					go deleteSandboxData(node.Id, sandboxId)
+				}
+			}(task, node)
 		}
-		// wg.Wait()
-		// time.Sleep(2 * time.Second)
 	}
 }
 
-func RunningQueueHandler() {
-	for {
+// RunningTaskHandler polls the node owning each running task until the
+// sandbox reports it or it times out.
+func RunningTaskHandler(ctx context.Context) {
+	for ctx.Err() == nil {
 
-		allSandboxTasks, err := fetchAllTasksFromSandbox()
-		if err != nil {
-			// slog.Println("ERROR WHILE FETCHING ALL TASKS FROM SANDBOX: ", err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
+		pool.refresh(ctx)
 
-		var sandboxTaskMap = make(map[int]string)
+		var sandboxTaskMap = make(map[string]map[int]string)
 
-		for _, sandboxTask := range allSandboxTasks {
-			sandboxTaskMap[sandboxTask.SandboxId] = sandboxTask.Status
+		for nodeId, sandboxTasks := range pool.snapshot() {
+			sandboxTaskMap[nodeId] = make(map[int]string)
+			for _, sandboxTask := range sandboxTasks {
+				sandboxTaskMap[nodeId][sandboxTask.SandboxId] = sandboxTask.Status
+			}
 		}
 
-		tasks := fetchTasksFromQueue(RunningQueue, -1)
-
-		// RemoveSandboxTasksWhichAreNotInTasksAnReachedTimeOut(allSandboxTasks, tasks)
-
-		if len(tasks) > 0 {
-			// slog.Println("RUNNING QUEUE TASKS: ", tasks)
+		tasks, err := claimTasks(Running, MAX_SANDBOX_TASKS)
+		if err != nil {
+			// slog.Println("ERROR WHILE CLAIMING RUNNING TASKS: ", err)
 		}
 
 		SANDBOX_TIME_OUT = time.Duration(getTimeOut()) * time.Minute
 
 		for _, task := range tasks {
 			if task.RunningRetryCount >= RUNNING_MAX_RETRIES {
-				changeStatusAndPushToLogQueue(task, Aborted)
-			} else {
-				sandboxStatus, ok := sandboxTaskMap[task.SandboxId]
-				if !ok {
-					// logger.LogAccToTaskId(task.Id, fmt.Sprintf("SANDBOX NOT FOUND IN MAP: %d", task.SandboxId))
-					// slog.Println("SANDBOX NOT FOUND IN MAP: ", task.SandboxId)
-					task.RunningRetryCount++
-					if task.RunningRetryCount >= RUNNING_MAX_RETRIES {
-						// logger.LogAccToTaskId(task.Id, fmt.Sprintf("SANDBOX NOT FOUND IN MAP: %d", task.SandboxId))
-						// slog.Println("SANDBOX NOT FOUND IN MAP: ", task.SandboxId)
-						changeStatusAndPushToLogQueue(task, Aborted)
-					}
-					changeStatusAndPushToLogQueue(task, RunningNotInQueue)
-				} else {
-					// logger.LogAccToTaskId(task.Id, fmt.Sprintf("TASKID - SANDBOXID - STATUS : %d - %d - %s", task.Id, task.SandboxId, sandboxStatus))
-					// slog.Println("TASKID - SANDBOXID - STATUS : ", task.Id, task.SandboxId, sandboxStatus)
-					if sandboxStatus == Reported {
-						changeStatusAndPushToLogQueue(task, Reported)
-					} else if sandboxStatus == Running || sandboxStatus == Completed || sandboxStatus == Pending {
-						if time.Since(task.RunningStartedAt) > SANDBOX_TIME_OUT {
-							// logger.LogAccToTaskId(task.Id, fmt.Sprintf("SANDBOX TIMEOUT FOR : %d", task.SandboxId))
-							// slog.Println("SANDBOX TIMEOUT FOR : ", task.SandboxId)
-							changeStatusAndPushToLogQueue(task, Aborted)
-						} else {
-							// push to runningQueue
-							err := pushToQueue(RunningQueue, task, task.Status)
-							if err != nil {
-								// logger.LogAccToTaskId(task.Id, fmt.Sprintf("PUSHING TASK BACK INTO RUNNING QUEUE: %v", err))
-								// slog.Println("PUSHING TASK BACK INTO RUNNING QUEUE:", task.SandboxId, err)
-								changeStatusAndPushToLogQueue(task, RunningNotInQueue)
-							}
-						}
+				task.Reason = extras.TASK_REASON_RUNNING_RETRIES_EXHAUSTED
+				changeStatus(task, Aborted)
+				continue
+			}
+
+			var nodeTaskMap map[int]string
+			if node, err := pool.node(task.SandboxNode); err == nil {
+				var healthy bool
+				nodeTaskMap, healthy = sandboxTaskMap[node.Id]
+				if !healthy {
+					// owning node did not answer, it is not the task's fault
+					// unless the node stays away past the running timeout
+					if time.Since(task.RunningStartedAt) > runningTimeout(task) {
+						task.Reason = extras.TASK_REASON_SANDBOX_NODE_UNREACHABLE
+						task.Detail = fmt.Sprintf("node %s unreachable, running since %s, timeout %v", task.SandboxNode, task.RunningStartedAt.Format(extras.TIME_FORMAT), runningTimeout(task))
+						changeStatus(task, Aborted)
 					} else {
-						// logger.LogAccToTaskId(task.Id, fmt.Sprintf("SANDBOX STATUS: %d", task.SandboxId))
-						// slog.Println("SANDBOX STATUS: ", task.SandboxId)
-						changeStatusAndPushToLogQueue(task, Aborted)
+						changeStatus(task, Running)
 					}
+					continue
 				}
-
 			}
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-}
-
-const (
-	MAX_CONCURRENT_LOG_QUEUE_TASKS = 50
-)
-
-func LogQueueHandler() {
-	for {
-
-		batchSize := 20
 
-		tasks := fetchTasksFromQueue(LogQueue, batchSize)
-
-		if len(tasks) > 0 {
-			logger.Print("LOG QUEUE TASKS: ", len(tasks))
-		} else {
-			continue
-		}
-
-		// var wg sync.WaitGroup
-		// sem := semaphore.NewWeighted(int64(MAX_CONCURRENT_LOG_QUEUE_TASKS))
+			sandboxStatus, ok := nodeTaskMap[task.SandboxId]
+			if !ok {
+				task.RunningRetryCount++
+				task.Detail = fmt.Sprintf("sandbox task %d not listed by node %s", task.SandboxId, task.SandboxNode)
+				if task.RunningRetryCount >= RUNNING_MAX_RETRIES {
+					task.Reason = extras.TASK_REASON_RUNNING_RETRIES_EXHAUSTED
+					changeStatus(task, Aborted)
+				} else {
+					task.Reason = extras.TASK_REASON_SANDBOX_TASK_MISSING
+					changeStatus(task, Running)
+				}
+				continue
+			}
 
-		for _, task := range tasks {
-			// wg.Add(1)
-			processLogQueueTasks(task)
+			if sandboxStatus == Reported {
+				task.Reason = extras.TASK_REASON_SANDBOX_REPORTED
+				changeStatus(task, Reported)
+			} else if sandboxStatus == Running || sandboxStatus == Completed || sandboxStatus == Pending {
+				if time.Since(task.RunningStartedAt) > runningTimeout(task) {
+					task.Reason = extras.TASK_REASON_RUNNING_TIMEOUT
+					task.Detail = fmt.Sprintf("running since %s, timeout %v, sandbox status %s", task.RunningStartedAt.Format(extras.TIME_FORMAT), runningTimeout(task), sandboxStatus)
+					changeStatus(task, Aborted)
+				} else {
+					changeStatus(task, Running)
+				}
+			} else {
+				task.Reason = extras.TASK_REASON_SANDBOX_FAILED
+				task.Detail = "sandbox status " + sandboxStatus
+				changeStatus(task, Aborted)
+			}
 		}
-		// wg.Wait()
+		sleep(ctx, 2*time.Second)
 	}
 }
 
-func processLogQueueTasks(task Task) {
-	// defer wg.Done()
-	// defer sem.Release(1)
-
-	if task.Status == SandboxApiTimeout {
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("%d - %d API TIMEOUT", task.Id, task.SandboxId))
-		// slog.Printf("%d - %d API TIMEOUT\n", task.Id, task.SandboxId)
-		// go deleteSandboxData(task.Id, task.SandboxId)
-	} else if task.Status == Reported {
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("%d - %d REPORTED", task.Id, task.SandboxId))
-		// slog.Printf("%d - %d REPORTED\n", task.Id, task.SandboxId)
-
-		score, err := fetchScoreFromSandBox(task.Id, task.SandboxId)
+// changeStatus writes the transition to newStatus along with queries. Terminal
+// states also set the verdict on the file, settle duplicates waiting on the
+// same hash and notify the submitting device.
+func changeStatus(task Task, newStatus string, queries ...string) error {
+
+	var score float32
+	switch newStatus {
+	case Reported:
+		var report *model.Report
+		var err error
+		score, report, err = fetchScoreFromSandBox(task)
 		if err != nil {
 			score = 0
+			task.Error = "could not fetch the sandbox report: " + err.Error()
 		}
-
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("SCORE: %f", score))
-		// slog.Printf("TASKID %d - SANDBOXID %d - SCORE %f\n", task.Id, task.SandboxId, score)
-
-		dbOprs := dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{},
-		}
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, updateFOD(task, score))
-		// saveVerdictInHash(task, score)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, moveTaskToFinishedTable(task, false)...)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, processDuplicateTasksForReported(task, score)...)
-		err = dao.GormOperations(&dbOprs, config.Db, dao.EXEC)
-		if err != nil {
-			// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN FINISHED TABLE: %v", err))
-			// slog.Println("ERROR WHILE CREATING TASK IN FINISHED TABLE: ", task.SandboxId, err)
-		}
-		SendAcknowledgementToClientIp(task.Id)
-		go deleteSandboxData(task.Id, task.SandboxId)
-		go deleteLocalTask(task.Id)
-
-	} else if task.Status == Aborted {
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("%d - %d ABORTED", task.Id, task.SandboxId))
-		// slog.Printf("%d - %d ABORTED\n", task.Id, task.SandboxId)
-		dbOprs := dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{},
-		}
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, updateFOD(task, 0))
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, moveTaskToFinishedTable(task, true)...)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, processDuplicateTasksForAborted(task)...)
-		err := dao.GormOperations(&dbOprs, config.Db, dao.EXEC)
-		if err != nil {
-			// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN FINISHED TABLE: %v", err))
-			// slog.Println("ERROR WHILE CREATING TASK IN FINISHED TABLE: ", task.SandboxId, err)
-		}
-		SendAcknowledgementToClientIp(task.Id)
-
-		// go deleteSandboxData(task.Id, task.SandboxId)
-		go deleteLocalTask(task.Id)
-
-	} else if task.Status == ReportedThroughClamd {
-		slog.Println("REPORTED THROUGH CLAMD")
-		score := float32(3.5)
-		dbOprs := dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{},
-		}
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, updateFOD(task, score))
-		// saveVerdictInHash(task, score)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, moveTaskToFinishedTable(task, false)...)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, processDuplicateTasksForReported(task, score)...)
-		err := dao.GormOperations(&dbOprs, config.Db, dao.EXEC)
-		if err != nil {
-			// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN FINISHED TABLE: %v", err))
-			// slog.Println("ERROR WHILE CREATING TASK IN FINISHED TABLE: ", task.SandboxId, err)
-		}
-		SendAcknowledgementToClientIp(task.Id)
-		go deleteLocalTask(task.Id)
-	} else if task.Status == ReportedThroughQuickScope {
-		slog.Println("REPORTED THROUGH QUICK SCOPE")
-		score := float32(3.5)
-		dbOprs := dao.DatabaseOperationsRepo{
-			QueryExecSet: []string{},
-		}
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, updateFOD(task, score))
-		// saveVerdictInHash(task, score)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, moveTaskToFinishedTable(task, false)...)
-		dbOprs.QueryExecSet = append(dbOprs.QueryExecSet, processDuplicateTasksForReported(task, score)...)
-		err := dao.GormOperations(&dbOprs, config.Db, dao.EXEC)
-		if err != nil {
-			// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN FINISHED TABLE: %v", err))
-			// slog.Println("ERROR WHILE CREATING TASK IN FINISHED TABLE: ", task.SandboxId, err)
-		}
-		SendAcknowledgementToClientIp(task.Id)
-		go deleteLocalTask(task.Id)
-	} else {
-		err := updateStatusChangeDirectlyThroughDb(task)
+		task.Detail = fmt.Sprintf("score %.1f", score)
+		// kept here, the sandbox copy is deleted once the task is reported
+		queries = append(queries, saveAnalysis(task, report)...)
+		queries = append(queries, saveAttackTechniques(task)...)
+	case ReportedThroughPrefilter:
+		score = task.Score
+		queries = append(queries, saveAttackTechniques(task)...)
+	case AllowedThroughPrefilter:
+		score = 0
+	case Aborted:
+	default:
+		err := applyTransition(task, newStatus, queries...)
 		if err != nil {
 			// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE UPDATING STATUS: %v", err))
-			// slog.Println("ERROR WHILE UPDATING STATUS: ", task.Id, err)
 		}
+		return err
 	}
-}
 
-func changeStatusAndPushToLogQueue(task Task, newStatus string) {
+	queryStringArr := append(queries, updateFOD(task, score))
+	if decidedByFileList(task) {
+		queryStringArr = append(queryStringArr, fileListStatusQuery(task))
+	}
+	// saveVerdictInHash(task, score)
+	if newStatus == Aborted {
+		queryStringArr = append(queryStringArr, processDuplicateTasksForAborted(task)...)
+	} else {
+		queryStringArr = append(queryStringArr, verdictHistoryQuery(task))
+		queryStringArr = append(queryStringArr, processDuplicateTasksForReported(task, score)...)
+	}
 
-	err := pushToQueue(LogQueue, task, newStatus)
+	err := applyTransition(task, newStatus, queryStringArr...)
 	if err != nil {
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE PUSHING TASK INTO LOG QUEUE: %v", err))
-		// slog.Println("ERROR WHILE PUSHING TASK INTO LOG QUEUE: ", task.Id, err)
-		task.LogQueueFailed = true
-		err = updateLiveTaskDirectlyThroughDb(task)
-		if err != nil {
-			// logger.LogAccToTaskId(task.Id, fmt.Sprintf("FAILED TO UPDATE LOG_QUEUE_FAILED %d STATUS TO %s: %v", task.Id, Aborted, err))
-			// slog.Println("FAILED TO UPDATE LOG_QUEUE_FAILED %d STATUS TO %s: %v", task.Id, Aborted, err)
-		}
+		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN FINISHED TABLE: %v", err))
+		return err
+	}
+
+	if newStatus == Reported {
+		go deleteSandboxData(task.SandboxNode, task.SandboxId)
+	}
+	event := extras.EVENT_TASK_REPORTED
+	if newStatus == Aborted {
+		event = extras.EVENT_TASK_ABORTED
+	}
+	if task.Type == extras.TASK_TYPE_URL {
+		NotifyTask(event, extras.TASK_TYPE_URL, task.Id)
+		return nil
+	}
+
+	if newStatus != Aborted && !decidedByFileList(task) {
+		cacheVerdict(task, score)
 	} else {
-		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("PUSHED TASK INTO LOG QUEUE: %s", newStatus))
-		// slog.Println("PUSHED TASK INTO LOG QUEUE: ", task.Id, newStatus)
+		verdictcache.DropProvisional(task.Md5, task.SHA, task.SHA256)
 	}
+	NotifyTask(event, extras.TASK_TYPE_FILE, task.Id)
+	FinishArchivesIfDone()
+	go retainLocalTask(task)
+	return nil
 }
 
 func deleteLocalTask(id int) {
@@ -7,6 +7,7 @@ import (
 	"anti-apt-backend/model"
 	"anti-apt-backend/util"
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -16,7 +17,7 @@ import (
 	"github.com/h2non/filetype"
 )
 
-func ScanFileThroughQuickScope(task Task) (bool, error) {
+func ScanFileThroughQuickScope(ctx context.Context, task Task) (bool, error) {
 	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
 	args := []string{"python3", "/home/prateek/Qu1cksc0pe/qu1cksc0pe.py", "--file"}
 
@@ -56,7 +57,7 @@ func ScanFileThroughQuickScope(task Task) (bool, error) {
 
 	// slog.Printf("Executing command: %s %v", args[0], args[1:])
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	var stdout, stderr bytes.Buffer
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -102,7 +103,7 @@ func ScanFileThroughQuickScope(task Task) (bool, error) {
 	}
 
 	var malwareFound bool = false
-	if found || strings.Contains(trimmedString, "I0C") || strings.Contains(strings.ToLower(trimmedString), "macro found") || strings.Contains(strings.ToLower(trimmedString), "rule name") {
+	if found || strings.Contains(trimmedString, "I0C") || strings.Contains(strings.ToLower(trimmedString), "macro found") {
 		malwareFound = true
 	}
 
@@ -0,0 +1,127 @@
+package queues
+
+import (
+	"anti-apt-backend/extras"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+const SAMPLE_PURGE_INTERVAL = time.Hour
+
+// GetSampleRetentionDays is how long finished samples are kept for
+// reanalysis, 0 when they are deleted straight away.
+func GetSampleRetentionDays() int {
+	content, err := os.ReadFile(extras.SAMPLE_RETENTION_FILE_PATH)
+	if err != nil {
+		return extras.DEFAULT_SAMPLE_RETENTION_DAYS
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || value < 0 {
+		return extras.DEFAULT_SAMPLE_RETENTION_DAYS
+	}
+	return value
+}
+
+// retained samples are stored by hash, so a file submitted again only takes
+// the space once
+func retainedSamplePath(sha256 string) string {
+	return extras.SAMPLE_RETENTION_PATH + sha256
+}
+
+// retainLocalTask moves the finished task's sample into the retention store,
+// the modification time of the retained copy is when it expires from.
+func retainLocalTask(task Task) {
+	if GetSampleRetentionDays() <= 0 || task.SHA256 == extras.EMPTY_STRING {
+		deleteLocalTask(task.Id)
+		return
+	}
+
+	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
+	retained := retainedSamplePath(task.SHA256)
+	err := os.MkdirAll(extras.SAMPLE_RETENTION_PATH, 0755)
+	if err == nil {
+		// the store may be on another file system than the uploads
+		if err = os.Rename(fp, retained); err != nil {
+			err = copyFile(fp, retained)
+		}
+	}
+	if err != nil {
+		slog.Println("ERROR WHILE RETAINING TASK FILE: ", task.Id, err)
+	}
+	os.Remove(fp)
+
+	now := time.Now()
+	os.Chtimes(retained, now, now)
+}
+
+// RestoreSample copies the retained sample with sha256 back as the file of
+// task id and keeps it for another retention period.
+func RestoreSample(sha256 string, id int) error {
+	retained := retainedSamplePath(sha256)
+	if _, err := os.Stat(retained); err != nil {
+		return extras.ErrSampleNotRetained
+	}
+
+	if err := copyFile(retained, extras.SANDBOX_FILE_PATHS+fmt.Sprintf("%d", id)); err != nil {
+		return err
+	}
+	now := time.Now()
+	os.Chtimes(retained, now, now)
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// purgeRetainedSamples deletes samples kept longer than the retention period,
+// all of them once retention is turned off.
+func purgeRetainedSamples() {
+	entries, err := os.ReadDir(extras.SAMPLE_RETENTION_PATH)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -GetSampleRetentionDays())
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(extras.SAMPLE_RETENTION_PATH, entry.Name())); err != nil {
+			slog.Println("ERROR WHILE PURGING RETAINED SAMPLE: ", entry.Name(), err)
+		}
+	}
+}
+
+// SampleRetentionHandler purges expired samples every SAMPLE_PURGE_INTERVAL.
+func SampleRetentionHandler(ctx context.Context) {
+	for ctx.Err() == nil {
+		purgeRetainedSamples()
+		sleep(ctx, SAMPLE_PURGE_INTERVAL)
+	}
+}
@@ -5,17 +5,17 @@ import (
 	"anti-apt-backend/dao"
 	"anti-apt-backend/extras"
 	"anti-apt-backend/model"
-	"anti-apt-backend/service/cuckoo"
-	"bytes"
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
-	"net/http"
 	"time"
+
+	"github.com/gookit/slog"
 )
 
 type Sandbox struct {
 	SandboxId   int
+	Node        string
 	Status      string
 	CompletedOn time.Time
 }
@@ -25,100 +25,118 @@ const (
 	SANDBOX_MAX_RETRIES = 5
 )
 
-// Add timeout for all cuckoo APIS
+// fetchAllTasksFromSandbox lists the tasks of every node that answers, it
+// only fails when none does.
 func fetchAllTasksFromSandbox() ([]Sandbox, error) {
-
-	client := cuckoo.New(&cuckoo.Config{
-		Client: &http.Client{Timeout: SANDBOX_API_TIMEOUT},
-	})
-	allTasks, err := client.ListAllTasks(context.Background())
-	if err != nil {
-		return nil, err
-	}
-
 	var resp []Sandbox
-	var t time.Time
-	for _, task := range allTasks {
-		if task.CompletedOn != nil {
-			t, _ = time.Parse(time.RFC1123, fmt.Sprintf("%v", task.CompletedOn))
+	var errs []error
+	for _, node := range pool.nodes {
+		tasks, err := node.Backend.ListTasks(context.Background())
+		if err != nil {
+			slog.Println("ERROR WHILE LISTING TASKS OF SANDBOX NODE: ", node.Id, err)
+			errs = append(errs, fmt.Errorf("sandbox node %s: %w", node.Id, err))
+			continue
+		}
+		for _, task := range tasks {
+			task.Node = node.Id
+			resp = append(resp, task)
 		}
-		resp = append(resp, Sandbox{
-			SandboxId:   task.ID,
-			Status:      string(task.Status),
-			CompletedOn: t,
-		})
 	}
-
+	if len(errs) > 0 && len(errs) == len(pool.nodes) {
+		return nil, errors.Join(errs...)
+	}
 	return resp, nil
 }
 
+// countOfFreeVm counts the unlocked machines of every node that answers, it
+// only fails when none does.
 func countOfFreeVm() (int, error) {
-	client := cuckoo.New(&cuckoo.Config{
-		Client: &http.Client{Timeout: SANDBOX_API_TIMEOUT},
-	})
-	allMachines, err := client.ListMachines(context.Background())
 	count := 0
-	if err != nil {
-		return count, err
-	}
-	for _, machine := range allMachines {
-		if !machine.Locked {
-			count++
+	var errs []error
+	for _, node := range pool.nodes {
+		allMachines, err := node.Backend.ListMachines(context.Background())
+		if err != nil {
+			slog.Println("ERROR WHILE LISTING MACHINES OF SANDBOX NODE: ", node.Id, err)
+			errs = append(errs, fmt.Errorf("sandbox node %s: %w", node.Id, err))
+			continue
 		}
+		for _, machine := range allMachines {
+			if !machine.Locked {
+				count++
+			}
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(pool.nodes) {
+		return count, errors.Join(errs...)
 	}
 	return count, nil
 }
 
-func sendToSandbox(task Task) (int, error) {
+func sendToSandbox(node *SandboxNode, task Task) (int, error) {
 
-	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
+	opts := SubmitOptions{
+		Priority: priorityOf(task),
+		Platform: task.Platform,
+		Timeout:  task.Timeout,
+		Tag:      task.VmTag,
+		Route:    task.Route,
+		Package:  task.Package,
+		Options:  task.Options,
+	}
 
-	client := cuckoo.New(&cuckoo.Config{})
-	sandboxId, err := client.CreateTaskFile(context.Background(), task.Id, fp)
+	var sandboxId int
+	var err error
+	if task.Type == extras.TASK_TYPE_URL {
+		sandboxId, err = node.Backend.SubmitUrl(context.Background(), task.Id, task.FileName, opts)
+	} else {
+		fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
+		sandboxId, err = node.Backend.SubmitFile(context.Background(), task.Id, fp, opts)
+	}
 	if err != nil {
 		// logger.LogAccToTaskId(task.Id, fmt.Sprintf("ERROR WHILE CREATING TASK IN SANDBOX: %v", err))
 		return -1, err
 	}
 
-	if sandboxId == 0 {
-		// logger.LogAccToTaskId(task.Id, "SANDBOXID IS ZERO")
-	}
-
 	return sandboxId, nil
 }
 
-func fetchScoreFromSandBox(taskId int, sandboxId int) (float32, error) {
+// fetchScoreFromSandBox returns the task's score and the full report, which is
+// nil when the backend only scores.
+func fetchScoreFromSandBox(task Task) (float32, *model.Report, error) {
+
+	node, err := pool.node(task.SandboxNode)
+	if err != nil {
+		return 0, nil, err
+	}
 
-	client := cuckoo.New(&cuckoo.Config{
-		Client: &http.Client{Timeout: SANDBOX_API_TIMEOUT},
-	})
-	report, err := client.TasksReport(context.Background(), sandboxId)
+	report, err := node.Backend.Report(context.Background(), task.SandboxId)
 	if err != nil {
 		// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING REPORT: %v", err))
-		return 0, err
+		return 0, nil, err
 	}
 
 	if report != nil {
-		return report.Info.Score, nil
+		return report.Score, report.Details, nil
 	}
 
-	return 0, nil
+	return 0, nil, nil
 }
 
-func deleteSandboxData(taskId int, sandboxId int) error {
+func deleteSandboxData(nodeId string, sandboxId int) error {
 
 	if sandboxId == 0 {
 		return nil
 	}
 
-	client := cuckoo.New(&cuckoo.Config{})
-	err := client.TasksDelete(context.Background(), sandboxId)
+	node, err := pool.node(nodeId)
+	if err != nil {
+		return err
+	}
+
+	err = node.Backend.DeleteTask(context.Background(), sandboxId)
 	if err != nil {
 		// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE DELETING SANDBOX DATA: %v", err))
 		return err
-	} else {
-		// logger.LogAccToTaskId(taskId, fmt.Sprintf("DELETED SANDBOX DATA: %d", sandboxId))
-		// slog.Println("DELETED SANDBOX DATA: ", sandboxId)
 	}
 
 	return nil
@@ -127,19 +145,26 @@ func deleteSandboxData(taskId int, sandboxId int) error {
 type Report struct {
 	Score       float32
 	Completedon time.Time
+	Details     *model.Report // the whole analysis, nil for backends that only score
 }
 
-func fetchReportInfoFromSandBox(sandboxId int) (Report, error) {
+func fetchReportInfoFromSandBox(nodeId string, sandboxId int) (Report, error) {
 
-	client := cuckoo.New(&cuckoo.Config{})
-	report, err := client.TasksReport(context.Background(), sandboxId)
+	node, err := pool.node(nodeId)
 	if err != nil {
 		return Report{}, err
 	}
 
-	completedOn, _ := time.Parse(time.RFC1123, fmt.Sprintf("%v", report.Info.Ended))
+	report, err := node.Backend.Report(context.Background(), sandboxId)
+	if err != nil {
+		return Report{}, err
+	}
+
+	if report == nil {
+		return Report{}, extras.ErrReportNotFound
+	}
 
-	return Report{Score: report.Info.Score, Completedon: completedOn}, nil
+	return *report, nil
 }
 
 func FlushSandboxData() error {
@@ -149,7 +174,7 @@ func FlushSandboxData() error {
 	}
 
 	for _, task := range allTasks {
-		err := deleteSandboxData(task.SandboxId, task.SandboxId)
+		err := deleteSandboxData(task.Node, task.SandboxId)
 		if err != nil {
 			// // slog.Println("ERROR WHILE DELETING SANDBOX DATA FOR ID: ", task.SandboxId, err)
 		}
@@ -157,94 +182,15 @@ func FlushSandboxData() error {
 	return nil
 }
 
-func RemoveSandboxTasksWhichAreNotInTasksAnReachedTimeOut(allSandboxTasks []Sandbox, tasks []Task) error {
-
-	taskMap := make(map[int]bool)
-
-	for _, task := range tasks {
-		if task.SandboxId > 0 {
-			taskMap[task.SandboxId] = true
-		}
-	}
-
-	var resp []Sandbox
-
-	for _, sandboxTask := range allSandboxTasks {
-		if _, ok := taskMap[sandboxTask.SandboxId]; !ok && time.Since(sandboxTask.CompletedOn) > 30*time.Minute {
-			resp = append(resp, sandboxTask)
-		}
-	}
-
-	for _, task := range resp {
-		err := pushToQueue(LogQueue, Task{SandboxId: task.SandboxId}, SandboxApiTimeout)
-		if err != nil {
-			go deleteSandboxData(task.SandboxId, task.SandboxId)
-		}
-	}
-	return nil
-}
-
 func FetchSandboxTaskCountWhichAreNotReported() int {
-	allSandboxTasks, err := fetchAllTasksFromSandbox()
-	if err != nil {
-		// slog.Println("ERROR WHILE FETCHING ALL TASKS FROM SANDBOX: ", err)
-	}
-
-	count := 0
-
-	for _, sandboxTask := range allSandboxTasks {
-		if sandboxTask.Status != extras.Reported && sandboxTask.Status != "failed_analysis" {
-			count++
-		}
-	}
-	return count
+	pool.refresh(context.Background())
+	return pool.notReported()
 }
 
 const (
 	ANALYSING = "ANALYSING"
 )
 
-func SendAcknowledgementToClientIp(taskId int) {
-
-	// slog.Println("SENDING ACKNOWLEDGEMENT TO CLIENT IP: ", taskId)
-
-	ip, verdict := sendAcknowledgement(taskId)
-
-	if ip == "" || verdict == "" {
-		return
-	}
-
-	URL := "https://" + ip + ":8085/verdict"
-
-	payload := fmt.Sprintf(`{"verdict": "%s", "taskID": %d}`, verdict, taskId)
-	req, err := http.NewRequest("POST", URL, bytes.NewBuffer([]byte(payload)))
-	if err != nil {
-		// slog.Println("ERROR WHILE CREATING REQUEST: ", err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	transport := http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	client := http.Client{
-		Timeout:   10 * time.Second,
-		Transport: &transport,
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		// slog.Println("ERROR WHILE MAKING REQUEST: ", err)
-		return
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		// slog.Printf("ERROR IN RESPONSE CODE: %d", resp.StatusCode)
-		return
-	}
-
-	// slog.Println("RESPONSE: ", resp)
-}
-
 func sendAcknowledgement(taskId int) (string, string) {
 	queryString := fmt.Sprintf("SELECT * FROM %s WHERE id = %d", extras.FileOnDemandTable, taskId)
 
@@ -0,0 +1,104 @@
+package queues
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/util"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrStaleTask         = fmt.Errorf("task was changed by another worker")
+	ErrInvalidTransition = fmt.Errorf("invalid task state transition")
+)
+
+// transitions lists the states a live task may move to. A state may move to
+// itself, which saves counters and gives up the claim without a status change.
+// Reported, ReportedThroughPrefilter, AllowedThroughPrefilter and Aborted are
+// terminal and move the task to the finished table.
+var transitions = map[string][]string{
+	Pending: {Queued, ReportedThroughPrefilter, AllowedThroughPrefilter, Aborted},
+	Queued:  {Queued, Running, Aborted},
+	Running: {Running, Reported, Aborted},
+}
+
+// a claimed task is not handed to another worker until the lease runs out
+const CLAIM_LEASE = 5 * time.Minute
+
+func isTerminal(status string) bool {
+	_, ok := transitions[status]
+	return !ok
+}
+
+func canTransition(from string, to string) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+var transitionStages = map[string]string{
+	Pending: extras.TASK_STAGE_PREFILTER,
+	Queued:  extras.TASK_STAGE_SUBMISSION,
+	Running: extras.TASK_STAGE_ANALYSIS,
+}
+
+// taskEventQuery records the move of task to newStatus in its timeline. Polls
+// that leave a task where it was are only recorded when they give a reason,
+// like a retry.
+func taskEventQuery(task Task, newStatus string) string {
+	if task.Status == newStatus && task.Reason == extras.EMPTY_STRING {
+		return extras.EMPTY_STRING
+	}
+
+	stage := transitionStages[task.Status]
+	if task.Status == Pending && task.Type == extras.TASK_TYPE_URL {
+		stage = extras.TASK_STAGE_REPUTATION
+	}
+	taskType := task.Type
+	if taskType == extras.EMPTY_STRING {
+		taskType = extras.TASK_TYPE_FILE
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (task_id, task_type, from_state, to_state, stage, reason, detail, error, sandbox_id, sandbox_node, sandbox_retry_count, running_retry_count, created_at) VALUES (%d, '%s', '%s', '%s', '%s', '%s', '%s', '%s', %d, '%s', %d, %d, '%s')", extras.TaskEventTable, task.Id, taskType, task.Status, newStatus, stage, task.Reason, util.EscapeSqlString(task.Detail), util.EscapeSqlString(task.Error), task.SandboxId, util.EscapeSqlString(task.SandboxNode), task.SandboxRetryCount, task.RunningRetryCount, time.Now().Format(extras.TIME_FORMAT))
+}
+
+// applyTransition moves task to newStatus and runs queries in the same db
+// transaction. The live row is matched on the version read at claim time, so
+// the write fails with ErrStaleTask if any other worker touched it since.
+func applyTransition(task Task, newStatus string, queries ...string) error {
+	if !canTransition(task.Status, newStatus) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, task.Status, newStatus)
+	}
+
+	var queryStringArr []string
+	event := taskEventQuery(task, newStatus)
+	if isTerminal(newStatus) {
+		queryStringArr = moveTaskToFinishedTable(task, newStatus == Aborted)
+	} else {
+		task.Status = newStatus
+		queryStringArr = []string{updateLiveTask(task)}
+	}
+	queryStringArr = append(queryStringArr, queries...)
+	if event != extras.EMPTY_STRING {
+		queryStringArr = append(queryStringArr, event)
+	}
+
+	return config.Db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(queryStringArr[0])
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrStaleTask
+		}
+
+		for _, query := range queryStringArr[1:] {
+			if err := tx.Exec(query).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
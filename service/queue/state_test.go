@@ -0,0 +1,178 @@
+package queues
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/internal/dbtest"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransitions(t *testing.T) {
+	allowed := [][2]string{
+		{Pending, Queued}, {Pending, ReportedThroughPrefilter}, {Pending, AllowedThroughPrefilter}, {Pending, Aborted},
+		{Queued, Queued}, {Queued, Running}, {Queued, Aborted},
+		{Running, Running}, {Running, Reported}, {Running, Aborted},
+	}
+	for _, transition := range allowed {
+		if !canTransition(transition[0], transition[1]) {
+			t.Errorf("%s -> %s should be allowed", transition[0], transition[1])
+		}
+	}
+
+	denied := [][2]string{
+		{Pending, Pending}, {Pending, Running}, {Pending, Reported},
+		{Queued, Pending}, {Queued, Reported},
+		{Running, Queued}, {Running, ReportedThroughPrefilter},
+		{Reported, Running}, {Aborted, Queued},
+	}
+	for _, transition := range denied {
+		if canTransition(transition[0], transition[1]) {
+			t.Errorf("%s -> %s should be denied", transition[0], transition[1])
+		}
+	}
+
+	for _, status := range []string{Reported, ReportedThroughPrefilter, AllowedThroughPrefilter, Aborted} {
+		if !isTerminal(status) {
+			t.Errorf("%s should be terminal", status)
+		}
+	}
+}
+
+func TestApplyTransitionInvalid(t *testing.T) {
+	db := dbtest.Use(t)
+	err := applyTransition(Task{LiveId: 3, Id: 30, Status: Running, Version: 5}, Queued)
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("got %v, want ErrInvalidTransition", err)
+	}
+	if len(db.Statements()) != 0 {
+		t.Errorf("invalid transition wrote %v", db.Statements())
+	}
+}
+
+func TestApplyTransitionMatchesVersion(t *testing.T) {
+	db := dbtest.Use(t)
+	task := Task{LiveId: 3, Id: 30, Status: Queued, Version: 5, SandboxId: 12, SandboxNode: "node-1"}
+	if err := applyTransition(task, Running, "UPDATE extra"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.Statements()) != 3 {
+		t.Fatalf("got %d statements, want the update, the extra query and the event: %v", len(db.Statements()), db.Statements())
+	}
+	update := db.Statements()[0]
+	for _, want := range []string{"status = 'running'", "claimed_until = NULL", "version = version + 1", "WHERE task_live_analysis_id = 3 AND version = 5"} {
+		if !strings.Contains(update, want) {
+			t.Errorf("update %q lacks %q", update, want)
+		}
+	}
+	if db.Statements()[1] != "UPDATE extra" || !strings.HasPrefix(db.Statements()[2], "INSERT INTO "+extras.TaskEventTable) {
+		t.Errorf("unexpected statements after the update: %v", db.Statements()[1:])
+	}
+}
+
+func TestApplyTransitionTerminal(t *testing.T) {
+	db := dbtest.Use(t)
+	task := Task{LiveId: 3, Id: 30, Status: Running, Version: 5}
+	if err := applyTransition(task, Reported); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf("DELETE FROM %s WHERE task_live_analysis_id = 3 AND version = 5", TaskLiveAnalysingTable)
+	if db.Statements()[0] != want {
+		t.Errorf("got %q, want %q", db.Statements()[0], want)
+	}
+	if !strings.HasPrefix(db.Statements()[1], "INSERT INTO "+TaskFinishedTable) || !strings.Contains(db.Statements()[1], "false)") {
+		t.Errorf("task not moved to the finished table: %v", db.Statements())
+	}
+}
+
+func TestApplyTransitionStale(t *testing.T) {
+	db := dbtest.Use(t)
+	// another worker moved the version on since the claim
+	db.RowsAffected = 0
+
+	err := applyTransition(Task{LiveId: 3, Id: 30, Status: Queued, Version: 5}, Running, "UPDATE extra")
+	if !errors.Is(err, ErrStaleTask) {
+		t.Fatalf("got %v, want ErrStaleTask", err)
+	}
+	if len(db.Statements()) != 1 || !db.RolledBack() {
+		t.Errorf("stale transition should stop at the update and roll back: %v", db.Statements())
+	}
+}
+
+func liveRows(query string) ([]string, [][]driver.Value) {
+	columns := []string{"live_id", "id", "type", "status", "version", "priority", "submitted_by"}
+	var rows [][]driver.Value
+	switch {
+	case strings.Contains(query, "= '"+extras.PRIORITY_INLINE+"'"):
+		rows = append(rows, []driver.Value{int64(4), int64(40), []byte(extras.TASK_TYPE_FILE), []byte(Pending), int64(2), []byte(extras.PRIORITY_INLINE), []byte("firewall")})
+	case strings.Contains(query, "NOT IN"):
+		rows = append(rows, []driver.Value{int64(2), int64(20), []byte(extras.TASK_TYPE_FILE), []byte(Pending), int64(0), []byte(extras.PRIORITY_MANUAL), []byte("admin")})
+	}
+	return columns, rows
+}
+
+func TestClaimTasksLeases(t *testing.T) {
+	db := dbtest.Use(t)
+	db.RowsFor = liveRows
+
+	before := time.Now()
+	tasks, err := claimTasks(Pending, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one window per priority class so a backlog of one cannot hide the others
+	var selects []string
+	for _, statement := range db.Statements() {
+		if strings.HasPrefix(statement, "SELECT") {
+			selects = append(selects, statement)
+		}
+	}
+	if len(selects) != len(PRIORITY_WEIGHTS) {
+		t.Fatalf("got %d claim queries, want one per priority class: %v", len(selects), selects)
+	}
+	for _, query := range selects {
+		if !strings.Contains(query, fmt.Sprintf("LIMIT %d FOR UPDATE OF live SKIP LOCKED", CLAIM_WINDOW)) {
+			t.Errorf("claim query does not lock a window of %d: %s", CLAIM_WINDOW, query)
+		}
+	}
+
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+	versions := map[int]int{}
+	for _, task := range tasks {
+		versions[task.LiveId] = task.Version
+	}
+	if versions[2] != 1 || versions[4] != 3 {
+		t.Errorf("claimed tasks should carry the version the lease wrote: %v", versions)
+	}
+
+	lease := db.Statements()[len(db.Statements())-1]
+	if !strings.Contains(lease, "version = version + 1 WHERE task_live_analysis_id IN (") || !strings.Contains(lease, "2") || !strings.Contains(lease, "4") {
+		t.Fatalf("claimed rows not leased: %s", lease)
+	}
+	_, until, _ := strings.Cut(lease, "claimed_until = '")
+	until, _, _ = strings.Cut(until, "'")
+	leasedUntil, err := time.ParseInLocation(extras.TIME_FORMAT, until, time.Local)
+	if err != nil || leasedUntil.Before(before.Add(CLAIM_LEASE)) || leasedUntil.After(time.Now().Add(CLAIM_LEASE)) {
+		t.Errorf("lease runs until %s, want %s from now", until, CLAIM_LEASE)
+	}
+}
+
+func TestClaimTasksUnfair(t *testing.T) {
+	db := dbtest.Use(t)
+
+	tasks, err := claimTasks(Running, 3)
+	if err != nil || len(tasks) != 0 {
+		t.Fatalf("got %v and %v, want no tasks", tasks, err)
+	}
+	if len(db.Statements()) != 1 || !strings.Contains(db.Statements()[0], "LIMIT 3 FOR UPDATE OF live SKIP LOCKED") {
+		t.Errorf("states without a scheduler should claim limit tasks by id: %v", db.Statements())
+	}
+}
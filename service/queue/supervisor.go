@@ -0,0 +1,61 @@
+package queues
+
+import (
+	"anti-apt-backend/dao"
+	"context"
+	"sync"
+	"time"
+)
+
+type Supervisor struct {
+	wg sync.WaitGroup
+}
+
+// StartQueueHandlers runs the queue loops until ctx is cancelled.
+func StartQueueHandlers(ctx context.Context) *Supervisor {
+	s := &Supervisor{}
+	for _, handler := range []func(context.Context){
+		PendingTaskHandler,
+		QueuedTaskHandler,
+		RunningTaskHandler,
+		NotificationHandler,
+		SampleRetentionHandler,
+	} {
+		s.wg.Add(1)
+		go func(handler func(context.Context)) {
+			defer s.wg.Done()
+			handler(ctx)
+		}(handler)
+	}
+	return s
+}
+
+// Shutdown waits for the loops to finish their current transitions and hands
+// the claims they held back, so the next start does not wait for the leases.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return dao.ReleaseTaskClaims()
+}
+
+// sleep returns false if ctx is cancelled before d elapses.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
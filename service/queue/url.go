@@ -0,0 +1,29 @@
+package queues
+
+import (
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"fmt"
+)
+
+// score given to a url on the block list
+const URL_REPUTATION_SCORE = 10
+
+// checkUrlReputation looks the task's url up in the url intel store. A listed
+// url is decided there without being detonated.
+func checkUrlReputation(task *Task) string {
+	entry, err := dao.LookupUrlIntel(task.FileName)
+	if err != nil || entry == nil {
+		return Queued
+	}
+
+	task.Detail = fmt.Sprintf("%s %s %s", entry.List, entry.Type, entry.Value)
+	if entry.List == extras.ALLOW {
+		task.Reason = extras.TASK_REASON_URL_INTEL_ALLOWED
+		task.Score = 0
+		return AllowedThroughPrefilter
+	}
+	task.Reason = extras.TASK_REASON_URL_INTEL_BLOCKED
+	task.Score = URL_REPUTATION_SCORE
+	return ReportedThroughPrefilter
+}
@@ -0,0 +1,177 @@
+package queues
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const YARA_BINARY = "yara"
+
+func EnabledYaraRulesets() ([]model.YaraRuleset, error) {
+	var rulesets []model.YaraRuleset
+	queryString := fmt.Sprintf("SELECT * FROM %s WHERE enabled = true AND active = true ORDER BY name", extras.YaraRulesetTable)
+	yaraRulesets := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &rulesets,
+	}
+	err := dao.GormOperations(&yaraRulesets, config.Db, dao.EXEC)
+	return rulesets, err
+}
+
+// ValidateYaraRules compiles the rules file against an empty input, yara exits
+// with an error and prints the line for any syntax error.
+func ValidateYaraRules(ctx context.Context, rulesPath string) error {
+	cmd := exec.CommandContext(ctx, YARA_BINARY, "-w", rulesPath, "/dev/null")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != extras.EMPTY_STRING {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// ScanWithYara runs every ruleset over fp and returns one match per rule hit.
+func ScanWithYara(ctx context.Context, fp string, rulesets []model.YaraRuleset) ([]model.TaskYaraMatch, error) {
+	var matches []model.TaskYaraMatch
+	for _, ruleset := range rulesets {
+		cmd := exec.CommandContext(ctx, YARA_BINARY, "-w", "-g", "-m", ruleset.FilePath, fp)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return matches, fmt.Errorf("ruleset %s: %v %s", ruleset.Name, err, strings.TrimSpace(stderr.String()))
+		}
+
+		scanner := bufio.NewScanner(&stdout)
+		for scanner.Scan() {
+			rule, tags, meta, ok := parseYaraLine(scanner.Text())
+			if !ok {
+				continue
+			}
+			metaJson, _ := json.Marshal(meta)
+			matches = append(matches, model.TaskYaraMatch{
+				Ruleset:        ruleset.Name,
+				RulesetVersion: ruleset.Version,
+				Rule:           rule,
+				Tags:           strings.Join(tags, ","),
+				Meta:           string(metaJson),
+			})
+		}
+	}
+	return matches, nil
+}
+
+// parseYaraLine reads a line printed by yara -g -m, which looks like
+// rule_name [tag1,tag2] [author="x",severity=3] /path/to/file
+func parseYaraLine(line string) (string, []string, map[string]string, bool) {
+	rule, rest, found := strings.Cut(strings.TrimSpace(line), " ")
+	if !found || rule == extras.EMPTY_STRING {
+		return extras.EMPTY_STRING, nil, nil, false
+	}
+
+	tagList, rest, ok := readBracketed(strings.TrimSpace(rest))
+	if !ok {
+		return extras.EMPTY_STRING, nil, nil, false
+	}
+	metaList, _, ok := readBracketed(strings.TrimSpace(rest))
+	if !ok {
+		return extras.EMPTY_STRING, nil, nil, false
+	}
+
+	var tags []string
+	for _, tag := range tagList {
+		if tag != extras.EMPTY_STRING {
+			tags = append(tags, tag)
+		}
+	}
+
+	meta := make(map[string]string)
+	for _, item := range metaList {
+		key, value, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+		}
+		meta[key] = value
+	}
+
+	return rule, tags, meta, true
+}
+
+// readBracketed splits a leading [a,b,"c,d"] group on the commas outside of
+// quotes and returns the items with what follows the group.
+func readBracketed(s string) ([]string, string, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return nil, s, false
+	}
+
+	var items []string
+	var item strings.Builder
+	inQuotes, escaped := false, false
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuotes:
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ',' && !inQuotes:
+			items = append(items, item.String())
+			item.Reset()
+			continue
+		case c == ']' && !inQuotes:
+			if item.Len() > 0 {
+				items = append(items, item.String())
+			}
+			return items, s[i+1:], true
+		}
+		item.WriteByte(c)
+	}
+	return nil, s, false
+}
+
+// ScanFileThroughYara scans the task's file with the enabled rulesets. It also
+// returns the queries saving the matches on the task.
+func ScanFileThroughYara(ctx context.Context, task Task) (bool, string, []string, error) {
+	rulesets, err := EnabledYaraRulesets()
+	if err != nil {
+		return false, extras.EMPTY_STRING, nil, err
+	}
+	if len(rulesets) == 0 {
+		return false, "no rulesets enabled", nil, nil
+	}
+
+	fp := extras.SANDBOX_FILE_PATHS + fmt.Sprintf("%d", task.Id)
+	matches, err := ScanWithYara(ctx, fp, rulesets)
+	if err != nil {
+		return false, extras.EMPTY_STRING, nil, err
+	}
+
+	var queryStringArr []string
+	var rules []string
+	now := time.Now().Format(extras.TIME_FORMAT)
+	for _, match := range matches {
+		rules = append(rules, match.Ruleset+":"+match.Rule)
+		queryStringArr = append(queryStringArr, fmt.Sprintf("INSERT INTO %s (task_id, ruleset, ruleset_version, rule, tags, meta, created_at) VALUES (%d, '%s', %d, '%s', '%s', '%s', '%s')", extras.TaskYaraMatchTable, task.Id, util.EscapeSqlString(match.Ruleset), match.RulesetVersion, util.EscapeSqlString(match.Rule), util.EscapeSqlString(match.Tags), util.EscapeSqlString(match.Meta), now))
+	}
+
+	return len(matches) > 0, strings.Join(rules, ", "), queryStringArr, nil
+}
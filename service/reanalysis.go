@@ -0,0 +1,231 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	queues "anti-apt-backend/service/queue"
+	"anti-apt-backend/util"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func validateReanalyzeRequest(req *model.ReanalyzeRequest) error {
+	opts := model.AnalysisOptions{Platform: req.Os, Timeout: req.Timeout}
+	err := util.ValidateAnalysisOptions(&opts)
+	req.Os = opts.Platform
+	return err
+}
+
+func isTaskFinished(taskId int, taskType string) (bool, error) {
+	column := "id"
+	if taskType == extras.TASK_TYPE_URL {
+		column = "url_id"
+	}
+
+	var count int64
+	finishedRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = %d", extras.TaskFinishedTable, column, taskId)},
+		Result:       &count,
+	}
+	err := dao.GormOperations(&finishedRepo, config.Db, dao.EXEC)
+	return count > 0, err
+}
+
+func reanalysisEvent(taskId int, taskType string, sourceId int, req model.ReanalyzeRequest) *model.TaskEvent {
+	detail := fmt.Sprintf("reanalysis of task %d", sourceId)
+	if req.Os != extras.EMPTY_STRING {
+		detail += ", os " + req.Os
+	}
+	if req.Timeout > 0 {
+		detail += fmt.Sprintf(", timeout %ds", req.Timeout)
+	}
+	return &model.TaskEvent{
+		TaskId:    taskId,
+		TaskType:  taskType,
+		ToState:   extras.Queued,
+		Stage:     extras.TASK_STAGE_SUBMISSION,
+		Reason:    extras.TASK_REASON_REANALYSIS_REQUESTED,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+}
+
+// ReanalyzeTask submits a finished task again as a new task, linked to the
+// first submission of the sample. It skips the pre-filter and goes straight to
+// the sandbox, with the os and timeout of req when they are set.
+func ReanalyzeTask(taskId int, taskType string, req model.ReanalyzeRequest, submittedBy string) model.APIResponse {
+	if err := validateReanalyzeRequest(&req); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_REANALYSIS, err)
+	}
+
+	finished, err := isTaskFinished(taskId, taskType)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	if !finished {
+		return model.NewErrorResponse(http.StatusConflict, extras.ERR_TASK_NOT_FINISHED, extras.ErrTaskNotFinished)
+	}
+
+	if taskType == extras.TASK_TYPE_URL {
+		return reanalyzeUrl(taskId, req, submittedBy)
+	}
+	return reanalyzeFile(taskId, req, submittedBy)
+}
+
+func reanalyzeFile(taskId int, req model.ReanalyzeRequest, submittedBy string) model.APIResponse {
+	var fod model.FileOnDemand
+	if err := config.Db.Where("id = ?", taskId).First(&fod).Error; err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+	}
+
+	originalId := fod.Id
+	if fod.ReanalysisOf > 0 {
+		originalId = fod.ReanalysisOf
+	}
+	// the other sandbox settings of the original submission are kept
+	opts := analysisOptionsOf(fod)
+	if req.Os != extras.EMPTY_STRING {
+		opts.Platform = req.Os
+	}
+	if req.Timeout != 0 {
+		opts.Timeout = req.Timeout
+	}
+
+	newId, err := nextFileOnDemandId()
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	if err = queues.RestoreSample(fod.SHA256, newId); err != nil {
+		if errors.Is(err, extras.ErrSampleNotRetained) {
+			return model.NewErrorResponse(http.StatusGone, extras.ERR_SAMPLE_NOT_RETAINED, err)
+		}
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+
+	err = config.Db.Transaction(func(tx *gorm.DB) error {
+		queryString := fmt.Sprintf("INSERT INTO %s (id, file_name, content_type, submitted_time, submitted_by, comments, file_count, from_device, priority, md5, sha, sha256, parent_id, reanalysis_of, os_supported, analysis_timeout, vm_tag, route, package, options, ssdeep, ssdeep_block_size) VALUES (%d, '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', '%s', %d, %d, '%s', %d, '%s', '%s', '%s', '%s', '%s', %d)", extras.FileOnDemandTable, newId, util.EscapeSqlString(fod.FileName), fod.ContentType, time.Now().Format(extras.TIME_FORMAT), submittedBy, util.EscapeSqlString(fod.Comments), 1, false, extras.PRIORITY_MANUAL, fod.Md5, fod.SHA, fod.SHA256, 0, originalId, opts.Platform, opts.Timeout, opts.Tag, opts.Route, opts.Package, util.EscapeSqlString(opts.Options), fod.Ssdeep, fod.SsdeepBlockSize)
+		if err := tx.Exec(queryString).Error; err != nil {
+			return err
+		}
+		queryString = fmt.Sprintf("INSERT INTO %s (id, status, running_retry_count, sandbox_retry_count, version, md5, sha, sha256) VALUES (%d, '%s', %d, %d, %d, '%s', '%s', '%s')", extras.TaskLiveAnalysisTable, newId, extras.Queued, 0, 0, 0, fod.Md5, fod.SHA, fod.SHA256)
+		if err := tx.Exec(queryString).Error; err != nil {
+			return err
+		}
+		return tx.Create(reanalysisEvent(newId, extras.TASK_TYPE_FILE, taskId, req)).Error
+	})
+	if err != nil {
+		os.Remove(extras.SANDBOX_FILE_PATHS + strconv.Itoa(newId))
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, map[string]int{"task_id": newId, "reanalysis_of": originalId})
+}
+
+func reanalyzeUrl(taskId int, req model.ReanalyzeRequest, submittedBy string) model.APIResponse {
+	var uod model.UrlOnDemand
+	if err := config.Db.Where("id = ?", taskId).First(&uod).Error; err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+	}
+
+	originalId := uod.Id
+	if uod.ReanalysisOf > 0 {
+		originalId = uod.ReanalysisOf
+	}
+	if req.Os == extras.EMPTY_STRING {
+		req.Os = uod.OsSupported
+	}
+	if req.Timeout == 0 {
+		req.Timeout = uod.AnalysisTimeout
+	}
+
+	md5Hash, sha1Hash, sha256Hash := urlHashes(uod.UrlName)
+
+	var newId int
+	err := config.Db.Transaction(func(tx *gorm.DB) error {
+		queryString := fmt.Sprintf("INSERT INTO %s (url_name, submitted_time, submitted_by, comments, url_count, from_device, priority, reanalysis_of, os_supported, analysis_timeout) VALUES ('%s', '%s', '%s', '%s', %d, %t, '%s', %d, '%s', %d)", extras.UrlOnDemandTable, util.EscapeSqlString(uod.UrlName), time.Now().Format(extras.TIME_FORMAT), submittedBy, util.EscapeSqlString(uod.Comments), 1, false, extras.PRIORITY_MANUAL, originalId, req.Os, req.Timeout)
+		if err := tx.Exec(queryString).Error; err != nil {
+			return err
+		}
+		if err := tx.Raw("SELECT LAST_INSERT_ID()").Scan(&newId).Error; err != nil {
+			return err
+		}
+		queryString = fmt.Sprintf("INSERT INTO %s (url_id, status, running_retry_count, sandbox_retry_count, version, md5, sha, sha256) VALUES (%d, '%s', %d, %d, %d, '%s', '%s', '%s')", extras.TaskLiveAnalysisTable, newId, extras.Queued, 0, 0, 0, md5Hash, sha1Hash, sha256Hash)
+		if err := tx.Exec(queryString).Error; err != nil {
+			return err
+		}
+		return tx.Create(reanalysisEvent(newId, extras.TASK_TYPE_URL, taskId, req)).Error
+	})
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, map[string]int{"task_id": newId, "reanalysis_of": originalId})
+}
+
+// GetVerdictHistory lists every verdict given to the task's sample, from its
+// first analysis through reanalyses and overrides.
+func GetVerdictHistory(taskId int, taskType string) model.APIResponse {
+	var originalId int
+	if taskType == extras.TASK_TYPE_URL {
+		var uod model.UrlOnDemand
+		if err := config.Db.Where("id = ?", taskId).First(&uod).Error; err != nil {
+			return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+		}
+		originalId = uod.ReanalysisOf
+	} else {
+		var fod model.FileOnDemand
+		if err := config.Db.Where("id = ?", taskId).First(&fod).Error; err != nil {
+			return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+		}
+		originalId = fod.ReanalysisOf
+	}
+	if originalId == 0 {
+		originalId = taskId
+	}
+
+	history := []model.VerdictHistory{}
+	historyRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s WHERE task_type = '%s' AND original_id = %d ORDER BY id", extras.VerdictHistoryTable, taskType, originalId)},
+		Result:       &history,
+	}
+	if err := dao.GormOperations(&historyRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, history)
+}
+
+// saveOverrideHistory records an overridden verdict of the task in its
+// verdict history.
+func saveOverrideHistory(taskId int, taskType string, updateBy string) error {
+	table := extras.FileOnDemandTable
+	if taskType == extras.TASK_TYPE_URL {
+		table = extras.UrlOnDemandTable
+	}
+	queryString := fmt.Sprintf("INSERT INTO %s (task_id, task_type, original_id, rating, score, final_verdict, source, changed_by, created_at) SELECT id, '%s', IF(IFNULL(reanalysis_of, 0) > 0, reanalysis_of, id), rating, score, final_verdict, '%s', '%s', '%s' FROM %s WHERE id = %d", extras.VerdictHistoryTable, taskType, extras.VERDICT_SOURCE_OVERRIDE, updateBy, time.Now().Format(extras.TIME_FORMAT), table, taskId)
+	// not through dao, it would run the INSERT ... SELECT as a query
+	return config.Db.Exec(queryString).Error
+}
+
+func GetSampleRetention() model.APIResponse {
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, model.SampleRetention{Days: queues.GetSampleRetentionDays()})
+}
+
+// UpdateSampleRetention sets how many days finished samples are kept, 0 stops
+// keeping them and drops the retained ones at the next purge.
+func UpdateSampleRetention(retention model.SampleRetention) model.APIResponse {
+	if retention.Days < 0 || retention.Days > extras.MAX_SAMPLE_RETENTION_DAYS {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, extras.ErrRetentionDays)
+	}
+	if err := os.WriteFile(extras.SAMPLE_RETENTION_FILE_PATH, []byte(strconv.Itoa(retention.Days)), 0644); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, retention)
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/jung-kurt/gofpdf"
 	"github.com/levenlabs/golib/timeutil"
@@ -81,13 +82,48 @@ func GetReport(jobId string, actionType string) model.APIResponse {
 			final_verdict = fod.FinalVerdict
 		}
 
+		var stageResults []model.TaskStageResult
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE task_id = %d ORDER BY stage", extras.TaskStageResultTable, taskId)
+		stageResultsRepo := dao.DatabaseOperationsRepo{
+			QueryExecSet: []string{queryString},
+			Result:       &stageResults,
+		}
+		err = dao.GormOperations(&stageResultsRepo, db, dao.EXEC)
+		if err != nil {
+			// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING STAGE RESULTS, ERROR: %v", err))
+		}
+
+		var yaraMatches []model.TaskYaraMatch
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE task_id = %d ORDER BY id", extras.TaskYaraMatchTable, taskId)
+		yaraMatchesRepo := dao.DatabaseOperationsRepo{
+			QueryExecSet: []string{queryString},
+			Result:       &yaraMatches,
+		}
+		err = dao.GormOperations(&yaraMatchesRepo, db, dao.EXEC)
+		if err != nil {
+			// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING YARA MATCHES, ERROR: %v", err))
+		}
+
+		ratedBy := "WiJungle Anti-APT"
+		for _, result := range stageResults {
+			if result.Action == extras.STAGE_BLOCK || result.Action == extras.STAGE_ALLOW {
+				ratedBy = "WiJungle Anti-APT (" + result.Engine + ")"
+				vm = "VM not assigned(decided by pre-filter)"
+			}
+		}
+
+		vmScanTimeout := 100
+		if fod.AnalysisTimeout > 0 {
+			vmScanTimeout = fod.AnalysisTimeout
+		}
+
 		jobSummary := model.JobSummary{
 			JobID:         jobId,
 			Status:        "reported",
 			ReceivedTime:  fod.SubmittedTime.Format(extras.TIME_FORMAT),
-			RatedBy:       "WiJungle Anti-APT",
+			RatedBy:       ratedBy,
 			SubmitType:    submitType,
-			VmScanTimeout: 100,
+			VmScanTimeout: vmScanTimeout,
 			Rating:        string(fod.Rating),
 			FinalVerdict:  final_verdict,
 		}
@@ -110,9 +146,14 @@ func GetReport(jobId string, actionType string) model.APIResponse {
 		}
 
 		jobReport = model.JobInfo{
-			Summary:  jobSummary,
-			Details:  jobDetail,
-			Filename: fod.FileName,
+			Summary:          jobSummary,
+			Details:          jobDetail,
+			Filename:         fod.FileName,
+			StageResults:     stageResults,
+			YaraMatches:      yaraMatches,
+			Analysis:         fetchTaskAnalysis(taskId, extras.TASK_TYPE_FILE),
+			AttackTechniques: fetchAttackTechniques(taskId, extras.TASK_TYPE_FILE),
+			AnalysisOptions:  analysisOptionsOf(fod),
 		}
 	} else if actionType == "url" {
 
@@ -136,7 +177,7 @@ func GetReport(jobId string, actionType string) model.APIResponse {
 			// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING FOD, ERROR: %v", err))
 		}
 
-		if uod.Status != extras.REPORTED {
+		if uod.Rating == extras.EMPTY_STRING {
 			resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_STILL_ANALYSING, extras.ErrReportNotGenerated)
 			return resp
 		}
@@ -202,9 +243,11 @@ func GetReport(jobId string, actionType string) model.APIResponse {
 		}
 
 		urlJobReport = model.UrlJobInfo{
-			Summary: jobSummary,
-			Details: jobDetail,
-			Url:     uod.UrlName,
+			Summary:          jobSummary,
+			Details:          jobDetail,
+			Url:              uod.UrlName,
+			Analysis:         fetchTaskAnalysis(taskId, extras.TASK_TYPE_URL),
+			AttackTechniques: fetchAttackTechniques(taskId, extras.TASK_TYPE_URL),
 		}
 
 	} else {
@@ -213,14 +256,61 @@ func GetReport(jobId string, actionType string) model.APIResponse {
 	}
 
 	if actionType == "url" {
+		urlJobReport.Iocs = extractIocs(urlJobReport.Analysis)
 		resp = model.NewSuccessResponse(extras.ERR_SUCCESS, urlJobReport)
 		return resp
 	}
 
+	jobReport.Iocs = extractIocs(jobReport.Analysis)
 	resp = model.NewSuccessResponse(extras.ERR_SUCCESS, jobReport)
 	return resp
 }
 
+// fetchTaskAnalysis loads what was kept of the task's sandbox report.
+func fetchTaskAnalysis(taskId int, taskType string) model.TaskAnalysis {
+	var analysis model.TaskAnalysis
+
+	tables := []struct {
+		table  string
+		result interface{}
+	}{
+		{extras.TaskSignatureTable, &analysis.Signatures},
+		{extras.TaskNetworkTable, &analysis.NetworkIndicators},
+		{extras.TaskDroppedFileTable, &analysis.DroppedFiles},
+		{extras.TaskActivityTable, &analysis.Activities},
+		{extras.TaskProcessTable, &analysis.Processes},
+	}
+	for _, t := range tables {
+		queryString := fmt.Sprintf("SELECT * FROM %s WHERE task_id = %d AND task_type = '%s' ORDER BY id", t.table, taskId, taskType)
+		analysisRepo := dao.DatabaseOperationsRepo{
+			QueryExecSet: []string{queryString},
+			Result:       t.result,
+		}
+		err := dao.GormOperations(&analysisRepo, config.Db, dao.EXEC)
+		if err != nil {
+			// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING %s, ERROR: %v", t.table, err))
+		}
+	}
+
+	return analysis
+}
+
+// fetchAttackTechniques loads the ATT&CK techniques the task's detections
+// mapped to, grouped by tactic.
+func fetchAttackTechniques(taskId int, taskType string) []model.TaskAttackTechnique {
+	techniques := []model.TaskAttackTechnique{}
+	queryString := fmt.Sprintf("SELECT * FROM %s WHERE task_id = %d AND task_type = '%s' ORDER BY tactic_id, technique_id", extras.TaskAttackTable, taskId, taskType)
+	attackRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &techniques,
+	}
+	err := dao.GormOperations(&attackRepo, config.Db, dao.EXEC)
+	if err != nil {
+		// logger.LogAccToTaskId(taskId, fmt.Sprintf("ERROR WHILE FETCHING ATT&CK TECHNIQUES, ERROR: %v", err))
+	}
+	return techniques
+}
+
 func DownloadReport(jobId string, actionType string) model.APIResponse {
 
 	resp := GetReport(jobId, actionType)
@@ -340,6 +430,65 @@ func generatePDF(jobInfo model.JobInfo) error {
 		pdf.CellFormat(0, 8, fmt.Sprintf("%v", value.Interface()), "1", 1, "L", true, 0, "")
 	}
 
+	if jobInfo.AnalysisOptions != (model.AnalysisOptions{}) {
+		pdf.SetTextColor(0, 64, 128)
+		pdf.Ln(5)
+		pdf.SetFont("Times", "I", 14)
+
+		pdf.CellFormat(0, 8, "Analysis Options", "0", 0, "C", false, 0, "")
+		pdf.Ln(10)
+
+		pdf.SetFont("Times", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+
+		optionsType := reflect.TypeOf(jobInfo.AnalysisOptions)
+		for i := 0; i < optionsType.NumField(); i++ {
+			value := reflect.ValueOf(jobInfo.AnalysisOptions).Field(i)
+			if value.IsZero() {
+				continue
+			}
+			pdf.CellFormat(40, 8, optionsType.Field(i).Name+":", "1", 0, "L", true, 0, "")
+			pdf.CellFormat(0, 8, fmt.Sprintf("%v", value.Interface()), "1", 1, "L", true, 0, "")
+		}
+	}
+
+	if len(jobInfo.StageResults) > 0 {
+		pdf.SetTextColor(0, 64, 128)
+		pdf.Ln(5)
+		pdf.SetFont("Times", "I", 14)
+
+		pdf.CellFormat(0, 8, "Pre-filter", "0", 0, "C", false, 0, "")
+		pdf.Ln(10)
+
+		pdf.SetFont("Times", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+
+		for _, result := range jobInfo.StageResults {
+			pdf.CellFormat(40, 8, fmt.Sprintf("%d. %s:", result.Stage, result.Engine), "1", 0, "L", true, 0, "")
+			pdf.CellFormat(0, 8, fmt.Sprintf("%s, %s %s", result.Outcome, result.Action, result.Detail), "1", 1, "L", true, 0, "")
+		}
+	}
+
+	if len(jobInfo.YaraMatches) > 0 {
+		pdf.SetTextColor(0, 64, 128)
+		pdf.Ln(5)
+		pdf.SetFont("Times", "I", 14)
+
+		pdf.CellFormat(0, 8, "YARA Matches", "0", 0, "C", false, 0, "")
+		pdf.Ln(10)
+
+		pdf.SetFont("Times", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+
+		for _, match := range jobInfo.YaraMatches {
+			pdf.CellFormat(40, 8, fmt.Sprintf("%s v%d:", match.Ruleset, match.RulesetVersion), "1", 0, "L", true, 0, "")
+			pdf.MultiCell(0, 8, fmt.Sprintf("%s [%s] %s", match.Rule, match.Tags, match.Meta), "1", "L", true)
+		}
+	}
+
+	writeAnalysisToPDF(pdf, jobInfo.Analysis)
+	writeAttackTechniquesToPDF(pdf, jobInfo.AttackTechniques)
+
 	pdf.Ln(5)
 	pdf.SetFont("Times", "B", 12)
 
@@ -439,6 +588,9 @@ func generatePDFurl(jobInfo model.UrlJobInfo) error {
 		pdf.CellFormat(0, 8, fmt.Sprintf("%v", value.Interface()), "1", 1, "L", true, 0, "")
 	}
 
+	writeAnalysisToPDF(pdf, jobInfo.Analysis)
+	writeAttackTechniquesToPDF(pdf, jobInfo.AttackTechniques)
+
 	pdf.Ln(5)
 	pdf.SetFont("Times", "B", 12)
 
@@ -465,3 +617,62 @@ func generatePDFurl(jobInfo model.UrlJobInfo) error {
 
 	return nil
 }
+
+// writeAnalysisToPDF adds the signatures and network indicators of the sandbox
+// report, the rest is left to the json report.
+func writeAnalysisToPDF(pdf *gofpdf.Fpdf, analysis model.TaskAnalysis) {
+	if len(analysis.Signatures) > 0 {
+		pdf.SetTextColor(0, 64, 128)
+		pdf.Ln(5)
+		pdf.SetFont("Times", "I", 14)
+
+		pdf.CellFormat(0, 8, "Signatures", "0", 0, "C", false, 0, "")
+		pdf.Ln(10)
+
+		pdf.SetFont("Times", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+
+		for _, signature := range analysis.Signatures {
+			pdf.CellFormat(40, 8, fmt.Sprintf("Severity %d:", signature.Severity), "1", 0, "L", true, 0, "")
+			pdf.MultiCell(0, 8, fmt.Sprintf("%s - %s", signature.Name, signature.Description), "1", "L", true)
+		}
+	}
+
+	if len(analysis.NetworkIndicators) > 0 {
+		pdf.SetTextColor(0, 64, 128)
+		pdf.Ln(5)
+		pdf.SetFont("Times", "I", 14)
+
+		pdf.CellFormat(0, 8, "Network Indicators", "0", 0, "C", false, 0, "")
+		pdf.Ln(10)
+
+		pdf.SetFont("Times", "", 10)
+		pdf.SetTextColor(0, 0, 0)
+
+		for _, indicator := range analysis.NetworkIndicators {
+			pdf.CellFormat(40, 8, strings.ToUpper(indicator.Kind)+":", "1", 0, "L", true, 0, "")
+			pdf.MultiCell(0, 8, strings.TrimSpace(indicator.Value+" "+indicator.Detail), "1", "L", true)
+		}
+	}
+}
+
+func writeAttackTechniquesToPDF(pdf *gofpdf.Fpdf, techniques []model.TaskAttackTechnique) {
+	if len(techniques) == 0 {
+		return
+	}
+
+	pdf.SetTextColor(0, 64, 128)
+	pdf.Ln(5)
+	pdf.SetFont("Times", "I", 14)
+
+	pdf.CellFormat(0, 8, "ATT&CK Techniques", "0", 0, "C", false, 0, "")
+	pdf.Ln(10)
+
+	pdf.SetFont("Times", "", 10)
+	pdf.SetTextColor(0, 0, 0)
+
+	for _, technique := range techniques {
+		pdf.CellFormat(40, 8, technique.TechniqueId+":", "1", 0, "L", true, 0, "")
+		pdf.MultiCell(0, 8, fmt.Sprintf("%s (%s) - %s %s", technique.Technique, technique.Tactic, technique.Source, technique.Evidence), "1", "L", true)
+	}
+}
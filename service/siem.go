@@ -0,0 +1,129 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/siem"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// destination names end up in spool file names
+var siemNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func validateSiemDestination(destination *model.SiemDestination) error {
+	destination.Name = strings.TrimSpace(destination.Name)
+	destination.Host = strings.TrimSpace(destination.Host)
+	destination.Protocol = strings.ToLower(strings.TrimSpace(destination.Protocol))
+	destination.Format = strings.ToLower(strings.TrimSpace(destination.Format))
+
+	if !siemNameRegex.MatchString(destination.Name) {
+		return extras.ErrSiemDestinationName
+	}
+	if destination.Host == extras.EMPTY_STRING || destination.Port <= 0 || destination.Port > 65535 {
+		return extras.ErrSiemDestinationHost
+	}
+	if destination.Protocol == extras.EMPTY_STRING {
+		destination.Protocol = extras.SIEM_PROTOCOL_UDP
+	}
+	if !slices.Contains([]string{extras.SIEM_PROTOCOL_UDP, extras.SIEM_PROTOCOL_TCP, extras.SIEM_PROTOCOL_TLS}, destination.Protocol) {
+		return extras.ErrSiemProtocol
+	}
+	if destination.Format == extras.EMPTY_STRING {
+		destination.Format = extras.SIEM_FORMAT_CEF
+	}
+	if !slices.Contains([]string{extras.SIEM_FORMAT_CEF, extras.SIEM_FORMAT_LEEF, extras.SIEM_FORMAT_JSON}, destination.Format) {
+		return extras.ErrSiemFormat
+	}
+	return nil
+}
+
+func GetSiemConfig() model.APIResponse {
+	siemConfig, _ := config.ReadSiemConfig()
+	if siemConfig.Destinations == nil {
+		siemConfig.Destinations = []model.SiemDestination{}
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, siemConfig)
+}
+
+func UpdateSiemConfig(siemConfig model.SiemConfig) model.APIResponse {
+	var names []string
+	for i := range siemConfig.Destinations {
+		if err := validateSiemDestination(&siemConfig.Destinations[i]); err != nil {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_SIEM_DESTINATION, err)
+		}
+		if slices.Contains(names, siemConfig.Destinations[i].Name) {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_SIEM_DESTINATION, extras.ErrSiemDestinationName)
+		}
+		names = append(names, siemConfig.Destinations[i].Name)
+	}
+
+	if err := config.UpdateSiemConfig(siemConfig); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	siem.Reload(siemConfig)
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, siemConfig)
+}
+
+// TestSiemDestination sends a test event to destination, it does not have to
+// be saved first.
+func TestSiemDestination(destination model.SiemDestination) model.APIResponse {
+	if err := validateSiemDestination(&destination); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_SIEM_DESTINATION, err)
+	}
+	if err := siem.Test(destination); err != nil {
+		return model.NewErrorResponse(http.StatusBadGateway, extras.ERR_SIEM_TEST_FAILED, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, "Test event sent to "+destination.Name)
+}
+
+// ForwardLogin tells the SIEM about a login attempt on the web interface.
+func ForwardLogin(username string, clientIp string, success bool) {
+	event := siem.Event{
+		Category: extras.SIEM_CATEGORY_AUDIT,
+		Name:     "login",
+		Severity: 1,
+		Message:  "User " + username + " logged in",
+		Fields:   map[string]string{"user": username, "client_ip": clientIp},
+	}
+	if !success {
+		event.Name = "login_failed"
+		event.Severity = 5
+		event.Message = "Failed login for user " + username
+	}
+	siem.Forward(event)
+}
+
+var haEventNames = map[int]string{
+	1: "ha_configured",
+	2: "ha_disabled",
+	3: "ha_synced",
+}
+
+// ForwardHaEvent tells the SIEM how an HA request of requestType went.
+func ForwardHaEvent(requestType int, resp model.APIResponse) {
+	name, ok := haEventNames[requestType]
+	if !ok {
+		return
+	}
+
+	event := siem.Event{
+		Category: extras.SIEM_CATEGORY_HA,
+		Name:     name,
+		Severity: 4,
+		Message:  fmt.Sprintf("%v", resp.Data),
+	}
+	if resp.StatusCode != http.StatusOK {
+		event.Name = name + "_failed"
+		event.Severity = 7
+		event.Message = resp.Message
+		if resp.Error != extras.EMPTY_STRING {
+			event.Message += ": " + resp.Error
+		}
+	}
+	siem.Forward(event)
+}
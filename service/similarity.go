@@ -0,0 +1,200 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/hash"
+	"anti-apt-backend/model"
+	"anti-apt-backend/verdictcache"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// fuzzyHashOf sets the ssdeep digest of the file at fp on fod, a file that
+// cannot be hashed is left out of similarity searches.
+func fuzzyHashOf(fod *model.FileOnDemand, fp string) {
+	fuzzyHash, err := hash.CalculateFuzzyHash(fp)
+	if err != nil {
+		// slog.Println("ERROR WHILE FUZZY HASHING: ", err)
+		return
+	}
+	blockSize, _, _, err := hash.ParseFuzzyHash(fuzzyHash)
+	if err != nil {
+		return
+	}
+	fod.Ssdeep = fuzzyHash
+	fod.SsdeepBlockSize = blockSize
+}
+
+func similarityConfig() model.SimilarityConfig {
+	similarityConfig, err := config.ReadSimilarityConfig()
+	if err != nil {
+		return model.SimilarityConfig{Threshold: extras.DEFAULT_SIMILARITY_THRESHOLD}
+	}
+	return similarityConfig
+}
+
+// similarSamples scores the newest samples sharing a piece of fuzzyHash and
+// returns the ones similar at all, most similar first and one per file. Only
+// samples found malicious are scored when maliciousOnly is set.
+func similarSamples(fuzzyHash string, excludeId int, maliciousOnly bool, limit int) ([]model.SimilarSample, error) {
+	candidates, err := dao.FuzzyHashCandidates(fuzzyHash, excludeId, maliciousOnly, extras.MAX_SIMILARITY_CANDIDATES)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	similar := []model.SimilarSample{}
+	for _, candidate := range candidates {
+		if seen[candidate.SHA256] {
+			continue
+		}
+		seen[candidate.SHA256] = true
+
+		if candidate.Similarity = hash.CompareFuzzyHashes(fuzzyHash, candidate.Ssdeep); candidate.Similarity > 0 {
+			similar = append(similar, candidate)
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Similarity > similar[j].Similarity
+	})
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+// provisionalVerdict blocks fod ahead of its analysis when it is similar
+// enough to a sample found malicious. The analysis still runs and gives the
+// final verdict.
+func provisionalVerdict(fod *model.FileOnDemand) {
+	threshold := similarityConfig().Threshold
+	if threshold <= 0 || fod.Ssdeep == extras.EMPTY_STRING {
+		return
+	}
+
+	similar, err := similarSamples(fod.Ssdeep, fod.Id, true, 1)
+	if err != nil || len(similar) == 0 || similar[0].Similarity < threshold {
+		return
+	}
+	fod.ProvisionalVerdict = extras.BLOCK
+	fod.SimilarTo = similar[0].TaskId
+	fod.Similarity = similar[0].Similarity
+}
+
+// scoreProvisionalVerdict indexes the fuzzy hash of the queued fod and looks
+// for its provisional verdict away from the upload, fod is sent back on the
+// channel once it is known.
+func scoreProvisionalVerdict(fod model.FileOnDemand) <-chan model.FileOnDemand {
+	scored := make(chan model.FileOnDemand, 1)
+	go func() {
+		defer func() { scored <- fod }()
+		if fod.Ssdeep == extras.EMPTY_STRING {
+			return
+		}
+		if err := dao.IndexFuzzyHash(fod.Id, fod.Ssdeep); err != nil {
+			// slog.Println("ERROR WHILE INDEXING FUZZY HASH: ", err)
+		}
+
+		provisionalVerdict(&fod)
+		if fod.ProvisionalVerdict != extras.BLOCK {
+			return
+		}
+		// a file analysed meanwhile has its verdict already
+		queryString := fmt.Sprintf("UPDATE %s SET provisional_verdict = '%s', similar_to = %d, similarity = %d WHERE id = %d AND finished_time IS NULL", extras.FileOnDemandTable, fod.ProvisionalVerdict, fod.SimilarTo, fod.Similarity, fod.Id)
+		if result := config.Db.Exec(queryString); result.Error != nil || result.RowsAffected == 0 {
+			fod.ProvisionalVerdict = extras.EMPTY_STRING
+			return
+		}
+		cacheProvisionalVerdict(fod)
+	}()
+	return scored
+}
+
+// awaitProvisionalVerdict returns fod with its provisional verdict, or as it
+// is when the search takes longer than an upload waits.
+func awaitProvisionalVerdict(fod model.FileOnDemand) model.FileOnDemand {
+	select {
+	case scored := <-scoreProvisionalVerdict(fod):
+		return scored
+	case <-time.After(extras.PROVISIONAL_VERDICT_WAIT):
+		return fod
+	}
+}
+
+// cacheProvisionalVerdict answers lookups of the queued fod with its
+// provisional verdict until the analysis replaces it.
+func cacheProvisionalVerdict(fod model.FileOnDemand) {
+	if fod.ProvisionalVerdict != extras.BLOCK {
+		return
+	}
+	entry := verdictcache.Entry{
+		Verdict:   extras.BLOCK,
+		Rating:    string(model.Critical),
+		TaskId:    fod.Id,
+		FirstSeen: fod.SubmittedTime,
+		LastSeen:  fod.SubmittedTime,
+	}
+	verdictcache.PutProvisional(entry, fod.Md5, fod.SHA, fod.SHA256)
+}
+
+func provisionalMessage(fod model.FileOnDemand) string {
+	if fod.ProvisionalVerdict != extras.BLOCK {
+		return extras.EMPTY_STRING
+	}
+	return fmt.Sprintf(", provisionally blocked as %d%% similar to task %d", fod.Similarity, fod.SimilarTo)
+}
+
+// SearchSimilar lists the samples most similar to the file of task jobId.
+func SearchSimilar(jobId int, limit int) model.APIResponse {
+	if limit <= 0 || limit > extras.MAX_SIMILAR_RESULTS {
+		limit = extras.DEFAULT_SIMILAR_RESULTS
+	}
+
+	var fod model.FileOnDemand
+	if err := config.Db.Where("id = ?", jobId).First(&fod).Error; err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+	}
+	if fod.Ssdeep == extras.EMPTY_STRING {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_NO_FUZZY_HASH, extras.ErrNoFuzzyHash)
+	}
+
+	similar, err := similarSamples(fod.Ssdeep, fod.Id, false, limit)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, similar)
+}
+
+func GetSimilarityConfig() model.APIResponse {
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, similarityConfig())
+}
+
+func UpdateSimilarityConfig(similarityConfig model.SimilarityConfig) model.APIResponse {
+	if similarityConfig.Threshold < 0 || similarityConfig.Threshold > 100 {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_SIMILARITY_CONFIG, extras.ErrSimilarityThreshold)
+	}
+
+	if err := config.UpdateSimilarityConfig(similarityConfig); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, similarityConfig)
+}
+
+// InitFuzzyHashIndex indexes the fuzzy hashes of the files submitted before
+// the similarity index existed.
+func InitFuzzyHashIndex() {
+	lastId := 0
+	for {
+		indexedTo, err := dao.IndexFuzzyHashes(lastId, extras.FUZZY_HASH_INDEX_BATCH)
+		if err != nil || indexedTo == lastId {
+			// slog.Println("ERROR WHILE INDEXING FUZZY HASHES: ", err)
+			return
+		}
+		lastId = indexedTo
+	}
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	queues "anti-apt-backend/service/queue"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	STIX_SPEC_VERSION = "2.1"
+	STIX_TIME_FORMAT  = "2006-01-02T15:04:05.000Z"
+)
+
+// ids of cyber observables are derived from their values under this namespace,
+// so the same domain gets the same id in every bundle
+var stixObservableNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+
+var stixIdentityId = "identity--" + uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://wijungle.com/anti-apt")).String()
+
+func stixTime(value string) string {
+	t, err := time.ParseInLocation(extras.TIME_FORMAT, value, time.Local)
+	if err != nil {
+		t = time.Now()
+	}
+	return t.UTC().Format(STIX_TIME_FORMAT)
+}
+
+// stixObservableId returns the id of a cyber observable from the properties
+// STIX names as its id contributing properties.
+func stixObservableId(objectType string, properties map[string]any) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(properties)
+	return objectType + "--" + uuid.NewSHA1(stixObservableNamespace, bytes.TrimSpace(buf.Bytes())).String()
+}
+
+// a file's id is derived from one of its hashes, the first present in the
+// order STIX gives
+var stixIdHashOrder = []string{"MD5", "SHA-1", "SHA-256", "SHA-512"}
+
+func stixIdHash(hashes map[string]string) (string, string) {
+	for _, algorithm := range stixIdHashOrder {
+		if value := hashes[algorithm]; value != extras.EMPTY_STRING {
+			return algorithm, value
+		}
+	}
+	return extras.EMPTY_STRING, extras.EMPTY_STRING
+}
+
+// stixString quotes a value for a STIX pattern.
+func stixString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// iocToStix returns the cyber observable of ioc and the pattern matching it.
+func iocToStix(ioc model.Ioc) (model.StixObject, string) {
+	switch ioc.Type {
+	case extras.IOC_DOMAIN:
+		return model.StixObject{Type: "domain-name", Value: ioc.Value}, "[domain-name:value = " + stixString(ioc.Value) + "]"
+	case extras.IOC_IPV4:
+		return model.StixObject{Type: "ipv4-addr", Value: ioc.Value}, "[ipv4-addr:value = " + stixString(ioc.Value) + "]"
+	case extras.IOC_IPV6:
+		return model.StixObject{Type: "ipv6-addr", Value: ioc.Value}, "[ipv6-addr:value = " + stixString(ioc.Value) + "]"
+	case extras.IOC_URL:
+		return model.StixObject{Type: "url", Value: ioc.Value}, "[url:value = " + stixString(ioc.Value) + "]"
+	case extras.IOC_SHA256:
+		return model.StixObject{Type: "file", Name: ioc.Context, Hashes: map[string]string{"SHA-256": ioc.Value}}, "[file:hashes.'SHA-256' = " + stixString(ioc.Value) + "]"
+	case extras.IOC_MUTEX:
+		return model.StixObject{Type: "mutex", Name: ioc.Value}, "[mutex:name = " + stixString(ioc.Value) + "]"
+	}
+	return model.StixObject{}, extras.EMPTY_STRING
+}
+
+// stixBundleBuilder keeps the objects of one bundle, the observables once each.
+type stixBundleBuilder struct {
+	created string
+	objects []model.StixObject
+	seen    map[string]bool
+}
+
+func (b *stixBundleBuilder) sdo(objectType string) model.StixObject {
+	return model.StixObject{
+		Type:         objectType,
+		SpecVersion:  STIX_SPEC_VERSION,
+		Id:           objectType + "--" + uuid.NewString(),
+		CreatedByRef: stixIdentityId,
+		Created:      b.created,
+		Modified:     b.created,
+	}
+}
+
+func (b *stixBundleBuilder) observable(object model.StixObject) string {
+	object.SpecVersion = STIX_SPEC_VERSION
+	if algorithm, value := stixIdHash(object.Hashes); value != extras.EMPTY_STRING {
+		object.Id = stixObservableId(object.Type, map[string]any{"hashes": map[string]string{algorithm: value}})
+	} else if object.Type == "mutex" || object.Type == "file" {
+		object.Id = stixObservableId(object.Type, map[string]any{"name": object.Name})
+	} else {
+		object.Id = stixObservableId(object.Type, map[string]any{"value": object.Value})
+	}
+	if !b.seen[object.Id] {
+		b.seen[object.Id] = true
+		b.objects = append(b.objects, object)
+	}
+	return object.Id
+}
+
+func (b *stixBundleBuilder) relate(source string, relationshipType string, target string) {
+	relationship := b.sdo("relationship")
+	relationship.RelationshipType = relationshipType
+	relationship.SourceRef = source
+	relationship.TargetRef = target
+	b.objects = append(b.objects, relationship)
+}
+
+// stixJob is the part of a file or url report the bundle is built from.
+type stixJob struct {
+	summary    model.JobSummary
+	endTime    string
+	name       string
+	sample     model.StixObject
+	detections []string
+	iocs       []model.Ioc
+}
+
+// buildStixBundle writes the sample and the indicators of its analysis as
+// observed data. A blocked sample also gets a malware object, with an
+// indicator for the sample and for each ioc pointing at it.
+func buildStixBundle(job stixJob) model.StixBundle {
+	b := &stixBundleBuilder{created: time.Now().UTC().Format(STIX_TIME_FORMAT), seen: make(map[string]bool)}
+
+	identity := model.StixObject{
+		Type:          "identity",
+		SpecVersion:   STIX_SPEC_VERSION,
+		Id:            stixIdentityId,
+		Created:       b.created,
+		Modified:      b.created,
+		Name:          "WiJungle Anti-APT",
+		IdentityClass: "system",
+	}
+	b.objects = append(b.objects, identity)
+
+	for algorithm, hash := range job.sample.Hashes {
+		if hash == extras.EMPTY_STRING {
+			delete(job.sample.Hashes, algorithm)
+		}
+	}
+	sampleId := b.observable(job.sample)
+	observedRefs := []string{sampleId}
+	type stixIndicator struct {
+		name, pattern string
+	}
+	var indicators []stixIndicator
+	for _, ioc := range job.iocs {
+		object, pattern := iocToStix(ioc)
+		if pattern == extras.EMPTY_STRING {
+			continue
+		}
+		observedRefs = append(observedRefs, b.observable(object))
+		indicators = append(indicators, stixIndicator{name: fmt.Sprintf("%s %s", ioc.Type, ioc.Value), pattern: pattern})
+	}
+
+	observed := b.sdo("observed-data")
+	observed.FirstObserved = stixTime(job.summary.ReceivedTime)
+	observed.LastObserved = stixTime(job.endTime)
+	observed.NumberObserved = 1
+	observed.ObjectRefs = observedRefs
+	b.objects = append(b.objects, observed)
+
+	if strings.HasPrefix(job.summary.FinalVerdict, extras.BLOCK) {
+		isFamily := false
+		malware := b.sdo("malware")
+		malware.Name = job.name
+		malware.IsFamily = &isFamily
+		malware.Description = fmt.Sprintf("Rated %s by WiJungle Anti-APT.", job.summary.Rating)
+		if len(job.detections) > 0 {
+			malware.Description += " Detected as " + strings.Join(job.detections, ", ") + "."
+		}
+		if job.sample.Type == "file" {
+			malware.SampleRefs = []string{sampleId}
+		}
+		b.objects = append(b.objects, malware)
+		b.relate(observed.Id, "related-to", malware.Id)
+
+		_, samplePattern := iocToStix(model.Ioc{Type: extras.IOC_SHA256, Value: job.sample.Hashes["SHA-256"]})
+		if job.sample.Type == "url" {
+			_, samplePattern = iocToStix(model.Ioc{Type: extras.IOC_URL, Value: job.sample.Value})
+		}
+		indicators = append([]stixIndicator{{name: job.name, pattern: samplePattern}}, indicators...)
+
+		validFrom := stixTime(job.endTime)
+		for _, i := range indicators {
+			indicator := b.sdo("indicator")
+			indicator.Name = i.name
+			indicator.IndicatorTypes = []string{"malicious-activity"}
+			indicator.Pattern = i.pattern
+			indicator.PatternType = "stix"
+			indicator.ValidFrom = validFrom
+			b.objects = append(b.objects, indicator)
+			b.relate(indicator.Id, "indicates", malware.Id)
+		}
+	}
+
+	return model.StixBundle{Type: "bundle", Id: "bundle--" + uuid.NewString(), Objects: b.objects}
+}
+
+// GetStixBundle exports a finished job as a STIX 2.1 bundle.
+func GetStixBundle(jobId string, actionType string) model.APIResponse {
+	resp := GetReport(jobId, actionType)
+	if resp.StatusCode != http.StatusOK {
+		return resp
+	}
+
+	var job stixJob
+	switch jobInfo := resp.Data.(type) {
+	case model.JobInfo:
+		job = stixJob{
+			summary: jobInfo.Summary,
+			endTime: jobInfo.Details.ScanEndTime,
+			name:    jobInfo.Filename,
+			sample: model.StixObject{
+				Type: "file",
+				Name: jobInfo.Filename,
+				Hashes: map[string]string{
+					"MD5":     jobInfo.Details.MD5,
+					"SHA-1":   jobInfo.Details.SHA1,
+					"SHA-256": jobInfo.Details.SHA256,
+				},
+			},
+			iocs: jobInfo.Iocs,
+		}
+		for _, result := range jobInfo.StageResults {
+			if result.Engine == queues.ENGINE_CLAMD && result.Outcome == extras.STAGE_DETECTED && result.Detail != extras.EMPTY_STRING {
+				job.detections = append(job.detections, result.Detail)
+			}
+		}
+		for _, match := range jobInfo.YaraMatches {
+			job.detections = append(job.detections, match.Ruleset+":"+match.Rule)
+		}
+	case model.UrlJobInfo:
+		job = stixJob{
+			summary: jobInfo.Summary,
+			endTime: jobInfo.Details.ScanEndTime,
+			name:    jobInfo.Url,
+			sample:  model.StixObject{Type: "url", Value: jobInfo.Url},
+			iocs:    jobInfo.Iocs,
+		}
+	default:
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_STILL_ANALYSING, extras.ErrReportNotGenerated)
+	}
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, buildStixBundle(job))
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"anti-apt-backend/model"
+	"testing"
+)
+
+func TestStixFileIdFollowsHashOrder(t *testing.T) {
+	const (
+		md5    = "44d88612fea8a8f36de82e1278abb02f"
+		sha1   = "3395856ce81f2b7382dee72602f798b642f14140"
+		sha256 = "275a021bbfb6489e54d471899f7db9d1663fc695ec2fe2a2c4538aabf651fd0f"
+	)
+	b := &stixBundleBuilder{seen: make(map[string]bool)}
+
+	id := b.observable(model.StixObject{Type: "file", Hashes: map[string]string{"SHA-256": sha256, "SHA-1": sha1, "MD5": md5}})
+	if want := stixObservableId("file", map[string]any{"hashes": map[string]string{"MD5": md5}}); id != want {
+		t.Errorf("file with every hash: got %s, want the id of its MD5 %s", id, want)
+	}
+	if other := b.observable(model.StixObject{Type: "file", Name: "other.exe", Hashes: map[string]string{"MD5": md5}}); other != id {
+		t.Errorf("same MD5 got another id: %s and %s", other, id)
+	}
+
+	id = b.observable(model.StixObject{Type: "file", Hashes: map[string]string{"MD5": "", "SHA-256": sha256}})
+	if want := stixObservableId("file", map[string]any{"hashes": map[string]string{"SHA-256": sha256}}); id != want {
+		t.Errorf("file without MD5 or SHA-1: got %s, want the id of its SHA-256 %s", id, want)
+	}
+	if len(b.objects) != 2 {
+		t.Errorf("got %d observables, want 2", len(b.objects))
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/feeds"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var threatFeedNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func GetThreatFeeds() model.APIResponse {
+	threatFeeds := []model.ThreatFeed{}
+	threatFeedRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s ORDER BY name", extras.ThreatFeedTable)},
+		Result:       &threatFeeds,
+	}
+	if err := dao.GormOperations(&threatFeedRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, threatFeeds)
+}
+
+func validFeedConfidence(confidence int) bool {
+	return confidence >= 0 && confidence <= 100
+}
+
+func validFeedExpiry(expiryDays int) bool {
+	return expiryDays >= 0 && expiryDays <= extras.MAX_FEED_EXPIRY_DAYS
+}
+
+// applyThreatFeedRequest validates req and copies it onto threatFeed. The api
+// key is only replaced when one is given.
+func applyThreatFeedRequest(threatFeed *model.ThreatFeed, req model.ThreatFeedRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if !threatFeedNameRegex.MatchString(req.Name) {
+		return extras.ErrThreatFeedName
+	}
+
+	u, err := url.Parse(strings.TrimSpace(req.Url))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == extras.EMPTY_STRING {
+		return extras.ErrThreatFeedUrl
+	}
+
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	if !slices.Contains(extras.FEED_FORMATS, req.Format) {
+		return extras.ErrThreatFeedFormat
+	}
+
+	if req.IntervalMinutes == 0 {
+		req.IntervalMinutes = extras.DEFAULT_FEED_INTERVAL_MINUTES
+	}
+	if req.IntervalMinutes < extras.MIN_FEED_INTERVAL_MINUTES || req.IntervalMinutes > extras.MAX_FEED_INTERVAL_MINUTES {
+		return extras.ErrThreatFeedInterval
+	}
+	if req.Confidence != nil {
+		if !validFeedConfidence(*req.Confidence) {
+			return extras.ErrThreatFeedConfidence
+		}
+		threatFeed.Confidence = *req.Confidence
+	}
+	if req.ExpiryDays != nil {
+		if !validFeedExpiry(*req.ExpiryDays) {
+			return extras.ErrThreatFeedExpiry
+		}
+		threatFeed.ExpiryDays = *req.ExpiryDays
+	}
+
+	threatFeed.Name = req.Name
+	threatFeed.Url = u.String()
+	threatFeed.Format = req.Format
+	threatFeed.IntervalMinutes = req.IntervalMinutes
+	threatFeed.SkipTlsVerify = req.SkipTlsVerify
+	if req.Enabled != nil {
+		threatFeed.Enabled = *req.Enabled
+	}
+	if req.ApiKey != extras.EMPTY_STRING {
+		threatFeed.ApiKey = req.ApiKey
+	}
+	return nil
+}
+
+func CreateThreatFeed(req model.ThreatFeedRequest, curUsr string) model.APIResponse {
+	threatFeed := model.ThreatFeed{
+		Enabled:    true,
+		Confidence: extras.DEFAULT_FEED_CONFIDENCE,
+		ExpiryDays: extras.DEFAULT_FEED_EXPIRY_DAYS,
+		CreatedBy:  curUsr,
+		CreatedAt:  time.Now(),
+	}
+	if err := applyThreatFeedRequest(&threatFeed, req); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, err)
+	}
+
+	if err := config.Db.Create(&threatFeed).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, threatFeed)
+}
+
+func fetchThreatFeed(id int) (model.ThreatFeed, error) {
+	var threatFeed model.ThreatFeed
+	if err := config.Db.Where("id = ?", id).First(&threatFeed).Error; err != nil {
+		return threatFeed, extras.ErrThreatFeedNotFound
+	}
+	return threatFeed, nil
+}
+
+func UpdateThreatFeed(id int, req model.ThreatFeedRequest) model.APIResponse {
+	threatFeed, err := fetchThreatFeed(id)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_THREAT_FEED_NOT_FOUND, err)
+	}
+	if err = applyThreatFeedRequest(&threatFeed, req); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, err)
+	}
+
+	if err = config.Db.Save(&threatFeed).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, threatFeed)
+}
+
+// DeleteThreatFeed stops pulling the feed, the hashes it brought stay until
+// they expire and its runs stay in the log.
+func DeleteThreatFeed(id int) model.APIResponse {
+	result := config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %d", extras.ThreatFeedTable, id))
+	if result.Error != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_THREAT_FEED_NOT_FOUND, extras.ErrThreatFeedNotFound)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Threat feed %d deleted", id))
+}
+
+// RunThreatFeed pulls the feed now and waits for the run to finish.
+func RunThreatFeed(id int, curUsr string) model.APIResponse {
+	threatFeed, err := fetchThreatFeed(id)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_THREAT_FEED_NOT_FOUND, err)
+	}
+
+	run, err := feeds.Pull(threatFeed, extras.FEED_TRIGGER_MANUAL, curUsr)
+	if err == extras.ErrThreatFeedRunning {
+		return model.NewErrorResponse(http.StatusConflict, extras.ERR_THREAT_FEED_RUN_FAILED, err)
+	}
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadGateway, extras.ERR_THREAT_FEED_RUN_FAILED, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, run)
+}
+
+// UploadThreatFeed stores the hashes of a feed file under source.
+func UploadThreatFeed(data []byte, format string, source string, confidence int, expiryDays int, curUsr string) model.APIResponse {
+	if !slices.Contains(extras.FEED_FORMATS, format) {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, extras.ErrThreatFeedFormat)
+	}
+	if !validFeedConfidence(confidence) {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, extras.ErrThreatFeedConfidence)
+	}
+	if !validFeedExpiry(expiryDays) {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_THREAT_FEED, extras.ErrThreatFeedExpiry)
+	}
+	if source == extras.EMPTY_STRING {
+		source = extras.FEED_SOURCE_UPLOAD
+	}
+
+	run, err := feeds.Upload(data, format, source, confidence, expiryDays, curUsr)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_THREAT_FEED_RUN_FAILED, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, run)
+}
+
+// GetThreatFeedRuns is the run log, newest first, of one feed when feedId is
+// given.
+func GetThreatFeedRuns(feedId int) model.APIResponse {
+	queryString := fmt.Sprintf("SELECT * FROM %s ORDER BY id DESC LIMIT 500", extras.ThreatFeedRunTable)
+	if feedId > 0 {
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE feed_id = %d ORDER BY id DESC LIMIT 500", extras.ThreatFeedRunTable, feedId)
+	}
+
+	runs := []model.ThreatFeedRun{}
+	runRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &runs,
+	}
+	if err := dao.GormOperations(&runRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, runs)
+}
+
+// GetFileHashes lists the newest stored malware hashes, of one source when
+// source is given.
+func GetFileHashes(source string) model.APIResponse {
+	queryString := fmt.Sprintf("SELECT * FROM %s ORDER BY updated_at DESC LIMIT 500", dao.FileHashesTable)
+	if source != extras.EMPTY_STRING {
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE source = '%s' ORDER BY updated_at DESC LIMIT 500", dao.FileHashesTable, util.EscapeSqlString(source))
+	}
+
+	fileHashes := []model.FileHashes{}
+	fileHashRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &fileHashes,
+	}
+	if err := dao.GormOperations(&fileHashRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fileHashes)
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"fmt"
+	"net/http"
+)
+
+// GetTaskTimeline returns the state transitions of a task, like why it was
+// aborted. A task still in the queue shows its live status.
+func GetTaskTimeline(taskId int, taskType string) model.APIResponse {
+	timeline := model.TaskTimeline{
+		TaskId:   taskId,
+		TaskType: taskType,
+		Events:   []model.TaskEvent{},
+	}
+
+	liveColumn := "id"
+	if taskType == extras.TASK_TYPE_URL {
+		var uod model.UrlOnDemand
+		if err := config.Db.Where("id = ?", taskId).First(&uod).Error; err != nil {
+			return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+		}
+		timeline.Name = uod.UrlName
+		timeline.Status = uod.Status
+		timeline.SubmittedTime = uod.SubmittedTime
+		liveColumn = "url_id"
+	} else {
+		var fod model.FileOnDemand
+		if err := config.Db.Where("id = ?", taskId).First(&fod).Error; err != nil {
+			return model.NewErrorResponse(http.StatusNotFound, extras.ERR_RECORD_NOT_FOUND, extras.ErrTaskNotFound)
+		}
+		timeline.Name = fod.FileName
+		timeline.Status = fod.Status
+		timeline.SubmittedTime = fod.SubmittedTime
+	}
+
+	if timeline.Status == extras.EMPTY_STRING {
+		var live model.TaskLiveAnalysisTable
+		if err := config.Db.Where(liveColumn+" = ?", taskId).First(&live).Error; err == nil {
+			timeline.Status = live.Status
+		}
+	}
+
+	eventRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s WHERE task_id = %d AND task_type = '%s' ORDER BY id", extras.TaskEventTable, taskId, taskType)},
+		Result:       &timeline.Events,
+	}
+	if err := dao.GormOperations(&eventRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, timeline)
+}
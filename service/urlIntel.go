@@ -0,0 +1,350 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rows upserted per statement on import
+const URL_INTEL_IMPORT_BATCH = 500
+
+var urlIntelTypes = []string{extras.URL_INTEL_URL, extras.URL_INTEL_PATH, extras.URL_INTEL_HOST, extras.URL_INTEL_DOMAIN, extras.URL_INTEL_IP}
+
+var urlIntelCsvHeader = []string{"value", "type", "list", "source", "expires_at"}
+
+type UrlIntelImportResult struct {
+	Imported int      `json:"imported"`
+	Rejected []string `json:"rejected"`
+}
+
+func fetchUrlIntel(list string, intelType string) ([]model.UrlIntel, error) {
+	var conditions []string
+	if list != extras.EMPTY_STRING {
+		conditions = append(conditions, fmt.Sprintf("list = '%s'", util.EscapeSqlString(list)))
+	}
+	if intelType != extras.EMPTY_STRING {
+		conditions = append(conditions, fmt.Sprintf("type = '%s'", util.EscapeSqlString(intelType)))
+	}
+
+	queryString := fmt.Sprintf("SELECT * FROM %s ORDER BY id", extras.UrlIntelTable)
+	if len(conditions) > 0 {
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY id", extras.UrlIntelTable, strings.Join(conditions, " AND "))
+	}
+
+	var entries []model.UrlIntel
+	urlIntel := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &entries,
+	}
+	err := dao.GormOperations(&urlIntel, config.Db, dao.EXEC)
+	return entries, err
+}
+
+func GetUrlIntel(list string, intelType string) model.APIResponse {
+	entries, err := fetchUrlIntel(list, intelType)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	if entries == nil {
+		entries = []model.UrlIntel{}
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entries)
+}
+
+// ImportUrlIntel upserts the rows of a csv with the columns value, type, list,
+// source and expires_at. Only value is required, list and source default to
+// the ones given and a missing type is guessed from the value.
+func ImportUrlIntel(r io.Reader, list string, source string) model.APIResponse {
+	if list == extras.EMPTY_STRING {
+		list = extras.BLOCK
+	}
+	if list != extras.ALLOW && list != extras.BLOCK {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_URL_INTEL_ENTRY, extras.ErrUrlIntelList)
+	}
+	if source == extras.EMPTY_STRING {
+		source = extras.URL_INTEL_SOURCE_MANUAL
+	}
+
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_CLIENT_SIDE, err)
+	}
+
+	result := UrlIntelImportResult{Rejected: []string{}}
+	var entries []model.UrlIntel
+	for i, row := range rows {
+		if len(row) == 0 || (i == 0 && strings.EqualFold(row[0], urlIntelCsvHeader[0])) {
+			continue
+		}
+		entry, err := urlIntelFromRow(row, list, source)
+		if err != nil {
+			result.Rejected = append(result.Rejected, fmt.Sprintf("line %d: %v", i+1, err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = saveUrlIntel(entries); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	result.Imported = len(entries)
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, result)
+}
+
+func urlIntelFromRow(row []string, list string, source string) (model.UrlIntel, error) {
+	column := func(i int) string {
+		if i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return extras.EMPTY_STRING
+	}
+
+	entry := model.UrlIntel{
+		Type:      strings.ToLower(column(1)),
+		List:      strings.ToLower(column(2)),
+		Source:    column(3),
+		CreatedAt: time.Now(),
+	}
+	if entry.Type == extras.EMPTY_STRING {
+		entry.Type = util.GuessUrlIntelType(column(0))
+	}
+	if !slices.Contains(urlIntelTypes, entry.Type) {
+		return entry, extras.ErrUrlIntelType
+	}
+	if entry.List == extras.EMPTY_STRING {
+		entry.List = list
+	}
+	if entry.List != extras.ALLOW && entry.List != extras.BLOCK {
+		return entry, extras.ErrUrlIntelList
+	}
+	if entry.Source == extras.EMPTY_STRING {
+		entry.Source = source
+	}
+
+	var err error
+	if entry.Value, err = util.NormalizeUrlIntelValue(entry.Type, column(0)); err != nil {
+		return entry, err
+	}
+
+	if expiresAt := column(4); expiresAt != extras.EMPTY_STRING {
+		t, err := parseUrlIntelExpiry(expiresAt)
+		if err != nil {
+			return entry, err
+		}
+		entry.ExpiresAt = sql.NullTime{Time: t, Valid: true}
+	}
+	return entry, nil
+}
+
+func parseUrlIntelExpiry(value string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{time.RFC3339, extras.TIME_FORMAT, "2006-01-02"} {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// saveUrlIntel inserts entries, an entry already present for the same type and
+// value takes the list, source and expiry of the new one.
+func saveUrlIntel(entries []model.UrlIntel) error {
+	return config.Db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(entries); start += URL_INTEL_IMPORT_BATCH {
+			batch := entries[start:min(start+URL_INTEL_IMPORT_BATCH, len(entries))]
+
+			var values []string
+			for _, entry := range batch {
+				expiresAt := "NULL"
+				if entry.ExpiresAt.Valid {
+					expiresAt = fmt.Sprintf("'%s'", entry.ExpiresAt.Time.Format(extras.TIME_FORMAT))
+				}
+				values = append(values, fmt.Sprintf("('%s', '%s', '%s', '%s', %s, '%s')", entry.Type, util.EscapeSqlString(entry.Value), entry.List, util.EscapeSqlString(entry.Source), expiresAt, entry.CreatedAt.Format(extras.TIME_FORMAT)))
+			}
+
+			queryString := fmt.Sprintf("INSERT INTO %s (type, value, list, source, expires_at, created_at) VALUES %s ON DUPLICATE KEY UPDATE list = VALUES(list), source = VALUES(source), expires_at = VALUES(expires_at)", extras.UrlIntelTable, strings.Join(values, ", "))
+			if err := tx.Exec(queryString).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExportUrlIntel writes the entries of list, or of both lists, in the csv
+// format ImportUrlIntel reads.
+func ExportUrlIntel(list string) ([]byte, error) {
+	entries, err := fetchUrlIntel(list, extras.EMPTY_STRING)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	csvWriter.Write(urlIntelCsvHeader)
+	for _, entry := range entries {
+		expiresAt := extras.EMPTY_STRING
+		if entry.ExpiresAt.Valid {
+			expiresAt = entry.ExpiresAt.Time.Format(time.RFC3339)
+		}
+		csvWriter.Write([]string{entry.Value, entry.Type, entry.List, entry.Source, expiresAt})
+	}
+	csvWriter.Flush()
+
+	return buf.Bytes(), csvWriter.Error()
+}
+
+// applyUrlIntelRequest validates req and copies it onto entry, a missing type
+// is guessed from the value.
+func applyUrlIntelRequest(entry *model.UrlIntel, req model.ListEntryRequest, curUsr string) error {
+	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+	if req.Type == extras.EMPTY_STRING {
+		req.Type = util.GuessUrlIntelType(strings.TrimSpace(req.Value))
+	}
+	if !slices.Contains(urlIntelTypes, req.Type) {
+		return extras.ErrUrlIntelType
+	}
+	req.List = strings.ToLower(strings.TrimSpace(req.List))
+	if req.List != extras.ALLOW && req.List != extras.BLOCK {
+		return extras.ErrUrlIntelList
+	}
+
+	value, err := util.NormalizeUrlIntelValue(req.Type, strings.TrimSpace(req.Value))
+	if err != nil {
+		return err
+	}
+	expiresAt, err := listExpiry(req.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	entry.Type = req.Type
+	entry.Value = value
+	entry.List = req.List
+	entry.Comment = strings.TrimSpace(req.Comment)
+	entry.Owner = strings.TrimSpace(req.Owner)
+	if entry.Owner == extras.EMPTY_STRING {
+		entry.Owner = curUsr
+	}
+	entry.ExpiresAt = expiresAt
+	return nil
+}
+
+// urlIntelEntryExists tells whether another entry than id has the type and
+// value.
+func urlIntelEntryExists(entry model.UrlIntel) bool {
+	var count int64
+	config.Db.Model(&model.UrlIntel{}).Where("type = ? AND value = ? AND id != ?", entry.Type, entry.Value, entry.Id).Count(&count)
+	return count > 0
+}
+
+func CreateUrlIntelEntry(req model.ListEntryRequest, curUsr string) model.APIResponse {
+	entry := model.UrlIntel{
+		Source:    extras.URL_INTEL_SOURCE_MANUAL,
+		CreatedBy: curUsr,
+		CreatedAt: time.Now(),
+	}
+	if err := applyUrlIntelRequest(&entry, req, curUsr); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_URL_INTEL_ENTRY, err)
+	}
+	if urlIntelEntryExists(entry) {
+		return model.NewErrorResponse(http.StatusConflict, extras.ERR_INVALID_URL_INTEL_ENTRY, extras.ErrListEntryExists)
+	}
+
+	if err := config.Db.Create(&entry).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entry)
+}
+
+func UpdateUrlIntelEntry(id int, req model.ListEntryRequest, curUsr string) model.APIResponse {
+	var entry model.UrlIntel
+	if err := config.Db.Where("id = ?", id).First(&entry).Error; err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_URL_INTEL_NOT_FOUND, extras.ErrUrlIntelNotFound)
+	}
+	if err := applyUrlIntelRequest(&entry, req, curUsr); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_URL_INTEL_ENTRY, err)
+	}
+	if urlIntelEntryExists(entry) {
+		return model.NewErrorResponse(http.StatusConflict, extras.ERR_INVALID_URL_INTEL_ENTRY, extras.ErrListEntryExists)
+	}
+
+	entry.UpdatedBy = curUsr
+	entry.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := config.Db.Save(&entry).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, entry)
+}
+
+func DeleteUrlIntel(id int) model.APIResponse {
+	result := config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %d", extras.UrlIntelTable, id))
+	if result.Error != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_URL_INTEL_NOT_FOUND, extras.ErrUrlIntelNotFound)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Entry %d deleted", id))
+}
+
+// renormalizeUrlIntel brings the path and url entries saved before their paths
+// were escaped to the form lookups derive.
+func renormalizeUrlIntel() {
+	var entries []model.UrlIntel
+	if err := config.Db.Select("id", "type", "value").Where("type IN ?", []string{extras.URL_INTEL_PATH, extras.URL_INTEL_URL}).Find(&entries).Error; err != nil {
+		return
+	}
+	for _, entry := range entries {
+		value, err := util.NormalizeUrlIntelValue(entry.Type, entry.Value)
+		if err != nil || value == entry.Value {
+			continue
+		}
+		// an entry already saved in the new form is kept instead
+		if err = config.Db.Exec(fmt.Sprintf("UPDATE IGNORE %s SET value = '%s' WHERE id = %d", extras.UrlIntelTable, util.EscapeSqlString(value), entry.Id)).Error; err != nil {
+			// slog.Println("ERROR WHILE NORMALIZING URL INTEL: ", entry.Id, err)
+		}
+	}
+}
+
+// InitUrlIntel moves the old temp_malicious_urls.csv block list into the url
+// intel store the first time the store is empty.
+func InitUrlIntel() {
+	renormalizeUrlIntel()
+
+	var count int64
+	if err := config.Db.Model(&model.UrlIntel{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	file, err := os.Open(extras.TEMP_MALICIOUS_URLS_FILE)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	resp := ImportUrlIntel(file, extras.BLOCK, extras.URL_INTEL_SOURCE_LEGACY)
+	if resp.StatusCode != http.StatusOK {
+		// slog.Println("ERROR WHILE IMPORTING MALICIOUS URLS: ", resp.Error)
+	}
+}
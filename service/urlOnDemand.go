@@ -6,18 +6,23 @@ import (
 	"anti-apt-backend/extras"
 	"anti-apt-backend/logger"
 	"anti-apt-backend/model"
+	queues "anti-apt-backend/service/queue"
+	"anti-apt-backend/util"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/csv"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
-func CreateUrlOnDemand(urlRequest model.UrlOnDemand, adminName, organizationKey string) model.APIResponse {
+func CreateUrlOnDemand(urlRequest model.UrlOnDemand, adminName, ip string) model.APIResponse {
 	var err error
 	var resp model.APIResponse
 	respMes := "Url: " + urlRequest.UrlName + " successfully uploaded"
@@ -41,57 +46,71 @@ func CreateUrlOnDemand(urlRequest model.UrlOnDemand, adminName, organizationKey
 	}
 
 	var fromDevice bool
-	if adminName == "DEVICE" && organizationKey == "" {
+	if adminName == "DEVICE" {
 		fromDevice = true
 	}
 
+	priority, err := util.GetPriority(urlRequest.Priority, fromDevice)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_PRIORITY, err)
+	}
+
 	// var platForm = "Windows 7"
 	// platFormInBytes, _ := os.ReadFile(extras.PLATFORM_FILE_NAME)
 	// if strings.Contains(strings.ToLower(string(platFormInBytes)), "ubuntu") {
 	// 	platForm = "Ubuntu 20"
 	// }
 
-	rand.Seed(time.Now().UnixNano())
-	randomNumber := rand.Intn(3) + 1
-
 	uod := model.UrlOnDemand{
-		UrlName:       urlRequest.UrlName,
+		UrlName:       strings.TrimSpace(urlRequest.UrlName),
 		SubmittedTime: time.Now(),
-		FinishedTime:  sql.NullTime{Time: time.Now().Add(time.Duration(randomNumber) * time.Second), Valid: true},
 		SubmittedBy:   adminName,
 		Comments:      urlRequest.Comments,
-		Status:        extras.REPORTED,
+		Status:        extras.Pending,
 		// OsSupported:   platForm,
 		UrlCount:   1,
 		FromDevice: fromDevice,
+		Priority:   priority,
 	}
-
-	rand.Seed(time.Now().UnixNano())
-	randomNumber = rand.Intn(5) + 1
-
-	ratings := []string{
-		string(model.Critical),
-		string(model.HighRisk),
-		string(model.MediumRisk),
-		string(model.LowRisk),
-		string(model.Clean),
+	if fromDevice {
+		uod.ClientIp = ip
 	}
 
-	// found in trusted_urls
-	malicipusUrls := FetchUrlsFromFile(extras.TEMP_MALICIOUS_URLS_FILE)
-	if maliciousUrlContains(malicipusUrls, uod.UrlName) {
-		uod.Rating = ratings[randomNumber-1]
-		uod.FinalVerdict = extras.BLOCK
-	} else {
-		uod.Rating = string(model.Clean)
-		uod.FinalVerdict = extras.ALLOW
+	// a url on the allow or block list is decided without analysis
+	entry, err := dao.LookupUrlIntel(uod.UrlName)
+	if err != nil {
+		// slog.Println("ERROR WHILE LOOKING UP URL INTEL: ", err)
 	}
-
-	queryString := fmt.Sprintf("INSERT INTO url_on_demands (url_name, submitted_time, finished_time, submitted_by, comments, status, url_count, from_device, rating, final_verdict) VALUES ('%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s')", uod.UrlName, uod.SubmittedTime.Format(extras.TIME_FORMAT), uod.FinishedTime.Time.Format(extras.TIME_FORMAT), uod.SubmittedBy, uod.Comments, uod.Status, uod.UrlCount, uod.FromDevice, uod.Rating, uod.FinalVerdict)
-	uodRepo := dao.DatabaseOperationsRepo{
-		QueryExecSet: []string{queryString},
+	if entry != nil {
+		return saveListedUrl(uod, *entry, respMes)
 	}
-	err = dao.GormOperations(&uodRepo, config.Db, dao.EXEC)
+
+	md5Hash, sha1Hash, sha256Hash := urlHashes(uod.UrlName)
+
+	// the url is analysed like a file, a url already being analysed waits for
+	// that verdict in the duplicate table
+	err = config.Db.Transaction(func(tx *gorm.DB) error {
+		queryString := fmt.Sprintf("INSERT INTO %s (url_name, submitted_time, submitted_by, comments, status, url_count, from_device, client_ip, priority) VALUES ('%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s')", extras.UrlOnDemandTable, util.EscapeSqlString(uod.UrlName), uod.SubmittedTime.Format(extras.TIME_FORMAT), uod.SubmittedBy, util.EscapeSqlString(uod.Comments), uod.Status, uod.UrlCount, uod.FromDevice, uod.ClientIp, uod.Priority)
+		if err := tx.Exec(queryString).Error; err != nil {
+			return err
+		}
+		if err := tx.Raw("SELECT LAST_INSERT_ID()").Scan(&uod.Id).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		queryString = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE url_id IS NOT NULL AND (md5 = '%s' OR sha = '%s' OR sha256 = '%s')", extras.TaskLiveAnalysisTable, md5Hash, sha1Hash, sha256Hash)
+		if err := tx.Raw(queryString).Scan(&count).Error; err != nil {
+			return err
+		}
+
+		if count <= 0 {
+			queryString = fmt.Sprintf("INSERT INTO %s (url_id, status, running_retry_count, sandbox_retry_count, version, md5, sha, sha256) VALUES (%d, '%s', %d, %d, %d, '%s', '%s', '%s')", extras.TaskLiveAnalysisTable, uod.Id, extras.Pending, 0, 0, 0, md5Hash, sha1Hash, sha256Hash)
+		} else {
+			queryString = fmt.Sprintf("INSERT INTO %s (url_id, md5, sha, sha256) VALUES (%d, '%s', '%s', '%s')", extras.TaskDuplicateTable, uod.Id, md5Hash, sha1Hash, sha256Hash)
+		}
+		return tx.Exec(queryString).Error
+	})
 	if err != nil {
 		// slog.Println("ERROR IN SAVING TASK: ", err)
 		resp = model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
@@ -102,36 +121,44 @@ func CreateUrlOnDemand(urlRequest model.UrlOnDemand, adminName, organizationKey
 		respMes = fmt.Sprintf("%d", uod.Id)
 	}
 
-	logger.LoggerFunc("info", logger.LoggerMessage("taskLog:Final report generated for "+uod.UrlName))
+	logger.LoggerFunc("info", logger.LoggerMessage("taskLog:Url queued for analysis "+uod.UrlName))
 
 	return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
 }
 
-func maliciousUrlContains(maliciousUrls []string, url string) bool {
-	for _, maliciousUrl := range maliciousUrls {
-		if strings.Contains(url, maliciousUrl) {
-			return true
-		}
+// saveListedUrl saves uod as finished with the verdict of its url intel entry.
+func saveListedUrl(uod model.UrlOnDemand, entry model.UrlIntel, respMes string) model.APIResponse {
+	uod.Status = extras.PREVIOUSLY_SCANNED_URL
+	uod.FinishedTime = sql.NullTime{Time: time.Now(), Valid: true}
+	uod.FinalVerdict = entry.List
+	if entry.List == extras.BLOCK {
+		uod.Score = queues.URL_REPUTATION_SCORE
 	}
-	return false
-}
+	uod.Rating = string(util.GetVerdict(uod.Score))
 
-func FetchUrlsFromFile(urlFilePath string) []string {
-	file, err := os.Open(urlFilePath)
+	err := config.Db.Transaction(func(tx *gorm.DB) error {
+		queryString := fmt.Sprintf("INSERT INTO %s (url_name, submitted_time, finished_time, submitted_by, comments, status, url_count, from_device, client_ip, priority, rating, score, final_verdict) VALUES ('%s', '%s', '%s', '%s', '%s', '%s', %d, %t, '%s', '%s', '%s', %f, '%s')", extras.UrlOnDemandTable, util.EscapeSqlString(uod.UrlName), uod.SubmittedTime.Format(extras.TIME_FORMAT), uod.FinishedTime.Time.Format(extras.TIME_FORMAT), uod.SubmittedBy, util.EscapeSqlString(uod.Comments), uod.Status, uod.UrlCount, uod.FromDevice, uod.ClientIp, uod.Priority, uod.Rating, uod.Score, uod.FinalVerdict)
+		if err := tx.Exec(queryString).Error; err != nil {
+			return err
+		}
+		return tx.Raw("SELECT LAST_INSERT_ID()").Scan(&uod.Id).Error
+	})
 	if err != nil {
-		// slog.Println("error in opening malicious urls file: ", err)
-		return []string{}
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
 	}
-	defer file.Close()
-
-	// read csv values using csv.Reader
-	csvReader := csv.NewReader(file)
-	fields, _ := csvReader.ReadAll()
 
-	var maliciousUrls []string
-	for _, field := range fields {
-		maliciousUrls = append(maliciousUrls, field[0])
+	if uod.FromDevice {
+		respMes = fmt.Sprintf("%d", uod.Id)
+		go queues.NotifyTask(extras.EVENT_TASK_REPORTED, extras.TASK_TYPE_URL, uod.Id)
 	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, respMes)
+}
 
-	return maliciousUrls
+// urlHashes are the hashes a url is matched on in the live and duplicate
+// tables.
+func urlHashes(urlName string) (string, string, string) {
+	md5Sum := md5.Sum([]byte(urlName))
+	sha1Sum := sha1.Sum([]byte(urlName))
+	sha256Sum := sha256.Sum256([]byte(urlName))
+	return hex.EncodeToString(md5Sum[:]), hex.EncodeToString(sha1Sum[:]), hex.EncodeToString(sha256Sum[:])
 }
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/verdictcache"
+	"net/http"
+)
+
+func GetVerdictCache() model.APIResponse {
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, model.VerdictCacheInfo{
+		Config: verdictcache.Policy(),
+		Stats:  verdictcache.Stats(),
+	})
+}
+
+// UpdateVerdictCacheConfig applies to cached verdicts straight away, they are
+// aged from when they were given. A new minimum feed confidence reloads the
+// malware hashes.
+func UpdateVerdictCacheConfig(verdictCacheConfig model.VerdictCacheConfig) model.APIResponse {
+	for _, policy := range []model.VerdictPolicy{verdictCacheConfig.Allow, verdictCacheConfig.Block} {
+		if policy.TtlHours < 0 || policy.RescanAfterHours < 0 {
+			return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_VERDICT_CACHE_CONFIG, extras.ErrVerdictCachePolicy)
+		}
+	}
+
+	if verdictCacheConfig.MinFeedConfidence < 0 || verdictCacheConfig.MinFeedConfidence > 100 {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_VERDICT_CACHE_CONFIG, extras.ErrVerdictCacheConfidence)
+	}
+
+	previous := verdictcache.Policy()
+	if err := config.UpdateVerdictCacheConfig(verdictCacheConfig); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	verdictcache.SetPolicy(verdictCacheConfig)
+	if previous.MinFeedConfidence != verdictCacheConfig.MinFeedConfidence {
+		go verdictcache.Load()
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, verdictCacheConfig)
+}
+
+// ReloadVerdictCache rebuilds the cache from the database.
+func ReloadVerdictCache() model.APIResponse {
+	if err := verdictcache.Load(); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, verdictcache.Stats())
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"anti-apt-backend/util"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+func GetWebhooks() model.APIResponse {
+	webhooks := []model.Webhook{}
+	webhookRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{fmt.Sprintf("SELECT * FROM %s ORDER BY name", extras.WebhookTable)},
+		Result:       &webhooks,
+	}
+	if err := dao.GormOperations(&webhookRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, webhooks)
+}
+
+// applyWebhookRequest validates req and copies it onto webhook. The secret is
+// only replaced when one is given.
+func applyWebhookRequest(webhook *model.Webhook, req model.WebhookRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == extras.EMPTY_STRING {
+		return extras.ErrWebhookName
+	}
+
+	u, err := url.Parse(strings.TrimSpace(req.Url))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == extras.EMPTY_STRING {
+		return extras.ErrWebhookUrl
+	}
+
+	var events []string
+	for _, event := range req.Events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if !slices.Contains(extras.NOTIFICATION_EVENTS, event) {
+			return extras.ErrWebhookEvent
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+
+	webhook.Name = req.Name
+	webhook.Url = u.String()
+	webhook.Events = strings.Join(events, ",")
+	webhook.SkipTlsVerify = req.SkipTlsVerify
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+	if req.Secret != extras.EMPTY_STRING {
+		webhook.Secret = req.Secret
+	}
+	return nil
+}
+
+func CreateWebhook(req model.WebhookRequest, curUsr string) model.APIResponse {
+	webhook := model.Webhook{
+		Enabled:   true,
+		CreatedBy: curUsr,
+		CreatedAt: time.Now(),
+	}
+	if err := applyWebhookRequest(&webhook, req); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_WEBHOOK, err)
+	}
+
+	if err := config.Db.Create(&webhook).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, webhook)
+}
+
+func UpdateWebhook(id int, req model.WebhookRequest) model.APIResponse {
+	var webhook model.Webhook
+	if err := config.Db.Where("id = ?", id).First(&webhook).Error; err != nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_WEBHOOK_NOT_FOUND, extras.ErrWebhookNotFound)
+	}
+	if err := applyWebhookRequest(&webhook, req); err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_WEBHOOK, err)
+	}
+
+	if err := config.Db.Save(&webhook).Error; err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, webhook)
+}
+
+// DeleteWebhook also drops the webhook's undelivered notifications, the log of
+// what was delivered is kept.
+func DeleteWebhook(id int) model.APIResponse {
+	result := config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %d", extras.WebhookTable, id))
+	if result.Error != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_WEBHOOK_NOT_FOUND, extras.ErrWebhookNotFound)
+	}
+
+	config.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE webhook_id = %d AND status = '%s'", extras.NotificationTable, id, extras.NOTIFICATION_PENDING))
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Webhook %d deleted", id))
+}
+
+// GetNotifications is the delivery log, newest first. status narrows it to
+// pending, delivered or failed notifications.
+func GetNotifications(status string, subscriber string) model.APIResponse {
+	var conditions []string
+	if status != extras.EMPTY_STRING {
+		conditions = append(conditions, fmt.Sprintf("status = '%s'", util.EscapeSqlString(status)))
+	}
+	if subscriber != extras.EMPTY_STRING {
+		conditions = append(conditions, fmt.Sprintf("subscriber = '%s'", util.EscapeSqlString(subscriber)))
+	}
+
+	queryString := fmt.Sprintf("SELECT * FROM %s", extras.NotificationTable)
+	if len(conditions) > 0 {
+		queryString += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	queryString += " ORDER BY id DESC LIMIT 500"
+
+	notifications := []model.NotificationOutbox{}
+	notificationRepo := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &notifications,
+	}
+	if err := dao.GormOperations(&notificationRepo, config.Db, dao.EXEC); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, notifications)
+}
+
+// RetryNotification gives a failed notification a fresh set of attempts.
+func RetryNotification(id int) model.APIResponse {
+	result := config.Db.Exec(fmt.Sprintf("UPDATE %s SET status = '%s', attempts = 0, next_attempt_at = '%s' WHERE id = %d AND status = '%s'", extras.NotificationTable, extras.NOTIFICATION_PENDING, time.Now().Format(extras.TIME_FORMAT), id, extras.NOTIFICATION_FAILED))
+	if result.Error != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_NOTIFICATION_NOT_FOUND, extras.ErrNotificationNotFound)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Notification %d queued again", id))
+}
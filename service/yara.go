@@ -0,0 +1,200 @@
+package service
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	queues "anti-apt-backend/service/queue"
+	"anti-apt-backend/util"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const YARA_COMMAND_TIMEOUT = 60 * time.Second
+
+var yaraRulesetNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func fetchYaraRulesets(name string) ([]model.YaraRuleset, error) {
+	var rulesets []model.YaraRuleset
+	queryString := fmt.Sprintf("SELECT * FROM %s ORDER BY name, version", extras.YaraRulesetTable)
+	if name != extras.EMPTY_STRING {
+		queryString = fmt.Sprintf("SELECT * FROM %s WHERE name = '%s' ORDER BY version", extras.YaraRulesetTable, name)
+	}
+	yaraRulesets := dao.DatabaseOperationsRepo{
+		QueryExecSet: []string{queryString},
+		Result:       &rulesets,
+	}
+	err := dao.GormOperations(&yaraRulesets, config.Db, dao.EXEC)
+	return rulesets, err
+}
+
+func GetYaraRulesets() model.APIResponse {
+	rulesets, err := fetchYaraRulesets(extras.EMPTY_STRING)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, rulesets)
+}
+
+// UploadYaraRuleset stores the rules as the next version of the named ruleset
+// and makes it the active one. A new ruleset starts enabled, a new version
+// keeps the ruleset's enabled flag.
+func UploadYaraRuleset(name string, file multipart.File, curUsr string) model.APIResponse {
+	if !yaraRulesetNameRegex.MatchString(name) {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_NAME_FORMAT, extras.ErrYaraRulesetName)
+	}
+
+	rulesets, err := fetchYaraRulesets(name)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+
+	ruleset := model.YaraRuleset{
+		Name:       name,
+		Version:    1,
+		Enabled:    true,
+		Active:     true,
+		UploadedBy: curUsr,
+		CreatedAt:  time.Now(),
+	}
+	for _, existing := range rulesets {
+		ruleset.Version = max(ruleset.Version, existing.Version+1)
+		if existing.Active {
+			ruleset.Enabled = existing.Enabled
+		}
+	}
+
+	ruleset.FilePath = filepath.Join(extras.YARA_RULES_PATH, name, fmt.Sprintf("v%d.yar", ruleset.Version))
+	if err = os.MkdirAll(filepath.Dir(ruleset.FilePath), 0755); err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+
+	out, err := os.Create(ruleset.FilePath)
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), file)
+	out.Close()
+	if err != nil {
+		os.Remove(ruleset.FilePath)
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+	ruleset.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), YARA_COMMAND_TIMEOUT)
+	defer cancel()
+	if err = queues.ValidateYaraRules(ctx, ruleset.FilePath); err != nil {
+		os.Remove(ruleset.FilePath)
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_INVALID_YARA_RULES, err)
+	}
+
+	err = config.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("UPDATE %s SET active = false WHERE name = '%s'", extras.YaraRulesetTable, name)).Error; err != nil {
+			return err
+		}
+		return tx.Create(&ruleset).Error
+	})
+	if err != nil {
+		os.Remove(ruleset.FilePath)
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+	}
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, ruleset)
+}
+
+func SetYaraRulesetEnabled(name string, enabled bool) model.APIResponse {
+	result := config.Db.Exec(fmt.Sprintf("UPDATE %s SET enabled = %t WHERE name = '%s'", extras.YaraRulesetTable, enabled, util.EscapeSqlString(name)))
+	if result.Error != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// mysql counts only changed rows, so the ruleset may already be in that state
+		rulesets, err := fetchYaraRulesets(util.EscapeSqlString(name))
+		if err != nil || len(rulesets) == 0 {
+			return model.NewErrorResponse(http.StatusNotFound, extras.ERR_YARA_RULESET_NOT_FOUND, extras.ErrYaraRulesetNotFound)
+		}
+	}
+
+	if enabled {
+		return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Ruleset %s enabled", name))
+	}
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, fmt.Sprintf("Ruleset %s disabled", name))
+}
+
+// SetYaraRulesetVersion makes an earlier upload the active version again.
+func SetYaraRulesetVersion(name string, version int) model.APIResponse {
+	rulesets, err := fetchYaraRulesets(util.EscapeSqlString(name))
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+
+	for _, ruleset := range rulesets {
+		if ruleset.Version != version {
+			continue
+		}
+
+		if err = config.Db.Exec(fmt.Sprintf("UPDATE %s SET active = (version = %d) WHERE name = '%s'", extras.YaraRulesetTable, version, util.EscapeSqlString(name))).Error; err != nil {
+			return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_SAVING_DATA, err)
+		}
+		ruleset.Active = true
+		return model.NewSuccessResponse(extras.ERR_SUCCESS, ruleset)
+	}
+
+	return model.NewErrorResponse(http.StatusNotFound, extras.ERR_YARA_RULESET_NOT_FOUND, extras.ErrYaraRulesetNotFound)
+}
+
+// TestYaraRuleset scans an uploaded sample with one ruleset and returns what
+// matched, nothing is saved. The active version is used when version is empty.
+func TestYaraRuleset(name string, version string, file multipart.File) model.APIResponse {
+	rulesets, err := fetchYaraRulesets(util.EscapeSqlString(name))
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_IN_FETCHING_DATA, err)
+	}
+
+	var ruleset *model.YaraRuleset
+	for i := range rulesets {
+		if version == extras.EMPTY_STRING && rulesets[i].Active || version == strconv.Itoa(rulesets[i].Version) {
+			ruleset = &rulesets[i]
+		}
+	}
+	if ruleset == nil {
+		return model.NewErrorResponse(http.StatusNotFound, extras.ERR_YARA_RULESET_NOT_FOUND, extras.ErrYaraRulesetNotFound)
+	}
+
+	sample, err := os.CreateTemp(extras.EMPTY_STRING, "yara-test-*")
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+	defer os.Remove(sample.Name())
+	_, err = io.Copy(sample, file)
+	sample.Close()
+	if err != nil {
+		return model.NewErrorResponse(http.StatusInternalServerError, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), YARA_COMMAND_TIMEOUT)
+	defer cancel()
+	matches, err := queues.ScanWithYara(ctx, sample.Name(), []model.YaraRuleset{*ruleset})
+	if err != nil {
+		return model.NewErrorResponse(http.StatusBadRequest, extras.ERR_FROM_SERVER_SIDE, err)
+	}
+	if matches == nil {
+		matches = []model.TaskYaraMatch{}
+	}
+
+	return model.NewSuccessResponse(extras.ERR_SUCCESS, matches)
+}
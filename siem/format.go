@@ -0,0 +1,143 @@
+package siem
+
+import (
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	VENDOR  = "WiJungle"
+	PRODUCT = "Anti-APT"
+	VERSION = "1.0"
+	APPNAME = "anti-apt"
+	// syslog facility local0
+	FACILITY = 16
+)
+
+// CEF has no free form keys, fields without a dictionary key go into the
+// custom string slots with their name as the label
+var cefKeys = map[string]string{
+	"user":      "suser",
+	"client_ip": "src",
+	"file_name": "fname",
+	"sha256":    "fileHash",
+	"url":       "request",
+	"verdict":   "act",
+	"task_id":   "externalId",
+}
+
+var leefKeys = map[string]string{
+	"user":      "usrName",
+	"client_ip": "src",
+}
+
+// syslogSeverity maps the 0-10 severity of CEF onto the syslog levels.
+func syslogSeverity(severity int) int {
+	switch {
+	case severity >= 9:
+		return 2 // critical
+	case severity >= 7:
+		return 3 // error
+	case severity >= 4:
+		return 4 // warning
+	}
+	return 6 // informational
+}
+
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+var leefValueEscaper = strings.NewReplacer("^", " ", "\n", " ", "\r", " ", "\t", " ")
+
+func formatCef(event Event) string {
+	extension := []string{
+		"rt=" + fmt.Sprintf("%d", event.Time.UnixMilli()),
+		"cat=" + cefValueEscaper.Replace(event.Category),
+		"msg=" + cefValueEscaper.Replace(event.Message),
+	}
+	custom := 0
+	for _, key := range sortedKeys(event.Fields) {
+		value := cefValueEscaper.Replace(event.Fields[key])
+		if cefKey, ok := cefKeys[key]; ok {
+			extension = append(extension, cefKey+"="+value)
+			continue
+		}
+		if custom == 6 {
+			continue
+		}
+		custom++
+		extension = append(extension, fmt.Sprintf("cs%d=%s", custom, value), fmt.Sprintf("cs%dLabel=%s", custom, key))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s", VENDOR, PRODUCT, VERSION, cefHeaderEscaper.Replace(event.Name), cefHeaderEscaper.Replace(event.Message), event.Severity, strings.Join(extension, " "))
+}
+
+// formatLeef writes LEEF 2.0 with ^ between the attributes.
+func formatLeef(event Event) string {
+	attributes := []string{
+		"devTime=" + event.Time.Format("Jan 02 2006 15:04:05"),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss",
+		"cat=" + leefValueEscaper.Replace(event.Category),
+		"sev=" + fmt.Sprintf("%d", event.Severity),
+		"msg=" + leefValueEscaper.Replace(event.Message),
+	}
+	for _, key := range sortedKeys(event.Fields) {
+		leefKey, ok := leefKeys[key]
+		if !ok {
+			leefKey = key
+		}
+		attributes = append(attributes, leefKey+"="+leefValueEscaper.Replace(event.Fields[key]))
+	}
+
+	return fmt.Sprintf("LEEF:2.0|%s|%s|%s|%s|^|%s", VENDOR, PRODUCT, VERSION, strings.ReplaceAll(event.Name, "|", " "), strings.Join(attributes, "^"))
+}
+
+func formatJson(event Event) string {
+	data := map[string]any{
+		"time":     event.Time.Format(time.RFC3339),
+		"category": event.Category,
+		"event":    event.Name,
+		"severity": event.Severity,
+		"message":  event.Message,
+	}
+	for key, value := range event.Fields {
+		if _, taken := data[key]; !taken {
+			data[key] = value
+		}
+	}
+	out, _ := json.Marshal(data)
+	return string(out)
+}
+
+// formatMessage wraps the event in an RFC 5424 header.
+func formatMessage(destination model.SiemDestination, event Event, hostname string) string {
+	var body string
+	switch destination.Format {
+	case extras.SIEM_FORMAT_LEEF:
+		body = formatLeef(event)
+	case extras.SIEM_FORMAT_JSON:
+		body = formatJson(event)
+	default:
+		body = formatCef(event)
+	}
+
+	pri := FACILITY*8 + syslogSeverity(event.Severity)
+	msgId := strings.ReplaceAll(event.Name, " ", "_")
+	if msgId == extras.EMPTY_STRING {
+		msgId = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - %s - %s", pri, event.Time.Format("2006-01-02T15:04:05.000000Z07:00"), hostname, APPNAME, msgId, body)
+}
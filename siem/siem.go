@@ -0,0 +1,332 @@
+package siem
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DIAL_TIMEOUT  = 5 * time.Second
+	WRITE_TIMEOUT = 5 * time.Second
+	// events waiting for the forwarder, more are spooled straight away
+	EVENT_BUFFER = 1000
+	// a destination's spool stops growing at this size, newer events are dropped
+	MAX_SPOOL_SIZE = 50 * 1024 * 1024
+	SPOOL_RETRY    = 30 * time.Second
+)
+
+// Event is one thing worth telling the SIEM about. Severity runs from 0 to 10
+// as in CEF, Fields are extra key values like user or sha256.
+type Event struct {
+	Category string
+	Name     string
+	Severity int
+	Message  string
+	Time     time.Time
+	Fields   map[string]string
+}
+
+var (
+	events = make(chan Event, EVENT_BUFFER)
+
+	mu           sync.RWMutex
+	destinations []model.SiemDestination
+	loaded       bool
+)
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == extras.EMPTY_STRING {
+		return "-"
+	}
+	return name
+}
+
+// Reload makes the forwarder use siemConfig from the next event on.
+func Reload(siemConfig model.SiemConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	destinations = siemConfig.Destinations
+	loaded = true
+}
+
+func currentDestinations() []model.SiemDestination {
+	mu.RLock()
+	if loaded {
+		defer mu.RUnlock()
+		return destinations
+	}
+	mu.RUnlock()
+
+	siemConfig, _ := config.ReadSiemConfig()
+	Reload(siemConfig)
+	return siemConfig.Destinations
+}
+
+func wants(destination model.SiemDestination, category string) bool {
+	if !destination.Enabled {
+		return false
+	}
+	switch category {
+	case extras.SIEM_CATEGORY_TASK:
+		return destination.Categories.Task
+	case extras.SIEM_CATEGORY_AUDIT:
+		return destination.Categories.Audit
+	case extras.SIEM_CATEGORY_SYSTEM:
+		return destination.Categories.System
+	case extras.SIEM_CATEGORY_HA:
+		return destination.Categories.Ha
+	}
+	return false
+}
+
+// Forward hands event to the forwarder without waiting for it to be sent.
+func Forward(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for key, value := range event.Fields {
+		if value == extras.EMPTY_STRING {
+			delete(event.Fields, key)
+		}
+	}
+
+	wanted := false
+	for _, destination := range currentDestinations() {
+		wanted = wanted || wants(destination, event.Category)
+	}
+	if !wanted {
+		return
+	}
+
+	select {
+	case events <- event:
+	default:
+		// the forwarder is behind, keep the event for the spool replay
+		host := hostname()
+		for _, destination := range currentDestinations() {
+			if wants(destination, event.Category) {
+				spool(destination.Name, formatMessage(destination, event, host))
+			}
+		}
+	}
+}
+
+// forwarder keeps one connection per destination, udp included.
+type forwarder struct {
+	host  string
+	conns map[string]net.Conn
+}
+
+func dial(destination model.SiemDestination) (net.Conn, error) {
+	address := net.JoinHostPort(destination.Host, fmt.Sprintf("%d", destination.Port))
+	switch destination.Protocol {
+	case extras.SIEM_PROTOCOL_UDP:
+		return net.DialTimeout("udp", address, DIAL_TIMEOUT)
+	case extras.SIEM_PROTOCOL_TLS:
+		dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+		return tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: destination.Host, InsecureSkipVerify: destination.SkipTlsVerify})
+	}
+	return net.DialTimeout("tcp", address, DIAL_TIMEOUT)
+}
+
+// frame uses octet counting over tcp and tls (RFC 6587), udp sends one message
+// per datagram.
+func frame(destination model.SiemDestination, message string) []byte {
+	if destination.Protocol == extras.SIEM_PROTOCOL_UDP {
+		return []byte(message)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(message), message))
+}
+
+func (f *forwarder) send(destination model.SiemDestination, message string) error {
+	conn, ok := f.conns[destination.Name]
+	if !ok {
+		var err error
+		if conn, err = dial(destination); err != nil {
+			return err
+		}
+		f.conns[destination.Name] = conn
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	if _, err := conn.Write(frame(destination, message)); err != nil {
+		conn.Close()
+		delete(f.conns, destination.Name)
+		return err
+	}
+	return nil
+}
+
+func (f *forwarder) forward(event Event) {
+	for _, destination := range currentDestinations() {
+		if !wants(destination, event.Category) {
+			continue
+		}
+		message := formatMessage(destination, event, f.host)
+		// spooled events go first, so the collector sees them in order
+		if spooled(destination.Name) || f.send(destination, message) != nil {
+			spool(destination.Name, message)
+		}
+	}
+}
+
+// replay sends what was spooled for each destination, what can not be sent
+// stays in the spool.
+func (f *forwarder) replay() {
+	for _, destination := range currentDestinations() {
+		if !destination.Enabled || !spooled(destination.Name) {
+			continue
+		}
+		messages, err := readSpool(destination.Name)
+		if err != nil {
+			continue
+		}
+		sent := 0
+		for _, message := range messages {
+			if f.send(destination, message) != nil {
+				break
+			}
+			sent++
+		}
+		if err = rewriteSpool(destination.Name, sent); err != nil {
+			// slog.Println("ERROR WHILE REWRITING SIEM SPOOL: ", destination.Name, err)
+		}
+	}
+}
+
+func (f *forwarder) close() {
+	for name, conn := range f.conns {
+		conn.Close()
+		delete(f.conns, name)
+	}
+}
+
+// Run forwards events until ctx is cancelled, retrying the spool every
+// SPOOL_RETRY.
+func Run(ctx context.Context) {
+	f := &forwarder{host: hostname(), conns: make(map[string]net.Conn)}
+	defer f.close()
+
+	ticker := time.NewTicker(SPOOL_RETRY)
+	defer ticker.Stop()
+	f.replay()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			f.forward(event)
+		case <-ticker.C:
+			// a config change may have renamed or moved a destination
+			f.close()
+			f.replay()
+		}
+	}
+}
+
+// Test sends a test event to destination straight away and reports whether it
+// could be written. Over udp that only means the datagram left.
+func Test(destination model.SiemDestination) error {
+	conn, err := dial(destination)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	event := Event{
+		Category: extras.SIEM_CATEGORY_SYSTEM,
+		Name:     "test",
+		Severity: 1,
+		Message:  "Test event from WiJungle Anti-APT",
+		Time:     time.Now(),
+	}
+	conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	_, err = conn.Write(frame(destination, formatMessage(destination, event, hostname())))
+	return err
+}
+
+var spoolMu sync.Mutex
+
+func spoolPath(name string) string {
+	return filepath.Join(extras.SIEM_SPOOL_PATH, name+".spool")
+}
+
+func spooled(name string) bool {
+	info, err := os.Stat(spoolPath(name))
+	return err == nil && info.Size() > 0
+}
+
+// spool appends message to the destination's spool, one message per line.
+// The formats escape line breaks, so a line is always a whole message.
+func spool(name string, message string) {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+
+	if info, err := os.Stat(spoolPath(name)); err == nil && info.Size() > MAX_SPOOL_SIZE {
+		return
+	}
+	if err := os.MkdirAll(extras.SIEM_SPOOL_PATH, 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(spoolPath(name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.WriteString(strings.ReplaceAll(message, "\n", " ") + "\n")
+}
+
+func readSpool(name string) ([]string, error) {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+
+	file, err := os.Open(spoolPath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != extras.EMPTY_STRING {
+			messages = append(messages, line)
+		}
+	}
+	return messages, scanner.Err()
+}
+
+// rewriteSpool drops the first sent messages from the spool. Messages are
+// only ever appended, so whatever was spooled during the replay is kept.
+func rewriteSpool(name string, sent int) error {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+
+	data, err := os.ReadFile(spoolPath(name))
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if sent > len(lines) {
+		sent = len(lines)
+	}
+
+	tmp := spoolPath(name) + ".tmp"
+	if err = os.WriteFile(tmp, []byte(strings.Join(lines[sent:], extras.EMPTY_STRING)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, spoolPath(name))
+}
@@ -0,0 +1,400 @@
+package util
+
+import (
+	"anti-apt-backend/extras"
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h2non/filetype"
+)
+
+const UNPACK_TIMEOUT = 5 * time.Minute
+
+// 7z asks for a password on stdin when -p has no value, a wrong one is ignored
+// by archives that are not encrypted
+const NO_PASSWORD = "no-password"
+
+// errZipEncrypted sends zips the standard library can not decrypt to 7z
+var errZipEncrypted = errors.New("zip has encrypted members")
+
+type ArchiveMember struct {
+	Name      string // path inside the archive, nested archives are joined with "/"
+	Path      string // where the member was extracted to
+	Size      int64
+	Encrypted bool // an inner archive none of the passwords opened
+}
+
+type unpacker struct {
+	ctx       context.Context
+	destDir   string
+	passwords []string
+	members   []ArchiveMember
+	files     int
+	total     int64
+}
+
+// ArchiveKind returns the type of archive at fp, or an empty string if it is
+// not one that can be unpacked.
+func ArchiveKind(fp string) string {
+	f, err := os.Open(fp)
+	if err != nil {
+		return extras.EMPTY_STRING
+	}
+	defer f.Close()
+
+	// iso9660 keeps its signature at 32k
+	head := make([]byte, 64*1024)
+	n, _ := io.ReadFull(f, head)
+	kind, _ := filetype.Match(head[:n])
+
+	switch kind.Extension {
+	case "zip", "7z", "rar", "gz", "tar", "iso":
+		return kind.Extension
+	}
+	return extras.EMPTY_STRING
+}
+
+// UnpackArchive extracts the archive at fp into destDir. Archives found inside
+// are unpacked in turn until MAX_ARCHIVE_DEPTH, deeper ones are returned as
+// members. It fails with ErrArchiveBomb when the content expands beyond
+// MAX_COMPRESSION_RATIO, and with ErrArchiveLimit for the other limits.
+// Encrypted archives are tried with each of passwords in turn, it fails with
+// ErrArchiveEncrypted when none of them opens fp.
+func UnpackArchive(fp string, name string, destDir string, passwords []string) ([]ArchiveMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), UNPACK_TIMEOUT)
+	defer cancel()
+
+	u := &unpacker{ctx: ctx, destDir: destDir, passwords: passwords}
+	if err := u.unpack(fp, name, 1); err != nil {
+		return nil, err
+	}
+	return u.members, nil
+}
+
+func (u *unpacker) unpack(fp string, name string, depth int) error {
+	info, err := os.Stat(fp)
+	if err != nil {
+		return err
+	}
+
+	before := u.total
+	switch ArchiveKind(fp) {
+	case "zip":
+		err = u.unpackZip(fp, name, depth)
+		if err == errZipEncrypted {
+			err = u.unpack7z(fp, name, depth, info.Size())
+		}
+	case "gz":
+		err = u.unpackGzip(fp, name, depth)
+	case "tar":
+		err = u.unpackTar(fp, name, depth)
+	default:
+		err = u.unpack7z(fp, name, depth, info.Size())
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > 0 && (u.total-before)/info.Size() > extras.MAX_COMPRESSION_RATIO {
+		return extras.ErrArchiveBomb
+	}
+	return nil
+}
+
+// add counts a member against the limits and unpacks it further when it is an
+// archive itself.
+func (u *unpacker) add(fp string, name string, size int64, depth int) error {
+	if ArchiveKind(fp) != extras.EMPTY_STRING && depth < extras.MAX_ARCHIVE_DEPTH {
+		err := u.unpack(fp, name, depth+1)
+		if err == nil || errors.Is(err, extras.ErrArchiveBomb) || errors.Is(err, extras.ErrArchiveLimit) || errors.Is(err, context.DeadlineExceeded) {
+			os.Remove(fp)
+			return err
+		}
+		// a damaged inner archive is analysed as it is, an encrypted one is
+		// only recorded
+		if errors.Is(err, extras.ErrArchiveEncrypted) {
+			os.Remove(fp)
+			return u.addMember(ArchiveMember{Name: name, Size: size, Encrypted: true})
+		}
+	}
+
+	return u.addMember(ArchiveMember{Name: name, Path: fp, Size: size})
+}
+
+func (u *unpacker) addMember(member ArchiveMember) error {
+	u.files++
+	if u.files > extras.MAX_ARCHIVE_FILES {
+		return extras.ErrArchiveLimit
+	}
+	u.members = append(u.members, member)
+	return nil
+}
+
+// extract copies one member out of the archive. declared is the size the
+// archive claims, it is not trusted.
+func (u *unpacker) extract(r io.Reader, declared int64) (string, int64, error) {
+	if err := u.ctx.Err(); err != nil {
+		return extras.EMPTY_STRING, 0, err
+	}
+	if declared > extras.MAX_ALLOWED_FILE_SIZE || u.total+declared > extras.MAX_ARCHIVE_TOTAL_SIZE {
+		return extras.EMPTY_STRING, 0, extras.ErrArchiveLimit
+	}
+
+	out, err := os.CreateTemp(u.destDir, "member-")
+	if err != nil {
+		return extras.EMPTY_STRING, 0, err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(r, extras.MAX_ALLOWED_FILE_SIZE+1))
+	u.total += n
+	if err != nil {
+		return out.Name(), n, err
+	}
+	// more came out than the archive said would
+	if declared > 0 && n > declared {
+		return out.Name(), n, extras.ErrArchiveBomb
+	}
+	if n > extras.MAX_ALLOWED_FILE_SIZE || u.total > extras.MAX_ARCHIVE_TOTAL_SIZE {
+		return out.Name(), n, extras.ErrArchiveLimit
+	}
+	return out.Name(), n, nil
+}
+
+func (u *unpacker) unpackZip(fp string, name string, depth int) error {
+	r, err := zip.OpenReader(fp)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Flags&0x1 != 0 {
+			return errZipEncrypted
+		}
+	}
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !f.Mode().IsRegular() {
+			continue
+		}
+		if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > uint64(extras.MAX_COMPRESSION_RATIO) && f.UncompressedSize64 > 1024*1024 {
+			return extras.ErrArchiveBomb
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		memberPath, n, err := u.extract(rc, int64(f.UncompressedSize64))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if err = u.add(memberPath, name+"/"+f.Name, n, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *unpacker) unpackTar(fp string, name string, depth int) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		memberPath, n, err := u.extract(tr, hdr.Size)
+		if err != nil {
+			return err
+		}
+		if err = u.add(memberPath, name+"/"+hdr.Name, n, depth); err != nil {
+			return err
+		}
+	}
+}
+
+// unpackGzip handles both .tar.gz and a single gzipped file.
+func (u *unpacker) unpackGzip(fp string, name string, depth int) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	memberPath, n, err := u.extract(gr, 0)
+	if err != nil {
+		return err
+	}
+
+	if ArchiveKind(memberPath) == "tar" {
+		u.total -= n
+		err = u.unpackTar(memberPath, name, depth)
+		os.Remove(memberPath)
+		return err
+	}
+
+	memberName := strings.TrimSuffix(filepath.Base(name), ".gz")
+	if gr.Name != extras.EMPTY_STRING {
+		memberName = gr.Name
+	}
+	return u.add(memberPath, name+"/"+memberName, n, depth)
+}
+
+// unpack7z lists the archive with 7z before extracting it, so oversized and
+// highly compressed archives are refused without writing them out.
+func (u *unpacker) unpack7z(fp string, name string, depth int, archiveSize int64) error {
+	password := NO_PASSWORD
+	listing, encrypted, err := u.list7z(fp, password)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		password, listing, err = u.findPassword(fp)
+		if err != nil {
+			return err
+		}
+	}
+
+	var files int
+	var declared int64
+	inEntries, isFolder := false, false
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "----------") {
+			inEntries = true
+			continue
+		}
+		if !inEntries {
+			continue
+		}
+		key, value, found := strings.Cut(line, " = ")
+		if !found {
+			continue
+		}
+		switch key {
+		case "Path":
+			isFolder = false
+		case "Folder":
+			isFolder = value == "+"
+		case "Size":
+			if isFolder {
+				continue
+			}
+			size, _ := strconv.ParseInt(value, 10, 64)
+			if size > extras.MAX_ALLOWED_FILE_SIZE {
+				return extras.ErrArchiveLimit
+			}
+			declared += size
+			files++
+		}
+	}
+
+	if archiveSize > 0 && declared/archiveSize > extras.MAX_COMPRESSION_RATIO && declared > 1024*1024 {
+		return extras.ErrArchiveBomb
+	}
+	if u.files+files > extras.MAX_ARCHIVE_FILES || u.total+declared > extras.MAX_ARCHIVE_TOTAL_SIZE {
+		return extras.ErrArchiveLimit
+	}
+
+	outDir, err := os.MkdirTemp(u.destDir, "7z-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	cmd := exec.CommandContext(u.ctx, "7z", "x", "-y", "-p"+password, "-o"+outDir, fp)
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("7z: %v", err)
+	}
+
+	return filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// links are left out, they could point anywhere on the host
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		memberPath, n, err := u.extract(in, 0)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(outDir, path)
+		return u.add(memberPath, name+"/"+filepath.ToSlash(rel), n, depth)
+	})
+}
+
+// list7z returns the technical listing of fp and whether any of it is encrypted.
+func (u *unpacker) list7z(fp string, password string) (string, bool, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(u.ctx, "7z", "l", "-slt", "-p"+password, fp)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		// archives with encrypted headers can not even be listed
+		if strings.Contains(out.String(), "Wrong password") || strings.Contains(out.String(), "Can not open encrypted archive") {
+			return out.String(), true, nil
+		}
+		return out.String(), false, err
+	}
+	return out.String(), strings.Contains(out.String(), "\nEncrypted = +"), nil
+}
+
+// findPassword tests each password against fp, 7z t fails on a wrong one.
+func (u *unpacker) findPassword(fp string) (string, string, error) {
+	for _, password := range u.passwords {
+		if password == extras.EMPTY_STRING {
+			continue
+		}
+		if err := exec.CommandContext(u.ctx, "7z", "t", "-p"+password, fp).Run(); err != nil {
+			continue
+		}
+		listing, _, err := u.list7z(fp, password)
+		if err == nil {
+			return password, listing, nil
+		}
+	}
+	return extras.EMPTY_STRING, extras.EMPTY_STRING, extras.ErrArchiveEncrypted
+}
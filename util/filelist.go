@@ -0,0 +1,184 @@
+package util
+
+import (
+	"anti-apt-backend/extras"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the certificate table of a PE file is not read past this size
+const MAX_CERTIFICATE_TABLE_SIZE = 4 * 1024 * 1024
+
+const WIN_CERT_TYPE_PKCS_SIGNED_DATA = 0x0002
+
+// NormalizeFileListValue brings a file list value to the form lookups compare
+// against: lower case hex for hashes and thumbprints, a lower case glob for
+// file names.
+func NormalizeFileListValue(listType string, value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == extras.EMPTY_STRING {
+		return extras.EMPTY_STRING, extras.ErrFileListValue
+	}
+
+	switch listType {
+	case extras.FILE_LIST_HASH:
+		if HashType(value) == extras.EMPTY_STRING {
+			return extras.EMPTY_STRING, extras.ErrFileListValue
+		}
+		return value, nil
+	case extras.FILE_LIST_SIGNER:
+		// thumbprints are often copied with spaces or colons between the bytes
+		value = strings.NewReplacer(" ", "", ":", "").Replace(value)
+		if hashType := HashType(value); hashType != extras.HASH_TYPE_SHA1 && hashType != extras.HASH_TYPE_SHA256 {
+			return extras.EMPTY_STRING, extras.ErrFileListValue
+		}
+		return value, nil
+	case extras.FILE_LIST_FILENAME:
+		if strings.ContainsAny(value, `/\`) {
+			return extras.EMPTY_STRING, extras.ErrFileListValue
+		}
+		if _, err := path.Match(value, extras.EMPTY_STRING); err != nil {
+			return extras.EMPTY_STRING, extras.ErrFileListValue
+		}
+		return value, nil
+	}
+	return extras.EMPTY_STRING, extras.ErrFileListType
+}
+
+// MatchFileNameGlob tells whether the base name of fileName matches glob,
+// ignoring case.
+func MatchFileNameGlob(glob string, fileName string) bool {
+	name := strings.ToLower(filepath.Base(strings.ReplaceAll(fileName, `\`, "/")))
+	matched, err := path.Match(glob, name)
+	return err == nil && matched
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	Crls             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version         int
+	IssuerAndSerial pkcs7IssuerAndSerial
+}
+
+// pkcs7Signer returns the certificate of the first signer of a PKCS #7
+// SignedData.
+func pkcs7Signer(der []byte) (*x509.Certificate, bool) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &contentInfo); err != nil {
+		return nil, false
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, false
+	}
+	var signerInfo pkcs7SignerInfo
+	if _, err := asn1.Unmarshal(signedData.SignerInfos.Bytes, &signerInfo); err != nil {
+		return nil, false
+	}
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, certificate := range certificates {
+		if certificate.SerialNumber.Cmp(signerInfo.IssuerAndSerial.SerialNumber) == 0 && bytes.Equal(certificate.RawIssuer, signerInfo.IssuerAndSerial.Issuer.FullBytes) {
+			return certificate, true
+		}
+	}
+	return nil, false
+}
+
+// Thumbprints are the sha1 and sha256 of a certificate, the forms Windows and
+// most tools show.
+func Thumbprints(certificate *x509.Certificate) []string {
+	sha1Sum := sha1.Sum(certificate.Raw)
+	sha256Sum := sha256.Sum256(certificate.Raw)
+	return []string{hex.EncodeToString(sha1Sum[:]), hex.EncodeToString(sha256Sum[:])}
+}
+
+// SignerThumbprints returns the thumbprints of the certificates that signed
+// the PE file at filePath, none when it is not a signed PE file. The
+// signatures are only read, not verified.
+func SignerThumbprints(filePath string) []string {
+	peFile, err := pe.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer peFile.Close()
+
+	var directory pe.DataDirectory
+	switch header := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			directory = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+			directory = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+		}
+	}
+	if directory.Size == 0 || directory.Size > MAX_CERTIFICATE_TABLE_SIZE {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	// the certificate table is addressed by file offset, not rva
+	table := make([]byte, directory.Size)
+	if _, err = file.ReadAt(table, int64(directory.VirtualAddress)); err != nil {
+		return nil
+	}
+
+	var thumbprints []string
+	for len(table) >= 8 {
+		length := binary.LittleEndian.Uint32(table[0:4])
+		certificateType := binary.LittleEndian.Uint16(table[6:8])
+		if length < 8 || int(length) > len(table) {
+			break
+		}
+		if certificateType == WIN_CERT_TYPE_PKCS_SIGNED_DATA {
+			if certificate, ok := pkcs7Signer(table[8:length]); ok {
+				thumbprints = append(thumbprints, Thumbprints(certificate)...)
+			}
+		}
+		// entries are aligned to 8 bytes
+		next := (int(length) + 7) &^ 7
+		if next >= len(table) {
+			break
+		}
+		table = table[next:]
+	}
+	return thumbprints
+}
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"anti-apt-backend/extras"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// a url is looked up on at most this many leading path segments
+const MAX_URL_INTEL_PATH_DEPTH = 16
+
+// NormalizeUrlIntelValue brings a url intel value to the form lookups derive
+// from urls, so the two compare equal.
+func NormalizeUrlIntelValue(intelType string, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == extras.EMPTY_STRING {
+		return extras.EMPTY_STRING, extras.ErrUrlIntelValue
+	}
+
+	switch intelType {
+	case extras.URL_INTEL_URL:
+		u, err := parseIntelUrl(value)
+		if err != nil {
+			return extras.EMPTY_STRING, err
+		}
+		return normalizedUrl(u), nil
+	case extras.URL_INTEL_PATH:
+		value, _, _ = strings.Cut(value, "#")
+		value, _, _ = strings.Cut(value, "?")
+		host, path, _ := strings.Cut(value, "/")
+		host = normalizeHost(host)
+		path = strings.Trim(path, "/")
+		if host == extras.EMPTY_STRING || path == extras.EMPTY_STRING {
+			return extras.EMPTY_STRING, extras.ErrUrlIntelValue
+		}
+		return host + "/" + escapedPath(path), nil
+	case extras.URL_INTEL_HOST, extras.URL_INTEL_DOMAIN:
+		host := normalizeHost(value)
+		if host == extras.EMPTY_STRING || strings.ContainsAny(host, "/:?#") {
+			return extras.EMPTY_STRING, extras.ErrUrlIntelValue
+		}
+		return host, nil
+	case extras.URL_INTEL_IP:
+		ip := net.ParseIP(strings.Trim(value, "[]"))
+		if ip == nil {
+			return extras.EMPTY_STRING, extras.ErrUrlIntelValue
+		}
+		return ip.String(), nil
+	}
+	return extras.EMPTY_STRING, extras.ErrUrlIntelType
+}
+
+// GuessUrlIntelType picks the type of a bare value from a list that does not
+// say, a bare registrable domain is taken to cover its subdomains.
+func GuessUrlIntelType(value string) string {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.Contains(value, "://"):
+		return extras.URL_INTEL_URL
+	case net.ParseIP(strings.Trim(value, "[]")) != nil:
+		return extras.URL_INTEL_IP
+	case strings.Contains(strings.Trim(value, "/"), "/"):
+		return extras.URL_INTEL_PATH
+	}
+
+	host := normalizeHost(value)
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil && domain == host {
+		return extras.URL_INTEL_DOMAIN
+	}
+	return extras.URL_INTEL_HOST
+}
+
+// UrlIntelKeys returns every type and value rawUrl can match an entry on. The
+// number of keys is bounded, so a lookup costs the same for any list size.
+func UrlIntelKeys(rawUrl string) (map[string][]string, error) {
+	u, err := parseIntelUrl(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	host := normalizeHost(u.Hostname())
+	keys := map[string][]string{
+		extras.URL_INTEL_URL:  {normalizedUrl(u)},
+		extras.URL_INTEL_HOST: {host},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		keys[extras.URL_INTEL_IP] = []string{ip.String()}
+	} else if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		// the host and each parent down to the registrable domain
+		for name := host; ; {
+			keys[extras.URL_INTEL_DOMAIN] = append(keys[extras.URL_INTEL_DOMAIN], name)
+			if name == domain {
+				break
+			}
+			_, name, _ = strings.Cut(name, ".")
+		}
+	}
+
+	prefix := host
+	for i, segment := range strings.Split(strings.Trim(escapedPath(rawPath(u)), "/"), "/") {
+		if segment == extras.EMPTY_STRING || i >= MAX_URL_INTEL_PATH_DEPTH {
+			break
+		}
+		prefix += "/" + segment
+		keys[extras.URL_INTEL_PATH] = append(keys[extras.URL_INTEL_PATH], prefix)
+	}
+
+	return keys, nil
+}
+
+func parseIntelUrl(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == extras.EMPTY_STRING || u.Hostname() == extras.EMPTY_STRING {
+		return nil, extras.ErrInvalidUrlFound
+	}
+	return u, nil
+}
+
+// escapedPath escapes every segment of path the same way, however the url or
+// the entry spelled it, so "/a b" and "/a%20b" are one path.
+func escapedPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// rawPath is the path of u as it was written, EscapedPath gives up on paths
+// that are not validly escaped and loses the escaped slashes in them.
+func rawPath(u *url.URL) string {
+	if u.RawPath != extras.EMPTY_STRING {
+		return u.RawPath
+	}
+	return u.EscapedPath()
+}
+
+// normalizedUrl drops the fragment and default port and lower cases the
+// scheme and host, the path is escaped like escapedPath and the query kept as
+// it is.
+func normalizedUrl(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := normalizeHost(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != extras.EMPTY_STRING && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+
+	path := escapedPath(rawPath(u))
+	if path == extras.EMPTY_STRING {
+		path = "/"
+	}
+	if u.RawQuery != extras.EMPTY_STRING {
+		path += "?" + u.RawQuery
+	}
+	return scheme + "://" + host + path
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"anti-apt-backend/extras"
+	"slices"
+	"testing"
+)
+
+func TestUrlIntelPathsMatchLookups(t *testing.T) {
+	keys, err := UrlIntelKeys("http://Example.com/a%20b/c%2Fd/e;f?q=1#top")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// however an entry spells the path, it is saved as the lookup derives it
+	for _, value := range []string{"example.com/a b", "example.com/a%20b/", "example.com/a%20b/c%2Fd/e;f?q=2"} {
+		normalized, err := NormalizeUrlIntelValue(extras.URL_INTEL_PATH, value)
+		if err != nil {
+			t.Fatalf("%s: %v", value, err)
+		}
+		if !slices.Contains(keys[extras.URL_INTEL_PATH], normalized) {
+			t.Errorf("%s saved as %s, not among the lookup keys %v", value, normalized, keys[extras.URL_INTEL_PATH])
+		}
+	}
+
+	for _, value := range []string{"http://example.com/a b/c%2Fd/e;f?q=1", "HTTP://example.com:80/a%20b/c%2fd/e%3Bf?q=1"} {
+		normalized, err := NormalizeUrlIntelValue(extras.URL_INTEL_URL, value)
+		if err != nil {
+			t.Fatalf("%s: %v", value, err)
+		}
+		if !slices.Contains(keys[extras.URL_INTEL_URL], normalized) {
+			t.Errorf("%s saved as %s, lookup derives %v", value, normalized, keys[extras.URL_INTEL_URL])
+		}
+	}
+}
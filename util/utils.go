@@ -221,6 +221,108 @@ func ValidateContentTypeOfFile(form *multipart.Form, scanProfiles model.ScanProf
 	return nil
 }
 
+// GetPriorityOfFile returns the priority class asked for in the form, devices
+// default to inline and analysts to manual.
+func GetPriorityOfFile(form *multipart.Form, fromDevice bool) (string, error) {
+	if len(form.Value["priority"]) == 0 {
+		return GetPriority(extras.EMPTY_STRING, fromDevice)
+	}
+	return GetPriority(form.Value["priority"][0], fromDevice)
+}
+
+// GetPriority validates a submitted priority class, an empty one defaults to
+// inline for devices and manual for the web ui.
+func GetPriority(priority string, fromDevice bool) (string, error) {
+	if strings.TrimSpace(priority) == extras.EMPTY_STRING {
+		if fromDevice {
+			return extras.PRIORITY_INLINE, nil
+		}
+		return extras.PRIORITY_MANUAL, nil
+	}
+
+	priority = strings.ToLower(strings.TrimSpace(priority))
+	if !slices.Contains([]string{extras.PRIORITY_INLINE, extras.PRIORITY_MANUAL, extras.PRIORITY_BULK}, priority) {
+		return extras.EMPTY_STRING, extras.ErrInvalidPriority
+	}
+	return priority, nil
+}
+
+var (
+	vmTagRegex     = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	optionKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// GetAnalysisOptionsOfFile reads the optional sandbox settings of a file
+// submission.
+func GetAnalysisOptionsOfFile(form *multipart.Form) (model.AnalysisOptions, error) {
+	value := func(field string) string {
+		if len(form.Value[field]) == 0 {
+			return extras.EMPTY_STRING
+		}
+		return strings.TrimSpace(form.Value[field][0])
+	}
+
+	opts := model.AnalysisOptions{
+		Platform: value("platform"),
+		Tag:      value("tag"),
+		Route:    value("route"),
+		Package:  value("package"),
+		Options:  value("options"),
+	}
+	if timeout := value("timeout"); timeout != extras.EMPTY_STRING {
+		var err error
+		if opts.Timeout, err = strconv.Atoi(timeout); err != nil {
+			return opts, extras.ErrAnalysisTimeout
+		}
+	}
+	return opts, ValidateAnalysisOptions(&opts)
+}
+
+// ValidateAnalysisOptions checks opts against the values the sandbox accepts
+// and normalises them. The route has its own field and cannot be set in the
+// custom options.
+func ValidateAnalysisOptions(opts *model.AnalysisOptions) error {
+	opts.Platform = strings.ToLower(strings.TrimSpace(opts.Platform))
+	opts.Tag = strings.TrimSpace(opts.Tag)
+	opts.Route = strings.ToLower(strings.TrimSpace(opts.Route))
+	opts.Package = strings.ToLower(strings.TrimSpace(opts.Package))
+
+	if opts.Timeout != 0 && (opts.Timeout < extras.MIN_ANALYSIS_TIMEOUT || opts.Timeout > extras.MAX_ANALYSIS_TIMEOUT) {
+		return extras.ErrAnalysisTimeout
+	}
+	if opts.Platform != extras.EMPTY_STRING && !slices.Contains(extras.VM_PLATFORMS, opts.Platform) {
+		return extras.ErrVmPlatform
+	}
+	if opts.Tag != extras.EMPTY_STRING && !vmTagRegex.MatchString(opts.Tag) {
+		return extras.ErrVmTag
+	}
+	if opts.Route != extras.EMPTY_STRING && !slices.Contains(extras.ANALYSIS_ROUTES, opts.Route) {
+		return extras.ErrAnalysisRoute
+	}
+	if opts.Package != extras.EMPTY_STRING && !slices.Contains(extras.ANALYSIS_PACKAGES, opts.Package) {
+		return extras.ErrAnalysisPackage
+	}
+
+	if len(opts.Options) > 1024 {
+		return extras.ErrAnalysisOptions
+	}
+	var pairs []string
+	for _, pair := range strings.Split(opts.Options, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == extras.EMPTY_STRING {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !optionKeyRegex.MatchString(key) || strings.ToLower(key) == "route" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("%w: %s", extras.ErrAnalysisOptions, pair)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+	opts.Options = strings.Join(pairs, ",")
+	return nil
+}
+
 func IsEmpty(value interface{}) bool {
 	v := reflect.ValueOf(value)
 	switch v.Kind() {
@@ -390,6 +492,11 @@ func QEscape(filename string) string {
 	return url.QueryEscape(filename)
 }
 
+// EscapeSqlString makes s safe to put between single quotes in a raw query.
+func EscapeSqlString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(s)
+}
+
 func QUnescape(filename string) string {
 	name, err := url.QueryUnescape(filename)
 	if err != nil {
@@ -617,6 +724,23 @@ func FormatWithOrdinal(t time.Time) string {
 	return fmt.Sprintf("%d%s %s %d", day, suffix, t.Month().String(), t.Year())
 }
 
+// HashType tells an md5, sha1 or sha256 apart by its length, it is empty when
+// hash is none of them.
+func HashType(hash string) string {
+	if _, err := hex.DecodeString(hash); err != nil {
+		return extras.EMPTY_STRING
+	}
+	switch len(hash) {
+	case 32:
+		return extras.HASH_TYPE_MD5
+	case 40:
+		return extras.HASH_TYPE_SHA1
+	case 64:
+		return extras.HASH_TYPE_SHA256
+	}
+	return extras.EMPTY_STRING
+}
+
 func CalculateHash(multipartForm *multipart.Form, hashType string) (string, error) {
 
 	file, err := multipartForm.File["filename"][0].Open()
@@ -0,0 +1,407 @@
+package verdictcache
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/dao"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the index is rebuilt this often to pick up verdicts written by others, like
+// a restored backup or the preset hashes being updated
+const RELOAD_INTERVAL = time.Hour
+
+// a provisional verdict is replaced when the analysis finishes, it only runs out
+// when that never happens
+const PROVISIONAL_TTL_HOURS = 24
+
+// clean files are analysed again after a week and forgotten after a month,
+// blocked ones are kept
+var DEFAULT_POLICY = model.VerdictCacheConfig{
+	Allow: model.VerdictPolicy{TtlHours: 30 * 24, RescanAfterHours: 7 * 24},
+}
+
+// Entry is the verdict known for a file, indexed under each of its hashes.
+type Entry struct {
+	Verdict   string // extras.ALLOW or extras.BLOCK
+	Rating    string
+	Score     float32
+	Source    string // one of extras.VERDICT_SOURCE_*
+	TaskId    int
+	UpdatedAt time.Time
+	// first and last time the file was submitted
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+type put struct {
+	entry  Entry
+	hashes []string
+	// replayed with the rules of PutProvisional and DropProvisional
+	provisional bool
+	drop        bool
+}
+
+var (
+	mu       sync.RWMutex
+	entries  = make(map[string]*Entry)
+	policy   = DEFAULT_POLICY
+	loadedAt time.Time
+	// set while Load reads the tables, puts made meanwhile are replayed on
+	// the new index
+	loading bool
+	replay  []put
+
+	loadMu sync.Mutex
+
+	hits   atomic.Int64
+	misses atomic.Int64
+)
+
+func keys(hashes []string) []string {
+	var keys []string
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if hash != extras.EMPTY_STRING {
+			keys = append(keys, hash)
+		}
+	}
+	return keys
+}
+
+func store(index map[string]*Entry, entry *Entry, keys []string) {
+	for _, key := range keys {
+		if previous, ok := index[key]; ok {
+			seen(entry, previous.FirstSeen)
+			seen(entry, previous.LastSeen)
+		}
+	}
+	for _, key := range keys {
+		index[key] = entry
+	}
+}
+
+func seen(entry *Entry, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	if entry.FirstSeen.IsZero() || at.Before(entry.FirstSeen) {
+		entry.FirstSeen = at
+	}
+	if at.After(entry.LastSeen) {
+		entry.LastSeen = at
+	}
+}
+
+// limits of entry, overrides by an analyst and the malware hashes never age,
+// the malware hashes expire as their feeds say instead
+func limits(entry Entry) model.VerdictPolicy {
+	if entry.Source == extras.VERDICT_SOURCE_OVERRIDE || entry.Source == extras.VERDICT_SOURCE_MALWARE_DB {
+		return model.VerdictPolicy{}
+	}
+	if entry.Source == extras.VERDICT_SOURCE_PROVISIONAL {
+		return model.VerdictPolicy{TtlHours: PROVISIONAL_TTL_HOURS}
+	}
+	if entry.Verdict == extras.BLOCK {
+		return policy.Block
+	}
+	return policy.Allow
+}
+
+func olderThan(entry Entry, hours int) bool {
+	return hours > 0 && time.Since(entry.UpdatedAt) > time.Duration(hours)*time.Hour
+}
+
+// Lookup returns the verdict cached under any of hashes, unless its ttl has
+// run out.
+func Lookup(hashes ...string) (Entry, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, key := range keys(hashes) {
+		if entry, ok := entries[key]; ok && !olderThan(*entry, limits(*entry).TtlHours) {
+			hits.Add(1)
+			return *entry, true
+		}
+	}
+	misses.Add(1)
+	return Entry{}, false
+}
+
+// NeedsRescan tells whether a file with entry's verdict is due to be analysed
+// again when it is submitted. A provisional verdict is never enough, the file
+// waits on the analysis giving it.
+func NeedsRescan(entry Entry) bool {
+	if entry.Source == extras.VERDICT_SOURCE_PROVISIONAL {
+		return true
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return olderThan(entry, limits(entry).RescanAfterHours)
+}
+
+// Put caches entry under each of hashes, replacing what they pointed at. The
+// times the file was seen are kept.
+func Put(entry Entry, hashes ...string) {
+	if entry.UpdatedAt.IsZero() {
+		entry.UpdatedAt = time.Now()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	store(entries, &entry, keys(hashes))
+	if loading {
+		replay = append(replay, put{entry: entry, hashes: hashes})
+	}
+}
+
+// putProvisional stores entry unless one of keys already has a verdict of its
+// own.
+func putProvisional(index map[string]*Entry, entry *Entry, keys []string) bool {
+	for _, key := range keys {
+		if previous, ok := index[key]; ok && previous.Source != extras.VERDICT_SOURCE_PROVISIONAL && !olderThan(*previous, limits(*previous).TtlHours) {
+			return false
+		}
+	}
+	store(index, entry, keys)
+	return true
+}
+
+func dropProvisional(index map[string]*Entry, keys []string) {
+	for _, key := range keys {
+		if previous, ok := index[key]; ok && previous.Source == extras.VERDICT_SOURCE_PROVISIONAL {
+			delete(index, key)
+		}
+	}
+}
+
+// PutProvisional caches entry as the provisional verdict of a file being
+// analysed, a verdict already known for any of hashes is kept instead. Put
+// replaces it with the final verdict. It tells whether entry was cached.
+func PutProvisional(entry Entry, hashes ...string) bool {
+	entry.Source = extras.VERDICT_SOURCE_PROVISIONAL
+	if entry.UpdatedAt.IsZero() {
+		entry.UpdatedAt = time.Now()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if loading {
+		replay = append(replay, put{entry: entry, hashes: hashes, provisional: true})
+	}
+	return putProvisional(entries, &entry, keys(hashes))
+}
+
+// DropProvisional forgets the provisional verdict of a file whose analysis
+// ended without a verdict to replace it.
+func DropProvisional(hashes ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if loading {
+		replay = append(replay, put{hashes: hashes, drop: true})
+	}
+	dropProvisional(entries, keys(hashes))
+}
+
+// Seen records that the file with hashes was submitted at.
+func Seen(at time.Time, hashes ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range keys(hashes) {
+		if entry, ok := entries[key]; ok {
+			seen(entry, at)
+		}
+	}
+}
+
+func SetPolicy(verdictCacheConfig model.VerdictCacheConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	policy = verdictCacheConfig
+}
+
+// Policy is the saved policy, or DEFAULT_POLICY when none was saved.
+func Policy() model.VerdictCacheConfig {
+	verdictCacheConfig, err := config.ReadVerdictCacheConfig()
+	if err != nil {
+		return DEFAULT_POLICY
+	}
+	return verdictCacheConfig
+}
+
+func Stats() model.VerdictCacheStats {
+	mu.RLock()
+	defer mu.RUnlock()
+	return model.VerdictCacheStats{
+		Hashes:   len(entries),
+		Hits:     hits.Load(),
+		Misses:   misses.Load(),
+		LoadedAt: loadedAt,
+	}
+}
+
+// loadMalwareHashes blocks the unexpired malware hashes reported with enough
+// confidence, the preset ones carry none and are always blocked.
+func loadMalwareHashes(index map[string]*Entry) error {
+	mu.RLock()
+	minConfidence := policy.MinFeedConfidence
+	mu.RUnlock()
+
+	queryString := fmt.Sprintf("SELECT md5, sha1, sha256 FROM %s WHERE (expires_at IS NULL OR expires_at > '%s') AND (confidence >= %d OR source = '%s')", dao.FileHashesTable, time.Now().Format(extras.TIME_FORMAT), minConfidence, extras.FEED_SOURCE_PRESET)
+	rows, err := config.Db.Raw(queryString).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var md5, sha1, sha256 sql.NullString
+		if err = rows.Scan(&md5, &sha1, &sha256); err != nil {
+			return err
+		}
+		store(index, &Entry{
+			Verdict: extras.BLOCK,
+			Rating:  string(model.Critical),
+			Score:   7,
+			Source:  extras.VERDICT_SOURCE_MALWARE_DB,
+		}, keys([]string{md5.String, sha1.String, sha256.String}))
+	}
+	return rows.Err()
+}
+
+// loadFileVerdicts goes through the files in id order so the latest verdict of
+// a hash wins. Files answered from the cache only count as sightings, their
+// verdict is a copy. Files still being analysed bring their provisional
+// verdict.
+func loadFileVerdicts(index map[string]*Entry) error {
+	queryString := fmt.Sprintf("SELECT id, md5, sha, sha256, final_verdict, rating, score, overridden_verdict, status, submitted_time, COALESCE(finished_time, submitted_time), IFNULL(provisional_verdict, ''), finished_time IS NULL FROM %s WHERE IFNULL(md5, '') != '' ORDER BY id", extras.FileOnDemandTable)
+	rows, err := config.Db.Raw(queryString).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry Entry
+		var md5, sha1, sha256, verdict, rating, status sql.NullString
+		var score sql.NullFloat64
+		var overridden, unfinished sql.NullBool
+		var submittedAt, updatedAt sql.NullTime
+		var provisional string
+		if err = rows.Scan(&entry.TaskId, &md5, &sha1, &sha256, &verdict, &rating, &score, &overridden, &status, &submittedAt, &updatedAt, &provisional, &unfinished); err != nil {
+			return err
+		}
+		hashes := keys([]string{md5.String, sha1.String, sha256.String})
+
+		if unfinished.Bool && provisional == extras.BLOCK {
+			entry.Verdict = extras.BLOCK
+			entry.Rating = string(model.Critical)
+			entry.Source = extras.VERDICT_SOURCE_PROVISIONAL
+			entry.UpdatedAt = submittedAt.Time
+			putProvisional(index, &entry, hashes)
+			continue
+		}
+
+		if status.String == extras.PREVIOUSLY_SCANNED_FILE || (verdict.String != extras.ALLOW && verdict.String != extras.BLOCK) {
+			for _, key := range hashes {
+				if previous, ok := index[key]; ok {
+					seen(previous, submittedAt.Time)
+				}
+			}
+			continue
+		}
+
+		entry.Verdict = verdict.String
+		entry.Rating = rating.String
+		entry.Score = float32(score.Float64)
+		entry.UpdatedAt = updatedAt.Time
+		entry.Source = extras.VERDICT_SOURCE_ANALYSIS
+		if overridden.Bool {
+			entry.Source = extras.VERDICT_SOURCE_OVERRIDE
+		}
+		seen(&entry, submittedAt.Time)
+		store(index, &entry, hashes)
+	}
+	return rows.Err()
+}
+
+// Load rebuilds the index from the unexpired malware hashes and the verdicts of
+// every finished file, and the hash lists from their unexpired entries.
+// Lookups keep being answered from the old index until the new one is
+// complete.
+func Load() error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	SetPolicy(Policy())
+
+	mu.Lock()
+	loading = true
+	replay = nil
+	listReplay = nil
+	mu.Unlock()
+
+	index := make(map[string]*Entry)
+	hashLists := newHashLists()
+	err := loadMalwareHashes(index)
+	if err == nil {
+		err = loadFileVerdicts(index)
+	}
+	if err == nil {
+		err = loadHashLists(hashLists)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	loading = false
+	if err == nil {
+		for _, p := range replay {
+			entry := p.entry
+			switch {
+			case p.drop:
+				dropProvisional(index, keys(p.hashes))
+			case p.provisional:
+				putProvisional(index, &entry, keys(p.hashes))
+			default:
+				store(index, &entry, keys(p.hashes))
+			}
+		}
+		for _, change := range listReplay {
+			if change.drop {
+				hashLists.drop(change.entry.Id)
+			} else {
+				hashLists.put(change.entry)
+			}
+		}
+		entries = index
+		lists = hashLists
+		loadedAt = time.Now()
+	}
+	replay = nil
+	listReplay = nil
+	return err
+}
+
+// Run reloads the index every RELOAD_INTERVAL.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(RELOAD_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Load(); err != nil {
+				// slog.Println("ERROR WHILE RELOADING VERDICT CACHE: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package verdictcache
+
+import (
+	"anti-apt-backend/config"
+	"anti-apt-backend/extras"
+	"anti-apt-backend/model"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hashLists holds the hash entries of the allow and block lists, they decide
+// over any verdict and are looked up on every file the firewall sees.
+type hashLists struct {
+	byHash map[string]model.FileListEntry
+	// the hash an entry is kept under, to move it when the entry changes
+	byId map[int]string
+}
+
+func newHashLists() hashLists {
+	return hashLists{byHash: make(map[string]model.FileListEntry), byId: make(map[int]string)}
+}
+
+func (l hashLists) put(entry model.FileListEntry) {
+	l.drop(entry.Id)
+	if entry.Type != extras.FILE_LIST_HASH {
+		return
+	}
+	key := strings.ToLower(strings.TrimSpace(entry.Value))
+	l.byHash[key] = entry
+	l.byId[entry.Id] = key
+}
+
+func (l hashLists) drop(id int) {
+	if key, ok := l.byId[id]; ok {
+		delete(l.byHash, key)
+		delete(l.byId, id)
+	}
+}
+
+type listChange struct {
+	entry model.FileListEntry
+	drop  bool
+}
+
+var (
+	lists = newHashLists()
+	// list changes made while Load reads the tables, replayed on the new lists
+	listReplay []listChange
+)
+
+// Listed returns the unexpired allow or block list entry of any of hashes.
+func Listed(hashes ...string) (model.FileListEntry, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range keys(hashes) {
+		if entry, ok := lists.byHash[key]; ok && (!entry.ExpiresAt.Valid || entry.ExpiresAt.Time.After(now)) {
+			return entry, true
+		}
+	}
+	return model.FileListEntry{}, false
+}
+
+// PutListed keeps the index in step with a created or changed list entry, an
+// entry no longer on a hash is dropped.
+func PutListed(entry model.FileListEntry) {
+	mu.Lock()
+	defer mu.Unlock()
+	lists.put(entry)
+	if loading {
+		listReplay = append(listReplay, listChange{entry: entry})
+	}
+}
+
+// DropListed forgets the deleted list entry with id.
+func DropListed(id int) {
+	mu.Lock()
+	defer mu.Unlock()
+	lists.drop(id)
+	if loading {
+		listReplay = append(listReplay, listChange{entry: model.FileListEntry{Id: id}, drop: true})
+	}
+}
+
+// loadHashLists reads the unexpired hash entries, the expired ones are left
+// behind on every reload.
+func loadHashLists(l hashLists) error {
+	var entries []model.FileListEntry
+	queryString := fmt.Sprintf("SELECT * FROM %s WHERE type = '%s' AND (expires_at IS NULL OR expires_at > '%s')", extras.FileListTable, extras.FILE_LIST_HASH, time.Now().Format(extras.TIME_FORMAT))
+	if err := config.Db.Raw(queryString).Scan(&entries).Error; err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		l.put(entry)
+	}
+	return nil
+}